@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// AccessibilityReporter lists the pages with accessibility problems: images
+// missing alt text, links without text, and no declared language.
+type AccessibilityReporter struct {
+	problems map[string][]string
+	lock     sync.Mutex
+}
+
+func NewAccessibilityReporter() *AccessibilityReporter {
+	return &AccessibilityReporter{problems: make(map[string][]string)}
+}
+
+func (a *AccessibilityReporter) Observe(page Page) {
+	if page.Accessibility == nil {
+		return
+	}
+	problems := page.Accessibility.Problems()
+	if len(problems) == 0 {
+		return
+	}
+
+	a.lock.Lock()
+	a.problems[page.URL.String()] = problems
+	a.lock.Unlock()
+}
+
+func (a *AccessibilityReporter) Report(w io.Writer) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	pages := make([]string, 0, len(a.problems))
+	for page := range a.problems {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	fmt.Fprintf(w, "Accessibility problems: %d pages\n", len(pages))
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s\n", page)
+		for _, problem := range a.problems[page] {
+			fmt.Fprintf(w, "  - %s\n", problem)
+		}
+	}
+}
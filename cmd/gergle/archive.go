@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ArchiveTransport replays the responses recorded in a WARC or HAR archive,
+// keyed by request method and URL, rather than making requests. HEAD requests
+// fall back to the recorded GET response, without its body.
+type ArchiveTransport struct {
+	responses map[string][]byte
+}
+
+func NewArchiveTransport() *ArchiveTransport {
+	return &ArchiveTransport{responses: make(map[string][]byte)}
+}
+
+// Add records the raw HTTP response to the request.
+func (a *ArchiveTransport) Add(method string, href string, response []byte) {
+	a.responses[method+" "+href] = response
+}
+
+// Len returns the number of responses recorded.
+func (a *ArchiveTransport) Len() int {
+	return len(a.responses)
+}
+
+func (a *ArchiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	response, found := a.responses[req.Method+" "+req.URL.String()]
+	if !found && req.Method == "HEAD" {
+		response, found = a.responses["GET "+req.URL.String()]
+	}
+	if !found {
+		return nil, fmt.Errorf("No response to %s %s in archive", req.Method, req.URL)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(response)), req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Method == "HEAD" {
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(nil))
+	}
+	return resp, nil
+}
+
+// ReadWARC adds the response records of the WARC archive, such as one written
+// by WARCWriter, to the transport. The methods of the requests are taken from
+// their request records, defaulting to GET.
+func (a *ArchiveTransport) ReadWARC(r io.Reader) error {
+	responses := make(map[string][]byte)
+	uris := make(map[string]string)
+	methods := make(map[string]string)
+
+	records := bufio.NewReader(r)
+	for {
+		version, err := records.ReadString('\n')
+		if err == io.EOF && version == "" {
+			break
+		} else if !strings.HasPrefix(version, "WARC/") {
+			return fmt.Errorf("Expected WARC record, got %q", strings.TrimSpace(version))
+		}
+		headers, err := textproto.NewReader(records).ReadMIMEHeader()
+		if err != nil {
+			return fmt.Errorf("Failed to read WARC record: %s", err)
+		}
+		length, err := strconv.Atoi(headers.Get("Content-Length"))
+		if err != nil {
+			return fmt.Errorf("Invalid WARC record length %q", headers.Get("Content-Length"))
+		}
+		block := make([]byte, length)
+		if _, err := io.ReadFull(records, block); err != nil {
+			return fmt.Errorf("Failed to read WARC record: %s", err)
+		}
+
+		id := headers.Get("WARC-Record-ID")
+		switch headers.Get("WARC-Type") {
+		case "response":
+			responses[id] = block
+			uris[id] = strings.Trim(headers.Get("WARC-Target-URI"), "<>")
+		case "request":
+			if method := strings.Fields(string(block)); len(method) > 0 {
+				methods[headers.Get("WARC-Concurrent-To")] = method[0]
+			}
+		}
+
+		// Skip the blank lines separating the records.
+		for {
+			b, err := records.Peek(1)
+			if err != nil || (b[0] != '\r' && b[0] != '\n') {
+				break
+			}
+			records.ReadByte()
+		}
+	}
+
+	for id, response := range responses {
+		method := methods[id]
+		if method == "" {
+			method = "GET"
+		}
+		a.Add(method, uris[id], response)
+	}
+	return nil
+}
+
+// harArchive is the subset of the HAR format needed to replay responses.
+type harArchive struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method string `json:"method"`
+				URL    string `json:"url"`
+			} `json:"request"`
+			Response struct {
+				Status     int    `json:"status"`
+				StatusText string `json:"statusText"`
+				Headers    []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				Content struct {
+					Text     string `json:"text"`
+					Encoding string `json:"encoding"`
+				} `json:"content"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// ReadHAR adds the responses of the HAR archive, as exported by browsers, to
+// the transport.
+func (a *ArchiveTransport) ReadHAR(r io.Reader) error {
+	har := harArchive{}
+	if err := json.NewDecoder(r).Decode(&har); err != nil {
+		return fmt.Errorf("Failed to read HAR: %s", err)
+	}
+
+	for _, entry := range har.Log.Entries {
+		body := []byte(entry.Response.Content.Text)
+		if entry.Response.Content.Encoding == "base64" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Response.Content.Text)
+			if err != nil {
+				return fmt.Errorf("Failed to decode response to %s: %s", entry.Request.URL, err)
+			}
+			body = decoded
+		}
+
+		// The body is decoded, and its length known, so drop the headers
+		// which would say otherwise.
+		var response bytes.Buffer
+		fmt.Fprintf(&response, "HTTP/1.1 %d %s\r\n", entry.Response.Status, entry.Response.StatusText)
+		for _, header := range entry.Response.Headers {
+			switch strings.ToLower(header.Name) {
+			case "content-length", "content-encoding", "transfer-encoding":
+				continue
+			}
+			fmt.Fprintf(&response, "%s: %s\r\n", header.Name, header.Value)
+		}
+		fmt.Fprintf(&response, "Content-Length: %d\r\n\r\n", len(body))
+		response.Write(body)
+
+		a.Add(entry.Request.Method, entry.Request.URL, response.Bytes())
+	}
+	return nil
+}
+
+// newFileTransport returns a transport serving responses from files rather than
+// the network: from a directory of fixtures, served by path as a web server
+// would serve the directory, or from a WARC (.warc or .warc.gz) or HAR (.har)
+// archive.
+func newFileTransport(path string) (http.RoundTripper, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		logger.Info("Serving fixtures", "dir", path)
+		return http.NewFileTransport(http.Dir(path)), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	archive := NewArchiveTransport()
+	switch {
+	case strings.HasSuffix(path, ".har"):
+		err = archive.ReadHAR(file)
+	case strings.HasSuffix(path, ".gz"):
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(file); err == nil {
+			err = archive.ReadWARC(gz)
+		}
+	default:
+		err = archive.ReadWARC(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Replaying archive", "file", path, "responses", archive.Len())
+	return archive, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// AssetFile is the content of an asset, as found by FetchAsset.
+type AssetFile struct {
+	Checksum string
+	Size     int64
+}
+
+// FetchAsset downloads the asset to find its size and checksum.
+func FetchAsset(client *http.Client, href string) (AssetFile, error) {
+	resp, err := client.Get(href)
+	if err != nil {
+		return AssetFile{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return AssetFile{}, fmt.Errorf("Asset responded %d", resp.StatusCode)
+	}
+
+	hash := sha1.New()
+	size, err := io.Copy(hash, resp.Body)
+	if err != nil {
+		return AssetFile{}, err
+	}
+	return AssetFile{fmt.Sprintf("%x", hash.Sum(nil)), size}, nil
+}
+
+// AssetReporter deduplicates the assets of the crawled pages, listing the Top
+// assets shared by the most pages, the files served under several URLs, which
+// browsers download and cache once for each, and the total weight of the
+// site's unique files. Assets are fetched once the crawl is complete.
+type AssetReporter struct {
+	Client *http.Client
+	// Top is the number of shared assets to list.
+	Top int
+	// Workers is the number of assets to fetch at once.
+	Workers int
+
+	pages map[string]map[string]bool
+	lock  sync.Mutex
+}
+
+func NewAssetReporter(client *http.Client, top int) *AssetReporter {
+	return &AssetReporter{
+		Client:  client,
+		Top:     top,
+		Workers: 4,
+		pages:   make(map[string]map[string]bool),
+	}
+}
+
+func (a *AssetReporter) Observe(page Page) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, asset := range page.Assets {
+		if !asset.IsHTTP() {
+			continue
+		}
+		href := asset.URL.String()
+		if a.pages[href] == nil {
+			a.pages[href] = make(map[string]bool)
+		}
+		a.pages[href][page.URL.String()] = true
+	}
+}
+
+// fetchAssets fetches all of the assets, keyed by URL, fetching the given
+// number of assets at once.
+func fetchAssets(client *http.Client, concurrency int, hrefs []string) (map[string]AssetFile, map[string]error) {
+	files := make(map[string]AssetFile, len(hrefs))
+	errs := make(map[string]error)
+	lock := sync.Mutex{}
+
+	probeEach(concurrency, len(hrefs), func(i int) {
+		logger.Debug("Fetching asset", "url", hrefs[i])
+		file, err := FetchAsset(client, hrefs[i])
+		lock.Lock()
+		if err != nil {
+			errs[hrefs[i]] = err
+		} else {
+			files[hrefs[i]] = file
+		}
+		lock.Unlock()
+	})
+	return files, errs
+}
+
+func (a *AssetReporter) Report(w io.Writer) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	hrefs := make([]string, 0, len(a.pages))
+	for href := range a.pages {
+		hrefs = append(hrefs, href)
+	}
+	sort.Strings(hrefs)
+
+	logger.Info("Fetching assets", "count", len(hrefs))
+	files, errs := fetchAssets(a.Client, a.Workers, hrefs)
+
+	shared := append([]string{}, hrefs...)
+	sort.SliceStable(shared, func(i, j int) bool {
+		return len(a.pages[shared[i]]) > len(a.pages[shared[j]])
+	})
+	if len(shared) > a.Top {
+		shared = shared[:a.Top]
+	}
+	fmt.Fprintf(w, "Shared assets: %d assets\n", len(hrefs))
+	for _, href := range shared {
+		fmt.Fprintf(w, "- %s: %d pages\n", href, len(a.pages[href]))
+	}
+
+	variations := make(map[string][]string)
+	checksums := []string{}
+	var weight int64
+	for _, href := range hrefs {
+		file, fetched := files[href]
+		if !fetched {
+			continue
+		}
+		if len(variations[file.Checksum]) == 0 {
+			checksums = append(checksums, file.Checksum)
+			weight += file.Size
+		}
+		variations[file.Checksum] = append(variations[file.Checksum], href)
+	}
+
+	duplicated := []string{}
+	for _, checksum := range checksums {
+		if len(variations[checksum]) > 1 {
+			duplicated = append(duplicated, checksum)
+		}
+	}
+	fmt.Fprintf(w, "Asset variations: %d files under several URLs\n", len(duplicated))
+	for _, checksum := range duplicated {
+		fmt.Fprintf(w, "- %s (%d bytes)\n", checksum, files[variations[checksum][0]].Size)
+		for _, href := range variations[checksum] {
+			fmt.Fprintf(w, "  - %s\n", href)
+		}
+	}
+
+	fmt.Fprintf(w, "Unique asset weight: %d bytes in %d files\n", weight, len(checksums))
+	if len(errs) > 0 {
+		fmt.Fprintf(w, "Assets not fetched: %d assets\n", len(errs))
+		for _, href := range hrefs {
+			if err, failed := errs[href]; failed {
+				fmt.Fprintf(w, "- %s: %s\n", href, err)
+			}
+		}
+	}
+}
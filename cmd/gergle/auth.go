@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryMargin is how long before its expiry a token is refreshed, so
+// that it doesn't expire in flight.
+const tokenExpiryMargin = 30 * time.Second
+
+// A TokenSource provides the bearer tokens used to authenticate requests.
+type TokenSource interface {
+	Token() (string, error)
+	// Invalidate discards the token, if it is still current, once the server
+	// has rejected it.
+	Invalidate(token string)
+}
+
+// StaticToken is a bearer token given by the user, which can't be refreshed.
+type StaticToken string
+
+func (s StaticToken) Token() (string, error) {
+	return string(s), nil
+}
+
+func (s StaticToken) Invalidate(token string) {}
+
+// ClientCredentials fetches tokens from an OAuth2 token endpoint using the
+// client credentials grant, refreshing them as they expire or are rejected.
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	Client       *http.Client
+
+	token   string
+	expires time.Time
+	lock    sync.Mutex
+}
+
+func (c *ClientCredentials) Token() (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.token != "" && (c.expires.IsZero() || time.Now().Before(c.expires.Add(-tokenExpiryMargin))) {
+		return c.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	req, err := http.NewRequest("POST", c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	logger.Info("Fetching OAuth2 token", "url", c.TokenURL)
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("OAuth2 token endpoint responded %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("OAuth2 token endpoint returned no access_token")
+	}
+
+	c.token, c.expires = token.AccessToken, time.Time{}
+	if token.ExpiresIn > 0 {
+		c.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return c.token, nil
+}
+
+func (c *ClientCredentials) Invalidate(token string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+// AuthTransport adds a bearer token to the requests made to Host, so that the
+// token isn't leaked to other websites. Requests rejected with a 401 are
+// retried once with a fresh token, if the TokenSource has one.
+type AuthTransport struct {
+	Transport http.RoundTripper
+	Host      string
+	Tokens    TokenSource
+}
+
+func (a *AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != a.Host {
+		return a.Transport.RoundTrip(req)
+	}
+
+	token, err := a.Tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.Transport.RoundTrip(withBearer(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || req.Body != nil {
+		return resp, err
+	}
+
+	a.Tokens.Invalidate(token)
+	fresh, err := a.Tokens.Token()
+	if err != nil || fresh == token {
+		return resp, nil
+	}
+	logger.Debug("Retrying with a fresh token", "url", req.URL)
+	resp.Body.Close()
+	return a.Transport.RoundTrip(withBearer(req, fresh))
+}
+
+// withBearer returns a copy of the request with its Authorization header set
+// to the bearer token.
+func withBearer(req *http.Request, token string) *http.Request {
+	authed := req.Clone(req.Context())
+	authed.Header.Set("Authorization", "Bearer "+token)
+	return authed
+}
+
+// newSiteTransport prepares the transport for requests to the crawled website,
+// authenticating them if the options configure a bearer token or OAuth2 client.
+func newSiteTransport(initUrl *url.URL, opts CrawlOptions) (http.RoundTripper, error) {
+	transport, err := newTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens TokenSource
+	if opts.BearerToken != "" && opts.OAuthTokenURL != "" {
+		return nil, errors.New("--bearer-token and --oauth-token-url are mutually exclusive options.")
+	} else if opts.BearerToken != "" {
+		tokens = StaticToken(opts.BearerToken)
+	} else if opts.OAuthTokenURL != "" {
+		if opts.OAuthClientID == "" {
+			return nil, errors.New("--oauth-client-id is required with --oauth-token-url.")
+		}
+		tokens = &ClientCredentials{
+			TokenURL:     opts.OAuthTokenURL,
+			ClientID:     opts.OAuthClientID,
+			ClientSecret: opts.OAuthClientSecret,
+			Scopes:       opts.OAuthScopes,
+			Client:       &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		}
+	} else {
+		return transport, nil
+	}
+
+	logger.Info("Authenticating requests", "host", initUrl.Host)
+	return &AuthTransport{transport, initUrl.Host, tokens}, nil
+}
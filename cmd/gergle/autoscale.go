@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// AutoConcurrencyFetcher adjusts the number of pages fetched at once to keep
+// the 95th percentile time to first byte under Target. Starting from a single
+// request at a time, it adds one more after each Window of responses which
+// are comfortably fast, up to Max, and cuts back by a quarter after any
+// Window which is too slow or has more than 5% errors.
+type AutoConcurrencyFetcher struct {
+	Fetcher Fetcher
+	// Target is the 95th percentile time to first byte to aim for.
+	Target time.Duration
+	// Max is the most pages to fetch at once.
+	Max int
+	// Window is the number of responses between each adjustment.
+	Window int
+
+	limit     int
+	active    int
+	latencies []time.Duration
+	errors    int
+	lock      sync.Mutex
+	cond      *sync.Cond
+}
+
+func NewAutoConcurrencyFetcher(target time.Duration, max int, fetcher Fetcher) *AutoConcurrencyFetcher {
+	a := &AutoConcurrencyFetcher{Fetcher: fetcher, Target: target, Max: max, Window: 20, limit: 1}
+	a.cond = sync.NewCond(&a.lock)
+	return a
+}
+
+func (a *AutoConcurrencyFetcher) Fetch(task *Task) Page {
+	a.lock.Lock()
+	for a.active >= a.limit {
+		a.cond.Wait()
+	}
+	a.active++
+	a.lock.Unlock()
+
+	start := time.Now()
+	page := a.Fetcher.Fetch(task)
+	latency := page.TTFB
+	if latency == 0 {
+		latency = time.Since(start)
+	}
+
+	a.lock.Lock()
+	a.active--
+	a.record(latency, page.StatusCode == 0 && page.Error != nil || page.StatusCode == 429 || page.StatusCode >= 500)
+	a.lock.Unlock()
+	a.cond.Broadcast()
+	return page
+}
+
+// Limit returns the number of pages currently fetched at once.
+func (a *AutoConcurrencyFetcher) Limit() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.limit
+}
+
+// record counts the outcome of a request, adjusting the limit at the end of
+// each window. The lock must be held.
+func (a *AutoConcurrencyFetcher) record(latency time.Duration, failed bool) {
+	a.latencies = append(a.latencies, latency)
+	if failed {
+		a.errors++
+	}
+	if len(a.latencies) < a.Window {
+		return
+	}
+
+	sort.Slice(a.latencies, func(i, j int) bool { return a.latencies[i] < a.latencies[j] })
+	p95 := a.latencies[(len(a.latencies)*95-1)/100]
+	errorRate := float64(a.errors) / float64(len(a.latencies))
+	a.latencies, a.errors = a.latencies[:0], 0
+
+	limit := a.limit
+	switch {
+	case p95 > a.Target || errorRate > 0.05:
+		limit = limit * 3 / 4
+		if limit < 1 {
+			limit = 1
+		}
+	case p95 < a.Target*3/4 && limit < a.Max:
+		limit++
+	}
+	if limit != a.limit {
+		logger.Info("Adjusting concurrency", "connections", limit, "p95", p95, "errorRate", errorRate)
+		a.limit = limit
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var bandwidthRegex = regexp.MustCompile(`(?i)^\s*([\d.]+)\s*([kmg]?)i?b?(?:/s)?\s*$`)
+
+// parseBandwidth reads a rate such as "2MB/s" or "512k" as a number of bytes
+// per second.
+func parseBandwidth(rate string) (float64, error) {
+	match := bandwidthRegex.FindStringSubmatch(rate)
+	if match == nil {
+		return 0, fmt.Errorf("Expected bandwidth of the form 2MB/s, got %q.", rate)
+	}
+	bytes, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("Expected bandwidth of the form 2MB/s, got %q.", rate)
+	}
+	return bytes * byteUnit(match[2]), nil
+}
+
+var sizeRegex = regexp.MustCompile(`(?i)^\s*([\d.]+)\s*([kmg]?)i?b?\s*$`)
+
+// parseSize reads a size such as "200KB" or "1.5m" as a number of bytes.
+func parseSize(size string) (float64, error) {
+	match := sizeRegex.FindStringSubmatch(size)
+	if match == nil {
+		return 0, fmt.Errorf("Expected size of the form 200KB, got %q.", size)
+	}
+	bytes, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("Expected size of the form 200KB, got %q.", size)
+	}
+	return bytes * byteUnit(match[2]), nil
+}
+
+// byteUnit returns the number of bytes in a k, m or g unit.
+func byteUnit(unit string) float64 {
+	switch strings.ToLower(unit) {
+	case "k":
+		return 1 << 10
+	case "m":
+		return 1 << 20
+	case "g":
+		return 1 << 30
+	}
+	return 1
+}
+
+// TokenBucket limits the rate at which bytes are consumed, allowing bursts of
+// up to a second's worth of bytes.
+type TokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+func NewTokenBucket(bytesPerSecond float64) *TokenBucket {
+	return &TokenBucket{rate: bytesPerSecond, tokens: bytesPerSecond, last: time.Now()}
+}
+
+// Take consumes n tokens, blocking until the bucket has refilled enough to
+// cover them.
+func (t *TokenBucket) Take(n int) {
+	t.lock.Lock()
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.rate {
+		t.tokens = t.rate
+	}
+	t.last = now
+	t.tokens -= float64(n)
+	deficit := -t.tokens
+	t.lock.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / t.rate * float64(time.Second)))
+	}
+}
+
+// Chunk is the largest read which should be made at once, so that no single
+// reader takes more than its share of the bucket.
+func (t *TokenBucket) Chunk() int {
+	chunk := int(t.rate / 10)
+	if chunk < 512 {
+		return 512
+	}
+	return chunk
+}
+
+// throttledReader reads from a response body no faster than its bucket allows.
+type throttledReader struct {
+	io.ReadCloser
+	bucket *TokenBucket
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if chunk := t.bucket.Chunk(); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := t.ReadCloser.Read(p)
+	t.bucket.Take(n)
+	return n, err
+}
+
+// ThrottledTransport limits the rate at which all of the response bodies it
+// returns can be read, sharing one bandwidth budget between all requests.
+type ThrottledTransport struct {
+	Transport http.RoundTripper
+	Bucket    *TokenBucket
+}
+
+func (t *ThrottledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &throttledReader{resp.Body, t.Bucket}
+	return resp, nil
+}
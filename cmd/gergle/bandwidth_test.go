@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	results := map[string]float64{
+		"100":     100,
+		"100B/s":  100,
+		"1.5k":    1536,
+		"2MB/s":   2 << 20,
+		"2 MiB/s": 2 << 20,
+		"1gb":     1 << 30,
+		" 512KB ": 512 << 10,
+	}
+	for rate, expected := range results {
+		bytes, err := parseBandwidth(rate)
+		if err != nil {
+			t.Errorf("parseBandwidth(%q) should not return error: %s", rate, err)
+		} else if bytes != expected {
+			t.Errorf("parseBandwidth(%q) should be %v but got %v", rate, expected, bytes)
+		}
+	}
+
+	for _, rate := range []string{"", "fast", "2TB/s", "MB/s"} {
+		if _, err := parseBandwidth(rate); err == nil {
+			t.Errorf("parseBandwidth(%q) should return error", rate)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	results := map[string]float64{
+		"100":    100,
+		"200KB":  200 << 10,
+		"1.5m":   1536 << 10,
+		"2 MiB ": 2 << 20,
+	}
+	for size, expected := range results {
+		bytes, err := parseSize(size)
+		if err != nil {
+			t.Errorf("parseSize(%q) should not return error: %s", size, err)
+		} else if bytes != expected {
+			t.Errorf("parseSize(%q) should be %v but got %v", size, expected, bytes)
+		}
+	}
+
+	for _, size := range []string{"", "big", "2MB/s"} {
+		if _, err := parseSize(size); err == nil {
+			t.Errorf("parseSize(%q) should return error", size)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A breakerTrip records a host's circuit breaker pausing requests to it.
+type breakerTrip struct {
+	Host     string
+	Time     time.Time
+	Failures int
+	Reason   string
+}
+
+// hostCircuit is the state of the circuit breaker of a single host.
+type hostCircuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+// CircuitBreaker pauses requests to a host for Cooldown after Threshold
+// consecutive connection failures or 5xx responses from it, so that a dying
+// server doesn't use up the crawl on timeouts. A failure of the first request
+// after the cooldown pauses the host again.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	hosts map[string]*hostCircuit
+	trips []breakerTrip
+	lock  sync.Mutex
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		hosts:     make(map[string]*hostCircuit),
+	}
+}
+
+func (c *CircuitBreaker) circuit(host string) *hostCircuit {
+	if c.hosts[host] == nil {
+		c.hosts[host] = &hostCircuit{}
+	}
+	return c.hosts[host]
+}
+
+// Wait blocks while requests to the host are paused.
+func (c *CircuitBreaker) Wait(host string) {
+	for {
+		c.lock.Lock()
+		wait := c.circuit(host).openUntil.Sub(time.Now())
+		c.lock.Unlock()
+		if wait <= 0 {
+			return
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Record counts the outcome of a request to the host, pausing requests to it
+// if it has failed Threshold times in a row.
+func (c *CircuitBreaker) Record(host string, page *Page) {
+	reason := ""
+	switch {
+	case page.StatusCode >= 500:
+		reason = fmt.Sprintf("%d response", page.StatusCode)
+	case page.StatusCode == 0 && page.Error != nil:
+		reason = (*page.Error).Error()
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	circuit := c.circuit(host)
+	if reason == "" {
+		circuit.failures = 0
+		return
+	}
+	circuit.failures++
+	if circuit.failures < c.Threshold || time.Now().Before(circuit.openUntil) {
+		return
+	}
+
+	logger.Warn("Pausing requests to failing host", "host", host, "failures", circuit.failures, "cooldown", c.Cooldown, "reason", reason)
+	c.trips = append(c.trips, breakerTrip{host, clock(), circuit.failures, reason})
+	circuit.openUntil = time.Now().Add(c.Cooldown)
+	// Give the host a single request to recover after the cooldown.
+	circuit.failures = c.Threshold - 1
+}
+
+// CircuitBreakerFetcher waits out the pauses of its CircuitBreaker before
+// each request, and records the outcome of each.
+type CircuitBreakerFetcher struct {
+	Breaker *CircuitBreaker
+	Fetcher Fetcher
+}
+
+func (c *CircuitBreakerFetcher) Fetch(task *Task) Page {
+	host := asciiHost(task.URL.Host)
+	c.Breaker.Wait(host)
+	page := c.Fetcher.Fetch(task)
+	c.Breaker.Record(host, &page)
+	return page
+}
+
+// BreakerReporter lists the times its CircuitBreaker paused requests to a
+// host, if there were any.
+type BreakerReporter struct {
+	Breaker *CircuitBreaker
+}
+
+func NewBreakerReporter(breaker *CircuitBreaker) *BreakerReporter {
+	return &BreakerReporter{breaker}
+}
+
+func (b *BreakerReporter) Observe(page Page) {}
+
+func (b *BreakerReporter) Report(w io.Writer) {
+	b.Breaker.lock.Lock()
+	defer b.Breaker.lock.Unlock()
+	if len(b.Breaker.trips) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "Circuit breaker: %d pauses\n", len(b.Breaker.trips))
+	for _, trip := range b.Breaker.trips {
+		fmt.Fprintf(w, "- %s at %s after %d failures: %s\n", trip.Host, trip.Time.Format(time.RFC3339), trip.Failures, trip.Reason)
+	}
+}
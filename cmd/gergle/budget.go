@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Budget limits one measure of each page, such as its size or number of
+// assets.
+type Budget struct {
+	Name  string
+	Limit float64
+}
+
+// budgetMeasures are the page measures which budgets may be set for, along
+// with how to read their limits and format their values.
+var budgetMeasures = map[string]struct {
+	measure func(page Page) float64
+	parse   func(limit string) (float64, error)
+	format  func(value float64) string
+}{
+	"html-size": {
+		func(page Page) float64 { return float64(page.Size) },
+		parseSize,
+		func(value float64) string { return fmt.Sprintf("%.0f bytes", value) },
+	},
+	"assets": {
+		func(page Page) float64 { return float64(len(page.Assets)) },
+		parseCount,
+		func(value float64) string { return fmt.Sprintf("%.0f", value) },
+	},
+	"links": {
+		func(page Page) float64 { return float64(len(page.Links)) },
+		parseCount,
+		func(value float64) string { return fmt.Sprintf("%.0f", value) },
+	},
+	"ttfb": {
+		func(page Page) float64 { return float64(page.TTFB) },
+		func(limit string) (float64, error) {
+			duration, err := time.ParseDuration(limit)
+			return float64(duration), err
+		},
+		func(value float64) string { return time.Duration(value).String() },
+	},
+}
+
+func parseCount(limit string) (float64, error) {
+	count, err := strconv.ParseUint(limit, 10, 32)
+	return float64(count), err
+}
+
+// ParseBudget reads a budget of the form NAME=LIMIT, such as html-size=200KB,
+// assets=50, links=100 or ttfb=500ms.
+func ParseBudget(rule string) (Budget, error) {
+	parts := strings.SplitN(rule, "=", 2)
+	if len(parts) != 2 {
+		return Budget{}, fmt.Errorf("Expected --budget of the form NAME=LIMIT, got %q.", rule)
+	}
+	name := strings.TrimSpace(parts[0])
+	measure, found := budgetMeasures[name]
+	if !found {
+		return Budget{}, fmt.Errorf("Unknown --budget %q: expected html-size, assets, links or ttfb.", name)
+	}
+	limit, err := measure.parse(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return Budget{}, fmt.Errorf("Invalid --budget %q: %s.", rule, err)
+	}
+	return Budget{name, limit}, nil
+}
+
+// BudgetReporter lists the pages which exceed any of its budgets, failing the
+// crawl if there are any.
+type BudgetReporter struct {
+	Budgets []Budget
+
+	violations map[string][]string
+	lock       sync.Mutex
+}
+
+func NewBudgetReporter(budgets ...Budget) *BudgetReporter {
+	return &BudgetReporter{Budgets: budgets, violations: make(map[string][]string)}
+}
+
+func (b *BudgetReporter) Observe(page Page) {
+	if !page.Processed {
+		return
+	}
+
+	var violations []string
+	for _, budget := range b.Budgets {
+		measure := budgetMeasures[budget.Name]
+		if value := measure.measure(page); value > budget.Limit {
+			violations = append(violations, fmt.Sprintf("%s: %s exceeds %s", budget.Name, measure.format(value), measure.format(budget.Limit)))
+		}
+	}
+	if len(violations) == 0 {
+		return
+	}
+
+	b.lock.Lock()
+	b.violations[page.URL.String()] = violations
+	b.lock.Unlock()
+}
+
+func (b *BudgetReporter) Report(w io.Writer) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	pages := make([]string, 0, len(b.violations))
+	for page := range b.violations {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	fmt.Fprintf(w, "Over budget: %d pages\n", len(pages))
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s\n", page)
+		for _, violation := range b.violations[page] {
+			fmt.Fprintf(w, "  - %s\n", violation)
+		}
+	}
+}
+
+func (b *BudgetReporter) Failed() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.violations) > 0
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CachingHeaders are the response headers which govern how a page is cached.
+var CachingHeaders = []string{"ETag", "Last-Modified", "Cache-Control", "Expires"}
+
+// parseCacheControl returns the directives of a Cache-Control header, keyed by
+// lower-case name, with the value of each, if any.
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "" {
+			continue
+		}
+		name, value := directive, ""
+		if eq := strings.Index(directive, "="); eq >= 0 {
+			name, value = directive[:eq], strings.Trim(strings.TrimSpace(directive[eq+1:]), `"`)
+		}
+		directives[strings.ToLower(strings.TrimSpace(name))] = value
+	}
+	return directives
+}
+
+// cachingProblems returns the missing and contradictory caching headers of a
+// response.
+func cachingProblems(header http.Header) (problems []string) {
+	cacheControl := strings.Join(header.Values("Cache-Control"), ", ")
+	directives := parseCacheControl(cacheControl)
+	expires := header.Get("Expires")
+	_, noStore := directives["no-store"]
+
+	if header.Get("ETag") == "" && header.Get("Last-Modified") == "" && !noStore {
+		problems = append(problems, "missing validator: no ETag or Last-Modified")
+	}
+	if cacheControl == "" && expires == "" {
+		problems = append(problems, "missing freshness: no Cache-Control or Expires")
+	}
+
+	maxAge := -1
+	if value, found := directives["max-age"]; found {
+		if age, err := strconv.Atoi(value); err == nil && age >= 0 {
+			maxAge = age
+		} else {
+			problems = append(problems, fmt.Sprintf("invalid max-age %q", value))
+		}
+	}
+	_, public := directives["public"]
+	_, private := directives["private"]
+	_, immutable := directives["immutable"]
+	_, noCache := directives["no-cache"]
+	switch {
+	case public && private:
+		problems = append(problems, "contradictory Cache-Control: public and private")
+	case noStore && (public || maxAge > 0):
+		problems = append(problems, fmt.Sprintf("contradictory Cache-Control: no-store with %s", cacheControl))
+	case immutable && (noCache || noStore):
+		problems = append(problems, "contradictory Cache-Control: immutable but not to be reused")
+	}
+
+	if expires != "" && expires != "0" && expires != "-1" {
+		expiry, err := http.ParseTime(expires)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid Expires %q", expires))
+		} else if expiry.Before(clock()) && maxAge > 0 {
+			problems = append(problems, fmt.Sprintf("contradictory caching: Expires %s has passed, but max-age is %d", expires, maxAge))
+		} else if !expiry.Before(clock()) && maxAge == 0 {
+			problems = append(problems, fmt.Sprintf("contradictory caching: Expires %s is in the future, but max-age is 0", expires))
+		}
+	}
+	if lastModified := header.Get("Last-Modified"); lastModified != "" {
+		if modified, err := http.ParseTime(lastModified); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid Last-Modified %q", lastModified))
+		} else if modified.After(clock()) {
+			problems = append(problems, fmt.Sprintf("future Last-Modified: %s", lastModified))
+		}
+	}
+	return
+}
+
+// CachingReporter lists the pages whose responses are missing caching headers
+// or have contradictory ones, with a count of the pages with each problem.
+type CachingReporter struct {
+	problems map[string][]string
+	counts   map[string]int
+	lock     sync.Mutex
+}
+
+func NewCachingReporter() *CachingReporter {
+	return &CachingReporter{problems: make(map[string][]string), counts: make(map[string]int)}
+}
+
+func (c *CachingReporter) Observe(page Page) {
+	if page.StatusCode != http.StatusOK {
+		return
+	}
+	problems := cachingProblems(page.Header)
+	if len(problems) == 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.problems[page.URL.String()] = problems
+	for _, problem := range problems {
+		kind := problem
+		if colon := strings.IndexAny(problem, ":\""); colon > 0 {
+			kind = strings.TrimSpace(problem[:colon])
+		}
+		c.counts[kind]++
+	}
+}
+
+func (c *CachingReporter) Report(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	pages := make([]string, 0, len(c.problems))
+	for page := range c.problems {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	fmt.Fprintf(w, "Caching problems: %d pages\n", len(pages))
+	printCounts(w, c.counts)
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s\n", page)
+		for _, problem := range c.problems[page] {
+			fmt.Fprintf(w, "  - %s\n", problem)
+		}
+	}
+}
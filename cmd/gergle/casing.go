@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// caseFoldKey identifies the URLs which only differ in the case of their
+// paths.
+func caseFoldKey(u *url.URL) string {
+	folded := *u
+	folded.Fragment = ""
+	folded.Host = strings.ToLower(folded.Host)
+	folded.Path = strings.ToLower(folded.Path)
+	folded.RawPath = ""
+	return folded.String()
+}
+
+// CaseDuplicateReporter lists the groups of crawled URLs which only differ in
+// the case of their paths, yet serve identical content. The server is
+// ignoring case, splitting the links and ranking of each page across
+// several URLs which ought to be canonicalised to one.
+type CaseDuplicateReporter struct {
+	// pages are the URLs of each checksum of each case-folded URL.
+	pages map[string]map[string][]string
+	lock  sync.Mutex
+}
+
+func NewCaseDuplicateReporter() *CaseDuplicateReporter {
+	return &CaseDuplicateReporter{pages: make(map[string]map[string][]string)}
+}
+
+func (c *CaseDuplicateReporter) Observe(page Page) {
+	if page.StatusCode != 200 || page.Checksum == "" {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := caseFoldKey(page.URL)
+	if c.pages[key] == nil {
+		c.pages[key] = make(map[string][]string)
+	}
+	c.pages[key][page.Checksum] = append(c.pages[key][page.Checksum], graphKey(page.URL))
+}
+
+// Duplicates returns the sorted groups of URLs which only differ in case and
+// have identical content.
+func (c *CaseDuplicateReporter) Duplicates() (groups [][]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, checksums := range c.pages {
+		for _, urls := range checksums {
+			sorted := append([]string{}, urls...)
+			sort.Strings(sorted)
+			unique := []string{}
+			for _, u := range sorted {
+				if len(unique) == 0 || unique[len(unique)-1] != u {
+					unique = append(unique, u)
+				}
+			}
+			if len(unique) > 1 {
+				groups = append(groups, unique)
+			}
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return
+}
+
+func (c *CaseDuplicateReporter) Report(w io.Writer) {
+	groups := c.Duplicates()
+	fmt.Fprintf(w, "Case-insensitive duplicates: %d groups\n", len(groups))
+	for _, urls := range groups {
+		fmt.Fprintf(w, "- %s\n", urls[0])
+		for _, u := range urls[1:] {
+			fmt.Fprintf(w, "  - %s\n", u)
+		}
+	}
+}
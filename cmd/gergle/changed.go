@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// readPreviousSnapshot reads the snapshot at the path, or the latest of those
+// saved into the directory at the path, which is nil if there are none.
+func readPreviousSnapshot(path string) (*Snapshot, error) {
+	if strings.HasSuffix(path, ".json") {
+		return ReadSnapshot(path)
+	}
+	return LatestSnapshot(path)
+}
+
+// ConditionalTransport makes the requests for pages recorded in a snapshot
+// conditional on their having changed since, by the ETag and Last-Modified
+// they were last fetched with.
+type ConditionalTransport struct {
+	Transport http.RoundTripper
+	Snapshot  *Snapshot
+}
+
+func (c *ConditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	record, found := c.Snapshot.Pages[req.URL.String()]
+	if !found || (req.Method != "GET" && req.Method != "HEAD") || (record.ETag == "" && record.LastModified == "") {
+		return c.Transport.RoundTrip(req)
+	}
+
+	// RoundTrippers mustn't modify the request they're given.
+	conditional := new(http.Request)
+	*conditional = *req
+	conditional.Header = make(http.Header, len(req.Header)+2)
+	for name, values := range req.Header {
+		conditional.Header[name] = values
+	}
+	if record.ETag != "" {
+		conditional.Header.Set("If-None-Match", record.ETag)
+	}
+	if record.LastModified != "" {
+		conditional.Header.Set("If-Modified-Since", record.LastModified)
+	}
+	return c.Transport.RoundTrip(conditional)
+}
+
+// ChangedOnlyFetcher marks the pages which haven't changed since a snapshot
+// was taken, replaying the pages which the server reports Not Modified from
+// the snapshot rather than fetching and parsing them again.
+type ChangedOnlyFetcher struct {
+	Fetcher Fetcher
+
+	replay *SnapshotFetcher
+}
+
+func NewChangedOnlyFetcher(fetcher Fetcher, snapshot *Snapshot) *ChangedOnlyFetcher {
+	return &ChangedOnlyFetcher{Fetcher: fetcher, replay: NewSnapshotFetcher(snapshot)}
+}
+
+func (c *ChangedOnlyFetcher) Fetch(task *Task) Page {
+	page := c.Fetcher.Fetch(task)
+	record, found := c.replay.Snapshot.Pages[task.URL.String()]
+	switch {
+	case !found:
+	case page.StatusCode == http.StatusNotModified:
+		unchanged := c.replay.Fetch(task)
+		unchanged.Header, unchanged.TTFB = page.Header, page.TTFB
+		unchanged.Unchanged = true
+		return unchanged
+	case page.Processed && page.Checksum == record.Checksum:
+		// The server doesn't support conditional requests, but the content
+		// is the same.
+		page.Unchanged = true
+	}
+	return page
+}
@@ -0,0 +1,17 @@
+package main
+
+import "time"
+
+// clock returns the time recorded in what the crawl outputs, such as the time
+// of its snapshot, as opposed to the time by which it paces and times its
+// requests, which is always time.Now. It is fixed by --deterministic.
+var clock = time.Now
+
+// deterministicTime is the time given by the clock of a deterministic crawl:
+// late enough that the dates of fixture sites aren't taken for calendar traps.
+var deterministicTime = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fixedClock returns a clock which always gives the time t.
+func fixedClock(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ContactReporter collects the unique email addresses and phone numbers
+// linked to across the site, along with the number of pages linking to each.
+type ContactReporter struct {
+	pages map[string]map[string]bool
+	lock  sync.Mutex
+}
+
+func NewContactReporter() *ContactReporter {
+	return &ContactReporter{pages: make(map[string]map[string]bool)}
+}
+
+func (c *ContactReporter) Observe(page Page) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, link := range page.Contacts {
+		contact := link.Type + ":" + link.Contact()
+		if c.pages[contact] == nil {
+			c.pages[contact] = make(map[string]bool)
+		}
+		c.pages[contact][page.URL.String()] = true
+	}
+}
+
+func (c *ContactReporter) Report(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	contacts := make([]string, 0, len(c.pages))
+	for contact := range c.pages {
+		contacts = append(contacts, contact)
+	}
+	sort.Strings(contacts)
+
+	fmt.Fprintf(w, "Contacts: %d addresses\n", len(contacts))
+	for _, contact := range contacts {
+		fmt.Fprintf(w, "- %s (%d pages)\n", contact, len(c.pages[contact]))
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CrawlControl lets a crawl be paused and resumed, slowed down or sped up,
+// inspected, told to skip hosts, and cancelled while it is running.
+type CrawlControl struct {
+	// Frontier is listed by the queue command, if it can list its tasks.
+	Frontier Frontier
+	// Fetcher has its delay changed by the delay command, if it is a
+	// DelaySetter.
+	Fetcher Fetcher
+
+	paused    bool
+	cancelled bool
+	skipped   map[string]bool
+	current   string
+	lock      sync.Mutex
+	cond      *sync.Cond
+}
+
+func NewCrawlControl(frontier Frontier, fetcher Fetcher) *CrawlControl {
+	c := &CrawlControl{Frontier: frontier, Fetcher: fetcher, skipped: make(map[string]bool)}
+	c.cond = sync.NewCond(&c.lock)
+	return c
+}
+
+// Start blocks while the crawl is paused, and then reports whether the task
+// ought to be crawled.
+func (c *CrawlControl) Start(task Task) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for c.paused {
+		c.cond.Wait()
+	}
+	if c.cancelled {
+		return false
+	}
+	if c.skipped[task.URL.Host] {
+		logger.Debug("Skipping task of skipped host", "url", task.URL)
+		return false
+	}
+	c.current = task.URL.Host
+	return true
+}
+
+func (c *CrawlControl) Pause() {
+	c.lock.Lock()
+	c.paused = true
+	c.lock.Unlock()
+	logger.Info("Pausing crawl")
+}
+
+func (c *CrawlControl) Resume() {
+	c.lock.Lock()
+	c.paused = false
+	c.lock.Unlock()
+	c.cond.Broadcast()
+	logger.Info("Resuming crawl")
+}
+
+// Cancel has all of the remaining tasks dropped, ending the crawl once those
+// already underway are complete.
+func (c *CrawlControl) Cancel() {
+	c.lock.Lock()
+	c.cancelled = true
+	c.paused = false
+	c.lock.Unlock()
+	c.cond.Broadcast()
+	logger.Info("Cancelling crawl")
+}
+
+// Cancelled reports whether the crawl was cancelled.
+func (c *CrawlControl) Cancelled() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.cancelled
+}
+
+// Skip has the remaining tasks of the host dropped, or those of the host
+// most recently crawled if none is given.
+func (c *CrawlControl) Skip(host string) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if host == "" {
+		host = c.current
+	}
+	if host == "" {
+		return "", errors.New("No host has been crawled yet.")
+	}
+	c.skipped[host] = true
+	logger.Info("Skipping host", "host", host)
+	return host, nil
+}
+
+// SetDelay changes the delay between requests.
+func (c *CrawlControl) SetDelay(delay time.Duration) error {
+	setter, ok := c.Fetcher.(DelaySetter)
+	if !ok {
+		return errors.New("Rate-limiting is disabled: start the crawl with --delay or --adaptive.")
+	}
+	if delay <= 0 {
+		return errors.New("Delay must be positive.")
+	}
+	setter.SetDelay(delay)
+	logger.Info("Changing rate-limiting", "interval", delay)
+	return nil
+}
+
+// Queue returns the tasks waiting to be crawled.
+func (c *CrawlControl) Queue() ([]Task, error) {
+	lister, ok := c.Frontier.(interface{ Tasks() []Task })
+	if !ok {
+		return nil, errors.New("The frontier cannot list its tasks.")
+	}
+	return lister.Tasks(), nil
+}
+
+// Exec runs a single control command, writing its response to w.
+func (c *CrawlControl) Exec(command string, w io.Writer) error {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "pause":
+		c.Pause()
+		fmt.Fprintln(w, "Paused.")
+	case "resume":
+		c.Resume()
+		fmt.Fprintln(w, "Resumed.")
+	case "delay":
+		if len(fields) != 2 {
+			return errors.New("Expected delay SECONDS.")
+		}
+		seconds, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return errors.New("Expected delay SECONDS.")
+		}
+		if err := c.SetDelay(time.Duration(seconds * 1e9)); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Delay set to %s.\n", time.Duration(seconds*1e9))
+	case "queue":
+		tasks, err := c.Queue()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Queue: %d tasks\n", len(tasks))
+		for _, task := range tasks {
+			fmt.Fprintf(w, "- %s (depth %d)\n", task.URL, task.Depth)
+		}
+	case "skip":
+		host := ""
+		if len(fields) > 1 {
+			host = fields[1]
+			if u, err := url.Parse(host); err == nil && u.Host != "" {
+				host = u.Host
+			}
+		}
+		host, err := c.Skip(host)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Skipping %s.\n", host)
+	case "cancel":
+		c.Cancel()
+		fmt.Fprintln(w, "Cancelled.")
+	case "help":
+		fmt.Fprintln(w, "Commands: pause, resume, delay SECONDS, queue, skip [HOST], cancel, help")
+	default:
+		return fmt.Errorf("Unknown command %q: try help.", fields[0])
+	}
+	return nil
+}
+
+// Serve executes the commands sent, one per line, by each connection to the
+// listener. It returns once the listener is closed.
+func (c *CrawlControl) Serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				if err := c.Exec(scanner.Text(), conn); err != nil {
+					fmt.Fprintf(conn, "Error: %s\n", err)
+				}
+			}
+		}()
+	}
+}
+
+// listenControl listens for control connections at the address, which is
+// either HOST:PORT or the path of a Unix socket.
+func listenControl(addr string) (net.Listener, error) {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return net.Listen("tcp", addr)
+	}
+	return net.Listen("unix", addr)
+}
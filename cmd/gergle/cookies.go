@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// httpOnlyPrefix marks the HttpOnly cookies of a cookies.txt file, as written
+// by curl and browser extensions.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// readCookiesTxt sets the cookies of a Netscape-format cookies.txt file on the
+// jar, returning the number of cookies read.
+func readCookiesTxt(jar http.CookieJar, body []byte) (int, error) {
+	count := 0
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		httpOnly := strings.HasPrefix(text, httpOnlyPrefix)
+		if httpOnly {
+			text = strings.TrimPrefix(text, httpOnlyPrefix)
+		} else if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return count, fmt.Errorf("Expected 7 tab-separated fields on line %d of cookies file, got %d.", line, len(fields))
+		}
+		domain, subdomains, path, secure := fields[0], fields[1] == "TRUE", fields[2], fields[3] == "TRUE"
+		cookie := &http.Cookie{Name: fields[5], Value: fields[6], Path: path, Secure: secure, HttpOnly: httpOnly}
+		if subdomains {
+			cookie.Domain = domain
+		}
+		if expires, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+
+		u := &url.URL{Scheme: "http", Host: strings.TrimPrefix(domain, "."), Path: path}
+		if secure {
+			u.Scheme = "https"
+		}
+		jar.SetCookies(u, []*http.Cookie{cookie})
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// newCookieJar returns a jar holding the cookies of the --cookies file and the
+// --cookie flags, which are sent to the crawled website. It returns nil if no
+// cookies were given, so that the crawl doesn't keep any.
+func newCookieJar(initUrl *url.URL, opts CrawlOptions) (http.CookieJar, error) {
+	if opts.CookiesFile == "" && len(opts.Cookies) == 0 {
+		return nil, nil
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CookiesFile != "" {
+		body, err := ioutil.ReadFile(opts.CookiesFile)
+		if err != nil {
+			return nil, err
+		}
+		count, err := readCookiesTxt(jar, body)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Loaded cookies", "file", opts.CookiesFile, "count", count)
+	}
+
+	cookies := []*http.Cookie{}
+	for _, cookie := range opts.Cookies {
+		eq := strings.Index(cookie, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("Expected --cookie of the form NAME=VALUE, got %q.", cookie)
+		}
+		cookies = append(cookies, &http.Cookie{Name: cookie[:eq], Value: cookie[eq+1:], Path: "/"})
+	}
+	jar.SetCookies(initUrl, cookies)
+	return jar, nil
+}
+
+// A setCookie is a cookie set by the crawled website, with the pages it was
+// set by.
+type setCookie struct {
+	Name    string
+	Missing []string
+	Pages   int
+}
+
+// missingCookieAttributes returns the Secure, HttpOnly and SameSite attributes
+// missing from a cookie.
+func missingCookieAttributes(cookie *http.Cookie) (missing []string) {
+	if !cookie.Secure {
+		missing = append(missing, "Secure")
+	}
+	if !cookie.HttpOnly {
+		missing = append(missing, "HttpOnly")
+	}
+	if cookie.SameSite == 0 {
+		missing = append(missing, "SameSite")
+	}
+	return
+}
+
+// cookieScope returns the domain and path a cookie set by a response to the
+// URL applies to, defaulting to the host and directory of the URL.
+func cookieScope(cookie *http.Cookie, u *url.URL) string {
+	domain := strings.TrimPrefix(strings.ToLower(cookie.Domain), ".")
+	if domain == "" {
+		domain = asciiHost(u.Hostname())
+	}
+	cookiePath := cookie.Path
+	if !strings.HasPrefix(cookiePath, "/") {
+		cookiePath = "/"
+		if slash := strings.LastIndex(u.Path, "/"); slash > 0 {
+			cookiePath = u.Path[:slash]
+		}
+	}
+	return domain + cookiePath
+}
+
+// CookieReporter lists the cookies set by the website through Set-Cookie
+// headers, grouped by the domain and path they apply to, along with the
+// security attributes each is missing.
+type CookieReporter struct {
+	scopes map[string]map[string]*setCookie
+	lock   sync.Mutex
+}
+
+func NewCookieReporter() *CookieReporter {
+	return &CookieReporter{scopes: make(map[string]map[string]*setCookie)}
+}
+
+func (c *CookieReporter) Observe(page Page) {
+	if page.StatusCode == 0 {
+		return
+	}
+	cookies := (&http.Response{Header: page.Header}).Cookies()
+	if len(cookies) == 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, cookie := range cookies {
+		scope := cookieScope(cookie, page.URL)
+		if c.scopes[scope] == nil {
+			c.scopes[scope] = make(map[string]*setCookie)
+		}
+		if c.scopes[scope][cookie.Name] == nil {
+			c.scopes[scope][cookie.Name] = &setCookie{Name: cookie.Name, Missing: missingCookieAttributes(cookie)}
+		}
+		c.scopes[scope][cookie.Name].Pages++
+	}
+}
+
+func (c *CookieReporter) Report(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	scopes := make([]string, 0, len(c.scopes))
+	total, insecure := 0, 0
+	for scope, cookies := range c.scopes {
+		scopes = append(scopes, scope)
+		for _, cookie := range cookies {
+			total++
+			if len(cookie.Missing) > 0 {
+				insecure++
+			}
+		}
+	}
+	sort.Strings(scopes)
+
+	fmt.Fprintf(w, "Cookies: %d cookies, %d missing Secure, HttpOnly or SameSite\n", total, insecure)
+	for _, scope := range scopes {
+		cookies := make([]*setCookie, 0, len(c.scopes[scope]))
+		for _, cookie := range c.scopes[scope] {
+			cookies = append(cookies, cookie)
+		}
+		sort.Slice(cookies, func(i, j int) bool { return cookies[i].Name < cookies[j].Name })
+
+		fmt.Fprintf(w, "- %s\n", scope)
+		for _, cookie := range cookies {
+			if len(cookie.Missing) > 0 {
+				fmt.Fprintf(w, "  - %s: missing %s, set by %d pages\n", cookie.Name, strings.Join(cookie.Missing, ", "), cookie.Pages)
+			} else {
+				fmt.Fprintf(w, "  - %s: set by %d pages\n", cookie.Name, cookie.Pages)
+			}
+		}
+	}
+}
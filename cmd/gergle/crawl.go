@@ -5,44 +5,280 @@ import (
 	"sync"
 )
 
-// crawl is the website-crawling loop. It fetches URLs, discovers more, and
-// fetches those too, until there are no unseen pages to fetch. This is a
-// behemoth of a function which really ought to be broken down into smaller,
-// more testable chunks. But later, when it's not 1am.
-func crawl(
-	fetcher Fetcher, initUrl *url.URL, out chan<- Page, follower Follower,
-) {
+// Crawler is the website-crawling loop. It fetches URLs, discovers more, and
+// fetches those too, until there are no unseen pages to fetch.
+type Crawler struct {
+	Fetcher  Fetcher
+	Follower Follower
+	Frontier Frontier
+	// Rewriter, if set, rewrites the URLs of links before they are followed.
+	Rewriter Rewriter
+	// Control, if set, can pause the crawl and skip its tasks.
+	Control *CrawlControl
+	// Memory, if set, throttles the crawl while it is over its memory limit.
+	Memory *MemoryGuard
+	// Workers is the number of pages to fetch at once.
+	Workers int
+	// Referrers, if set, records the pages found linking to each URL. Each
+	// page is sent with the referrers known when it was fetched, and the rest
+	// are only in Referrers once the crawl is complete.
+	Referrers *Referrers
+	// Check lists the sorted follower rules whose declined links are still
+	// requested for their status, once the rest of the crawl is complete, so
+	// that links which weren't followed can be told apart from broken ones.
+	Check []string
+}
+
+// Crawl explores the website from initUrl, sending each page to out as it is
+// fetched. It returns once the crawl is complete.
+func (c *Crawler) Crawl(initUrl *url.URL, out chan<- Page) {
+	if c.Rewriter != nil {
+		initUrl = c.Rewriter.Rewrite(initUrl)
+	}
 	logger.Info("Starting crawl", "url", initUrl)
 
 	unexplored := sync.WaitGroup{}
 	unexplored.Add(1)
 
+	referrers := c.Referrers
+	if referrers == nil {
+		referrers = NewReferrers()
+	}
+	unfollowed := NewUnfollowedLinks()
+
+	if c.Memory != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go c.Memory.Watch(stop)
+	}
+
+	// Resume the tasks an earlier crawl left in the frontier.
+	if resumed, ok := c.Frontier.(interface{ Len() int }); ok {
+		if pending := resumed.Len(); pending > 0 {
+			logger.Info("Resuming crawl", "pending", pending)
+			unexplored.Add(pending)
+		}
+	}
+
 	// Seed the work queue.
-	pending := make(chan Task, 100)
-	pending <- Task{initUrl, 0}
+	c.Frontier.Push(Task{URL: initUrl, Depth: 0})
 
 	// Request pending, and requeue discovered pages.
-	go func() {
-		for task := range pending {
-			go func(task Task) {
-				logger.Debug("Starting", "url", task.URL)
-				page := fetcher.Fetch(&task)
-				out <- page
-
-				for _, link := range page.Links {
-					if err := follower.Follow(link); err != nil {
-						logger.Debug("Not following link", "link", link, "reason", err)
-					} else {
-						unexplored.Add(1)
-						pending <- LinkTask(link)
+	workers := sync.WaitGroup{}
+	for i := 0; i < c.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				task, ok := c.Frontier.Pop()
+				if !ok {
+					return
+				}
+				if c.Control != nil && !c.Control.Start(task) {
+					if !c.Control.Cancelled() {
+						// The tasks of a cancelled crawl are left underway,
+						// for a resumed crawl to pick up.
+						c.done(task)
 					}
+					unexplored.Done()
+					continue
+				}
+				if c.Memory != nil {
+					c.Memory.Acquire()
+				}
+				c.explore(task, out, referrers, unfollowed, &unexplored)
+				if c.Memory != nil {
+					c.Memory.Release()
 				}
+				c.done(task)
 				unexplored.Done()
-			}(task)
-		}
-	}()
+			}
+		}()
+	}
 
 	// Tie eveything off so that we exit clearly.
 	unexplored.Wait()
-	close(pending)
+	if tasks := unfollowed.Tasks(); len(tasks) > 0 {
+		logger.Info("Checking unfollowed links", "count", len(tasks))
+		unexplored.Add(len(tasks))
+		for _, task := range tasks {
+			c.Frontier.Push(task)
+		}
+		unexplored.Wait()
+	}
+	c.Frontier.Close()
+	workers.Wait()
+}
+
+// done tells the frontier, if it keeps the tasks underway until they're
+// done, that the task has been handled.
+func (c *Crawler) done(task Task) {
+	if frontier, ok := c.Frontier.(interface{ Done(Task) }); ok {
+		frontier.Done(task)
+	}
+}
+
+// explore fetches the task's page and queues those of its links which ought
+// to be followed.
+func (c *Crawler) explore(task Task, out chan<- Page, referrers *Referrers, unfollowed *UnfollowedLinks, unexplored *sync.WaitGroup) {
+	logger.Debug("Starting", "url", task.URL, "referrer", task.Referrer)
+	page := c.Fetcher.Fetch(&task)
+	page.Referrers = referrers.Crawled(page.URL)
+	page.Frame = task.Frame
+	page.Unfollowed = task.Unfollowed
+	out <- page
+	if task.Unfollowed != "" {
+		return
+	}
+	unfollowed.Crawled(task.URL)
+
+	for _, link := range page.Links {
+		link = c.rewrite(link, page.URL)
+		referrers.Add(link.URL, page.URL)
+		if decision := c.Follower.Follow(link); !decision.Allowed {
+			logger.Debug("Not following link", "link", link, "rule", decision.Rule, "reason", decision.Reason)
+			if link.IsHTTP() && containsString(c.Check, decision.Rule) {
+				task := LinkTask(link, page.URL)
+				task.Unfollowed = decision.Rule
+				unfollowed.Add(task)
+			}
+		} else {
+			unexplored.Add(1)
+			c.Frontier.Push(LinkTask(link, page.URL))
+		}
+	}
+}
+
+// rewrite returns the link with its URL rewritten, leaving the link as found
+// on the page untouched.
+func (c *Crawler) rewrite(link *Link, referrer *url.URL) *Link {
+	if c.Rewriter == nil {
+		return link
+	}
+	u := c.Rewriter.Rewrite(link.URL)
+	if u == link.URL {
+		return link
+	}
+	rewritten := *link
+	rewritten.URL = u
+	rewritten.External = isExternal(u, referrer)
+	return &rewritten
+}
+
+// UnfollowedLinks records the tasks of the links which weren't followed, to
+// be checked once the crawl is complete, along with the URLs which were
+// crawled, which needn't be.
+type UnfollowedLinks struct {
+	tasks   []Task
+	seen    map[string]bool
+	crawled map[string]bool
+	lock    sync.Mutex
+}
+
+func NewUnfollowedLinks() *UnfollowedLinks {
+	return &UnfollowedLinks{seen: make(map[string]bool), crawled: make(map[string]bool)}
+}
+
+// Add records the task of a link which wasn't followed, unless one has been
+// recorded for its URL already.
+func (u *UnfollowedLinks) Add(task Task) {
+	href := sanitizeURL(task.URL)
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if !u.seen[href] {
+		u.seen[href] = true
+		u.tasks = append(u.tasks, task)
+	}
+}
+
+// Crawled records that the URL was crawled.
+func (u *UnfollowedLinks) Crawled(pageURL *url.URL) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.crawled[sanitizeURL(pageURL)] = true
+}
+
+// Tasks returns the tasks of the links which weren't followed to URLs which
+// weren't crawled, in the order they were found.
+func (u *UnfollowedLinks) Tasks() []Task {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	tasks := []Task{}
+	for _, task := range u.tasks {
+		if !u.crawled[sanitizeURL(task.URL)] {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// Referrers records which pages have been found linking to each URL, and
+// which of them were known when each URL was crawled.
+type Referrers struct {
+	pages   map[string][]*url.URL
+	crawled map[string]int
+	order   []*url.URL
+	lock    sync.RWMutex
+}
+
+func NewReferrers() *Referrers {
+	return &Referrers{pages: make(map[string][]*url.URL), crawled: make(map[string]int)}
+}
+
+// Add records that the referrer page links to the URL.
+func (r *Referrers) Add(u *url.URL, referrer *url.URL) {
+	href := sanitizeURL(u)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, seen := range r.pages[href] {
+		if seen.String() == referrer.String() {
+			return
+		}
+	}
+	r.pages[href] = append(r.pages[href], referrer)
+}
+
+// Get returns the pages known so far to link to the URL.
+func (r *Referrers) Get(u *url.URL) []*url.URL {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	pages := r.pages[sanitizeURL(u)]
+	return append(make([]*url.URL, 0, len(pages)), pages...)
+}
+
+// Crawled returns the pages known so far to link to the URL being crawled,
+// recording them as those its page was sent with.
+func (r *Referrers) Crawled(u *url.URL) []*url.URL {
+	href := sanitizeURL(u)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	pages := r.pages[href]
+	if _, found := r.crawled[href]; !found {
+		r.order = append(r.order, u)
+	}
+	r.crawled[href] = len(pages)
+	return append(make([]*url.URL, 0, len(pages)), pages...)
+}
+
+// PageReferrers are all of the pages linking to a crawled page.
+type PageReferrers struct {
+	URL       *url.URL
+	Referrers []*url.URL
+}
+
+// Late returns the crawled pages which more pages were found linking to after
+// they were crawled, with all of their referrers, in the order they were
+// crawled. Once the crawl is complete, these complete the Referrers of the
+// pages it sent.
+func (r *Referrers) Late() []PageReferrers {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	late := []PageReferrers{}
+	for _, u := range r.order {
+		href := sanitizeURL(u)
+		if pages := r.pages[href]; len(pages) > r.crawled[href] {
+			late = append(late, PageReferrers{u, append([]*url.URL{}, pages...)})
+		}
+	}
+	return late
 }
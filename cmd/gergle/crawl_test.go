@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func mockPage(href string, links ...string) Page {
+	u, _ := url.Parse(href)
+	page := Page{URL: u, Processed: true}
+	for _, link := range links {
+		l, _ := AnchorLink(link, u, 1)
+		page.Links = append(page.Links, l)
+	}
+	return page
+}
+
+func TestCrawler(t *testing.T) {
+	initUrl, _ := url.Parse("http://example.com/")
+	crawler := &Crawler{
+		Fetcher: NewMockFetcher(
+			mockPage("http://example.com/", "/a", "/b", "http://other.com/"),
+			mockPage("http://example.com/a", "/", "/b"),
+			mockPage("http://example.com/b", "/a", "/missing"),
+		),
+		Follower: UnanimousFollower{&LocalFollower{}, NewUnseenFollower(initUrl)},
+		Frontier: NewPriorityFrontier(),
+		Workers:  2,
+	}
+
+	out := make(chan Page, 10)
+	go func() {
+		crawler.Crawl(initUrl, out)
+		close(out)
+	}()
+
+	referrers := map[string][]string{}
+	for page := range out {
+		hrefs := urlStrings(page.Referrers)
+		sort.Strings(hrefs)
+		referrers[page.URL.String()] = hrefs
+	}
+
+	expected := map[string]int{
+		"http://example.com/":        0,
+		"http://example.com/a":       1,
+		"http://example.com/b":       1,
+		"http://example.com/missing": 1,
+	}
+	if len(referrers) != len(expected) {
+		t.Errorf("Expected to crawl %d pages but crawled %d: %v", len(expected), len(referrers), referrers)
+	}
+	for href, minReferrers := range expected {
+		if hrefs, crawled := referrers[href]; !crawled {
+			t.Errorf("Expected %s to be crawled", href)
+		} else if len(hrefs) < minReferrers {
+			t.Errorf("Expected %s to have at least %d referrers but got %v", href, minReferrers, hrefs)
+		}
+	}
+}
+
+func TestCrawlerLateReferrers(t *testing.T) {
+	initUrl, _ := url.Parse("http://example.com/")
+	crawler := &Crawler{
+		Fetcher: NewMockFetcher(
+			mockPage("http://example.com/", "/a", "/b"),
+			mockPage("http://example.com/a", "/b"),
+			mockPage("http://example.com/b", "/a"),
+		),
+		Follower:  UnanimousFollower{&LocalFollower{}, NewUnseenFollower(initUrl)},
+		Frontier:  NewPriorityFrontier(),
+		Workers:   1,
+		Referrers: NewReferrers(),
+	}
+
+	out := make(chan Page, 10)
+	go func() {
+		crawler.Crawl(initUrl, out)
+		close(out)
+	}()
+
+	snapshot := NewSnapshot()
+	for page := range out {
+		snapshot.Add(page)
+	}
+	// Whichever of /a and /b is crawled first links to the other, so the
+	// other's link to it is only found after it is crawled.
+	late := crawler.Referrers.Late()
+	if len(late) != 1 || len(late[0].Referrers) != 2 {
+		t.Fatalf("Expected one page to be linked to after it was crawled, but got %v", late)
+	}
+	first := late[0].URL.String()
+	if referrers := snapshot.Pages[first].Referrers; len(referrers) != 1 {
+		t.Errorf("Expected %s to be sent with the one referrer known when it was crawled, but got %v", first, referrers)
+	}
+
+	snapshot.AddReferrers(crawler.Referrers)
+	for _, href := range []string{"http://example.com/a", "http://example.com/b"} {
+		referrers := snapshot.Pages[href].Referrers
+		sort.Strings(referrers)
+		if len(referrers) != 2 || referrers[0] != "http://example.com/" {
+			t.Errorf("Expected %s to be linked to from / and the other page, but got %v", href, referrers)
+		}
+	}
+}
+
+func TestCrawlerCheck(t *testing.T) {
+	initUrl, _ := url.Parse("http://example.com/")
+	crawler := &Crawler{
+		Fetcher: NewMockFetcher(
+			mockPage("http://example.com/", "/a", "/private/a"),
+			mockPage("http://example.com/a", "/private/a", "/private/b"),
+			mockPage("http://example.com/private/a", "/c"),
+		),
+		Follower: UnanimousFollower{&LocalFollower{}, NewRobotsDisallowFollower("/private"), NewUnseenFollower(initUrl)},
+		Frontier: NewPriorityFrontier(),
+		Workers:  2,
+		Check:    []string{"disallowed"},
+	}
+
+	out := make(chan Page, 10)
+	go func() {
+		crawler.Crawl(initUrl, out)
+		close(out)
+	}()
+
+	unfollowed := map[string]string{}
+	for page := range out {
+		unfollowed[page.URL.String()] = page.Unfollowed
+	}
+	expected := map[string]string{
+		"http://example.com/":          "",
+		"http://example.com/a":         "",
+		"http://example.com/private/a": "disallowed",
+		"http://example.com/private/b": "disallowed",
+	}
+	if !reflect.DeepEqual(unfollowed, expected) {
+		t.Errorf("Expected to crawl %v but crawled %v", expected, unfollowed)
+	}
+}
+
+func TestCrawlerRewriter(t *testing.T) {
+	rule, err := ParseRewriteRule(`[?&]sid=\w+=>`)
+	if err != nil {
+		t.Fatalf("ParseRewriteRule should not return error: %s", err)
+	}
+
+	initUrl, _ := url.Parse("http://example.com/")
+	crawler := &Crawler{
+		Fetcher: NewMockFetcher(
+			mockPage("http://example.com/", "/a?sid=123", "/b?sid=456"),
+			mockPage("http://example.com/a", "/b?sid=789"),
+			mockPage("http://example.com/b"),
+		),
+		Follower: UnanimousFollower{&LocalFollower{}, NewUnseenFollower(initUrl)},
+		Frontier: NewPriorityFrontier(),
+		Rewriter: RegexRewriter{rule},
+		Workers:  2,
+	}
+
+	out := make(chan Page, 10)
+	go func() {
+		crawler.Crawl(initUrl, out)
+		close(out)
+	}()
+
+	crawled := []string{}
+	for page := range out {
+		crawled = append(crawled, page.URL.String())
+	}
+	sort.Strings(crawled)
+
+	expected := []string{"http://example.com/", "http://example.com/a", "http://example.com/b"}
+	if !reflect.DeepEqual(crawled, expected) {
+		t.Errorf("Expected to crawl %v but crawled %v", expected, crawled)
+	}
+}
+
+func TestParseRewriteRule(t *testing.T) {
+	rule, err := ParseRewriteRule(`^http://(.*)=>https://$1`)
+	if err != nil {
+		t.Fatalf("ParseRewriteRule should not return error: %s", err)
+	}
+	u, _ := url.Parse("http://example.com/a")
+	if rewritten := (RegexRewriter{rule}).Rewrite(u); rewritten.String() != "https://example.com/a" {
+		t.Errorf("Expected https://example.com/a but got %s", rewritten)
+	}
+
+	for _, rule := range []string{"", "=>x", "no-arrow", "(=>x"} {
+		if _, err := ParseRewriteRule(rule); err == nil {
+			t.Errorf("ParseRewriteRule(%q) should return error", rule)
+		}
+	}
+}
+
+func TestCrawlControl(t *testing.T) {
+	control := NewCrawlControl(NewPriorityFrontier(), NewMockFetcher())
+	task := Task{URL: &url.URL{Scheme: "http", Host: "example.com", Path: "/"}}
+	if !control.Start(task) {
+		t.Error("Expected the task to be started")
+	}
+
+	out := &bytes.Buffer{}
+	if err := control.Exec("skip", out); err != nil {
+		t.Errorf("skip should not return error: %s", err)
+	}
+	if control.Start(task) {
+		t.Error("Expected the task of a skipped host not to be started")
+	}
+	if err := control.Exec("delay 1", out); err == nil {
+		t.Error("Expected delay to fail without rate-limiting")
+	}
+	if err := control.Exec("explode", out); err == nil {
+		t.Error("Expected an unknown command to fail")
+	}
+
+	control.Exec("pause", out)
+	started := make(chan bool)
+	go func() {
+		started <- control.Start(Task{URL: &url.URL{Host: "other.com"}})
+	}()
+	select {
+	case <-started:
+		t.Error("Expected the task to wait while paused")
+	case <-time.After(10 * time.Millisecond):
+	}
+	control.Exec("resume", out)
+	if !<-started {
+		t.Error("Expected the task to be started once resumed")
+	}
+}
+
+func TestUpgradeRewriter(t *testing.T) {
+	initUrl, _ := url.Parse("http://example.com/")
+	rewriter := Rewriters{&UpgradeRewriter{Host: initUrl.Host}}
+	crawler := &Crawler{
+		Fetcher: NewMockFetcher(
+			mockPage("https://example.com/", "/a", "http://example.com/b", "http://other.com/"),
+			mockPage("https://example.com/a", "https://example.com/", "http://example.com/a"),
+			mockPage("https://example.com/b", "http://example.com/"),
+		),
+		Follower: UnanimousFollower{&LocalFollower{}, NewUnseenFollower(rewriter.Rewrite(initUrl))},
+		Frontier: NewPriorityFrontier(),
+		Rewriter: rewriter,
+		Workers:  2,
+	}
+
+	out := make(chan Page, 10)
+	go func() {
+		crawler.Crawl(initUrl, out)
+		close(out)
+	}()
+
+	crawled := []string{}
+	for page := range out {
+		crawled = append(crawled, page.URL.String())
+	}
+	sort.Strings(crawled)
+
+	expected := []string{"https://example.com/", "https://example.com/a", "https://example.com/b"}
+	if !reflect.DeepEqual(crawled, expected) {
+		t.Errorf("Expected to crawl %v but crawled %v", expected, crawled)
+	}
+}
+
+func TestMemoryGuard(t *testing.T) {
+	usage := uint64(0)
+	guard := NewMemoryGuard(100)
+	guard.usage = func() uint64 { return usage }
+
+	guard.Acquire()
+	guard.Acquire()
+	guard.Release()
+	guard.Release()
+
+	usage = 150
+	guard.check()
+	guard.Acquire()
+	acquired := make(chan bool)
+	go func() {
+		guard.Acquire()
+		acquired <- true
+	}()
+	select {
+	case <-acquired:
+		t.Error("Expected only one page to be fetched at a time while over the limit")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	usage = 95
+	guard.check()
+	select {
+	case <-acquired:
+		t.Error("Expected the crawl to stay throttled until well under the limit")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	usage = 80
+	guard.check()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Error("Expected the crawl to resume once under the limit")
+	}
+}
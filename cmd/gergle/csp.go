@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A ContentSecurityPolicy maps the fetch directives of a policy to their
+// source lists.
+type ContentSecurityPolicy map[string][]string
+
+// ParseContentSecurityPolicy reads the directives of a Content-Security-Policy
+// header. Only the first occurrence of a directive counts, as in browsers.
+func ParseContentSecurityPolicy(header string) ContentSecurityPolicy {
+	policy := make(ContentSecurityPolicy)
+	for _, directive := range strings.Split(header, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if _, seen := policy[name]; !seen {
+			policy[name] = fields[1:]
+		}
+	}
+	return policy
+}
+
+// cspDirectives are the directives which govern each type of asset, most
+// specific first, before falling back to default-src.
+var cspDirectives = map[string][]string{
+	"script":     {"script-src-elem", "script-src"},
+	"stylesheet": {"style-src-elem", "style-src"},
+	"img":        {"img-src"},
+	"icon":       {"img-src"},
+	"audio":      {"media-src"},
+	"video":      {"media-src"},
+	"track":      {"media-src"},
+	"embed":      {"object-src"},
+	"object":     {"object-src"},
+	"font":       {"font-src"},
+	"manifest":   {"manifest-src"},
+	"frame":      {"frame-src", "child-src"},
+	"iframe":     {"frame-src", "child-src"},
+}
+
+// Directive returns the directive of the policy governing assets of the type,
+// and its sources, or false if the policy doesn't restrict them.
+func (p ContentSecurityPolicy) Directive(assetType string) (string, []string, bool) {
+	for _, name := range append(cspDirectives[assetType], "default-src") {
+		if sources, found := p[name]; found {
+			return name, sources, true
+		}
+	}
+	return "", nil, false
+}
+
+// Allows reports whether the policy of the page allows an asset of the type to
+// be loaded from the URL. Sources which can't be checked from the URL alone,
+// such as script-src 'strict-dynamic', allow everything.
+func (p ContentSecurityPolicy) Allows(assetType string, page, asset *url.URL) bool {
+	_, sources, found := p.Directive(assetType)
+	if !found {
+		return true
+	}
+	for _, source := range sources {
+		if strings.EqualFold(source, "'strict-dynamic'") {
+			return true
+		}
+	}
+	for _, source := range sources {
+		if cspSourceMatches(strings.ToLower(source), page, asset) {
+			return true
+		}
+	}
+	return false
+}
+
+// cspSourceMatches reports whether a source expression of a policy matches the
+// URL of an asset of the page.
+func cspSourceMatches(source string, page, asset *url.URL) bool {
+	scheme := strings.ToLower(asset.Scheme)
+	switch {
+	case source == "'self'":
+		return (scheme == page.Scheme || page.Scheme == "http" && scheme == "https") &&
+			strings.EqualFold(asset.Hostname(), page.Hostname()) && cspPort(asset) == cspPort(page)
+	case source == "*":
+		return scheme == "http" || scheme == "https" || scheme == "ws" || scheme == "wss" || scheme == "ftp"
+	case strings.HasPrefix(source, "'"):
+		// Keywords, nonces and hashes only allow inline and marked resources.
+		return false
+	case strings.HasSuffix(source, ":") && !strings.Contains(source, "/"):
+		return cspSchemeMatches(strings.TrimSuffix(source, ":"), scheme)
+	}
+
+	// A host source of the form [scheme://]host[:port][/path].
+	sourceScheme := ""
+	if i := strings.Index(source, "://"); i >= 0 {
+		sourceScheme, source = source[:i], source[i+3:]
+	}
+	if sourceScheme != "" {
+		if !cspSchemeMatches(sourceScheme, scheme) {
+			return false
+		}
+	} else if !cspSchemeMatches(page.Scheme, scheme) {
+		return false
+	}
+	path := ""
+	if i := strings.Index(source, "/"); i >= 0 {
+		source, path = source[:i], source[i:]
+	}
+	host, port := source, ""
+	if i := strings.LastIndex(source, ":"); i >= 0 {
+		host, port = source[:i], source[i+1:]
+	}
+
+	assetHost := strings.ToLower(asset.Hostname())
+	if strings.HasPrefix(host, "*.") {
+		if !strings.HasSuffix(assetHost, host[1:]) {
+			return false
+		}
+	} else if host != "*" && assetHost != host {
+		return false
+	}
+	switch {
+	case port == "*":
+	case port != "":
+		if cspPort(asset) != port {
+			return false
+		}
+	case asset.Port() != "" && asset.Port() != defaultPorts[scheme]:
+		return false
+	}
+	if path != "" {
+		if strings.HasSuffix(path, "/") {
+			return strings.HasPrefix(asset.Path, path)
+		}
+		return asset.Path == path
+	}
+	return true
+}
+
+// cspSchemeMatches reports whether the scheme of a source allows a URL of the
+// scheme, allowing secure upgrades of http and ws.
+func cspSchemeMatches(source, scheme string) bool {
+	return source == scheme || source == "http" && scheme == "https" || source == "ws" && scheme == "wss"
+}
+
+var defaultPorts = map[string]string{"http": "80", "https": "443", "ws": "80", "wss": "443", "ftp": "21"}
+
+// cspPort returns the port of the URL, or the default port of its scheme.
+func cspPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	return defaultPorts[strings.ToLower(u.Scheme)]
+}
+
+// CSPReporter lists the assets and frames of pages which the pages' own
+// Content-Security-Policy headers would block.
+type CSPReporter struct {
+	pages    int
+	blocked  map[string][]string
+	blocking map[string]int
+	lock     sync.Mutex
+}
+
+func NewCSPReporter() *CSPReporter {
+	return &CSPReporter{blocked: make(map[string][]string), blocking: make(map[string]int)}
+}
+
+func (c *CSPReporter) Observe(page Page) {
+	if page.StatusCode != http.StatusOK || len(page.Header.Values("Content-Security-Policy")) == 0 {
+		return
+	}
+
+	var blocked []string
+	blocking := make(map[string]bool)
+	for _, header := range page.Header.Values("Content-Security-Policy") {
+		policy := ParseContentSecurityPolicy(header)
+		check := func(link *Link) {
+			if !policy.Allows(link.Type, page.URL, link.URL) {
+				directive, sources, _ := policy.Directive(link.Type)
+				blocked = append(blocked, fmt.Sprintf("%s %s blocked by %s %s", link.Type, link.URL, directive, strings.Join(sources, " ")))
+				blocking[directive] = true
+			}
+		}
+		for _, asset := range page.Assets {
+			check(asset)
+		}
+		for _, link := range page.Links {
+			if link.IsFrame() {
+				check(link)
+			}
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.pages++
+	if len(blocked) == 0 {
+		return
+	}
+	c.blocked[page.URL.String()] = blocked
+	for directive := range blocking {
+		c.blocking[directive]++
+	}
+}
+
+func (c *CSPReporter) Report(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	pages := make([]string, 0, len(c.blocked))
+	for page := range c.blocked {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	fmt.Fprintf(w, "Content-Security-Policy violations: %d of %d pages\n", len(pages), c.pages)
+	printCounts(w, c.blocking)
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s\n", page)
+		for _, blocked := range c.blocked[page] {
+			fmt.Fprintf(w, "  - %s\n", blocked)
+		}
+	}
+}
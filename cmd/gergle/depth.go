@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// DepthReporter reports the click distance of pages from the start of the
+// crawl: the number of pages at each depth, the average depth of the pages of
+// each section, and the pages deeper than MaxDepth.
+type DepthReporter struct {
+	MaxDepth uint16
+
+	depths   map[uint16]int
+	sections map[string][]uint16
+	deep     map[string]uint16
+	lock     sync.Mutex
+}
+
+func NewDepthReporter(maxDepth uint16) *DepthReporter {
+	return &DepthReporter{
+		MaxDepth: maxDepth,
+		depths:   make(map[uint16]int),
+		sections: make(map[string][]uint16),
+		deep:     make(map[string]uint16),
+	}
+}
+
+func (d *DepthReporter) Observe(page Page) {
+	if page.StatusCode == 0 {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.depths[page.Depth]++
+	section := "/" + pathSection(page.URL)
+	d.sections[section] = append(d.sections[section], page.Depth)
+	if page.Depth > d.MaxDepth {
+		d.deep[page.URL.String()] = page.Depth
+	}
+}
+
+// AverageDepths returns the mean depth of the pages of each section.
+func (d *DepthReporter) AverageDepths() map[string]float64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	averages := make(map[string]float64, len(d.sections))
+	for section, depths := range d.sections {
+		total := 0
+		for _, depth := range depths {
+			total += int(depth)
+		}
+		averages[section] = float64(total) / float64(len(depths))
+	}
+	return averages
+}
+
+func (d *DepthReporter) Report(w io.Writer) {
+	averages := d.AverageDepths()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	depths := make([]int, 0, len(d.depths))
+	for depth := range d.depths {
+		depths = append(depths, int(depth))
+	}
+	sort.Ints(depths)
+	fmt.Fprintf(w, "Click depth: %d depths\n", len(depths))
+	for _, depth := range depths {
+		fmt.Fprintf(w, "- %d clicks: %d pages\n", depth, d.depths[uint16(depth)])
+	}
+
+	sections := make([]string, 0, len(averages))
+	for section := range averages {
+		sections = append(sections, section)
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		if averages[sections[i]] != averages[sections[j]] {
+			return averages[sections[i]] > averages[sections[j]]
+		}
+		return sections[i] < sections[j]
+	})
+	fmt.Fprintf(w, "Average click depth: %d sections\n", len(sections))
+	for _, section := range sections {
+		fmt.Fprintf(w, "- %s: %.1f clicks (%d pages)\n", section, averages[section], len(d.sections[section]))
+	}
+
+	pages := make([]string, 0, len(d.deep))
+	for page := range d.deep {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+	fmt.Fprintf(w, "Deeper than %d clicks: %d pages\n", d.MaxDepth, len(pages))
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s (%d clicks)\n", page, d.deep[page])
+	}
+}
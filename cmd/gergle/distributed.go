@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoteTask is the API representation of a Task handed to a worker.
+type RemoteTask struct {
+	ID         int    `json:"id"`
+	URL        string `json:"url"`
+	Depth      uint16 `json:"depth"`
+	Referrer   string `json:"referrer,omitempty"`
+	Frame      bool   `json:"frame,omitempty"`
+	Pagination uint16 `json:"pagination,omitempty"`
+	Unfollowed string `json:"unfollowed,omitempty"`
+}
+
+// Task returns the task the worker is to fetch.
+func (r RemoteTask) Task() (*Task, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	task := &Task{URL: u, Depth: r.Depth, Frame: r.Frame, Pagination: r.Pagination, Unfollowed: r.Unfollowed}
+	if r.Referrer != "" {
+		if task.Referrer, err = url.Parse(r.Referrer); err != nil {
+			return nil, err
+		}
+	}
+	return task, nil
+}
+
+// RemotePage is the API representation of a Page fetched by a worker. The
+// TLS connection state isn't sent, so --tls only covers the coordinator's own
+// connections.
+type RemotePage struct {
+	Page
+	// Error and TLS shadow those of the Page, which can't be decoded.
+	Error string    `json:",omitempty"`
+	TLS   *struct{} `json:",omitempty"`
+}
+
+func NewRemotePage(page Page) RemotePage {
+	remote := RemotePage{Page: page}
+	if page.Error != nil {
+		remote.Error = (*page.Error).Error()
+	}
+	return remote
+}
+
+// Fetched returns the page the worker fetched.
+func (r RemotePage) Fetched() Page {
+	page := r.Page
+	page.Error, page.TLS = nil, nil
+	if r.Error != "" {
+		err := errors.New(r.Error)
+		page.Error = &err
+	}
+	return page
+}
+
+// coordinatedTask is a task waiting to be fetched by a worker.
+type coordinatedTask struct {
+	RemoteTask
+	result chan Page
+}
+
+// Coordinator is a Fetcher which hands each task to the next worker asking
+// for one over HTTP, and waits for the worker to send back the page, so that
+// a crawl's frontier and seen pages are kept in one place while the fetching
+// and parsing is spread across machines. Tasks which aren't sent back within
+// the Lease are handed to another worker.
+//
+// Workers speak plain HTTP and JSON to the coordinator rather than gRPC or a
+// shared Redis queue: pages are sent back as the JSON of the Page itself, so
+// that they aren't translated to and from another schema, and a worker needs
+// nothing but the coordinator's address. With a Token, each request must
+// present it as an "Authorization: Bearer TOKEN" header.
+//
+//	GET  /crawl      The URL the crawl started from, {"url": "..."}.
+//	GET  /tasks      The next task to fetch, waiting up to PollTimeout for
+//	                 one. 204 if there isn't one yet, 410 once the crawl
+//	                 is complete.
+//	POST /tasks/ID   The page fetched for a task.
+type Coordinator struct {
+	URL         *url.URL
+	Lease       time.Duration
+	PollTimeout time.Duration
+	// Token, if set, is the bearer token which workers must present.
+	Token string
+
+	queue   chan *coordinatedTask
+	pending map[int]*coordinatedTask
+	seq     int
+	done    chan struct{}
+	closed  bool
+	lock    sync.Mutex
+}
+
+func NewCoordinator(initUrl *url.URL, lease time.Duration) *Coordinator {
+	return &Coordinator{
+		URL:         initUrl,
+		Lease:       lease,
+		PollTimeout: 30 * time.Second,
+		queue:       make(chan *coordinatedTask),
+		pending:     make(map[int]*coordinatedTask),
+		done:        make(chan struct{}),
+	}
+}
+
+func (c *Coordinator) Fetch(task *Task) Page {
+	c.lock.Lock()
+	c.seq++
+	t := &coordinatedTask{
+		RemoteTask: RemoteTask{
+			ID:         c.seq,
+			URL:        task.URL.String(),
+			Depth:      task.Depth,
+			Frame:      task.Frame,
+			Pagination: task.Pagination,
+			Unfollowed: task.Unfollowed,
+		},
+		result: make(chan Page, 1),
+	}
+	if task.Referrer != nil {
+		t.Referrer = task.Referrer.String()
+	}
+	c.pending[t.ID] = t
+	c.lock.Unlock()
+	defer c.forget(t.ID)
+
+	for {
+		select {
+		case c.queue <- t:
+		case page := <-t.result:
+			return page
+		case <-c.done:
+			return ErrorPage(task.URL, task.Depth, errors.New("Coordinator closed"))
+		}
+
+		select {
+		case page := <-t.result:
+			return page
+		case <-time.After(c.Lease):
+			logger.Warn("Reassigning task", "url", task.URL, "lease", c.Lease)
+		case <-c.done:
+			return ErrorPage(task.URL, task.Depth, errors.New("Coordinator closed"))
+		}
+	}
+}
+
+// forget drops the task once it's no longer waiting for a worker, whether it
+// was sent back or the coordinator closed first.
+func (c *Coordinator) forget(id int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.pending, id)
+}
+
+// Close tells the workers that the crawl is complete.
+func (c *Coordinator) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.closed {
+		close(c.done)
+		c.closed = true
+	}
+}
+
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !bearerAuthorized(r.Header.Get("Authorization"), c.Token) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, errors.New("Expected an Authorization: Bearer token."))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "crawl" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]string{"url": c.URL.String()})
+	case len(parts) == 1 && parts[0] == "tasks" && r.Method == "GET":
+		c.nextTask(w, r)
+	case len(parts) == 2 && parts[0] == "tasks" && r.Method == "POST":
+		c.completeTask(w, r, parts[1])
+	default:
+		writeError(w, http.StatusNotFound, errors.New("Not found."))
+	}
+}
+
+// nextTask hands the next task to a worker.
+func (c *Coordinator) nextTask(w http.ResponseWriter, r *http.Request) {
+	select {
+	case t := <-c.queue:
+		writeJSON(w, http.StatusOK, t.RemoteTask)
+	case <-c.done:
+		writeError(w, http.StatusGone, errors.New("Crawl complete."))
+	case <-time.After(c.PollTimeout):
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+}
+
+// completeTask receives the page a worker fetched for a task.
+func (c *Coordinator) completeTask(w http.ResponseWriter, r *http.Request, id string) {
+	var remote RemotePage
+	if err := json.NewDecoder(r.Body).Decode(&remote); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Expected a JSON page: %s", err))
+		return
+	}
+	n, _ := strconv.Atoi(id)
+
+	c.lock.Lock()
+	t, found := c.pending[n]
+	delete(c.pending, n)
+	c.lock.Unlock()
+	if !found {
+		// Another worker sent the page back first.
+		writeError(w, http.StatusNotFound, fmt.Errorf("No pending task %q.", id))
+		return
+	}
+	t.result <- remote.Fetched()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Worker fetches the tasks of a Coordinator until its crawl is complete.
+type Worker struct {
+	Coordinator *url.URL
+	Client      *http.Client
+	Fetcher     Fetcher
+	// Token, if set, is the bearer token presented to the coordinator.
+	Token string
+	// Retry is the time to wait after first failing to reach the coordinator,
+	// doubling with each failure after it up to MaxRetry.
+	Retry    time.Duration
+	MaxRetry time.Duration
+	// Wait, if set, is how long Site waits for the coordinator to start.
+	Wait time.Duration
+	// GiveUp, if set, stops the worker once the coordinator has been
+	// unreachable for this long, such as after it exits.
+	GiveUp time.Duration
+}
+
+// Site asks the coordinator for the URL its crawl started from, waiting for
+// the coordinator to start.
+func (w *Worker) Site() (*url.URL, error) {
+	start := time.Now()
+	retry := w.Retry
+	for {
+		resp, err := w.do("GET", w.Coordinator.ResolveReference(&url.URL{Path: "crawl"}).String(), nil)
+		if err != nil {
+			if w.Wait > 0 && time.Since(start) > w.Wait {
+				return nil, fmt.Errorf("Failed to reach coordinator within %s: %s", w.Wait, err)
+			}
+			logger.Warn("Failed to reach coordinator", "url", w.Coordinator, "error", err, "retry", retry)
+			time.Sleep(retry)
+			retry = w.backoff(retry)
+			continue
+		}
+		var crawl struct {
+			URL string `json:"url"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&crawl)
+		resp.Body.Close()
+		if err == nil && resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("Unexpected %d response", resp.StatusCode)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read crawl from coordinator: %s", err)
+		}
+		return url.Parse(crawl.URL)
+	}
+}
+
+// Work fetches tasks until the crawl is complete, returning an error if the
+// coordinator is lost before then.
+func (w *Worker) Work() error {
+	tasks := w.Coordinator.ResolveReference(&url.URL{Path: "tasks"})
+	contact := time.Now()
+	retry := w.Retry
+	for {
+		resp, err := w.do("GET", tasks.String(), nil)
+		if err != nil {
+			if w.GiveUp > 0 && time.Since(contact) > w.GiveUp {
+				return fmt.Errorf("Lost the coordinator for %s: %s", w.GiveUp, err)
+			}
+			logger.Warn("Failed to reach coordinator", "url", w.Coordinator, "error", err, "retry", retry)
+			time.Sleep(retry)
+			retry = w.backoff(retry)
+			continue
+		}
+		contact = time.Now()
+		retry = w.Retry
+		var remote RemoteTask
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.NewDecoder(resp.Body).Decode(&remote)
+		case http.StatusNoContent:
+			resp.Body.Close()
+			continue
+		case http.StatusGone:
+			resp.Body.Close()
+			return nil
+		default:
+			err = fmt.Errorf("Unexpected %d response", resp.StatusCode)
+		}
+		resp.Body.Close()
+		if err != nil {
+			logger.Warn("Failed to get task from coordinator", "error", err)
+			time.Sleep(w.Retry)
+			continue
+		}
+
+		task, err := remote.Task()
+		if err != nil {
+			logger.Warn("Ignoring invalid task", "url", remote.URL, "error", err)
+			continue
+		}
+		page := w.Fetcher.Fetch(task)
+		if err := w.send(tasks, remote.ID, page); err != nil {
+			logger.Warn("Failed to send page to coordinator", "url", task.URL, "error", err)
+		}
+	}
+}
+
+// backoff returns the time to wait after the next failure to reach the
+// coordinator.
+func (w *Worker) backoff(retry time.Duration) time.Duration {
+	retry *= 2
+	if w.MaxRetry > 0 && retry > w.MaxRetry {
+		retry = w.MaxRetry
+	}
+	return retry
+}
+
+// send returns the page fetched for the task to the coordinator.
+func (w *Worker) send(tasks *url.URL, id int, page Page) error {
+	body, err := json.Marshal(NewRemotePage(page))
+	if err != nil {
+		return err
+	}
+	resp, err := w.do("POST", fmt.Sprintf("%s/%d", tasks, id), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Unexpected %d response", resp.StatusCode)
+	}
+	return nil
+}
+
+// do makes a request of the coordinator, presenting the token.
+func (w *Worker) do(method, target string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	}
+	return w.Client.Do(req)
+}
+
+// newWorkerCommand returns the command which fetches and parses pages on
+// behalf of a coordinating crawl.
+func newWorkerCommand(opts *CrawlOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "worker COORDINATOR",
+		Short: "Fetch and parse pages for a crawl run with --coordinate elsewhere.",
+		Long: `Fetch and parse pages for a crawl run with --coordinate elsewhere, taking
+tasks from the coordinator at the COORDINATOR URL on --connections connections
+until its crawl is complete. The coordinator keeps the frontier and the seen
+pages, and paces the requests, so that very large sites can be crawled across
+many machines, each running a worker. Give the worker the same
+--coordinate-token as the coordinator.`,
+	}
+	var wait time.Duration
+	cmd.Flags().DurationVarP(&wait, "wait", "", 5*time.Minute, "How long to wait for the coordinator to start before giving up.")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		coordinator, err := parseURLArg(args)
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(coordinator.Path, "/") {
+			coordinator.Path += "/"
+		}
+		if opts.NumConns < 1 {
+			return errors.New("--connections must be at least 1.")
+		}
+
+		worker := &Worker{Coordinator: coordinator, Client: &http.Client{Timeout: time.Minute}, Token: opts.CoordinateToken, Retry: time.Second, MaxRetry: 30 * time.Second, Wait: wait, GiveUp: 15 * time.Second}
+		site, err := worker.Site()
+		if err != nil {
+			return err
+		}
+		transport, err := newSiteTransport(site, *opts)
+		if err != nil {
+			return err
+		}
+		jar, err := newCookieJar(site, *opts)
+		if err != nil {
+			return err
+		}
+		// The coordinator's reporters may need any of the details of pages.
+		parseOpts := *opts
+		parseOpts.CollectImages, parseOpts.CollectForms, parseOpts.CollectContacts = true, true, true
+		parseOpts.CollectSchemas, parseOpts.CollectSocial, parseOpts.CollectIcons = true, true, true
+		parser, err := newPageParser(parseOpts)
+		if err != nil {
+			return err
+		}
+		worker.Fetcher = &HTTPFetcher{
+			Client:      &http.Client{Transport: transport, CheckRedirect: checkRedirect, Jar: jar},
+			Parser:      parser,
+			HeadFirst:   opts.HeadFirst,
+			SnippetSize: opts.ErrorSnippet,
+		}
+
+		logger.Info("Working for coordinator", "url", coordinator, "site", site, "connections", opts.NumConns)
+		errs := make(chan error, opts.NumConns)
+		for i := 0; i < opts.NumConns; i++ {
+			go func() {
+				errs <- worker.Work()
+			}()
+		}
+		for i := 0; i < opts.NumConns; i++ {
+			if workErr := <-errs; workErr != nil && err == nil {
+				err = workErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+		logger.Info("Crawl complete")
+		return nil
+	}
+
+	return cmd
+}
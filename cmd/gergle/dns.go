@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSDialer resolves hostnames using its own Resolver, within a timeout,
+// before connecting to the resolved addresses.
+type DNSDialer struct {
+	Dialer   *net.Dialer
+	Resolver *net.Resolver
+	Timeout  time.Duration
+}
+
+// NewDNSDialer returns a DNSDialer querying the DNS server at the given
+// host:port, or the system's configured servers if none is given.
+func NewDNSDialer(dialer *net.Dialer, server string, timeout time.Duration) *DNSDialer {
+	resolver := net.DefaultResolver
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return &DNSDialer{Dialer: dialer, Resolver: resolver, Timeout: timeout}
+}
+
+func (d *DNSDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return d.Dialer.DialContext(ctx, network, addr)
+	}
+
+	lookupCtx := ctx
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		lookupCtx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	ipNetwork := "ip"
+	switch network {
+	case "tcp4":
+		ipNetwork = "ip4"
+	case "tcp6":
+		ipNetwork = "ip6"
+	}
+	ips, err := d.Resolver.LookupIP(lookupCtx, ipNetwork, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = d.Dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// classifyDNSError describes the kind of DNS failure which caused the error,
+// or returns the empty string if the error wasn't caused by DNS.
+func classifyDNSError(err error) string {
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		return ""
+	}
+	switch {
+	case dnsErr.IsNotFound:
+		return "NXDOMAIN"
+	case dnsErr.IsTimeout:
+		return "timeout"
+	case strings.Contains(dnsErr.Err, "server misbehaving"):
+		return "SERVFAIL"
+	}
+	return "error"
+}
+
+// DNSReporter counts the DNS failures of each host, by kind.
+type DNSReporter struct {
+	failures map[string]map[string]int
+	lock     sync.Mutex
+}
+
+func NewDNSReporter() *DNSReporter {
+	return &DNSReporter{failures: make(map[string]map[string]int)}
+}
+
+func (d *DNSReporter) Observe(page Page) {
+	if page.Error == nil {
+		return
+	}
+	kind := classifyDNSError(*page.Error)
+	if kind == "" {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	host := page.URL.Hostname()
+	if d.failures[host] == nil {
+		d.failures[host] = make(map[string]int)
+	}
+	d.failures[host][kind]++
+}
+
+func (d *DNSReporter) Report(w io.Writer) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	hosts := make([]string, 0, len(d.failures))
+	for host := range d.failures {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	fmt.Fprintf(w, "DNS failures: %d hosts\n", len(hosts))
+	for _, host := range hosts {
+		kinds := make([]string, 0, len(d.failures[host]))
+		for kind, count := range d.failures[host] {
+			kinds = append(kinds, fmt.Sprintf("%s: %d", kind, count))
+		}
+		sort.Strings(kinds)
+		fmt.Fprintf(w, "- %s (%s)\n", host, strings.Join(kinds, ", "))
+	}
+}
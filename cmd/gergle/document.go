@@ -0,0 +1,179 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// maxDocumentStream caps the decompressed size of each stream of a PDF which
+// is searched for links.
+const maxDocumentStream = 16 << 20
+
+// isDocument reports whether responses of the Content-Type are PDF or Office
+// Open XML documents, which links can be extracted from.
+func isDocument(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.Contains(contentType, "application/pdf") ||
+		strings.Contains(contentType, "application/vnd.openxmlformats-officedocument.")
+}
+
+// parseDocument returns the Page describing a PDF or Office document, linking
+// to each of the URLs embedded within it.
+func parseDocument(task *Task, resp *http.Response, body []byte) Page {
+	page := Page{
+		URL:       task.URL,
+		Checksum:  fmt.Sprintf("%x", sha1.Sum(body)),
+		Processed: true,
+		Depth:     task.Depth,
+		Size:      len(body),
+		Links:     []*Link{},
+		Assets:    []*Link{},
+		Endpoints: []*Link{},
+	}
+
+	var hrefs []string
+	if bytes.HasPrefix(body, []byte("%PDF")) {
+		hrefs = pdfLinks(body)
+	} else {
+		hrefs = officeLinks(body)
+	}
+	for _, href := range hrefs {
+		link, err := AssetLink("document", href, resp.Request.URL, task.Depth+1)
+		if err != nil {
+			logger.Debug("Failed to parse document link", "href", href)
+			continue
+		}
+		if !link.IsHTTP() {
+			continue
+		}
+		page.Links = append(page.Links, link)
+	}
+	return page
+}
+
+var (
+	pdfURIRegex    = regexp.MustCompile(`/URI\s*(?:\(((?:\\.|[^\\)])*)\)|<([0-9A-Fa-f\s]*)>)`)
+	pdfStreamRegex = regexp.MustCompile(`(?s)stream\r?\n(.*?)endstream`)
+)
+
+// pdfLinks returns the URIs of the link actions of a PDF, found both in its
+// plain objects and its compressed streams.
+func pdfLinks(body []byte) (hrefs []string) {
+	sections := [][]byte{body}
+	for _, stream := range pdfStreamRegex.FindAllSubmatch(body, -1) {
+		reader, err := zlib.NewReader(bytes.NewReader(stream[1]))
+		if err != nil {
+			continue
+		}
+		// The end-of-line marker before endstream trips up the decompressor,
+		// so keep whatever was decompressed before the error.
+		decompressed, _ := ioutil.ReadAll(io.LimitReader(reader, maxDocumentStream))
+		reader.Close()
+		sections = append(sections, decompressed)
+	}
+
+	seen := make(map[string]bool)
+	for _, section := range sections {
+		for _, match := range pdfURIRegex.FindAllSubmatch(section, -1) {
+			var href string
+			if match[2] != nil {
+				href = pdfHexString(match[2])
+			} else {
+				href = pdfLiteralString(match[1])
+			}
+			href = strings.TrimSpace(href)
+			if href != "" && !seen[href] {
+				seen[href] = true
+				hrefs = append(hrefs, href)
+			}
+		}
+	}
+	return
+}
+
+// pdfLiteralString decodes the escape sequences of a PDF (literal string).
+func pdfLiteralString(s []byte) string {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case '\r', '\n':
+			// An escaped newline continues the string on the next line.
+		default:
+			out = append(out, s[i])
+		}
+	}
+	return string(out)
+}
+
+// pdfHexString decodes a PDF <hexadecimal string>, whose last digit may be
+// omitted when zero.
+func pdfHexString(s []byte) string {
+	digits := strings.Join(strings.Fields(string(s)), "")
+	if len(digits)%2 == 1 {
+		digits += "0"
+	}
+	decoded, err := hex.DecodeString(digits)
+	if err != nil {
+		return ""
+	}
+	return string(decoded)
+}
+
+// officeLinks returns the targets of the external relationships of an Office
+// Open XML document, such as the hyperlinks of a .docx.
+func officeLinks(body []byte) (hrefs []string) {
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for _, file := range archive.File {
+		if !strings.HasSuffix(file.Name, ".rels") {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			continue
+		}
+		var rels struct {
+			Relationships []struct {
+				Target     string `xml:",attr"`
+				TargetMode string `xml:",attr"`
+			} `xml:"Relationship"`
+		}
+		err = xml.NewDecoder(io.LimitReader(reader, maxDocumentStream)).Decode(&rels)
+		reader.Close()
+		if err != nil {
+			continue
+		}
+		for _, rel := range rels.Relationships {
+			if rel.TargetMode == "External" && !seen[rel.Target] {
+				seen[rel.Target] = true
+				hrefs = append(hrefs, rel.Target)
+			}
+		}
+	}
+	return
+}
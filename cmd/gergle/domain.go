@@ -1,45 +1,279 @@
 package main
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
 	"net/url"
+	"regexp"
+	"strings"
+	"time"
 )
 
 // A pending Task for crawl workers to complete.
 type Task struct {
-	URL   *url.URL
-	Depth uint16
+	URL      *url.URL
+	Depth    uint16
+	Referrer *url.URL
+	Frame    bool
+	// Pagination is the number of rel="next" or rel="prev" links followed in
+	// a row to reach the task.
+	Pagination uint16
+	// Unfollowed is the rule which declined to follow the task's link, for
+	// tasks only requested for their status.
+	Unfollowed string
 }
 
-// The Task for following a Link.
-func LinkTask(link *Link) Task {
-	return Task{URL: link.URL, Depth: link.Depth}
+// The Task for following a Link found on the referrer page.
+func LinkTask(link *Link, referrer *url.URL) Task {
+	return Task{
+		URL:        link.URL,
+		Depth:      link.Depth,
+		Referrer:   referrer,
+		Frame:      link.IsFrame(),
+		Pagination: link.Pagination,
+	}
 }
 
 // A Page the crawler has scraped and parsed.
 type Page struct {
-	URL       *url.URL
-	Processed bool
-	Depth     uint16
-	Links     []*Link
-	Assets    []*Link
-	Error     *error
+	URL        *url.URL
+	StatusCode int
+	Header     http.Header
+	MediaType  string
+	Language   string
+	Template   string
+	Schemas    []string
+	Social     map[string]string
+	Checksum   string
+	Redirects  []*url.URL
+	Size       int
+	TTFB       time.Duration
+	Processed  bool
+	Depth      uint16
+	Links      []*Link
+	Assets     []*Link
+	Endpoints  []*Link
+	Alternates []*Alternate
+	Images     []*Image
+	Forms      []*Form
+	Contacts   []*Link
+	NonHTTP    []*Link
+	Icons      []*url.URL
+	Manifest   *url.URL
+	Next       *url.URL
+	Prev       *url.URL
+	Text       string
+	Matches    map[string]int
+	Referrers  []*url.URL
+	Frame      bool
+	NoIndex    bool
+	NoFollow   bool
+	Unchanged  bool
+	// Unfollowed is the rule which declined to follow the page's link, if it
+	// was only requested for its status.
+	Unfollowed string
+	TLS        *tls.ConnectionState
+	Error      *error
+	// Snippet is the start of the body of a 4xx or 5xx response, with the
+	// server's explanation of the error, if the fetcher was asked for it.
+	Snippet string
+
+	// Accessibility is only checked if the parser is asked to.
+	Accessibility *Accessibility
+	// Fragments are the sorted ids and <a name> targets of the page, only
+	// collected if the parser is asked to.
+	Fragments []string
+	// BadHrefs are the raw hrefs and srcs of the page which aren't validly
+	// encoded URLs, only checked if the parser is asked to.
+	BadHrefs []string
+}
+
+// Broken reports whether the page could not be fetched, or the server
+// responded with an error status.
+func (p *Page) Broken() bool {
+	if p.StatusCode == 0 {
+		return p.Error != nil
+	}
+	return p.StatusCode >= 400
 }
 
 func ErrorPage(pageURL *url.URL, depth uint16, err error) Page {
-	return Page{pageURL, false, depth, []*Link{}, []*Link{}, &err}
+	return Page{
+		URL:       pageURL,
+		Processed: false,
+		Depth:     depth,
+		Links:     []*Link{},
+		Assets:    []*Link{},
+		Endpoints: []*Link{},
+		Error:     &err,
+	}
+}
+
+// An Alternate version of a page, such as a translation or mobile version.
+type Alternate struct {
+	URL      *url.URL
+	HrefLang string
+	Media    string
+	AMP      bool
+}
+
+// IsMobile reports whether the alternate is an AMP or mobile-specific version
+// of its page.
+func (a *Alternate) IsMobile() bool {
+	return a.AMP || a.Media != ""
+}
+
+// Type describes the kind of alternate for output.
+func (a *Alternate) Type() string {
+	switch {
+	case a.AMP:
+		return "amphtml"
+	case a.Media != "":
+		return "mobile"
+	}
+	return "hreflang"
+}
+
+// Link returns the Link for following the alternate from its page.
+func (a *Alternate) Link(page *url.URL, depth uint16) *Link {
+	return &Link{
+		Type:     a.Type(),
+		URL:      a.URL,
+		External: isExternal(a.URL, page),
+		Depth:    depth,
+	}
+}
+
+// An Image displayed by an <img> tag on a page.
+type Image struct {
+	URL    *url.URL
+	Alt    string
+	HasAlt bool
+}
+
+// Accessibility counts the accessibility problems found on a page.
+type Accessibility struct {
+	// MissingAlt is the number of images without alt attributes.
+	MissingAlt int `json:"missing_alt"`
+	// EmptyLinks is the number of links without text or a label.
+	EmptyLinks int `json:"empty_links"`
+	// MissingLang is whether the <html> element lacks a lang attribute.
+	MissingLang bool `json:"missing_lang"`
+}
+
+// Problems describes each of the problems found.
+func (a *Accessibility) Problems() (problems []string) {
+	if a.MissingAlt > 0 {
+		problems = append(problems, fmt.Sprintf("%d images missing alt text", a.MissingAlt))
+	}
+	if a.EmptyLinks > 0 {
+		problems = append(problems, fmt.Sprintf("%d links without text", a.EmptyLinks))
+	}
+	if a.MissingLang {
+		problems = append(problems, "missing lang attribute")
+	}
+	return
+}
+
+// A Form on a page, submitting its named inputs to the action URL.
+type Form struct {
+	Action *url.URL
+	Method string
+	Inputs []string
+}
+
+// Link returns the Link for following the form's action from its page, as
+// if submitted without any input.
+func (f *Form) Link(page *url.URL, depth uint16) *Link {
+	return &Link{
+		Type:     "form",
+		URL:      f.Action,
+		External: isExternal(f.Action, page),
+		Depth:    depth,
+	}
 }
 
 // A link on a page to another resource.
 type Link struct {
-	Type     string
-	URL      *url.URL
-	External bool
-	Depth    uint16
+	Type       string
+	URL        *url.URL
+	External   bool
+	Depth      uint16
+	Pagination uint16
+	// Region is the part of the page an anchor was found in: "nav",
+	// "header", "footer" or "aside" for boilerplate, or else "content".
+	Region string
+}
+
+// IsBoilerplate reports whether the link was found in the navigation, header,
+// footer or sidebar of its page, which are repeated across pages.
+func (l *Link) IsBoilerplate() bool {
+	return l.Region != "" && l.Region != "content"
+}
+
+// IsFrame reports whether the link is to a document displayed within a
+// <frame> or <iframe> of its page.
+func (l *Link) IsFrame() bool {
+	return l.Type == "frame" || l.Type == "iframe"
+}
+
+// IsContact reports whether the link is to a mailto: email address or tel:
+// phone number, rather than a resource to be fetched.
+func (l *Link) IsContact() bool {
+	return l.URL.Scheme == "mailto" || l.URL.Scheme == "tel"
 }
 
+// Contact returns the email address or phone number of a contact link.
+func (l *Link) Contact() string {
+	address := l.URL.Opaque
+	if address == "" {
+		address = strings.TrimPrefix(l.URL.Path, "//")
+	}
+	if unescaped, err := url.PathUnescape(address); err == nil {
+		address = unescaped
+	}
+	if l.URL.Scheme == "mailto" {
+		return strings.ToLower(address)
+	}
+	return address
+}
+
+// IsHTTP reports whether the link is to an http or https URL, which can be
+// crawled, rather than a javascript:, data:, ftp:, mailto: or other scheme.
+func (l *Link) IsHTTP() bool {
+	return l.URL.Scheme == "http" || l.URL.Scheme == "https"
+}
+
+var hrefSchemeRegex = regexp.MustCompile("^\\s*([a-zA-Z][a-zA-Z0-9+.-]*):")
+
+// opaqueSchemes are the schemes whose hrefs are code or content rather than
+// addresses, so aren't parsed as URLs.
+var opaqueSchemes = map[string]bool{"javascript": true, "data": true}
+
 // AnchorLink returns a Link object from an <a> href, according to the base URL.
+// Links to http and https URLs, including protocol-relative //host/path links,
+// are typed "anchor", and links with other schemes are typed by their scheme,
+// such as "javascript", "data", "ftp" or "mailto".
 func AnchorLink(href string, base *url.URL, depth uint16) (*Link, error) {
-	return AssetLink("anchor", href, base, depth)
+	if match := hrefSchemeRegex.FindStringSubmatch(href); match != nil && opaqueSchemes[strings.ToLower(match[1])] {
+		scheme := strings.ToLower(match[1])
+		return &Link{
+			Type:     scheme,
+			URL:      &url.URL{Scheme: scheme, Opaque: strings.TrimSpace(href)[len(match[1])+1:]},
+			External: true,
+			Depth:    depth,
+		}, nil
+	}
+
+	link, err := AssetLink("anchor", href, base, depth)
+	if err != nil {
+		return nil, err
+	}
+	if !link.IsHTTP() {
+		link.Type = link.URL.Scheme
+	}
+	return link, nil
 }
 
 // AssetLink returns a Link object describing a Page's dependency on another resource.
@@ -56,7 +290,7 @@ func AssetLink(assetType string, href string, base *url.URL, depth uint16) (*Lin
 	return &Link{
 		Type:     assetType,
 		URL:      hrefUrl,
-		External: hrefUrl.Scheme != base.Scheme || hrefUrl.Host != base.Host,
+		External: isExternal(hrefUrl, base),
 		Depth:    depth,
 	}, nil
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// offlineTransport fails every request, so that a dry run can't reach the
+// network.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("Not requesting %s in a dry run", req.URL)
+}
+
+// dryRunTransport returns the transport replaying a crawl from the snapshot
+// (.json) file at the path, or from the archive or directory of fixtures
+// served by newFileTransport. Snapshots are returned to be replayed by a
+// SnapshotFetcher, and the transport makes no requests.
+func dryRunTransport(path string) (http.RoundTripper, *Snapshot, error) {
+	if !strings.HasSuffix(path, ".json") {
+		transport, err := newFileTransport(path)
+		return transport, nil, err
+	}
+
+	snapshot, err := ReadSnapshot(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read snapshot %s: %s", path, err)
+	}
+	logger.Info("Replaying crawl from snapshot", "file", path, "pages", len(snapshot.Pages))
+	return offlineTransport{}, snapshot, nil
+}
+
+// SnapshotFetcher replays the pages of a Snapshot without making requests.
+// Snapshots don't record links, so the links of each page are taken to be the
+// pages which it referred to, in order of URL. Pages the snapshot's crawl
+// didn't fetch can't be reached.
+type SnapshotFetcher struct {
+	Snapshot *Snapshot
+	links    map[string][]string
+}
+
+func NewSnapshotFetcher(snapshot *Snapshot) *SnapshotFetcher {
+	links := make(map[string][]string)
+	for href, page := range snapshot.Pages {
+		for _, referrer := range page.Referrers {
+			links[referrer] = append(links[referrer], href)
+		}
+	}
+	for _, hrefs := range links {
+		sort.Strings(hrefs)
+	}
+	return &SnapshotFetcher{Snapshot: snapshot, links: links}
+}
+
+func (s *SnapshotFetcher) Fetch(task *Task) Page {
+	record, found := s.Snapshot.Pages[task.URL.String()]
+	if !found {
+		return ErrorPage(task.URL, task.Depth, errors.New("Not in snapshot"))
+	}
+
+	page := Page{
+		URL:        task.URL,
+		StatusCode: record.StatusCode,
+		Checksum:   record.Checksum,
+		Processed:  record.StatusCode == 200 && record.Error == "",
+		Depth:      task.Depth,
+	}
+	if record.Error != "" {
+		err := errors.New(record.Error)
+		page.Error = &err
+	}
+	for _, href := range record.Redirects {
+		if u, err := url.Parse(href); err == nil {
+			page.Redirects = append(page.Redirects, u)
+		}
+	}
+	for _, href := range s.links[task.URL.String()] {
+		link, err := AnchorLink(href, task.URL, task.Depth+1)
+		if err != nil {
+			continue
+		}
+		page.Links = append(page.Links, link)
+	}
+	return page
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	xhtml "golang.org/x/net/html"
+	"html"
+	"net/url"
+	"strings"
+)
+
+// An htmlElement is a start tag of an HTML page, with the region of the page it
+// was found in: "nav", "header", "footer" or "aside" for boilerplate, or else
+// "content".
+type htmlElement struct {
+	Tag    string
+	Attrs  map[string]string
+	Region string
+	// InForm is whether the element is within a <form>, whose inputs it may
+	// be.
+	InForm bool
+	// Text is the contents of <script> elements.
+	Text string
+}
+
+// An ElementParser finds the elements of HTML pages, as a more accurate
+// alternative to the regular expressions of RegexPageParser.
+type ElementParser interface {
+	Elements(body []byte) []htmlElement
+}
+
+// ParserBackends are the names of the backends accepted by --parser.
+var ParserBackends = []string{"regex", "tokenizer", "dom"}
+
+// NewElementParser returns the ElementParser of the named backend, or nil for
+// the regex backend.
+func NewElementParser(backend string) (ElementParser, error) {
+	switch backend {
+	case "", "regex":
+		return nil, nil
+	case "tokenizer":
+		return &TokenizerParser{}, nil
+	case "dom":
+		return &DOMParser{}, nil
+	}
+	return nil, fmt.Errorf("Expected --parser of %s, got %q.", strings.Join(ParserBackends, ", "), backend)
+}
+
+// An htmlToken is a start or end tag of a page.
+type htmlToken struct {
+	Tag   string
+	Attrs map[string]string
+	End   bool
+}
+
+// rawTextElements contain text rather than markup, up to their end tag.
+var rawTextElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// tokenizeHTML calls fn with each start and end tag of the page, skipping
+// comments, doctypes and the contents of script and style elements, and
+// unescaping attribute values.
+func tokenizeHTML(body []byte, fn func(htmlToken)) {
+	for i := 0; i < len(body); {
+		lt := bytes.IndexByte(body[i:], '<')
+		if lt < 0 || i+lt+1 >= len(body) {
+			return
+		}
+		i += lt
+
+		switch c := body[i+1]; {
+		case bytes.HasPrefix(body[i:], []byte("<!--")):
+			end := bytes.Index(body[i+4:], []byte("-->"))
+			if end < 0 {
+				return
+			}
+			i += 4 + end + 3
+		case c == '!' || c == '?':
+			end := bytes.IndexByte(body[i:], '>')
+			if end < 0 {
+				return
+			}
+			i += end + 1
+		case c == '/' || isASCIILetter(c):
+			token, n := readTag(body[i:])
+			if n == 0 {
+				return
+			}
+			i += n
+			fn(token)
+			if !token.End && rawTextElements[token.Tag] {
+				end := indexEndTag(body[i:], token.Tag)
+				if end < 0 {
+					return
+				}
+				i += end
+			}
+		default:
+			i++
+		}
+	}
+}
+
+// indexEndTag returns the index of the first end tag of the element within the
+// body, or -1 if there is none.
+func indexEndTag(body []byte, tag string) int {
+	for i := 0; ; {
+		lt := bytes.Index(body[i:], []byte("</"))
+		if lt < 0 {
+			return -1
+		}
+		i += lt
+		if end := i + 2 + len(tag); end <= len(body) && strings.EqualFold(string(body[i+2:end]), tag) {
+			return i
+		}
+		i += 2
+	}
+}
+
+func isASCIILetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// readTag reads the start or end tag at the beginning of tag, returning its
+// length, or zero if it isn't terminated.
+func readTag(tag []byte) (token htmlToken, n int) {
+	i := 1
+	if tag[i] == '/' {
+		token.End = true
+		i++
+	}
+	start := i
+	for i < len(tag) && !isHTMLSpace(tag[i]) && tag[i] != '/' && tag[i] != '>' {
+		i++
+	}
+	token.Tag = strings.ToLower(string(tag[start:i]))
+	token.Attrs = make(map[string]string)
+
+	for i < len(tag) {
+		for i < len(tag) && (isHTMLSpace(tag[i]) || tag[i] == '/') {
+			i++
+		}
+		if i >= len(tag) {
+			break
+		}
+		if tag[i] == '>' {
+			return token, i + 1
+		}
+
+		start := i
+		for i < len(tag) && !isHTMLSpace(tag[i]) && tag[i] != '=' && tag[i] != '>' && tag[i] != '/' {
+			i++
+		}
+		name := strings.ToLower(string(tag[start:i]))
+		for i < len(tag) && isHTMLSpace(tag[i]) {
+			i++
+		}
+		value := ""
+		if i < len(tag) && tag[i] == '=' {
+			i++
+			for i < len(tag) && isHTMLSpace(tag[i]) {
+				i++
+			}
+			if i < len(tag) && (tag[i] == '"' || tag[i] == '\'') {
+				quote := tag[i]
+				end := bytes.IndexByte(tag[i+1:], quote)
+				if end < 0 {
+					break
+				}
+				value = string(tag[i+1 : i+1+end])
+				i += end + 2
+			} else {
+				start := i
+				for i < len(tag) && !isHTMLSpace(tag[i]) && tag[i] != '>' {
+					i++
+				}
+				value = string(tag[start:i])
+			}
+		}
+		if _, found := token.Attrs[name]; !found && name != "" {
+			token.Attrs[name] = html.UnescapeString(value)
+		}
+	}
+	return htmlToken{}, 0
+}
+
+// openElements tracks the elements a page's tags are nested within, closing
+// those left open by a parent's end tag.
+type openElements []string
+
+// Push opens the element of a start tag, unless it is void.
+func (o *openElements) Push(tag string) {
+	if !voidElements[tag] {
+		*o = append(*o, tag)
+	}
+}
+
+// Pop closes the innermost open element of the end tag, and any left open
+// within it, returning the number closed. End tags with no open element are
+// ignored.
+func (o *openElements) Pop(tag string) int {
+	for i := len(*o) - 1; i >= 0; i-- {
+		if (*o)[i] == tag {
+			closed := len(*o) - i
+			*o = (*o)[:i]
+			return closed
+		}
+	}
+	return 0
+}
+
+// TokenizerParser finds elements with the tokenizer of golang.org/x/net/html,
+// tracking the boilerplate elements they're nested within.
+type TokenizerParser struct{}
+
+func (t *TokenizerParser) Elements(body []byte) (elements []htmlElement) {
+	open := openElements{}
+	tokenizer := xhtml.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch tokenizer.Next() {
+		case xhtml.ErrorToken:
+			return
+		case xhtml.EndTagToken:
+			open.Pop(tokenizer.Token().Data)
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			token := tokenizer.Token()
+			inForm := containsString(open, "form")
+			if token.Type == xhtml.StartTagToken {
+				open.Push(token.Data)
+			}
+			elements = append(elements, htmlElement{Tag: token.Data, Attrs: elementAttrs(token.Attr), Region: innermostRegion(open), InForm: inForm})
+		case xhtml.TextToken:
+			if last := len(elements) - 1; last >= 0 && elements[last].Tag == "script" && len(open) > 0 && open[len(open)-1] == "script" {
+				elements[last].Text += string(tokenizer.Text())
+			}
+		}
+	}
+}
+
+// elementAttrs returns the attributes of an element by name, keeping the first
+// of any repeated, as browsers do.
+func elementAttrs(attrs []xhtml.Attribute) map[string]string {
+	byName := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		name := attr.Key
+		if attr.Namespace != "" {
+			name = attr.Namespace + ":" + attr.Key
+		}
+		if _, found := byName[name]; !found {
+			byName[name] = attr.Val
+		}
+	}
+	return byName
+}
+
+// innermostRegion returns the innermost boilerplate element of those open, or
+// "content" if there is none.
+func innermostRegion(open []string) string {
+	for i := len(open) - 1; i >= 0; i-- {
+		if boilerplateRegexes[open[i]] != nil {
+			return open[i]
+		}
+	}
+	return "content"
+}
+
+// nodeRegion returns the innermost boilerplate element containing the node of
+// the document tree, or "content" if there is none.
+func nodeRegion(n *xhtml.Node) string {
+	for ; n != nil; n = n.Parent {
+		if n.Type == xhtml.ElementNode && boilerplateRegexes[n.Data] != nil {
+			return n.Data
+		}
+	}
+	return "content"
+}
+
+// nodeWithin reports whether the node of the document tree is within an
+// element of the tag.
+func nodeWithin(n *xhtml.Node, tag string) bool {
+	for n = n.Parent; n != nil; n = n.Parent {
+		if n.Type == xhtml.ElementNode && n.Data == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// DOMParser finds elements by building the page's document tree with the
+// parser of golang.org/x/net/html, skipping the inert contents of <template>
+// elements.
+type DOMParser struct{}
+
+func (d *DOMParser) Elements(body []byte) (elements []htmlElement) {
+	root, err := xhtml.Parse(bytes.NewReader(body))
+	if err != nil {
+		logger.Debug("Failed to parse the document tree", "err", err)
+		return nil
+	}
+
+	var walk func(n *xhtml.Node)
+	walk = func(n *xhtml.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == xhtml.ElementNode {
+				element := htmlElement{Tag: child.Data, Attrs: elementAttrs(child.Attr), Region: nodeRegion(child), InForm: nodeWithin(child, "form")}
+				if child.Data == "script" && child.FirstChild != nil && child.FirstChild.Type == xhtml.TextNode {
+					element.Text = child.FirstChild.Data
+				}
+				elements = append(elements, element)
+				if child.Data == "template" {
+					continue
+				}
+			}
+			walk(child)
+		}
+	}
+	walk(root)
+	return
+}
+
+// elementDetails fills in the links, assets, frames, feeds, endpoints,
+// alternates, images, forms and contacts of the page from its elements, as
+// regexDetails does with the regular expressions.
+func (r *RegexPageParser) elementDetails(page *Page, elements []htmlElement, base *url.URL) {
+	depth := page.Depth + 1
+	tagRules := r.tagAssetRules()
+	var frames, feeds []*Link
+	var hrefs []string
+	var form *Form
+	for _, element := range elements {
+		attrs := element.Attrs
+		switch element.Tag {
+		case "a":
+			if href, found := attrs["href"]; found {
+				r.elementAnchor(page, href, element.Region, base)
+			}
+		case "link":
+			if alternate := parseAlternateAttrs(attrs, base); alternate != nil {
+				page.Alternates = append(page.Alternates, alternate)
+			}
+			if feed := parseFeedAttrs(attrs, base, depth); feed != nil {
+				feeds = append(feeds, feed)
+			}
+		case "frame", "iframe":
+			if src := attrs["src"]; src != "" {
+				frame, err := AssetLink(element.Tag, src, base, page.Depth)
+				if err != nil {
+					logger.Debug("Failed to parse frame source", "src", src)
+				} else {
+					frames = append(frames, frame)
+				}
+			}
+		case "img":
+			if r.CollectImages || r.CheckAccessibility {
+				if image := parseImageAttrs(attrs, base); image != nil {
+					page.Images = append(page.Images, image)
+				}
+			}
+		case "form":
+			form = nil
+			if r.CollectForms || r.FollowForms {
+				if form = parseFormAttrs(attrs, base); form != nil {
+					page.Forms = append(page.Forms, form)
+				}
+			}
+		case "input", "select", "textarea", "button":
+			if name := attrs["name"]; form != nil && element.InForm && name != "" {
+				form.Inputs = append(form.Inputs, name)
+			}
+		case "script":
+			if isJSONScript(attrs["type"]) {
+				var data interface{}
+				if err := json.Unmarshal([]byte(element.Text), &data); err != nil {
+					logger.Debug("Failed to parse inline JSON", "error", err)
+				} else {
+					hrefs = append(hrefs, jsonURLs(data)...)
+				}
+			}
+		}
+
+		if tagRules[element.Tag] != nil {
+			page.Assets = append(page.Assets, elementAssets(tagRules[element.Tag], element.Tag, attrs, base, depth)...)
+		}
+		for _, name := range []string{"data-href", "data-url"} {
+			if href, found := attrs[name]; found {
+				hrefs = append(hrefs, href)
+			}
+		}
+	}
+	page.Endpoints = endpointLinks(hrefs, base, depth)
+	page.Links = append(page.Links, frames...)
+	page.Links = append(page.Links, feeds...)
+}
+
+// elementAnchor adds the anchor link of the href to the page's links, or to
+// its non-HTTP links and contacts.
+func (r *RegexPageParser) elementAnchor(page *Page, href, region string, base *url.URL) {
+	link, err := AnchorLink(href, base, page.Depth+1)
+	if err != nil {
+		logger.Debug("Failed to parse href", "href", href)
+		return
+	}
+	if link.IsHTTP() {
+		link.Region = region
+		page.Links = append(page.Links, link)
+		return
+	}
+
+	link.Depth = 0
+	page.NonHTTP = append(page.NonHTTP, link)
+	if r.CollectContacts && link.IsContact() {
+		contact := *link
+		page.Contacts = append(page.Contacts, &contact)
+	}
+}
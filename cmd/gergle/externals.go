@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// CheckExternalLink requests the link, returning an error if it's dead. Links
+// are requested with HEAD, falling back to GET for servers which don't allow
+// it.
+func CheckExternalLink(client *http.Client, href string) error {
+	resp, err := client.Head(href)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, err = client.Get(href)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("Responded %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// externalDomain is the external links to a domain and the pages which
+// link to it.
+type externalDomain struct {
+	links map[string]bool
+	pages map[string]bool
+}
+
+// ExternalReporter aggregates the links to other sites by their domain,
+// counting the links to each and the pages linking to them, to show how much
+// of the site depends on each third party. If it has a Client, each external
+// link is requested once the crawl is complete, and the dead links are listed
+// along with the pages linking to them.
+type ExternalReporter struct {
+	Client *http.Client
+	// Workers is the number of links to check at once.
+	Workers int
+
+	domains   map[string]*externalDomain
+	referrers map[string]map[string]bool
+	lock      sync.Mutex
+}
+
+func NewExternalReporter(client *http.Client) *ExternalReporter {
+	return &ExternalReporter{
+		Client:    client,
+		Workers:   4,
+		domains:   make(map[string]*externalDomain),
+		referrers: make(map[string]map[string]bool),
+	}
+}
+
+func (e *ExternalReporter) Observe(page Page) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, link := range page.Links {
+		if !link.External || !link.IsHTTP() {
+			continue
+		}
+		domain := asciiHost(link.URL.Hostname())
+		if e.domains[domain] == nil {
+			e.domains[domain] = &externalDomain{make(map[string]bool), make(map[string]bool)}
+		}
+		u := *link.URL
+		u.Fragment = ""
+		href := u.String()
+		e.domains[domain].links[href] = true
+		e.domains[domain].pages[page.URL.String()] = true
+		if e.referrers[href] == nil {
+			e.referrers[href] = make(map[string]bool)
+		}
+		e.referrers[href][page.URL.String()] = true
+	}
+}
+
+// checkExternalLinks checks all of the links, returning the errors of those
+// which are dead, keyed by URL, checking the given number of links at once.
+func checkExternalLinks(client *http.Client, concurrency int, hrefs []string) map[string]error {
+	errs := make(map[string]error)
+	lock := sync.Mutex{}
+
+	probeEach(concurrency, len(hrefs), func(i int) {
+		logger.Debug("Checking external link", "url", hrefs[i])
+		if err := CheckExternalLink(client, hrefs[i]); err != nil {
+			lock.Lock()
+			errs[hrefs[i]] = err
+			lock.Unlock()
+		}
+	})
+	return errs
+}
+
+func (e *ExternalReporter) Report(w io.Writer) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	hrefs := make([]string, 0, len(e.referrers))
+	for href := range e.referrers {
+		hrefs = append(hrefs, href)
+	}
+	sort.Strings(hrefs)
+
+	var errs map[string]error
+	if e.Client != nil {
+		logger.Info("Checking external links", "count", len(hrefs))
+		errs = checkExternalLinks(e.Client, e.Workers, hrefs)
+	}
+
+	domains := make([]string, 0, len(e.domains))
+	for domain := range e.domains {
+		domains = append(domains, domain)
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		if len(e.domains[domains[i]].pages) != len(e.domains[domains[j]].pages) {
+			return len(e.domains[domains[i]].pages) > len(e.domains[domains[j]].pages)
+		}
+		return domains[i] < domains[j]
+	})
+
+	fmt.Fprintf(w, "External domains: %d domains, %d links\n", len(domains), len(hrefs))
+	for _, domain := range domains {
+		links, pages := e.domains[domain].links, e.domains[domain].pages
+		if e.Client == nil {
+			fmt.Fprintf(w, "- %s: %d links from %d pages\n", domain, len(links), len(pages))
+			continue
+		}
+		dead := 0
+		for href := range links {
+			if _, failed := errs[href]; failed {
+				dead++
+			}
+		}
+		fmt.Fprintf(w, "- %s: %d links from %d pages, %d dead\n", domain, len(links), len(pages), dead)
+	}
+
+	if e.Client == nil {
+		return
+	}
+	fmt.Fprintf(w, "Dead external links: %d links\n", len(errs))
+	for _, href := range hrefs {
+		err, failed := errs[href]
+		if !failed {
+			continue
+		}
+		fmt.Fprintf(w, "- %s: %s\n", href, err)
+		pages := make([]string, 0, len(e.referrers[href]))
+		for page := range e.referrers[href] {
+			pages = append(pages, page)
+		}
+		sort.Strings(pages)
+		for _, page := range pages {
+			fmt.Fprintf(w, "  - %s\n", page)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// isFeed reports whether the XML document is an RSS or Atom feed, judging by
+// its root element.
+func isFeed(body []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			switch strings.ToLower(start.Name.Local) {
+			case "rss", "feed", "rdf":
+				return true
+			}
+			return false
+		}
+	}
+}
+
+// parseFeed returns the Page describing an RSS or Atom feed, linking to each
+// of its entries.
+func parseFeed(task *Task, resp *http.Response, body []byte) Page {
+	page := Page{
+		URL:       task.URL,
+		Checksum:  fmt.Sprintf("%x", sha1.Sum(body)),
+		Processed: true,
+		Depth:     task.Depth,
+		Links:     []*Link{},
+		Assets:    []*Link{},
+		Endpoints: []*Link{},
+	}
+
+	for _, href := range feedLinks(body) {
+		link, err := AssetLink("entry", href, resp.Request.URL, task.Depth+1)
+		if err != nil {
+			logger.Debug("Failed to parse feed link", "href", href)
+			continue
+		}
+		page.Links = append(page.Links, link)
+	}
+	return page
+}
+
+// feedLinks returns the hrefs of the <link> elements within a feed: the text
+// of RSS links, and the href of Atom links to alternate representations.
+func feedLinks(body []byte) (hrefs []string) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+
+	inLink := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			logger.Debug("Failed to parse feed", "error", err)
+			return
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "link" {
+				continue
+			}
+			href, rel := "", ""
+			for _, attr := range t.Attr {
+				switch attr.Name.Local {
+				case "href":
+					href = attr.Value
+				case "rel":
+					rel = attr.Value
+				}
+			}
+			if href == "" {
+				inLink = true
+			} else if rel == "" || rel == "alternate" {
+				hrefs = append(hrefs, href)
+			}
+		case xml.CharData:
+			if inLink {
+				if href := strings.TrimSpace(string(t)); href != "" {
+					hrefs = append(hrefs, href)
+				}
+			}
+		case xml.EndElement:
+			inLink = false
+		}
+	}
+}
@@ -2,7 +2,14 @@ package main
 
 import (
 	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -13,22 +20,124 @@ type Fetcher interface {
 type HTTPFetcher struct {
 	Client *http.Client
 	Parser ResponsePageParser
+	// HeadFirst has each URL requested with HEAD first, and only fetched with
+	// GET if it looks like a page which can be parsed.
+	HeadFirst bool
+	// SnippetSize is the number of bytes of the bodies of 4xx and 5xx
+	// responses to keep as the Snippet of their pages.
+	SnippetSize int
 }
 
 func (h *HTTPFetcher) Fetch(task *Task) Page {
-	resp, err := h.Client.Get(task.URL.String())
-	if err != nil {
-		return ErrorPage(task.URL, task.Depth, err)
+	if task.Unfollowed != "" {
+		return h.check(task)
+	}
+	if h.HeadFirst {
+		resp, ttfb, err := h.request("HEAD", task)
+		if err != nil {
+			return errorResponsePage(task, resp, err)
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+			logger.Debug("HEAD not supported", "url", task.URL, "status", resp.StatusCode)
+		case resp.StatusCode >= 400 && h.SnippetSize > 0:
+			// The error's explanation is in the body of the GET response.
+		case resp.StatusCode != http.StatusOK || !parses(h.Parser, resp.Header.Get("Content-Type")):
+			// The parser won't read the body of responses it can't parse.
+			return h.page(task, resp, ttfb)
+		}
 	}
 
+	resp, ttfb, err := h.request("GET", task)
+	if err != nil {
+		return errorResponsePage(task, resp, err)
+	}
 	defer resp.Body.Close()
-	return h.Parser.Parse(task, resp)
+	return h.page(task, resp, ttfb)
+}
+
+// check requests the task's URL for its status alone, with HEAD, or GET for
+// servers which don't allow it, without parsing the response.
+func (h *HTTPFetcher) check(task *Task) Page {
+	resp, ttfb, err := h.request("HEAD", task)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, ttfb, err = h.request("GET", task)
+	}
+	if err != nil {
+		return errorResponsePage(task, resp, err)
+	}
+	resp.Body.Close()
+
+	page := Page{URL: task.URL, Depth: task.Depth, Links: []*Link{}, Assets: []*Link{}, Endpoints: []*Link{}}
+	page.Redirects = redirectChain(resp)
+	page.StatusCode = resp.StatusCode
+	page.Header = resp.Header
+	page.MediaType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	page.TLS = resp.TLS
+	page.TTFB = ttfb
+	return page
+}
+
+// request makes a request for the task's URL, timing the first byte of the
+// final response, after any redirects.
+func (h *HTTPFetcher) request(method string, task *Task) (*http.Response, time.Duration, error) {
+	req, err := http.NewRequest(method, task.URL.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var start time.Time
+	var ttfb time.Duration
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GetConn:              func(string) { start = time.Now() },
+		GotFirstResponseByte: func() { ttfb = time.Since(start) },
+	}))
+
+	resp, err := h.Client.Do(req)
+	return resp, ttfb, err
+}
+
+// page parses the response into the Page of the task.
+func (h *HTTPFetcher) page(task *Task, resp *http.Response, ttfb time.Duration) Page {
+	var snippet []byte
+	if resp.StatusCode >= 400 && h.SnippetSize > 0 {
+		// The parser doesn't read the bodies of error responses.
+		snippet, _ = ioutil.ReadAll(io.LimitReader(resp.Body, int64(h.SnippetSize)))
+	}
+	page := h.Parser.Parse(task, resp)
+	page.Snippet = strings.TrimSpace(strings.ToValidUTF8(string(snippet), ""))
+	page.Redirects = redirectChain(resp)
+	page.StatusCode = resp.StatusCode
+	page.Header = resp.Header
+	page.MediaType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	page.TLS = resp.TLS
+	page.TTFB = ttfb
+	return page
+}
+
+// errorResponsePage returns the Page of a failed request.
+func errorResponsePage(task *Task, resp *http.Response, err error) Page {
+	page := ErrorPage(task.URL, task.Depth, err)
+	if resp != nil {
+		// The redirect policy stopped following redirects.
+		page.Redirects = redirectChain(resp)
+	}
+	return page
 }
 
 type Stopper interface {
 	Stop()
 }
 
+// A DelaySetter is a Fetcher whose delay between requests can be changed
+// while it is in use.
+type DelaySetter interface {
+	SetDelay(delay time.Duration)
+}
+
 type MockFetcher struct {
 	pages map[string]Page
 }
@@ -51,6 +160,30 @@ func NewMockFetcher(pages ...Page) *MockFetcher {
 	return fetcher
 }
 
+// A Semaphore holds a fixed number of slots, to be acquired before some
+// limited work and released after it.
+type Semaphore chan struct{}
+
+func NewSemaphore(slots int) Semaphore {
+	return make(Semaphore, slots)
+}
+
+func (s Semaphore) Acquire() { s <- struct{}{} }
+func (s Semaphore) Release() { <-s }
+
+// LimitedFetcher caps the number of fetches underway at once across all of
+// the fetchers sharing its Slots.
+type LimitedFetcher struct {
+	Slots   Semaphore
+	fetcher Fetcher
+}
+
+func (l *LimitedFetcher) Fetch(task *Task) Page {
+	l.Slots.Acquire()
+	defer l.Slots.Release()
+	return l.fetcher.Fetch(task)
+}
+
 type RateLimitedFetcher struct {
 	ticker  *time.Ticker
 	fetcher Fetcher
@@ -65,9 +198,198 @@ func (r *RateLimitedFetcher) Stop() {
 	r.ticker.Stop()
 }
 
+func (r *RateLimitedFetcher) SetDelay(delay time.Duration) {
+	r.ticker.Reset(delay)
+}
+
 func NewRateLimitedFetcher(delay time.Duration, fetcher Fetcher) *RateLimitedFetcher {
 	return &RateLimitedFetcher{
 		ticker:  time.NewTicker(delay),
 		fetcher: fetcher,
 	}
 }
+
+// AdaptiveRateLimitedFetcher spaces out requests like RateLimitedFetcher, but
+// slows down when the server responds 429 or 503, or its latency climbs, and
+// speeds back up towards MinDelay as it recovers. Retry-After headers pause
+// all requests for the time given.
+type AdaptiveRateLimitedFetcher struct {
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	fetcher Fetcher
+	delay   time.Duration
+	next    time.Time
+	latency time.Duration
+	lock    sync.Mutex
+}
+
+func NewAdaptiveRateLimitedFetcher(minDelay time.Duration, fetcher Fetcher) *AdaptiveRateLimitedFetcher {
+	return &AdaptiveRateLimitedFetcher{
+		MinDelay: minDelay,
+		MaxDelay: time.Minute,
+		fetcher:  fetcher,
+		delay:    minDelay,
+	}
+}
+
+func (a *AdaptiveRateLimitedFetcher) Fetch(task *Task) Page {
+	a.wait()
+	start := time.Now()
+	page := a.fetcher.Fetch(task)
+	a.adapt(&page, time.Since(start))
+	return page
+}
+
+// SetDelay changes the MinDelay, which the delay is immediately reset to.
+func (a *AdaptiveRateLimitedFetcher) SetDelay(delay time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.MinDelay = delay
+	a.delay = delay
+}
+
+// wait blocks until the request is next in line to be made.
+func (a *AdaptiveRateLimitedFetcher) wait() {
+	a.lock.Lock()
+	now := time.Now()
+	start := a.next
+	if start.Before(now) {
+		start = now
+	}
+	a.next = start.Add(a.delay)
+	a.lock.Unlock()
+
+	time.Sleep(start.Sub(now))
+}
+
+// adapt adjusts the delay between requests according to the server's
+// response.
+func (a *AdaptiveRateLimitedFetcher) adapt(page *Page, latency time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	switch {
+	case page.StatusCode == 429 || page.StatusCode == 503:
+		a.delay *= 2
+		if a.delay < 250*time.Millisecond {
+			a.delay = 250 * time.Millisecond
+		}
+		if retryAfter, ok := parseRetryAfter(page.Header.Get("Retry-After")); ok {
+			if resume := time.Now().Add(retryAfter); resume.After(a.next) {
+				a.next = resume
+			}
+		}
+		logger.Info("Server overloaded, slowing down", "status", page.StatusCode, "delay", a.delay)
+	case a.latency > 0 && latency > 2*a.latency:
+		a.delay = a.delay * 3 / 2
+		if a.delay < 100*time.Millisecond {
+			a.delay = 100 * time.Millisecond
+		}
+		logger.Debug("Latency climbing, slowing down", "latency", latency, "delay", a.delay)
+	default:
+		a.delay = a.delay * 9 / 10
+	}
+
+	if a.delay > a.MaxDelay {
+		a.delay = a.MaxDelay
+	} else if a.delay < a.MinDelay {
+		a.delay = a.MinDelay
+	}
+
+	// Track an exponentially-weighted moving average of latency.
+	if a.latency == 0 {
+		a.latency = latency
+	} else {
+		a.latency = (a.latency*4 + latency) / 5
+	}
+}
+
+// RetryAfterFetcher pauses requests for the time given by the Retry-After
+// header of 429 and 503 responses, so that every worker backs off together
+// rather than the others carrying on while one waits, and then requests the
+// page again. It pauses the whole crawl unless PerHost, when only requests to
+// the host which responded wait.
+type RetryAfterFetcher struct {
+	PerHost bool
+	// MaxWait caps the pause, for servers asking for more than the crawl can
+	// spare.
+	MaxWait time.Duration
+	// Attempts is the most times a page is requested before its 429 or 503
+	// response is given up on.
+	Attempts int
+	Fetcher  Fetcher
+
+	paused map[string]time.Time
+	lock   sync.Mutex
+}
+
+func NewRetryAfterFetcher(perHost bool, maxWait time.Duration, attempts int, fetcher Fetcher) *RetryAfterFetcher {
+	return &RetryAfterFetcher{PerHost: perHost, MaxWait: maxWait, Attempts: attempts, Fetcher: fetcher, paused: make(map[string]time.Time)}
+}
+
+func (r *RetryAfterFetcher) Fetch(task *Task) Page {
+	lane := ""
+	if r.PerHost {
+		lane = asciiHost(task.URL.Host)
+	}
+	for attempt := 1; ; attempt++ {
+		r.wait(lane)
+		page := r.Fetcher.Fetch(task)
+		if !r.pause(lane, task, &page) || attempt >= r.Attempts {
+			return page
+		}
+		logger.Info("Retrying after Retry-After", "url", task.URL, "status", page.StatusCode, "attempt", attempt+1)
+	}
+}
+
+// wait blocks until requests to the lane are no longer paused.
+func (r *RetryAfterFetcher) wait(lane string) {
+	for {
+		r.lock.Lock()
+		wait := r.paused[lane].Sub(time.Now())
+		r.lock.Unlock()
+		if wait <= 0 {
+			return
+		}
+		time.Sleep(wait)
+	}
+}
+
+// pause holds back requests to the lane for the Retry-After of 429 and 503
+// responses, returning whether the page asked to be retried.
+func (r *RetryAfterFetcher) pause(lane string, task *Task, page *Page) bool {
+	if page.StatusCode != 429 && page.StatusCode != 503 {
+		return false
+	}
+	retryAfter, ok := parseRetryAfter(page.Header.Get("Retry-After"))
+	if !ok || retryAfter <= 0 {
+		return false
+	}
+	if retryAfter > r.MaxWait {
+		retryAfter = r.MaxWait
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if resume := time.Now().Add(retryAfter); resume.After(r.paused[lane]) {
+		logger.Warn("Pausing requests for Retry-After", "host", task.URL.Host, "perHost", r.PerHost, "status", page.StatusCode, "wait", retryAfter)
+		r.paused[lane] = resume
+	}
+	return true
+}
+
+// parseRetryAfter reads a Retry-After header value, given either in seconds
+// or as an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, seconds >= 0
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(time.Now()), true
+	}
+	return 0, false
+}
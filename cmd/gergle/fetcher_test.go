@@ -0,0 +1,628 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveRateLimitedFetcher(t *testing.T) {
+	a := NewAdaptiveRateLimitedFetcher(100*time.Millisecond, NewMockFetcher())
+
+	a.adapt(&Page{StatusCode: 200}, 10*time.Millisecond)
+	if a.delay != 100*time.Millisecond {
+		t.Errorf("Expected delay to remain at its minimum, but got %s", a.delay)
+	}
+
+	a.adapt(&Page{StatusCode: 429, Header: http.Header{"Retry-After": {"5"}}}, 10*time.Millisecond)
+	if a.delay != 250*time.Millisecond {
+		t.Errorf("Expected delay to back off to 250ms, but got %s", a.delay)
+	}
+	if wait := a.next.Sub(time.Now()); wait < 4*time.Second || wait > 5*time.Second {
+		t.Errorf("Expected the next request to wait for Retry-After, but got %s", wait)
+	}
+
+	a.adapt(&Page{StatusCode: 503}, 10*time.Millisecond)
+	if a.delay != 500*time.Millisecond {
+		t.Errorf("Expected delay to back off to 500ms, but got %s", a.delay)
+	}
+
+	a.adapt(&Page{StatusCode: 200}, 100*time.Millisecond)
+	if a.delay != 750*time.Millisecond {
+		t.Errorf("Expected delay to increase with latency to 750ms, but got %s", a.delay)
+	}
+
+	for i := 0; i < 100; i++ {
+		a.adapt(&Page{StatusCode: 200}, a.latency)
+	}
+	if a.delay != a.MinDelay {
+		t.Errorf("Expected delay to recover to its minimum, but got %s", a.delay)
+	}
+}
+
+func TestCircuitBreakerFetcher(t *testing.T) {
+	ok, _ := url.Parse("http://example.com/ok")
+	down, _ := url.Parse("http://example.com/down")
+	breaker := NewCircuitBreaker(2, 50*time.Millisecond)
+	fetcher := &CircuitBreakerFetcher{breaker, NewMockFetcher(Page{URL: ok, StatusCode: 200})}
+
+	fetcher.Fetch(&Task{URL: down})
+	fetcher.Fetch(&Task{URL: ok})
+	fetcher.Fetch(&Task{URL: down})
+	if len(breaker.trips) != 0 {
+		t.Fatal("Expected a success to reset the count of consecutive failures")
+	}
+	fetcher.Fetch(&Task{URL: down})
+	if len(breaker.trips) != 1 {
+		t.Fatal("Expected the second consecutive failure to pause the host")
+	}
+
+	start := time.Now()
+	fetcher.Fetch(&Task{URL: down})
+	if wait := time.Since(start); wait < 40*time.Millisecond {
+		t.Errorf("Expected the request to wait out the cooldown, but it waited %s", wait)
+	}
+	if len(breaker.trips) != 2 {
+		t.Error("Expected a failure after the cooldown to pause the host again")
+	}
+
+	out := &bytes.Buffer{}
+	NewBreakerReporter(breaker).Report(out)
+	if !strings.HasPrefix(out.String(), "Circuit breaker: 2 pauses\n- example.com at ") || !strings.Contains(out.String(), "after 2 failures: Page not found") {
+		t.Errorf("Expected the pauses to be reported, but got %q", out.String())
+	}
+}
+
+func TestRetryAfterFetcher(t *testing.T) {
+	limited, _ := url.Parse("http://example.com/limited")
+	other, _ := url.Parse("http://example.com/other")
+	elsewhere, _ := url.Parse("http://example.org/")
+	mock := NewMockFetcher(
+		Page{URL: limited, StatusCode: 429, Header: http.Header{"Retry-After": {"60"}}},
+		Page{URL: other, StatusCode: 200},
+		Page{URL: elsewhere, StatusCode: 200},
+	)
+
+	timeFetch := func(fetcher Fetcher, u *url.URL) time.Duration {
+		start := time.Now()
+		fetcher.Fetch(&Task{URL: u})
+		return time.Since(start)
+	}
+
+	crawl := NewRetryAfterFetcher(false, 50*time.Millisecond, 1, mock)
+	timeFetch(crawl, limited)
+	if wait := timeFetch(crawl, elsewhere); wait < 40*time.Millisecond {
+		t.Errorf("Expected every host to wait out the Retry-After, but it waited %s", wait)
+	}
+
+	host := NewRetryAfterFetcher(true, 50*time.Millisecond, 1, mock)
+	timeFetch(host, limited)
+	if wait := timeFetch(host, elsewhere); wait >= 40*time.Millisecond {
+		t.Errorf("Expected other hosts not to wait out the Retry-After, but it waited %s", wait)
+	}
+	if wait := timeFetch(host, other); wait < 40*time.Millisecond {
+		t.Errorf("Expected the host to wait out the Retry-After, but it waited %s", wait)
+	}
+	if wait := timeFetch(host, other); wait >= 40*time.Millisecond {
+		t.Errorf("Expected the pause to be over, but it waited %s", wait)
+	}
+}
+
+func TestRetryAfterFetcherRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/busy" || requests == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>Done</p>"))
+	}))
+	defer server.Close()
+
+	fetcher := NewRetryAfterFetcher(false, 10*time.Millisecond, 3, &HTTPFetcher{Client: &http.Client{}, Parser: &RegexPageParser{}})
+	u, _ := url.Parse(server.URL + "/")
+	if page := fetcher.Fetch(&Task{URL: u}); page.StatusCode != 200 || requests != 2 {
+		t.Errorf("Expected the page to be fetched on the second attempt, but got %d after %d requests", page.StatusCode, requests)
+	}
+
+	requests = 0
+	u, _ = url.Parse(server.URL + "/busy")
+	if page := fetcher.Fetch(&Task{URL: u}); page.StatusCode != 503 || requests != 3 {
+		t.Errorf("Expected the 503 once 3 attempts were used up, but got %d after %d requests", page.StatusCode, requests)
+	}
+}
+
+func TestRetryAfterRateLimited(t *testing.T) {
+	requests := []time.Time{}
+	limited := false
+	lock := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests = append(requests, time.Now())
+		if r.URL.Path == "/a" && !limited {
+			limited = true
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/a">A</a> <a href="/b">B</a> <a href="/c">C</a>`)
+		}
+	}))
+	defer server.Close()
+
+	initUrl, _ := url.Parse(server.URL + "/")
+	opts := CrawlOptions{MaxDepth: 5, NumConns: 3, Delay: 0.1, RetryAfter: "crawl", MaxRetryAfter: time.Second, RetryAttempts: 3}
+	pages, _, err := startCrawl(initUrl, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for page := range pages {
+		if page.StatusCode != 200 {
+			t.Errorf("Expected %s to be fetched once the pause was over, but got %d", page.URL, page.StatusCode)
+		}
+	}
+
+	// Requests paused by the 429 carry on spaced by the delay, rather than
+	// all at once.
+	if len(requests) != 6 {
+		t.Fatalf("Expected 6 requests but got %d", len(requests))
+	}
+	for i := 1; i < len(requests); i++ {
+		if gap := requests[i].Sub(requests[i-1]); gap < 80*time.Millisecond {
+			t.Errorf("Expected requests to be spaced by the delay, but requests %d and %d were %s apart", i-1, i, gap)
+		}
+	}
+}
+
+func TestAutoConcurrencyFetcher(t *testing.T) {
+	a := NewAutoConcurrencyFetcher(100*time.Millisecond, 3, NewMockFetcher())
+	a.Window = 4
+	fast := func(n int) {
+		for i := 0; i < n; i++ {
+			a.record(10*time.Millisecond, false)
+		}
+	}
+
+	fast(4)
+	fast(4)
+	fast(4)
+	if limit := a.Limit(); limit != 3 {
+		t.Errorf("Expected fast responses to ramp up to the maximum of 3, but got %d", limit)
+	}
+
+	fast(3)
+	a.record(time.Second, false)
+	if limit := a.Limit(); limit != 2 {
+		t.Errorf("Expected a slow p95 to cut back to 2, but got %d", limit)
+	}
+
+	fast(3)
+	a.record(10*time.Millisecond, true)
+	if limit := a.Limit(); limit != 1 {
+		t.Errorf("Expected errors to cut back to 1, but got %d", limit)
+	}
+	fast(3)
+	a.record(10*time.Millisecond, true)
+	if limit := a.Limit(); limit != 1 {
+		t.Errorf("Expected never to go below 1, but got %d", limit)
+	}
+}
+
+func TestCoordinator(t *testing.T) {
+	site, _ := url.Parse("http://example.com/")
+	about, _ := url.Parse("http://example.com/about")
+	coordinator := NewCoordinator(site, time.Minute)
+	coordinator.PollTimeout = 50 * time.Millisecond
+	coordinator.Token = "secret"
+	server := httptest.NewServer(coordinator)
+	defer server.Close()
+
+	remote, _ := url.Parse(server.URL + "/")
+	intruder := &Worker{Coordinator: remote, Client: server.Client(), Token: "wrong", Wait: 50 * time.Millisecond}
+	if _, err := intruder.Site(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected a worker with the wrong token to be refused, but got %v", err)
+	}
+
+	worker := &Worker{
+		Coordinator: remote,
+		Client:      server.Client(),
+		Token:       "secret",
+		Fetcher: NewMockFetcher(Page{
+			URL:        site,
+			StatusCode: 200,
+			Links:      []*Link{{Type: "a", URL: about}},
+		}),
+		Retry: 10 * time.Millisecond,
+	}
+	if u, err := worker.Site(); err != nil || u.String() != site.String() {
+		t.Fatalf("Expected the worker to crawl %s, but got %v (%v)", site, u, err)
+	}
+	done := make(chan struct{})
+	go func() {
+		if err := worker.Work(); err != nil {
+			t.Errorf("Expected the worker to finish with the crawl, but got %s", err)
+		}
+		close(done)
+	}()
+
+	page := coordinator.Fetch(&Task{URL: site})
+	if page.StatusCode != 200 || len(page.Links) != 1 || page.Links[0].URL.String() != about.String() {
+		t.Errorf("Expected the worker's page with its link, but got %+v", page)
+	}
+	page = coordinator.Fetch(&Task{URL: about, Depth: 1})
+	if page.Error == nil || page.Depth != 1 {
+		t.Errorf("Expected the worker's error page at depth 1, but got %+v", page)
+	}
+
+	coordinator.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected the worker to stop once the crawl is complete")
+	}
+}
+
+func TestCoordinatorForgetsTasks(t *testing.T) {
+	site, _ := url.Parse("http://example.com/")
+	coordinator := NewCoordinator(site, time.Minute)
+
+	// The task is handed to a worker which never sends its page back.
+	pages := make(chan Page)
+	go func() { pages <- coordinator.Fetch(&Task{URL: site}) }()
+	<-coordinator.queue
+
+	coordinator.Close()
+	if page := <-pages; page.Error == nil {
+		t.Errorf("Expected an error page once the coordinator closed, but got %+v", page)
+	}
+	coordinator.lock.Lock()
+	defer coordinator.lock.Unlock()
+	if len(coordinator.pending) != 0 {
+		t.Errorf("Expected no pending tasks once the coordinator closed, but got %d", len(coordinator.pending))
+	}
+}
+
+func TestWorkerGivesUp(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	remote, _ := url.Parse(server.URL + "/")
+	server.Close()
+
+	worker := &Worker{
+		Coordinator: remote,
+		Client:      &http.Client{},
+		Retry:       time.Millisecond,
+		MaxRetry:    10 * time.Millisecond,
+		Wait:        50 * time.Millisecond,
+		GiveUp:      50 * time.Millisecond,
+	}
+	if _, err := worker.Site(); err == nil {
+		t.Error("Expected Site to give up waiting for the coordinator")
+	}
+	if err := worker.Work(); err == nil {
+		t.Error("Expected Work to give up on the lost coordinator")
+	}
+}
+
+func TestRunPreflight(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /app\n")
+		case "/app":
+			fmt.Fprint(w, `<html><body><div id="root"></div><script src="/app.js"></script></body></html>`)
+		case "/private":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			fmt.Fprint(w, `<html><body><a href="/a">A</a> <a href="/b">B</a></body></html>`)
+		}
+	}))
+	defer server.Close()
+	client := server.Client()
+
+	home, _ := url.Parse(server.URL + "/")
+	preflight, err := RunPreflight(client, home, CrawlOptions{}, 3)
+	if err != nil {
+		t.Fatalf("Expected preflight of %s to pass: %s", home, err)
+	}
+	if len(preflight.Latencies) != 3 || len(preflight.Warnings) != 0 {
+		t.Errorf("Expected 3 requests without warnings, got %d requests and %v", len(preflight.Latencies), preflight.Warnings)
+	}
+
+	app, _ := url.Parse(server.URL + "/app")
+	preflight, err = RunPreflight(client, app, CrawlOptions{}, 1)
+	if err != nil {
+		t.Fatalf("Expected preflight of %s to pass: %s", app, err)
+	}
+	expected := []string{
+		app.String() + " looks rendered by JavaScript (0 links in its HTML): only links in the HTML served are crawled",
+		"robots.txt disallows /app: its links won't be followed without --zero",
+	}
+	if !reflect.DeepEqual(preflight.Warnings, expected) {
+		t.Errorf("Expected warnings %v, got %v", expected, preflight.Warnings)
+	}
+
+	private, _ := url.Parse(server.URL + "/private")
+	if _, err := RunPreflight(client, private, CrawlOptions{BearerToken: "token"}, 1); err == nil || !strings.Contains(err.Error(), "rejected the credentials") {
+		t.Errorf("Expected the credentials to be rejected, got %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d, ok := parseRetryAfter("120"); !ok || d != 2*time.Minute {
+		t.Errorf("Expected Retry-After of 120 seconds to be 2m, but got %s", d)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if d, ok := parseRetryAfter(date); !ok || d < 59*time.Minute || d > time.Hour {
+		t.Errorf("Expected Retry-After of %s to be about 1h, but got %s", date, d)
+	}
+	for _, value := range []string{"", "soon", "-1"} {
+		if _, ok := parseRetryAfter(value); ok {
+			t.Errorf("Expected Retry-After of %q to be invalid", value)
+		}
+	}
+}
+
+func TestHTTPFetcherRedirects(t *testing.T) {
+	redirects := map[string]string{"/a": "/b", "/b": "/c", "/loop": "/back", "/back": "/loop"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if to, found := redirects[r.URL.Path]; found {
+			http.Redirect(w, r, to, http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>Done</p>"))
+	}))
+	defer server.Close()
+
+	fetcher := &HTTPFetcher{Client: &http.Client{CheckRedirect: checkRedirect}, Parser: &RegexPageParser{}}
+	fetch := func(path string) Page {
+		u, _ := url.Parse(server.URL + path)
+		return fetcher.Fetch(&Task{URL: u})
+	}
+
+	page := fetch("/a")
+	if chain := urlStrings(page.Redirects); len(chain) != 3 || chain[2] != server.URL+"/c" {
+		t.Errorf("Expected /a to redirect through /b to /c but got %v", chain)
+	}
+	if page.StatusCode != 200 {
+		t.Errorf("Expected the redirected page to be fetched but got %d", page.StatusCode)
+	}
+
+	if page := fetch("/c"); page.Redirects != nil {
+		t.Errorf("Expected no redirects for /c but got %v", page.Redirects)
+	}
+
+	page = fetch("/loop")
+	if page.Error == nil || !errors.Is(*page.Error, ErrRedirectLoop) {
+		t.Errorf("Expected a redirect loop error but got %v", page.Error)
+	}
+
+	r := NewRedirectReporter(1)
+	r.Observe(fetch("/a"))
+	r.Observe(page)
+	out := &bytes.Buffer{}
+	r.Report(out)
+	if !strings.Contains(out.String(), "Redirect loops: 1 pages\n") || !strings.Contains(out.String(), "/c (2 hops)\n") {
+		t.Errorf("Expected a loop and a chain to be reported but got %q", out.String())
+	}
+}
+
+func TestHTTPFetcherHeadFirst(t *testing.T) {
+	gets := map[string]int{}
+	lock := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			lock.Lock()
+			gets[r.URL.Path]++
+			lock.Unlock()
+		}
+		switch r.URL.Path {
+		case "/file.zip":
+			w.Header().Set("Content-Type", "application/zip")
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/no-head":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/page">Page</a>`))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/file.zip">File</a>`))
+		}
+	}))
+	defer server.Close()
+
+	fetcher := &HTTPFetcher{Client: http.DefaultClient, Parser: &RegexPageParser{}, HeadFirst: true}
+	for _, test := range []struct {
+		path       string
+		unfollowed string
+		status     int
+		processed  bool
+		gets       int
+	}{
+		{"/page", "", 200, true, 1},
+		{"/file.zip", "", 200, false, 0},
+		{"/missing", "", 404, false, 0},
+		{"/no-head", "", 200, true, 1},
+		{"/page", "depth", 200, false, 1},
+		{"/no-head", "depth", 200, false, 2},
+	} {
+		u, _ := url.Parse(server.URL + test.path)
+		page := fetcher.Fetch(&Task{URL: u, Unfollowed: test.unfollowed})
+		lock.Lock()
+		if page.StatusCode != test.status || page.Processed != test.processed || gets[test.path] != test.gets {
+			t.Errorf("Expected %s to be %d (processed: %t) after %d GETs, but got %d (processed: %t) after %d GETs",
+				test.path, test.status, test.processed, test.gets, page.StatusCode, page.Processed, gets[test.path])
+		}
+		lock.Unlock()
+	}
+}
+
+func TestHTTPFetcherSnippet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("\n  Database connection pool exhausted, try again later.\n"))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/error">Error</a>`))
+		}
+	}))
+	defer server.Close()
+
+	for _, headFirst := range []bool{false, true} {
+		fetcher := &HTTPFetcher{Client: http.DefaultClient, Parser: &RegexPageParser{}, HeadFirst: headFirst, SnippetSize: 27}
+		u, _ := url.Parse(server.URL + "/error")
+		if page := fetcher.Fetch(&Task{URL: u}); page.StatusCode != 500 || page.Snippet != "Database connection pool" {
+			t.Errorf("Expected a 500 with the snippet %q (head first: %t) but got %d with %q", "Database connection pool", headFirst, page.StatusCode, page.Snippet)
+		}
+		u, _ = url.Parse(server.URL + "/")
+		if page := fetcher.Fetch(&Task{URL: u}); page.Snippet != "" || len(page.Links) != 1 {
+			t.Errorf("Expected a page without a snippet (head first: %t) but got %q", headFirst, page.Snippet)
+		}
+	}
+}
+
+func TestParsePool(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/file.zip" {
+			w.Header().Set("Content-Type", "application/zip")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="/a">A</a> <a href="/b">B</a>`))
+	}))
+	defer server.Close()
+
+	pool := NewParsePool(&RegexPageParser{}, 2)
+	defer pool.Stop()
+	fetcher := &HTTPFetcher{Client: http.DefaultClient, Parser: pool}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, _ := url.Parse(server.URL + "/page")
+			if page := fetcher.Fetch(&Task{URL: u}); !page.Processed || len(page.Links) != 2 {
+				t.Errorf("Expected the page to be parsed with 2 links, but got processed: %t with %d links", page.Processed, len(page.Links))
+			}
+		}()
+	}
+	wg.Wait()
+
+	u, _ := url.Parse(server.URL + "/file.zip")
+	if page := fetcher.Fetch(&Task{URL: u}); page.Processed {
+		t.Errorf("Expected %s not to be parsed", u)
+	}
+}
+
+func TestPageDetail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<link rel="stylesheet" href="/site.css"><img src="/a.png"><img src="/a.png"><script src="/missing.js"></script><a href="/other">Other</a>`))
+		case "/site.css", "/a.png":
+			w.Write([]byte("asset"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/")
+	fetcher := &HTTPFetcher{Client: http.DefaultClient, Parser: &RegexPageParser{AssetRules: pageDetailAssetRules}}
+	timings := PageDetail(fetcher, http.DefaultClient, u, 2)
+	actual := []string{}
+	for _, timing := range timings {
+		actual = append(actual, fmt.Sprintf("%d %s %s %d", timing.Status, timing.Type, timing.URL.Path, timing.Size))
+	}
+	expected := []string{"200 page / 137", "200 stylesheet /site.css 5", "200 img /a.png 5", "404 script /missing.js 19"}
+	if strings.Join(actual, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("Expected resources %q but got %q", expected, actual)
+	}
+
+	out := &bytes.Buffer{}
+	printWaterfall(out, timings)
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 5 || !strings.HasPrefix(lines[0], "Page detail: 4 resources, 166 bytes") {
+		t.Errorf("Expected a waterfall of 4 resources but got %q", out.String())
+	}
+}
+
+func TestArchiveTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/", http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="/old">Old</a>`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gergle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Record the site to a WARC file, as --warc would.
+	warcFile := filepath.Join(dir, "crawl.warc.gz")
+	warc, err := NewWARCWriter(warcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &HTTPFetcher{Client: &http.Client{Transport: &WARCTransport{http.DefaultTransport, warc}}, Parser: &RegexPageParser{}}
+	u, _ := url.Parse(server.URL + "/old")
+	recorder.Fetch(&Task{URL: u})
+	warc.Close()
+	server.Close()
+
+	har := filepath.Join(dir, "crawl.har")
+	ioutil.WriteFile(har, []byte(`{"log": {"entries": [
+		{"request": {"method": "GET", "url": "`+server.URL+`/old"},
+		 "response": {"status": 301, "statusText": "Moved Permanently", "headers": [{"name": "Location", "value": "/"}], "content": {"text": ""}}},
+		{"request": {"method": "GET", "url": "`+server.URL+`/"},
+		 "response": {"status": 200, "statusText": "OK", "headers": [{"name": "Content-Type", "value": "text/html"}, {"name": "Content-Encoding", "value": "gzip"}],
+		              "content": {"text": "PGEgaHJlZj0iL29sZCI+T2xkPC9hPg==", "encoding": "base64"}}}
+	]}}`), 0600)
+
+	for _, archive := range []string{warcFile, har} {
+		transport, err := newFileTransport(archive)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s", archive, err)
+		}
+		fetcher := &HTTPFetcher{Client: &http.Client{Transport: transport, CheckRedirect: checkRedirect}, Parser: &RegexPageParser{}, HeadFirst: true}
+		page := fetcher.Fetch(&Task{URL: u})
+		if page.StatusCode != 200 || len(page.Redirects) != 2 || len(page.Links) != 1 || page.Links[0].URL.String() != u.String() {
+			t.Errorf("Expected %s to replay the redirected page and its link, but got %d %v %v", filepath.Base(archive), page.StatusCode, page.Redirects, page.Links)
+		}
+
+		missing, _ := url.Parse(server.URL + "/missing")
+		if page := fetcher.Fetch(&Task{URL: missing}); page.Error == nil {
+			t.Errorf("Expected %s to have no response to %s", filepath.Base(archive), missing)
+		}
+	}
+}
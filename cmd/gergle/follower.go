@@ -1,59 +1,105 @@
 package main
 
 import (
-	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 )
 
 type Follower interface {
-	Follow(link *Link) error
+	Follow(link *Link) FollowDecision
+}
+
+// A FollowDecision is a Follower's verdict on a link: whether it is to be
+// followed and, if not, the rule which declined it and why.
+type FollowDecision struct {
+	Allowed bool
+	// Rule names the rule which declined the link, such as "depth" or
+	// "seen", for machines.
+	Rule string
+	// Reason describes why the link was declined, for people.
+	Reason string
+}
+
+// FollowerRules are the sorted rules which may decline to follow a link to a
+// page which hasn't been seen.
+var FollowerRules = []string{"boilerplate", "depth", "disallowed", "external", "pagination", "path-segments", "section", "trap", "url-length"}
+
+// Allow is the decision to follow a link.
+var Allow = FollowDecision{Allowed: true}
+
+// Deny returns the decision of the rule not to follow a link.
+func Deny(rule string, format string, args ...interface{}) FollowDecision {
+	return FollowDecision{Rule: rule, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (d FollowDecision) String() string {
+	if d.Allowed {
+		return "allowed"
+	}
+	return fmt.Sprintf("%s: %s", d.Rule, d.Reason)
 }
 
 type AlwaysFollow struct{}
 
-func (_ *AlwaysFollow) Follow(link *Link) error {
-	return nil
+func (_ *AlwaysFollow) Follow(link *Link) FollowDecision {
+	return Allow
 }
 
 type NeverFollow struct{}
 
-func (_ *NeverFollow) Follow(link *Link) error {
-	return errors.New("Never follow")
+func (_ *NeverFollow) Follow(link *Link) FollowDecision {
+	return Deny("never", "Never follow")
 }
 
 type UnanimousFollower []Follower
 
-func (all UnanimousFollower) Follow(link *Link) error {
+func (all UnanimousFollower) Follow(link *Link) FollowDecision {
 	for _, follower := range all {
-		if err := follower.Follow(link); err != nil {
-			return err
+		if decision := follower.Follow(link); !decision.Allowed {
+			return decision
 		}
 	}
-	return nil
+	return Allow
 }
 
-type LocalFollower struct{}
+type LocalFollower struct {
+	// Hosts are the other hosts whose links are followed, which may be
+	// patterns such as *.example.com matching any subdomain.
+	Hosts []string
+}
 
-func (l *LocalFollower) Follow(link *Link) error {
-	if link.External {
-		return errors.New("Not internal link")
+func (l *LocalFollower) Follow(link *Link) FollowDecision {
+	if link.External && (len(l.Hosts) == 0 || !l.allowsHost(link.URL.Hostname())) {
+		return Deny("external", "Not internal link")
+	}
+	return Allow
+}
+
+func (l *LocalFollower) allowsHost(host string) bool {
+	host = asciiHost(host)
+	for _, pattern := range l.Hosts {
+		pattern = asciiHost(pattern)
+		if pattern == host || (strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])) {
+			return true
+		}
 	}
-	return nil
+	return false
 }
 
 type ShallowFollower struct {
 	MaxDepth uint16
 }
 
-func (s *ShallowFollower) Follow(link *Link) error {
+func (s *ShallowFollower) Follow(link *Link) FollowDecision {
 	if link.Depth > s.MaxDepth {
-		return errors.New(fmt.Sprintf("Link beyond depth %d", s.MaxDepth))
+		return Deny("depth", "Link beyond depth %d", s.MaxDepth)
 	}
-	return nil
+	return Allow
 }
 
 type UnseenFollower struct {
@@ -64,15 +110,16 @@ type UnseenFollower struct {
 func NewUnseenFollower(seen ...*url.URL) *UnseenFollower {
 	follower := &UnseenFollower{seen: make(map[string]bool, len(seen))}
 	for _, u := range seen {
-		follower.recordSeen(follower.sanitizeURL(u))
+		follower.recordSeen(sanitizeURL(u))
 	}
 	return follower
 }
 
 // sanitizeURL returns a stripped-down string representation of a URL designed
 // to maximise overlap of equivalent URLs with slight variations.
-func (_ *UnseenFollower) sanitizeURL(u *url.URL) string {
+func sanitizeURL(u *url.URL) string {
 	dupe := *u
+	dupe.Host = asciiHost(dupe.Host)
 	dupe.Path = strings.TrimRight(dupe.Path, "/")
 	dupe.Fragment = ""
 	return dupe.String()
@@ -91,34 +138,40 @@ func (u *UnseenFollower) recordSeen(href string) {
 	u.lock.Unlock()
 }
 
-func (u *UnseenFollower) Follow(link *Link) error {
-	href := u.sanitizeURL(link.URL)
+func (u *UnseenFollower) Follow(link *Link) FollowDecision {
+	href := sanitizeURL(link.URL)
 	if u.hasSeen(href) {
-		return errors.New("Not following seen link")
+		return Deny("seen", "Not following seen link")
 	}
 
 	u.recordSeen(href)
-	return nil
+	return Allow
 }
 
 type RegexpDisallowFollower struct {
 	Rules []*regexp.Regexp
 }
 
-func (r *RegexpDisallowFollower) Follow(link *Link) error {
+func (r *RegexpDisallowFollower) Follow(link *Link) FollowDecision {
 	for _, rule := range r.Rules {
 		if rule.MatchString(link.URL.Path) {
-			return fmt.Errorf("Link disallowed by rule %s", rule)
+			return Deny("disallowed", "Link disallowed by rule %s", rule)
 		}
 	}
-	return nil
+	return Allow
+}
+
+// compileRobotsPattern transforms a path pattern in the style of robots.txt,
+// where * matches anything, into a regexp.Regexp.
+func compileRobotsPattern(rule string) (*regexp.Regexp, error) {
+	return regexp.Compile("^/?" + strings.Replace(regexp.QuoteMeta(strings.TrimLeft(rule, "/")), "\\*", ".*", -1))
 }
 
 func NewRobotsDisallowFollower(disallowRule ...string) *RegexpDisallowFollower {
 	follower := &RegexpDisallowFollower{make([]*regexp.Regexp, 0)}
 
 	for _, rule := range disallowRule {
-		regexpRule, err := regexp.Compile("^/?" + strings.Replace(regexp.QuoteMeta(strings.TrimLeft(rule, "/")), "\\*", ".*", -1))
+		regexpRule, err := compileRobotsPattern(rule)
 		if err != nil {
 			// TODO: Log that we couldn't generate the regex.
 			continue
@@ -129,3 +182,149 @@ func NewRobotsDisallowFollower(disallowRule ...string) *RegexpDisallowFollower {
 
 	return follower
 }
+
+// SectionFollower caps the number of links followed under each first-level
+// path segment, so that no one section of a site can starve the rest.
+type SectionFollower struct {
+	MaxPerSection int
+
+	counts map[string]int
+	lock   sync.Mutex
+}
+
+func NewSectionFollower(maxPerSection int) *SectionFollower {
+	return &SectionFollower{MaxPerSection: maxPerSection, counts: make(map[string]int)}
+}
+
+// pathSection returns the first segment of the URL's path.
+func pathSection(u *url.URL) string {
+	return strings.SplitN(strings.TrimLeft(u.Path, "/"), "/", 2)[0]
+}
+
+func (s *SectionFollower) Follow(link *Link) FollowDecision {
+	section := pathSection(link.URL)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.counts[section] >= s.MaxPerSection {
+		return Deny("section", "Section /%s reached its limit of %d", section, s.MaxPerSection)
+	}
+	s.counts[section]++
+	return Allow
+}
+
+// PaginationFollower stops following rel="next" and rel="prev" links once
+// a paginated sequence has been followed MaxPagination pages deep.
+type PaginationFollower struct {
+	MaxPagination uint16
+}
+
+func (p *PaginationFollower) Follow(link *Link) FollowDecision {
+	if link.Pagination > p.MaxPagination {
+		return Deny("pagination", "Link beyond pagination %d", p.MaxPagination)
+	}
+	return Allow
+}
+
+// BoilerplateFollower doesn't follow the links found in the navigation,
+// header, footer or sidebar of pages, leaving only those in their content.
+type BoilerplateFollower struct{}
+
+func (b *BoilerplateFollower) Follow(link *Link) FollowDecision {
+	if link.IsBoilerplate() {
+		return Deny("boilerplate", "Link in page %s", link.Region)
+	}
+	return Allow
+}
+
+// URLLengthFollower doesn't follow links with URLs longer than MaxLength.
+type URLLengthFollower struct {
+	MaxLength int
+}
+
+func (u *URLLengthFollower) Follow(link *Link) FollowDecision {
+	if length := len(link.URL.String()); length > u.MaxLength {
+		return Deny("url-length", "URL longer than %d characters (%d)", u.MaxLength, length)
+	}
+	return Allow
+}
+
+// PathSegmentsFollower doesn't follow links with more than MaxSegments
+// segments to their paths, such as the 3 of /a/b/c.
+type PathSegmentsFollower struct {
+	MaxSegments int
+}
+
+func (p *PathSegmentsFollower) Follow(link *Link) FollowDecision {
+	if segments := len(strings.FieldsFunc(link.URL.Path, func(r rune) bool { return r == '/' })); segments > p.MaxSegments {
+		return Deny("path-segments", "Path deeper than %d segments (%d)", p.MaxSegments, segments)
+	}
+	return Allow
+}
+
+// FollowerStats counts the links declined by each rule of a crawl. Each link
+// is counted against the first rule to decline it.
+type FollowerStats struct {
+	counts map[string]int
+	lock   sync.Mutex
+}
+
+func NewFollowerStats() *FollowerStats {
+	return &FollowerStats{counts: make(map[string]int)}
+}
+
+// Counting returns the follower, counting the links it declines by the rule
+// which declined them.
+func (s *FollowerStats) Counting(follower Follower) Follower {
+	return &countingFollower{follower, s}
+}
+
+type countingFollower struct {
+	Follower
+	stats *FollowerStats
+}
+
+func (c *countingFollower) Follow(link *Link) FollowDecision {
+	decision := c.Follower.Follow(link)
+	if !decision.Allowed {
+		c.stats.lock.Lock()
+		c.stats.counts[decision.Rule]++
+		c.stats.lock.Unlock()
+	}
+	return decision
+}
+
+// FollowerReporter lists the number of links declined by each rule, to
+// explain why a crawl covered fewer pages than expected.
+type FollowerReporter struct {
+	Stats *FollowerStats
+}
+
+func NewFollowerReporter(stats *FollowerStats) *FollowerReporter {
+	return &FollowerReporter{stats}
+}
+
+func (f *FollowerReporter) Observe(page Page) {}
+
+func (f *FollowerReporter) Report(w io.Writer) {
+	f.Stats.lock.Lock()
+	defer f.Stats.lock.Unlock()
+
+	rules := make([]string, 0, len(f.Stats.counts))
+	total := 0
+	for rule, count := range f.Stats.counts {
+		rules = append(rules, rule)
+		total += count
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if f.Stats.counts[rules[i]] != f.Stats.counts[rules[j]] {
+			return f.Stats.counts[rules[i]] > f.Stats.counts[rules[j]]
+		}
+		return rules[i] < rules[j]
+	})
+
+	fmt.Fprintf(w, "Links not followed: %d links\n", total)
+	for _, rule := range rules {
+		fmt.Fprintf(w, "- %s: %d links\n", rule, f.Stats.counts[rule])
+	}
+}
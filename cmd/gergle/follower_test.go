@@ -1,20 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"net/url"
 	"testing"
+	"time"
 )
 
 func TestAlwaysFollow(t *testing.T) {
 	f := &AlwaysFollow{}
-	if f.Follow(nil) != nil {
+	if !f.Follow(nil).Allowed {
 		t.Error("AlwaysFollow.Follow should never return an error.")
 	}
 }
 
 func TestNeverFollow(t *testing.T) {
 	f := &NeverFollow{}
-	if f.Follow(nil) == nil {
+	if f.Follow(nil).Allowed {
 		t.Error("NeverFollow.Follow should always return an error.")
 	}
 }
@@ -43,11 +45,11 @@ func TestUnanimousFollower(t *testing.T) {
 	}
 
 	for _, test := range results {
-		if (test.f.Follow(nil) == nil) != test.ok {
+		if test.f.Follow(nil).Allowed != test.ok {
 			if test.ok {
-				t.Errorf("%#v.Follow(nil) should not return error.", test.f)
+				t.Errorf("%#v.Follow(nil) should allow the link.", test.f)
 			} else {
-				t.Errorf("%#v.Follow(nil) should have returned an error, but did not.", test.f)
+				t.Errorf("%#v.Follow(nil) should deny the link, but did not.", test.f)
 			}
 		}
 	}
@@ -55,24 +57,38 @@ func TestUnanimousFollower(t *testing.T) {
 
 func TestLocalFollower(t *testing.T) {
 	f := LocalFollower{}
-	if f.Follow(&Link{External: true}) == nil {
+	if f.Follow(&Link{External: true}).Allowed {
 		t.Error("LocalFollower.Follow should return an error when link is external.")
 	}
-	if f.Follow(&Link{External: false}) != nil {
+	if !f.Follow(&Link{External: false}).Allowed {
 		t.Error("LocalFollower.Follow should not return an error when link is not external.")
 	}
+
+	f = LocalFollower{Hosts: []string{"cdn.example.com", "*.example.org"}}
+	for href, allowed := range map[string]bool{
+		"http://cdn.example.com/a":      true,
+		"http://cdn.example.com:8080/a": true,
+		"http://www.example.org/a":      true,
+		"http://example.org/a":          false,
+		"http://www.example.com/a":      false,
+	} {
+		u, _ := url.Parse(href)
+		if decision := f.Follow(&Link{URL: u, External: true}); decision.Allowed != allowed {
+			t.Errorf("LocalFollower.Follow(%s) should allow: %t, but got %v", href, allowed, decision)
+		}
+	}
 }
 
 func TestShallowFollower(t *testing.T) {
 	f := &ShallowFollower{MaxDepth: 10}
 
-	if f.Follow(&Link{Depth: 9}) != nil {
+	if !f.Follow(&Link{Depth: 9}).Allowed {
 		t.Error("ShallowFollower.Follow should not return an error for depths less than its MaxDepth.")
 	}
-	if f.Follow(&Link{Depth: 10}) != nil {
+	if !f.Follow(&Link{Depth: 10}).Allowed {
 		t.Error("ShallowFollower.Follow should not return an error for depths equal to its MaxDepth.")
 	}
-	if f.Follow(&Link{Depth: 11}) == nil {
+	if f.Follow(&Link{Depth: 11}).Allowed {
 		t.Error("ShallowFollower.Follow should return an error for depths greater than its MaxDepth.")
 	}
 }
@@ -80,30 +96,60 @@ func TestShallowFollower(t *testing.T) {
 func TestUnseenFollower(t *testing.T) {
 	f := NewUnseenFollower(&url.URL{Path: "/seen"})
 
-	if f.Follow(&Link{URL: &url.URL{Path: "/seen"}}) == nil {
+	if f.Follow(&Link{URL: &url.URL{Path: "/seen"}}).Allowed {
 		t.Error("UnseenFollower.Follow should return an error for URLs it was instantiated with.")
 	}
-	if f.Follow(&Link{URL: &url.URL{Path: "/seen/"}}) == nil {
+	if f.Follow(&Link{URL: &url.URL{Path: "/seen/"}}).Allowed {
 		t.Error("UnseenFollower.Follow should return an error for URLs probably the same as other it's already seen.")
 	}
-	if f.Follow(&Link{URL: &url.URL{Path: "/seen", Fragment: "#irrelevant"}}) == nil {
+	if f.Follow(&Link{URL: &url.URL{Path: "/seen", Fragment: "#irrelevant"}}).Allowed {
 		t.Error("UnseenFollower.Follow should return an error for URLs only differing in fragment from another it's already seen.")
 	}
 
-	if f.Follow(&Link{URL: &url.URL{Path: "/unseen/1"}}) != nil {
+	if !f.Follow(&Link{URL: &url.URL{Path: "/unseen/1"}}).Allowed {
 		t.Error("UnseenFollower.Follow should not return an error for URLs previously unseen.")
 	}
-	if f.Follow(&Link{URL: &url.URL{Path: "/unseen/1"}}) == nil {
+	if f.Follow(&Link{URL: &url.URL{Path: "/unseen/1"}}).Allowed {
 		t.Error("UnseenFollower.Follow should return an error for URLs it's been asked about previously.")
 	}
-	if f.Follow(&Link{URL: &url.URL{Path: "/unseen/1", Fragment: "ignored"}}) == nil {
+	if f.Follow(&Link{URL: &url.URL{Path: "/unseen/1", Fragment: "ignored"}}).Allowed {
 		t.Error("UnseenFollower.Follow should return an error for URLs it's been asked about previously, even if they differ in fragment.")
 	}
 
 	f.Follow(&Link{URL: &url.URL{Path: "/unseen/2", Fragment: "ignored"}})
-	if f.Follow(&Link{URL: &url.URL{Path: "/unseen/2"}}) == nil {
+	if f.Follow(&Link{URL: &url.URL{Path: "/unseen/2"}}).Allowed {
 		t.Error("UnseenFollower.Follow should return an error for URLs it's previously seen with a fragment.")
 	}
+
+	unicode, _ := url.Parse("https://bücher.example/")
+	punycode, _ := url.Parse("https://xn--bcher-kva.example/")
+	f.Follow(&Link{URL: unicode})
+	if f.Follow(&Link{URL: punycode}).Allowed {
+		t.Error("UnseenFollower.Follow should return an error for URLs it's previously seen with an internationalized host.")
+	}
+}
+
+func TestAsciiHost(t *testing.T) {
+	tests := map[string]string{
+		"example.com":           "example.com",
+		"Example.COM:8080":      "example.com:8080",
+		"bücher.example":        "xn--bcher-kva.example",
+		"BÜCHER.example:443":    "xn--bcher-kva.example:443",
+		"münchen.de":            "xn--mnchen-3ya.de",
+		"例え.テスト":                "xn--r8jz45g.xn--zckzah",
+		"xn--bcher-kva.example": "xn--bcher-kva.example",
+		"ｂüｃｈｅｒ.example":        "xn--bcher-kva.example",
+	}
+	for host, expected := range tests {
+		if ascii := asciiHost(host); ascii != expected {
+			t.Errorf("Expected asciiHost(%q) to be %q but got %q", host, expected, ascii)
+		}
+	}
+
+	page, _ := url.Parse("https://bücher.example/")
+	if link, _ := AnchorLink("https://xn--bcher-kva.example/about", page, 1); link.External {
+		t.Error("Expected links between the Unicode and punycode forms of a host to be internal.")
+	}
 }
 
 func TestRegexpDisallowFollower(t *testing.T) {
@@ -123,13 +169,170 @@ func TestRegexpDisallowFollower(t *testing.T) {
 		}
 	}
 
-	if f.Follow(&Link{URL: &url.URL{Path: "hello/asdf/world"}}) == nil {
+	if f.Follow(&Link{URL: &url.URL{Path: "hello/asdf/world"}}).Allowed {
 		t.Error("RegexpDisallowFollower should disallow.")
 	}
-	if f.Follow(&Link{URL: &url.URL{Path: "hel.lo"}}) == nil {
+	if f.Follow(&Link{URL: &url.URL{Path: "hel.lo"}}).Allowed {
 		t.Error("RegexpDisallowFollower should disallow.")
 	}
-	if f.Follow(&Link{URL: &url.URL{Path: "goodbye"}}) != nil {
+	if !f.Follow(&Link{URL: &url.URL{Path: "goodbye"}}).Allowed {
 		t.Error("RegexpDisallowFollower should allow.")
 	}
 }
+
+func TestSectionFollower(t *testing.T) {
+	f := NewSectionFollower(2)
+
+	results := []struct {
+		path string
+		ok   bool
+	}{
+		{"/forum/1", true},
+		{"/forum/2", true},
+		{"/forum/3", false},
+		{"/forum", false},
+		{"/blog/1", true},
+		{"/about", true},
+		{"/", true},
+		{"/blog/2", true},
+		{"/blog/3", false},
+	}
+	for _, test := range results {
+		if f.Follow(&Link{URL: &url.URL{Path: test.path}}).Allowed != test.ok {
+			if test.ok {
+				t.Errorf("SectionFollower.Follow should not return an error for %s.", test.path)
+			} else {
+				t.Errorf("SectionFollower.Follow should return an error for %s once its section is full.", test.path)
+			}
+		}
+	}
+}
+
+func TestPaginationFollower(t *testing.T) {
+	f := &PaginationFollower{MaxPagination: 20}
+
+	if !f.Follow(&Link{Pagination: 0}).Allowed {
+		t.Error("PaginationFollower.Follow should not return an error for links outside of pagination.")
+	}
+	if !f.Follow(&Link{Pagination: 20}).Allowed {
+		t.Error("PaginationFollower.Follow should not return an error for pagination equal to its MaxPagination.")
+	}
+	if f.Follow(&Link{Pagination: 21}).Allowed {
+		t.Error("PaginationFollower.Follow should return an error for pagination greater than its MaxPagination.")
+	}
+}
+
+func TestBoilerplateFollower(t *testing.T) {
+	f := &BoilerplateFollower{}
+
+	for _, region := range []string{"", "content"} {
+		if !f.Follow(&Link{Region: region}).Allowed {
+			t.Errorf("BoilerplateFollower.Follow should not return an error for links in region %q.", region)
+		}
+	}
+	for _, region := range []string{"nav", "header", "footer", "aside"} {
+		if f.Follow(&Link{Region: region}).Allowed {
+			t.Errorf("BoilerplateFollower.Follow should return an error for links in region %q.", region)
+		}
+	}
+}
+
+func TestTrapFollower(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	for href, expected := range map[string]string{
+		"/blog/2020/05/post":         "",
+		"/news/news/story":           "",
+		"/calendar/2022-05":          "",
+		"/calendar/2022-07":          "calendar date far in the future",
+		"/events?year=2023&month=1":  "calendar date far in the future",
+		"/events?year=2021":          "",
+		"/a/b/a/b":                   "repeating path /a/b",
+		"/docs/img/img/img/logo.png": "repeating path /img",
+		"/x/y/z/w":                   "",
+	} {
+		u, _ := url.Parse("http://example.com" + href)
+		if reason := detectTrap(u, now); reason != expected {
+			t.Errorf("Expected detectTrap(%s) to be %q but got %q", href, expected, reason)
+		}
+	}
+
+	f := NewTrapFollower()
+	trap, _ := url.Parse("http://example.com/a/b/a/b/")
+	if f.Follow(&Link{URL: trap}).Allowed {
+		t.Error("TrapFollower.Follow should return an error for a repeating path.")
+	}
+	out := &bytes.Buffer{}
+	NewTrapReporter(f).Report(out)
+	if expected := "URL traps: 1 traps\n- repeating path /a/b: 1 links not followed, e.g. http://example.com/a/b/a/b/\n"; out.String() != expected {
+		t.Errorf("Expected report %q but got %q", expected, out.String())
+	}
+}
+
+func TestURLLengthFollower(t *testing.T) {
+	f := &URLLengthFollower{MaxLength: 22}
+
+	short, _ := url.Parse("http://example.com/abc")
+	if !f.Follow(&Link{URL: short}).Allowed {
+		t.Error("URLLengthFollower.Follow should not return an error for URLs of its MaxLength.")
+	}
+	long, _ := url.Parse("http://example.com/abcd")
+	if f.Follow(&Link{URL: long}).Allowed {
+		t.Error("URLLengthFollower.Follow should return an error for URLs longer than its MaxLength.")
+	}
+}
+
+func TestPathSegmentsFollower(t *testing.T) {
+	f := &PathSegmentsFollower{MaxSegments: 2}
+
+	for _, href := range []string{"http://example.com", "http://example.com/", "http://example.com/a/b/", "http://example.com//a/b?c=/d"} {
+		u, _ := url.Parse(href)
+		if !f.Follow(&Link{URL: u}).Allowed {
+			t.Errorf("PathSegmentsFollower.Follow should not return an error for %s.", href)
+		}
+	}
+	deep, _ := url.Parse("http://example.com/a/b/c")
+	if f.Follow(&Link{URL: deep}).Allowed {
+		t.Error("PathSegmentsFollower.Follow should return an error for paths with more than MaxSegments segments.")
+	}
+}
+
+func TestFollowDecision(t *testing.T) {
+	f := UnanimousFollower{&ShallowFollower{1}, &PaginationFollower{2}}
+	decision := f.Follow(&Link{Depth: 1, Pagination: 3})
+	if decision.Allowed || decision.Rule != "pagination" || decision.Reason != "Link beyond pagination 2" {
+		t.Errorf("Expected pagination to decline the link, got %#v", decision)
+	}
+	if decision := f.Follow(&Link{Depth: 1}); decision != Allow {
+		t.Errorf("Expected the link to be allowed, got %#v", decision)
+	}
+}
+
+func TestFollowerStats(t *testing.T) {
+	stats := NewFollowerStats()
+	follower := stats.Counting(UnanimousFollower{
+		&LocalFollower{},
+		&ShallowFollower{1},
+		NewUnseenFollower(),
+	})
+
+	links := []*Link{
+		{URL: &url.URL{Scheme: "http", Host: "example.com", Path: "/a"}, Depth: 1},
+		{URL: &url.URL{Scheme: "http", Host: "example.com", Path: "/a"}, Depth: 1},
+		{URL: &url.URL{Scheme: "http", Host: "example.com", Path: "/b"}, Depth: 2},
+		{URL: &url.URL{Scheme: "http", Host: "other.com", Path: "/"}, Depth: 2, External: true},
+		{URL: &url.URL{Scheme: "http", Host: "other.com", Path: "/c"}, Depth: 1, External: true},
+	}
+	for _, link := range links {
+		follower.Follow(link)
+	}
+
+	out := &bytes.Buffer{}
+	NewFollowerReporter(stats).Report(out)
+	expected := "Links not followed: 4 links\n" +
+		"- external: 2 links\n" +
+		"- depth: 1 links\n" +
+		"- seen: 1 links\n"
+	if out.String() != expected {
+		t.Errorf("Expected report:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FormReporter lists each distinct form found during the crawl, by method,
+// action and inputs, along with the pages it was found on.
+type FormReporter struct {
+	pages map[string][]string
+	lock  sync.Mutex
+}
+
+func NewFormReporter() *FormReporter {
+	return &FormReporter{pages: make(map[string][]string)}
+}
+
+func (f *FormReporter) Observe(page Page) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, form := range page.Forms {
+		key := fmt.Sprintf("%s %s (%s)", form.Method, form.Action, strings.Join(form.Inputs, ", "))
+		f.pages[key] = append(f.pages[key], page.URL.String())
+	}
+}
+
+func (f *FormReporter) Report(w io.Writer) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	forms := make([]string, 0, len(f.pages))
+	for form := range f.pages {
+		forms = append(forms, form)
+	}
+	sort.Strings(forms)
+
+	fmt.Fprintf(w, "Forms: %d forms\n", len(forms))
+	for _, form := range forms {
+		pages := f.pages[form]
+		sort.Strings(pages)
+		fmt.Fprintf(w, "- %s\n", form)
+		for _, page := range pages {
+			fmt.Fprintf(w, "  - %s\n", page)
+		}
+	}
+}
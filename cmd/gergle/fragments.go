@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fragmentLink is a link to a #fragment of a page, from its referrer.
+type fragmentLink struct {
+	Referrer string
+	Href     string
+	Fragment string
+}
+
+// FragmentReporter lists the links to #fragments of crawled pages which don't
+// have an element with that id, or an <a> with that name, to scroll to.
+type FragmentReporter struct {
+	fragments map[string][]string
+	links     map[string][]fragmentLink
+	lock      sync.Mutex
+}
+
+func NewFragmentReporter() *FragmentReporter {
+	return &FragmentReporter{
+		fragments: make(map[string][]string),
+		links:     make(map[string][]fragmentLink),
+	}
+}
+
+// checkedFragment reports whether the fragment should scroll to a target of
+// the page, rather than being #top, which browsers always scroll to, or a
+// route of a single-page app, like #!/about or #/about.
+func checkedFragment(fragment string) bool {
+	return fragment != "" && !strings.EqualFold(fragment, "top") && !strings.HasPrefix(fragment, "!") && !strings.HasPrefix(fragment, "/")
+}
+
+func (f *FragmentReporter) Observe(page Page) {
+	if !page.Processed {
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.fragments[graphKey(page.URL)] = page.Fragments
+	for _, link := range page.Links {
+		if link.External || !checkedFragment(link.URL.Fragment) {
+			continue
+		}
+		target := graphKey(link.URL)
+		f.links[target] = append(f.links[target], fragmentLink{page.URL.String(), link.URL.String(), link.URL.Fragment})
+	}
+}
+
+// Broken returns the sorted links to missing fragments of each referring
+// page.
+func (f *FragmentReporter) Broken() map[string][]string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	broken := make(map[string][]string)
+	for target, links := range f.links {
+		fragments, crawled := f.fragments[target]
+		if !crawled {
+			continue
+		}
+		for _, link := range links {
+			if !containsString(fragments, link.Fragment) {
+				broken[link.Referrer] = append(broken[link.Referrer], link.Href)
+			}
+		}
+	}
+	for _, hrefs := range broken {
+		sort.Strings(hrefs)
+	}
+	return broken
+}
+
+func (f *FragmentReporter) Report(w io.Writer) {
+	broken := f.Broken()
+	pages := make([]string, 0, len(broken))
+	for page := range broken {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	fmt.Fprintf(w, "Broken fragments: %d pages\n", len(pages))
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s\n", page)
+		for _, href := range broken[page] {
+			fmt.Fprintf(w, "  - %s\n", href)
+		}
+	}
+}
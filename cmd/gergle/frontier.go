@@ -0,0 +1,225 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Frontier holds the Tasks waiting to be crawled, and decides which is to
+// be crawled next.
+type Frontier interface {
+	// Push adds a task to the frontier.
+	Push(task Task)
+	// Pop removes the next task from the frontier, blocking until there is
+	// one. It returns false once the frontier has been closed.
+	Pop() (Task, bool)
+	// Close releases everyone waiting to Pop.
+	Close()
+}
+
+// A PriorityRule assigns a priority to the URLs whose paths match it.
+type PriorityRule struct {
+	Pattern  *regexp.Regexp
+	Priority int
+}
+
+// ParsePriorityRule reads a rule of the form PATTERN=PRIORITY, where PATTERN is
+// a path in the style of a robots.txt rule.
+func ParsePriorityRule(rule string) (PriorityRule, error) {
+	eq := strings.LastIndex(rule, "=")
+	if eq < 0 {
+		return PriorityRule{}, fmt.Errorf("Expected --priority of the form PATTERN=PRIORITY, got %q.", rule)
+	}
+	priority, err := strconv.Atoi(rule[eq+1:])
+	if err != nil {
+		return PriorityRule{}, fmt.Errorf("Expected --priority of the form PATTERN=PRIORITY, got %q.", rule)
+	}
+	pattern, err := compileRobotsPattern(rule[:eq])
+	if err != nil {
+		return PriorityRule{}, err
+	}
+	return PriorityRule{pattern, priority}, nil
+}
+
+// PriorityFrontier pops the tasks with the highest priority first, and tasks
+// of equal priority in the order they were pushed. The priority of a task is
+// given by the first of the Rules to match it, or zero if none do.
+//
+// If MaxTasks is set, tasks pushed beyond that many are spilled to temporary
+// files, one per priority, and read back as they come up, so that huge sites
+// don't hold their whole frontier in memory.
+type PriorityFrontier struct {
+	Rules    []PriorityRule
+	MaxTasks int
+
+	queue   taskHeap
+	spilled map[int]*taskSpill
+	seq     uint64
+	closed  bool
+	lock    sync.Mutex
+	cond    *sync.Cond
+}
+
+func NewPriorityFrontier(rules ...PriorityRule) *PriorityFrontier {
+	p := &PriorityFrontier{Rules: rules, spilled: make(map[int]*taskSpill)}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+func (p *PriorityFrontier) priority(u *url.URL) int {
+	for _, rule := range p.Rules {
+		if rule.Pattern.MatchString(u.Path) {
+			return rule.Priority
+		}
+	}
+	return 0
+}
+
+func (p *PriorityFrontier) Push(task Task) {
+	priority := p.priority(task.URL)
+
+	p.lock.Lock()
+	p.seq++
+	queued := prioritisedTask{task, priority, p.seq}
+	spill := p.spilled[priority]
+	if spill != nil || (p.MaxTasks > 0 && len(p.queue) >= p.MaxTasks) {
+		// Tasks queue behind those of their priority already on disk.
+		if spill == nil {
+			spill = &taskSpill{}
+		}
+		if err := spill.Push(queued); err != nil {
+			logger.Error("Failed to spill task to disk", "url", task.URL, "error", err)
+			if p.spilled[priority] == nil {
+				spill.Close()
+			}
+			heap.Push(&p.queue, queued)
+		} else {
+			p.spilled[priority] = spill
+		}
+	} else {
+		heap.Push(&p.queue, queued)
+	}
+	p.lock.Unlock()
+	p.cond.Signal()
+}
+
+// spillRetry is how long Pop waits to read spilled tasks again, when they are
+// all that is left and failed to be read.
+var spillRetry = time.Second
+
+func (p *PriorityFrontier) Pop() (Task, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for {
+		for len(p.queue) == 0 && len(p.spilled) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if task, ok := p.next(); ok {
+			return task, true
+		}
+		if p.closed {
+			return Task{}, false
+		}
+		p.lock.Unlock()
+		time.Sleep(spillRetry)
+		p.lock.Lock()
+	}
+}
+
+// next removes the next task from memory or disk, keeping those spilled
+// tasks which fail to be read to try again. The lock must be held.
+func (p *PriorityFrontier) next() (Task, bool) {
+	// Take the next task from disk if it comes before those in memory.
+	var next *taskSpill
+	var nextHead prioritisedTask
+	for priority, spill := range p.spilled {
+		head, err := spill.Peek()
+		if err != nil {
+			logger.Error("Failed to read spilled tasks from disk", "priority", priority, "error", err)
+			continue
+		}
+		if next == nil || (taskHeap{head, nextHead}).Less(0, 1) {
+			next, nextHead = spill, head
+		}
+	}
+	if next != nil && (len(p.queue) == 0 || (taskHeap{nextHead, p.queue[0]}).Less(0, 1)) {
+		next.Pop()
+		if next.Len() == 0 {
+			next.Close()
+			delete(p.spilled, nextHead.priority)
+		}
+		return nextHead.Task, true
+	}
+
+	if len(p.queue) == 0 {
+		return Task{}, false
+	}
+	return heap.Pop(&p.queue).(prioritisedTask).Task, true
+}
+
+func (p *PriorityFrontier) Close() {
+	p.lock.Lock()
+	p.closed = true
+	p.lock.Unlock()
+	p.cond.Broadcast()
+}
+
+// Len returns the number of tasks waiting in the frontier.
+func (p *PriorityFrontier) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	n := len(p.queue)
+	for _, spill := range p.spilled {
+		n += spill.Len()
+	}
+	return n
+}
+
+// Tasks returns the tasks waiting in memory, in the order they would be
+// popped, without those spilled to disk.
+func (p *PriorityFrontier) Tasks() []Task {
+	p.lock.Lock()
+	queue := append(taskHeap{}, p.queue...)
+	p.lock.Unlock()
+
+	sort.Sort(queue)
+	tasks := make([]Task, len(queue))
+	for i, task := range queue {
+		tasks[i] = task.Task
+	}
+	return tasks
+}
+
+type prioritisedTask struct {
+	Task
+	priority int
+	seq      uint64
+}
+
+// taskHeap implements heap.Interface, ordering by descending priority and
+// then ascending sequence number.
+type taskHeap []prioritisedTask
+
+func (h taskHeap) Len() int { return len(h) }
+func (h taskHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h taskHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *taskHeap) Push(x interface{}) { *h = append(*h, x.(prioritisedTask)) }
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	task := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return task
+}
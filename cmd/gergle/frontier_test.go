@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriorityFrontier(t *testing.T) {
+	rules := []PriorityRule{}
+	for _, rule := range []string{"/docs/*=10", "/archive/*=-1"} {
+		r, err := ParsePriorityRule(rule)
+		if err != nil {
+			t.Fatalf("ParsePriorityRule(%q) should not return error: %s", rule, err)
+		}
+		rules = append(rules, r)
+	}
+	f := NewPriorityFrontier(rules...)
+
+	for _, path := range []string{"/archive/1", "/about", "/docs/1", "/archive/2", "/docs/2", "/contact"} {
+		f.Push(Task{URL: &url.URL{Path: path}})
+	}
+	f.Close()
+
+	expected := []string{"/docs/1", "/docs/2", "/about", "/contact", "/archive/1", "/archive/2"}
+	for _, path := range expected {
+		task, ok := f.Pop()
+		if !ok {
+			t.Fatalf("Expected to pop %s but the frontier was empty", path)
+		}
+		if task.URL.Path != path {
+			t.Errorf("Expected to pop %s but got %s", path, task.URL.Path)
+		}
+	}
+	if _, ok := f.Pop(); ok {
+		t.Error("Expected the closed frontier to be empty")
+	}
+
+	for _, rule := range []string{"/docs", "/docs=high"} {
+		if _, err := ParsePriorityRule(rule); err == nil {
+			t.Errorf("ParsePriorityRule(%q) should return error", rule)
+		}
+	}
+}
+
+func TestPriorityFrontierTasks(t *testing.T) {
+	rule, _ := ParsePriorityRule("/important=1")
+	frontier := NewPriorityFrontier(rule)
+	for _, path := range []string{"/a", "/important", "/b"} {
+		frontier.Push(Task{URL: &url.URL{Path: path}})
+	}
+
+	tasks := frontier.Tasks()
+	paths := make([]string, len(tasks))
+	for i, task := range tasks {
+		paths[i] = task.URL.Path
+	}
+	if expected := []string{"/important", "/a", "/b"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected tasks %v but got %v", expected, paths)
+	}
+	if frontier.Len() != 3 {
+		t.Errorf("Expected Tasks to leave 3 tasks in the frontier but got %d", frontier.Len())
+	}
+}
+
+func TestPriorityFrontierSpill(t *testing.T) {
+	rule, _ := ParsePriorityRule("/docs/*=1")
+	f := NewPriorityFrontier(rule)
+	f.MaxTasks = 2
+
+	referrer, _ := url.Parse("http://example.com/")
+	for _, path := range []string{"/a", "/b", "/c", "/docs/1", "/d", "/docs/2"} {
+		f.Push(Task{URL: &url.URL{Scheme: "http", Host: "example.com", Path: path}, Referrer: referrer, Depth: 2})
+	}
+	if len(f.spilled) != 2 {
+		t.Errorf("Expected tasks of 2 priorities to be spilled but got %d", len(f.spilled))
+	}
+	if f.Len() != 6 {
+		t.Errorf("Expected 6 tasks but got %d", f.Len())
+	}
+
+	popped := []string{}
+	pop := func() {
+		task, ok := f.Pop()
+		if !ok {
+			t.Fatal("Expected to pop a task but the frontier was empty")
+		}
+		if task.Depth != 2 || task.Referrer.String() != referrer.String() {
+			t.Errorf("Expected %s to keep its depth and referrer but got %d and %s", task.URL, task.Depth, task.Referrer)
+		}
+		popped = append(popped, task.URL.Path)
+	}
+	pop()
+	pop()
+	f.Push(Task{URL: &url.URL{Path: "/e"}, Referrer: referrer, Depth: 2})
+	f.Close()
+	for f.Len() > 0 {
+		pop()
+	}
+
+	expected := []string{"/docs/1", "/docs/2", "/a", "/b", "/c", "/d", "/e"}
+	if !reflect.DeepEqual(popped, expected) {
+		t.Errorf("Expected to pop %v but got %v", expected, popped)
+	}
+	if len(f.spilled) != 0 {
+		t.Errorf("Expected the spilled tasks to be cleaned up but got %v", f.spilled)
+	}
+}
+
+func TestPriorityFrontierSpillReadFailure(t *testing.T) {
+	defer func(retry time.Duration) { spillRetry = retry }(spillRetry)
+	spillRetry = time.Millisecond
+
+	f := NewPriorityFrontier()
+	f.MaxTasks = 1
+	for _, path := range []string{"/a", "/b", "/c"} {
+		f.Push(Task{URL: &url.URL{Scheme: "http", Host: "example.com", Path: path}})
+	}
+
+	// Fail the reads of the spilled tasks, both while there are tasks in
+	// memory and once there are none.
+	popped := []string{}
+	for i := 0; i < 3; i++ {
+		f.spilled[0].readFile.Close()
+		task, ok := f.Pop()
+		if !ok {
+			t.Fatalf("Expected to pop a task despite the failed read, but the frontier was empty after %v", popped)
+		}
+		popped = append(popped, task.URL.Path)
+		if len(f.spilled) == 0 {
+			break
+		}
+	}
+	for f.Len() > 0 {
+		task, _ := f.Pop()
+		popped = append(popped, task.URL.Path)
+	}
+
+	expected := []string{"/a", "/b", "/c"}
+	if !reflect.DeepEqual(popped, expected) {
+		t.Errorf("Expected to pop %v but got %v", expected, popped)
+	}
+	f.Close()
+	if _, ok := f.Pop(); ok {
+		t.Error("Expected the closed frontier to pop nothing")
+	}
+}
+
+// fakeRedis serves the few Redis commands used by the Redis frontier and
+// seen set, without blocking on BLMOVE.
+func fakeRedis(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists := make(map[string][]string)
+	sets := make(map[string]map[string]bool)
+	values := make(map[string]string)
+	lock := sync.Mutex{}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					reply, err := readRedisReply(reader)
+					if err != nil {
+						return
+					}
+					args := []string{}
+					for _, arg := range reply.([]interface{}) {
+						args = append(args, arg.(string))
+					}
+
+					lock.Lock()
+					switch strings.ToUpper(args[0]) {
+					case "PING":
+						fmt.Fprint(conn, "+PONG\r\n")
+					case "RPUSH":
+						lists[args[1]] = append(lists[args[1]], args[2:]...)
+						fmt.Fprintf(conn, ":%d\r\n", len(lists[args[1]]))
+					case "BLMOVE", "LMOVE":
+						// Moves from the LEFT or RIGHT of the source to the
+						// LEFT or RIGHT of the destination.
+						src, dst := lists[args[1]], lists[args[2]]
+						if len(src) == 0 {
+							fmt.Fprint(conn, "$-1\r\n")
+							break
+						}
+						var item string
+						if strings.ToUpper(args[3]) == "LEFT" {
+							item, lists[args[1]] = src[0], src[1:]
+						} else {
+							item, lists[args[1]] = src[len(src)-1], src[:len(src)-1]
+						}
+						if strings.ToUpper(args[4]) == "LEFT" {
+							lists[args[2]] = append([]string{item}, dst...)
+						} else {
+							lists[args[2]] = append(dst, item)
+						}
+						fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(item), item)
+					case "LREM":
+						removed := 0
+						list := []string{}
+						for _, item := range lists[args[1]] {
+							if item == args[3] && removed == 0 {
+								removed++
+								continue
+							}
+							list = append(list, item)
+						}
+						lists[args[1]] = list
+						fmt.Fprintf(conn, ":%d\r\n", removed)
+					case "LLEN":
+						fmt.Fprintf(conn, ":%d\r\n", len(lists[args[1]]))
+					case "SADD":
+						if sets[args[1]] == nil {
+							sets[args[1]] = make(map[string]bool)
+						}
+						added := 0
+						for _, member := range args[2:] {
+							if !sets[args[1]][member] {
+								sets[args[1]][member] = true
+								added++
+							}
+						}
+						fmt.Fprintf(conn, ":%d\r\n", added)
+					case "SET":
+						// Supports NX, ignoring expiry.
+						if _, found := values[args[1]]; found && len(args) > 3 && strings.ToUpper(args[3]) == "NX" {
+							fmt.Fprint(conn, "$-1\r\n")
+							break
+						}
+						values[args[1]] = args[2]
+						fmt.Fprint(conn, "+OK\r\n")
+					case "GET":
+						if value, found := values[args[1]]; found {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+						} else {
+							fmt.Fprint(conn, "$-1\r\n")
+						}
+					case "PEXPIRE":
+						if _, found := values[args[1]]; found {
+							fmt.Fprint(conn, ":1\r\n")
+						} else {
+							fmt.Fprint(conn, ":0\r\n")
+						}
+					case "DEL":
+						for _, key := range args[1:] {
+							delete(lists, key)
+							delete(sets, key)
+							delete(values, key)
+						}
+						fmt.Fprint(conn, ":1\r\n")
+					default:
+						fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+					}
+					lock.Unlock()
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestRedisFrontier(t *testing.T) {
+	server := fakeRedis(t)
+	defer server.Close()
+	client := NewRedisClient(server.Addr().String())
+	defer client.Close()
+
+	f := NewRedisFrontier(client, "test:frontier")
+	referrer, _ := url.Parse("http://example.com/")
+	for _, path := range []string{"/a", "/b"} {
+		u, _ := url.Parse("http://example.com" + path)
+		f.Push(Task{URL: u, Depth: 1, Referrer: referrer})
+	}
+
+	// A second process resumes the tasks of the first, but stops before the
+	// second task is done.
+	resumed := NewRedisFrontier(client, "test:frontier")
+	if n := resumed.Len(); n != 2 {
+		t.Fatalf("Expected 2 tasks in the frontier, got %d", n)
+	}
+	for _, path := range []string{"/a", "/b"} {
+		task, ok := resumed.Pop()
+		if !ok || task.URL.Path != path || task.Depth != 1 || task.Referrer.String() != referrer.String() {
+			t.Errorf("Expected task %s at depth 1 from %s, got %v (%v)", path, referrer, task, ok)
+		}
+		if path == "/a" {
+			resumed.Done(task)
+		}
+	}
+	resumed.Close()
+	if _, ok := resumed.Pop(); ok {
+		t.Error("Expected no tasks once the frontier is closed")
+	}
+
+	// A third process picks up the unfinished task.
+	resumed = NewRedisFrontier(client, "test:frontier")
+	if n, err := resumed.Requeue(); n != 1 || err != nil {
+		t.Fatalf("Expected the unfinished task to be requeued, got %d (%v)", n, err)
+	}
+	if task, ok := resumed.Pop(); !ok || task.URL.Path != "/b" {
+		t.Errorf("Expected the unfinished task /b, got %v (%v)", task, ok)
+	}
+
+	if _, err := client.Do("NOPE"); err == nil || err.Error() != "ERR unknown command 'NOPE'" {
+		t.Errorf("Expected the error reply, got %v", err)
+	}
+}
+
+func TestRedisFrontierCancelled(t *testing.T) {
+	server := fakeRedis(t)
+	defer server.Close()
+	client := NewRedisClient(server.Addr().String())
+	defer client.Close()
+
+	initUrl, _ := url.Parse("http://example.com/")
+	frontier := NewRedisFrontier(client, "test:frontier")
+	crawler := &Crawler{
+		Fetcher:  NewMockFetcher(mockPage("http://example.com/")),
+		Follower: NewUnseenFollower(initUrl),
+		Frontier: frontier,
+		Control:  NewCrawlControl(frontier, nil),
+		Workers:  1,
+	}
+	crawler.Control.Cancel()
+	out := make(chan Page, 1)
+	crawler.Crawl(initUrl, out)
+
+	if n, err := NewRedisFrontier(client, "test:frontier").Requeue(); n != 1 || err != nil {
+		t.Errorf("Expected the cancelled crawl's task to be kept to resume, got %d (%v)", n, err)
+	}
+}
+
+func TestRedisLock(t *testing.T) {
+	server := fakeRedis(t)
+	defer server.Close()
+	client := NewRedisClient(server.Addr().String())
+	defer client.Close()
+
+	lock, err := AcquireRedisLock(client, "test:lock", time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to take the lock, got %s", err)
+	}
+	if _, err := AcquireRedisLock(client, "test:lock", time.Minute); err == nil {
+		t.Error("Expected a second crawl to be refused the lock")
+	}
+	lock.Release()
+	lock, err = AcquireRedisLock(client, "test:lock", time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to take the released lock, got %s", err)
+	}
+	lock.Release()
+}
+
+func TestRedisClientTimeout(t *testing.T) {
+	// The server accepts connections but never replies.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := NewRedisClient(listener.Addr().String())
+	client.Timeout = 50 * time.Millisecond
+	defer client.Close()
+	start := time.Now()
+	if _, err := client.Do("PING"); err == nil {
+		t.Error("Expected the stalled command to fail")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Expected the stalled command to time out, but it took %s", took)
+	}
+}
+
+func TestRedisSeenFollower(t *testing.T) {
+	server := fakeRedis(t)
+	defer server.Close()
+	client := NewRedisClient(server.Addr().String())
+	defer client.Close()
+
+	home, _ := url.Parse("http://example.com/")
+	about, _ := url.Parse("http://example.com/about/")
+	follower := NewRedisSeenFollower(client, "test:seen", home)
+	if follower.Follow(&Link{URL: home}).Allowed {
+		t.Error("Expected the seen home page not to be followed")
+	}
+	if !follower.Follow(&Link{URL: about}).Allowed {
+		t.Error("Expected the unseen page to be followed")
+	}
+
+	// The URLs seen by one process are seen by the next.
+	follower = NewRedisSeenFollower(client, "test:seen")
+	about.Path = "/about"
+	if follower.Follow(&Link{URL: about}).Allowed {
+		t.Error("Expected the page seen by the earlier follower not to be followed")
+	}
+}
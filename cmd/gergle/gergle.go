@@ -1,42 +1,344 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	log "gopkg.in/inconshreveable/log15.v2"
+	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 )
 
 var logger = log.New()
 
+// CrawlOptions are the user's preferences for how a website is crawled.
+type CrawlOptions struct {
+	MaxDepth        uint16
+	Disallow        []string
+	AllowHosts      []string
+	NumConns        int
+	Resolve         []string
+	ConnectTo       []string
+	DNSServer       string
+	DNSTimeout      time.Duration
+	IPv4            bool
+	IPv6            bool
+	WarmUp          bool
+	KeepAlive       time.Duration
+	IdleConnTimeout time.Duration
+	MaxBandwidth    string
+	Priority        []string
+	Rewrite         []string
+	UpgradeHTTP     bool
+	MaxPerSection   int
+	MaxURLLength    int
+	MaxPathSegments int
+	MaxPagination   uint16
+	ZeroBothers     bool
+	RobotsFile      string
+	SkipBoilerplate bool
+	FollowTraps     bool
+	CheckUnfollowed []string
+	IgnoreRobotsTag bool
+	Delay           float64
+	AdaptiveDelay   bool
+	AutoConcurrency bool
+	TargetLatency   time.Duration
+	BreakerFailures int
+	BreakerCooldown time.Duration
+	RetryAfter      string
+	MaxRetryAfter   time.Duration
+	RetryAttempts   int
+	FollowEndpoints bool
+	FollowMobile    bool
+	FollowForms     bool
+	ExtractText     bool
+	Accessibility   bool
+	CheckFragments  bool
+	CheckHrefs      bool
+	FollowDocuments bool
+	Grep            []string
+	HeadFirst       bool
+	ErrorSnippet    int
+	Assets          []string
+	WARCFile        string
+	DryRun          string
+	ChangedOnly     string
+	Deterministic   bool
+	Parser          string
+	Parsers         int
+	StreamOver      string
+	FromArchive     string
+	Control         string
+	Coordinate      string
+	CoordinateToken string
+	Lease           time.Duration
+	MaxMemory       string
+	MaxFrontier     int
+	Redis           string
+	RedisKey        string
+
+	BearerToken       string
+	OAuthTokenURL     string
+	OAuthClientID     string
+	OAuthClientSecret string
+	OAuthScopes       []string
+	ClientCert        string
+	ClientKey         string
+	CookiesFile       string
+	Cookies           []string
+
+	WebhookURL             string
+	WebhookOnServerError   bool
+	WebhookBrokenThreshold int
+
+	// Slots, if set, is shared between crawls to cap the number of requests
+	// they make at once.
+	Slots Semaphore
+	// Traps, if set, records the URL traps the crawl avoids.
+	Traps *TrapFollower
+	// Breaker, if set, is the circuit breaker of the crawl, recording the
+	// hosts it pauses.
+	Breaker *CircuitBreaker
+	// Follows, if set, counts the links declined by each rule of the crawl.
+	Follows *FollowerStats
+	// Probes, if set, is given the crawl's transport, delay and disallowed
+	// paths, for the requests reporters make of the site themselves.
+	Probes *ProbeTransport
+	// Referrers, if set, records the pages linking to each URL, completing
+	// the Referrers of the crawl's pages once it is over.
+	Referrers *Referrers
+	// CollectImages, CollectForms, CollectContacts, CollectSchemas,
+	// CollectSocial and CollectIcons have those details of HTML pages parsed,
+	// for the reporters and output which use them.
+	CollectImages   bool
+	CollectForms    bool
+	CollectContacts bool
+	CollectSchemas  bool
+	CollectSocial   bool
+	CollectIcons    bool
+}
+
+func (o *CrawlOptions) AddFlags(flags *pflag.FlagSet) {
+	flags.Uint16VarP(&o.MaxDepth, "depth", "d", 100, "Maximum crawl depth.")
+	flags.StringSliceVarP(&o.Disallow, "disallow", "i", nil, "Disallowed paths.")
+	flags.StringSliceVarP(&o.AllowHosts, "allow-host", "", nil, "Other hosts to follow links to, such as *.example.com for all subdomains, breaking the crawl down by host.")
+	flags.Uint16VarP(&o.MaxPagination, "max-pagination", "", 0, "Maximum number of rel=\"next\" and rel=\"prev\" links to follow in a row.")
+	flags.BoolVarP(&o.FollowTraps, "follow-traps", "", false, "Follow links which look like endless calendars or repeating paths.")
+	flags.BoolVarP(&o.SkipBoilerplate, "skip-boilerplate-links", "", false, "Only follow links in the content of pages, not their navigation, header, footer or sidebar.")
+	flags.IntVarP(&o.MaxURLLength, "max-url-length", "", 0, "Maximum length of URLs to follow.")
+	flags.IntVarP(&o.MaxPathSegments, "max-path-segments", "", 0, "Maximum number of segments in the paths of URLs to follow.")
+	flags.IntVarP(&o.MaxPerSection, "max-per-section", "", 0, "Maximum pages to crawl under each top-level directory.")
+	flags.IntVarP(&o.NumConns, "connections", "c", 5, "Maximum number of open connections to the server.")
+	flags.StringSliceVarP(&o.Resolve, "resolve", "", nil, "Connect to ADDR for requests to HOST:PORT, given as HOST:PORT:ADDR.")
+	flags.StringSliceVarP(&o.ConnectTo, "connect-to", "", nil, "Connect to HOST2:PORT2 for requests to HOST1:PORT1, given as HOST1:PORT1:HOST2:PORT2.")
+	flags.StringVarP(&o.DNSServer, "dns-server", "", "", "Resolve hostnames using the DNS server at HOST[:PORT].")
+	flags.DurationVarP(&o.DNSTimeout, "dns-timeout", "", 0, "Maximum time to wait for hostnames to resolve.")
+	flags.BoolVarP(&o.IPv4, "ipv4", "4", false, "Only connect to servers over IPv4.")
+	flags.BoolVarP(&o.IPv6, "ipv6", "6", false, "Only connect to servers over IPv6.")
+	flags.BoolVarP(&o.WarmUp, "warm-up", "", false, "Resolve and connect to the site on every connection before the crawl begins, so that the first pages' timings aren't skewed.")
+	flags.DurationVarP(&o.KeepAlive, "keep-alive", "", 30*time.Second, "The interval between TCP keep-alive probes of open connections, or negative to disable them.")
+	flags.DurationVarP(&o.IdleConnTimeout, "idle-conn-timeout", "", 90*time.Second, "How long to keep idle connections open for reuse.")
+	flags.StringVarP(&o.MaxBandwidth, "max-bandwidth", "", "", "Maximum rate at which to download responses, such as 2MB/s.")
+	flags.StringSliceVarP(&o.Rewrite, "rewrite", "", nil, "Rewrite discovered URLs with REGEXP=>REPLACEMENT rules before following them.")
+	flags.BoolVarP(&o.UpgradeHTTP, "upgrade-http", "", false, "Treat http URLs of the site as their https equivalents, for sites which redirect http to https.")
+	flags.StringSliceVarP(&o.Priority, "priority", "", nil, "Crawl paths matching PATTERN=PRIORITY rules first, highest priority first.")
+	flags.BoolVarP(&o.ZeroBothers, "zero", "", false, "The number of bothers to give about robots.txt. ")
+	flags.StringVarP(&o.RobotsFile, "robots-file", "", "", "Read robots.txt rules from this file instead of fetching them, to test changes before deploying them.")
+	flags.StringSliceVarP(&o.CheckUnfollowed, "check-unfollowed", "", nil, "Request the links which these rules, such as depth or disallowed, declined to follow with HEAD once the crawl is complete, to report their status.")
+	flags.BoolVarP(&o.IgnoreRobotsTag, "ignore-robots-tag", "", false, "Follow links from pages with an X-Robots-Tag: nofollow header.")
+	flags.Float64VarP(&o.Delay, "delay", "t", -1, "The number of seconds between requests to the server.")
+	flags.BoolVarP(&o.AutoConcurrency, "auto-concurrency", "", false, "Start with one connection, adding more up to --connections while the server keeps up with --target-latency.")
+	flags.DurationVarP(&o.TargetLatency, "target-latency", "", 500*time.Millisecond, "The 95th percentile time to first byte which --auto-concurrency aims for.")
+	flags.IntVarP(&o.BreakerFailures, "breaker-failures", "", 0, "Pause requests to a host after this many consecutive connection failures or 5xx responses.")
+	flags.DurationVarP(&o.BreakerCooldown, "breaker-cooldown", "", time.Minute, "How long to pause requests to a host once --breaker-failures is reached.")
+	flags.StringVarP(&o.RetryAfter, "retry-after", "", "crawl", "What to pause for the Retry-After of a 429 or 503 response: the whole crawl, the host which responded, or none.")
+	flags.DurationVarP(&o.MaxRetryAfter, "max-retry-after", "", 5*time.Minute, "The longest pause to honour for --retry-after.")
+	flags.IntVarP(&o.RetryAttempts, "retry-after-attempts", "", 3, "The most times to request a page which keeps responding 429 or 503 with a Retry-After.")
+	flags.BoolVarP(&o.AdaptiveDelay, "adaptive", "", false, "Slow down when the server is struggling, and speed up again as it recovers.")
+	flags.StringVarP(&o.WARCFile, "warc", "", "", "Archive all requests and responses to a WARC file (.warc.gz to compress).")
+	flags.StringVarP(&o.DryRun, "dry-run", "", "", "Replay the crawl from a snapshot (.json), archive or directory of fixtures, without any requests, to list the URLs which would be fetched.")
+	flags.StringVarP(&o.ChangedOnly, "changed-only", "", "", "Make conditional requests for the pages of a previous crawl's snapshot (.json, or the latest in a directory), replaying those which haven't changed.")
+	flags.StringVarP(&o.Parser, "parser", "", "regex", "Find the links, assets, frames, feeds, endpoints, alternates, images, forms and contacts of pages with the regex parser, or the tokenizer or dom parsers of golang.org/x/net/html, which skip comments and scripts and unescape URLs.")
+	flags.IntVarP(&o.Parsers, "parsers", "", 0, "Parse pages with this many workers, separately from those fetching them, rather than on each connection's worker.")
+	flags.StringVarP(&o.StreamOver, "stream-over", "", "", "Parse HTML pages larger than this size, such as 50MB, as they're downloaded rather than in memory, finding only their links, assets and frames.")
+	flags.BoolVarP(&o.Deterministic, "deterministic", "", false, "Crawl with a single worker and a fixed clock, so that the output for an unchanging site is identical between runs, except for the timings of requests.")
+	flags.StringVarP(&o.FromArchive, "from-archive", "", "", "Crawl the responses recorded in a WARC or HAR archive, or a directory of fixtures, instead of the website.")
+	flags.StringVarP(&o.Coordinate, "coordinate", "", "", "Hand pages to gergle worker instances connecting to HOST:PORT to fetch and parse, instead of fetching them here.")
+	flags.StringVarP(&o.CoordinateToken, "coordinate-token", "", "", "The bearer token which --coordinate workers must present, and which a worker presents to its coordinator.")
+	flags.DurationVarP(&o.Lease, "lease", "", 2*time.Minute, "How long a --coordinate worker has to return a page before it's handed to another.")
+	flags.StringVarP(&o.Control, "control", "", "", "Accept commands to pause, resume, slow down and inspect the crawl at HOST:PORT or a Unix socket path.")
+	flags.IntVarP(&o.MaxFrontier, "max-frontier", "", 0, "Keep at most this many pending URLs in memory, spilling the rest to disk.")
+	flags.StringVarP(&o.Redis, "redis", "", "", "Keep the frontier and seen pages in the Redis server at HOST:PORT, so that an interrupted crawl resumes where it left off. Only one crawl can use a --redis-key at a time.")
+	flags.StringVarP(&o.RedisKey, "redis-key", "", "gergle", "The prefix of the --redis keys, to keep crawls sharing a Redis server apart.")
+	flags.StringVarP(&o.MaxMemory, "max-memory", "", "", "Fetch one page at a time while the heap is over this size, such as 2GB.")
+	flags.BoolVarP(&o.FollowEndpoints, "follow-endpoints", "", false, "Follow page-like URLs found in inline JSON and data attributes.")
+	flags.BoolVarP(&o.FollowMobile, "follow-mobile", "", false, "Follow the AMP and mobile alternates of pages.")
+	flags.BoolVarP(&o.FollowForms, "follow-forms", "", false, "Follow the actions of GET forms.")
+	flags.IntVarP(&o.ErrorSnippet, "error-snippet", "", 0, "Keep this many bytes of the body of each 4xx and 5xx response, with the server's explanation of the error.")
+	flags.BoolVarP(&o.HeadFirst, "head-first", "", false, "Request each URL with HEAD, and only GET those which look like HTML.")
+	flags.StringSliceVarP(&o.Assets, "asset", "", nil, "Also extract assets from TAG:ATTR[:REL] tags, such as link:href:preload or track:src.")
+	flags.StringArrayVarP(&o.Grep, "grep", "", nil, "Count the matches of regular expressions within each page.")
+	flags.BoolVarP(&o.FollowDocuments, "follow-documents", "", false, "Follow the links embedded in PDF and Office (.docx, .xlsx, .pptx) documents, as links of type \"document\".")
+	flags.BoolVarP(&o.CheckFragments, "check-fragments", "", false, "Report links to #fragments which don't match an id or <a name> of the page linked to.")
+	flags.BoolVarP(&o.CheckHrefs, "check-hrefs", "", false, "Report hrefs with unencoded spaces, control characters or invalid percent-encodings, which some clients can't follow.")
+	flags.BoolVarP(&o.Accessibility, "accessibility", "", false, "Check pages for images missing alt text, links without text and a missing lang attribute.")
+	flags.BoolVarP(&o.ExtractText, "extract-text", "", false, "Extract the visible text of each page, for analysis.")
+	flags.StringVarP(&o.BearerToken, "bearer-token", "", "", "Authenticate requests to the website with this bearer token.")
+	flags.StringVarP(&o.OAuthTokenURL, "oauth-token-url", "", "", "Authenticate requests with tokens from this OAuth2 client credentials endpoint.")
+	flags.StringVarP(&o.OAuthClientID, "oauth-client-id", "", "", "The OAuth2 client ID.")
+	flags.StringVarP(&o.OAuthClientSecret, "oauth-client-secret", "", "", "The OAuth2 client secret.")
+	flags.StringSliceVarP(&o.OAuthScopes, "oauth-scope", "", nil, "The OAuth2 scopes to request.")
+	flags.StringVarP(&o.ClientCert, "client-cert", "", "", "PEM client certificate to present to servers requiring mutual TLS.")
+	flags.StringVarP(&o.ClientKey, "client-key", "", "", "PEM private key of the --client-cert.")
+	flags.StringVarP(&o.CookiesFile, "cookies", "", "", "Send the cookies of a Netscape-format cookies.txt file, such as a browser export.")
+	flags.StringSliceVarP(&o.Cookies, "cookie", "", nil, "Send NAME=VALUE cookies to the website.")
+	flags.StringVarP(&o.WebhookURL, "webhook", "", "", "URL to POST JSON notifications of crawl events to.")
+	flags.BoolVarP(&o.WebhookOnServerError, "webhook-5xx", "", false, "Notify the webhook of the first 5xx response.")
+	flags.IntVarP(&o.WebhookBrokenThreshold, "webhook-broken", "", 0, "Notify the webhook once more than this many pages are broken.")
+}
+
+// Webhook returns the webhook to notify of crawl events, if there is one.
+func (o *CrawlOptions) Webhook() *Webhook {
+	if o.WebhookURL == "" {
+		return nil
+	}
+	webhook := NewWebhook(o.WebhookURL)
+	webhook.OnServerError = o.WebhookOnServerError
+	webhook.BrokenThreshold = o.WebhookBrokenThreshold
+	return webhook
+}
+
 func main() {
-	var maxDepth uint16
-	var disallow []string
 	var quiet bool
 	var verbose bool
-	var numConns int
-	var zeroBothers bool
-	var delay float64
+	var logFormat string
+	var logFile string
 	var longOutput bool
+	var outputFile string
+	var outputSplit int
+	var outputSplitSize string
+	var sinkURL string
+	var labelPairs []string
+	var uploadURL string
+	var manifestFile string
+	var tlsReport bool
+	var tlsExpiryDays int
+	var mixedContent bool
+	var hreflang bool
+	var mobile bool
+	var forms bool
+	var contacts bool
+	var schemes []string
+	var redirectHops int
+	var soft404 bool
+	var pagination bool
+	var stats bool
+	var clickDepth int
+	var authority int
+	var linkStructure bool
+	var structuredData bool
+	var expectSchemas []string
+	var images bool
+	var sharedAssets int
+	var social bool
+	var icons bool
+	var wellKnown bool
+	var caching bool
+	var securityHeaders bool
+	var csp bool
+	var auditCookies bool
+	var externalDomains bool
+	var checkExternal bool
+	var pageDetail string
+	var preset string
+	var preflight bool
+	var followStats bool
+	var caseDuplicates bool
+	var trailingSlashes bool
+	var failOn []string
+	var maxImageSize string
+	var dnsReport bool
+	var captureHeaders []string
+	var assertHeaders []string
+	var budgets []string
+	var orphans bool
+	var sitemaps []string
+	opts := CrawlOptions{}
 
 	cmd := &cobra.Command{
 		Use:   "gergle URL",
 		Short: "Website crawler.",
+		Args:  cobra.ArbitraryArgs,
 	}
-	cmd.Flags().Uint16VarP(&maxDepth, "depth", "d", 100, "Maximum crawl depth.")
-	cmd.Flags().StringSliceVarP(&disallow, "disallow", "i", nil, "Disallowed paths.")
-	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "No logging to stderr.")
-	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output logging.")
-	cmd.Flags().IntVarP(&numConns, "connections", "c", 5, "Maximum number of open connections to the server.")
-	cmd.Flags().BoolVarP(&zeroBothers, "zero", "", false, "The number of bothers to give about robots.txt. ")
-	cmd.Flags().Float64VarP(&delay, "delay", "t", -1, "The number of seconds between requests to the server.")
-	cmd.Flags().BoolVarP(&longOutput, "long", "", false, "List all of the links and assets from a page.")
+	cmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "No logging to stderr.")
+	cmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output logging.")
+	cmd.PersistentFlags().StringVarP(&logFormat, "log-format", "", "", "Log format: json, logfmt or terminal.")
+	cmd.PersistentFlags().StringVarP(&logFile, "log-file", "", "", "Write logs to a file instead of stderr.")
+	opts.AddFlags(cmd.PersistentFlags())
+	cmd.Flags().BoolVarP(&longOutput, "long", "", false, "List all of the links, assets, endpoints and referrers of a page.")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Write each page as a line of JSON to a file (.gz to compress).")
+	cmd.Flags().StringSliceVarP(&labelPairs, "label", "", nil, "Attach KEY=VALUE labels, such as env=staging, to each page of the --output, --sink and --manifest, to tell crawls apart once merged.")
+	cmd.Flags().IntVarP(&outputSplit, "output-split", "", 0, "Start a new --output file after this many pages.")
+	cmd.Flags().StringVarP(&manifestFile, "manifest", "", "", "Write a JSON summary of the crawl's options, timing, totals, errors and output files to a file once it is complete.")
+	cmd.Flags().StringVarP(&uploadURL, "upload", "", "", "Upload the report and the --output and --warc files to s3://BUCKET/PREFIX or gs://BUCKET/PREFIX once the crawl is complete, with the credentials of AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.")
+	cmd.Flags().StringVarP(&sinkURL, "sink", "", "", "Publish each page as a JSON message to nats://HOST[:PORT]/SUBJECT.")
+	cmd.Flags().StringVarP(&outputSplitSize, "output-split-size", "", "", "Start a new --output file before it exceeds this size, such as 100MB.")
+	cmd.Flags().BoolVarP(&tlsReport, "tls", "", false, "Summarise the TLS connection and certificates of each host.")
+	cmd.Flags().IntVarP(&tlsExpiryDays, "tls-expiry", "", 30, "Warn of certificates expiring within this many days.")
+	cmd.Flags().BoolVarP(&mixedContent, "mixed-content", "", false, "Report the plain http links and assets of https pages.")
+	cmd.Flags().BoolVarP(&hreflang, "hreflang", "", false, "Report hreflang alternates which aren't reciprocated.")
+	cmd.Flags().StringSliceVarP(&captureHeaders, "capture-header", "", nil, "Response headers to list beneath each page.")
+	cmd.Flags().StringSliceVarP(&assertHeaders, "assert-header", "", nil, "Fail unless response headers match NAME=REGEXP assertions.")
+	cmd.Flags().StringSliceVarP(&budgets, "budget", "", nil, "Fail when pages exceed NAME=LIMIT budgets for html-size, assets, links or ttfb.")
+	cmd.Flags().BoolVarP(&orphans, "orphans", "", false, "Report sitemap pages which no link led to, and pages missing from the sitemap.")
+	cmd.Flags().StringSliceVarP(&sitemaps, "sitemap", "", nil, "Sitemap URLs to compare against, instead of those listed in robots.txt.")
+	cmd.Flags().BoolVarP(&dnsReport, "dns-errors", "", false, "Report DNS failures (NXDOMAIN, timeout, SERVFAIL) by host.")
+	cmd.Flags().BoolVarP(&forms, "forms", "", false, "Report the forms found on each page, with their method, action and inputs.")
+	cmd.Flags().BoolVarP(&contacts, "collect-contacts", "", false, "Summarise the unique mailto: and tel: addresses linked to across the site.")
+	cmd.Flags().StringSliceVarP(&schemes, "report-schemes", "", nil, "List the pages linking to URLs of these schemes, such as javascript, data or ftp.")
+	cmd.Flags().IntVarP(&redirectHops, "redirect-chains", "", 0, "Report redirect loops, and redirect chains longer than this many hops.")
+	cmd.Flags().BoolVarP(&soft404, "soft-404", "", false, "Report pages which respond 200 but look like the site's missing page.")
+	cmd.Flags().BoolVarP(&pagination, "pagination", "", false, "Report the paginated sequences of pages linked by rel=\"next\".")
+	cmd.Flags().BoolVarP(&stats, "stats", "", false, "Count the pages of each language, media type and template.")
+	cmd.Flags().IntVarP(&clickDepth, "click-depth", "", 0, "Report the pages at each click depth and the average depth of each section, listing pages deeper than this.")
+	cmd.Flags().IntVarP(&authority, "authority", "", 0, "List this many pages with the highest PageRank over the site's internal links.")
+	cmd.Flags().BoolVarP(&linkStructure, "link-structure", "", false, "Report dead-end pages, pages unreachable from the home page, and isolated clusters of pages.")
+	cmd.Flags().BoolVarP(&structuredData, "structured-data", "", false, "Count the pages with each schema.org type of JSON-LD, microdata or RDFa.")
+	cmd.Flags().StringSliceVarP(&expectSchemas, "expect-schema", "", nil, "Fail unless pages matching PATTERN=TYPE rules have structured data of the type.")
+	cmd.Flags().BoolVarP(&images, "images", "", false, "Audit the format, dimensions, size and alt text of images.")
+	cmd.Flags().IntVarP(&sharedAssets, "shared-assets", "", 0, "List this many assets shared by the most pages, along with assets served under several URLs and the total weight of unique assets.")
+	cmd.Flags().BoolVarP(&social, "social", "", false, "Audit the Open Graph and Twitter card metadata of pages, checking og:images resolve.")
+	cmd.Flags().BoolVarP(&icons, "icons", "", false, "Check /favicon.ico, the icons pages declare and their web app manifests.")
+	cmd.Flags().BoolVarP(&wellKnown, "well-known", "", false, "Probe the site's security.txt, change-password, robots.txt and sitemap.xml, reporting the status of each.")
+	cmd.Flags().BoolVarP(&caching, "caching", "", false, "Report pages with missing or contradictory ETag, Last-Modified, Cache-Control and Expires headers.")
+	cmd.Flags().BoolVarP(&securityHeaders, "security-headers", "", false, "Report pages missing CSP, HSTS, X-Content-Type-Options, Referrer-Policy or X-Frame-Options headers.")
+	cmd.Flags().BoolVarP(&csp, "csp", "", false, "Report the assets and frames of pages which their Content-Security-Policy would block.")
+	cmd.Flags().BoolVarP(&externalDomains, "external-domains", "", false, "Summarise the links to other sites by domain, with the pages linking to each.")
+	cmd.Flags().BoolVarP(&checkExternal, "check-external", "", false, "Request each link to other sites after the crawl, summarising them by domain and listing those which are dead.")
+	cmd.Flags().BoolVarP(&auditCookies, "audit-cookies", "", false, "Report the cookies the website sets by path, with those missing Secure, HttpOnly or SameSite attributes.")
+	cmd.Flags().BoolVarP(&caseDuplicates, "case-duplicates", "", false, "Report URLs which only differ in the case of their paths but serve identical content.")
+	cmd.Flags().BoolVarP(&trailingSlashes, "trailing-slashes", "", false, "Probe each page with and without a trailing slash, reporting duplicates and redirects against the site's usual direction.")
+	cmd.Flags().BoolVarP(&followStats, "follow-stats", "", false, "Count the links each rule, such as external, depth, disallowed or seen, declined to follow.")
+	cmd.Flags().BoolVarP(&preflight, "preflight", "", false, "Before crawling, check the site resolves, responds quickly, accepts the credentials and serves its links in HTML, failing fast on problems.")
+	cmd.Flags().StringVarP(&preset, "preset", "", "", "Crawl with the flags of a preset: seo-audit, link-check or mirror. Flags given explicitly take precedence.")
+	cmd.Flags().StringVarP(&pageDetail, "page-detail", "", "", "Fetch this page and all of its assets instead of crawling, printing a waterfall of their timings and sizes.")
+	cmd.Flags().StringSliceVarP(&failOn, "fail-on", "", nil, "Fail the crawl on these findings: missing-security-headers.")
+	cmd.Flags().StringVarP(&maxImageSize, "max-image-size", "", "200KB", "Report images larger than this size.")
+	cmd.Flags().BoolVarP(&mobile, "mobile", "", false, "Report AMP and mobile alternates which are broken or weren't crawled.")
 
-	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
 		// Configure logging.
 		var logLevel log.Lvl
 		if verbose && quiet {
@@ -48,95 +350,1016 @@ func main() {
 		} else {
 			logLevel = log.LvlInfo
 		}
-		logger.SetHandler(log.LvlFilterHandler(logLevel, log.StderrHandler))
+		handler, err := logHandler(logFormat, logFile)
+		if err != nil {
+			return err
+		}
+		logger.SetHandler(log.LvlFilterHandler(logLevel, handler))
 
-		// Ensure the user provides only a single URL.
-		if len(args) < 1 {
-			return errors.New("URL argument required.")
-		} else if len(args) > 1 {
-			return errors.New("Unexpected arguments after URL.")
+		if opts.Deterministic {
+			clock = fixedClock(deterministicTime)
+		}
+		return nil
+	}
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if preset != "" {
+			if err := applyPreset(cmd.Flags(), preset); err != nil {
+				return err
+			}
+		}
+		if pageDetail != "" {
+			if len(args) > 0 {
+				return errors.New("Unexpected URL argument with --page-detail.")
+			}
+			return runPageDetail(pageDetail, opts)
 		}
 
-		// Ensure the user has provided a valid URL.
-		initUrl, err := url.Parse(args[0])
-		if err != nil || (initUrl.Scheme != "http" && initUrl.Scheme != "https") {
-			return errors.New("Expected URL of the form http[s]://...")
+		initUrl, err := parseURLArg(args)
+		if err != nil {
+			return err
 		}
 
-		// Prepare the HTTP Client with a series of connections.
-		client := &http.Client{Transport: &http.Transport{
-			MaxIdleConnsPerHost: numConns,
-		}}
+		failChecks, err := parseFailOn(failOn)
+		if err != nil {
+			return err
+		}
 
-		if !zeroBothers {
-			// Be a good citizen: fetch the target's preferred defaults.
-			robots, err := fetchRobots(client, initUrl)
-			if err == nil {
-				disallow = append(disallow, readDisallowRules(robots)...)
-				if delay < 0 {
-					delay = readCrawlDelay(robots)
+		replay := ""
+		if opts.DryRun != "" {
+			replay = "--dry-run"
+		} else if opts.FromArchive != "" {
+			replay = "--from-archive"
+		}
+		if replay != "" {
+			live := []struct {
+				flag string
+				set  bool
+			}{{"--preflight", preflight}, {"--soft-404", soft404}, {"--orphans", orphans}, {"--check-external", checkExternal}}
+			for _, check := range live {
+				if check.set {
+					return fmt.Errorf("%s requests the live site, so can't be used with %s.", check.flag, replay)
 				}
-			} else {
-				logger.Info("Failed to fetch robots.txt", "error", err)
 			}
 		}
 
-		var fetcher Fetcher = &HTTPFetcher{client, &RegexPageParser{}}
-
-		// Rate-limiting.
-		if delay > 0 {
-			duration := time.Duration(delay * 1e9)
-			fetcher = NewRateLimitedFetcher(duration, fetcher)
-			logger.Info("Using rate-limiting", "interval", duration)
+		if opts.Deterministic {
+			realDates := []struct {
+				flag string
+				set  bool
+			}{{"--tls", tlsReport}, {"--caching", caching}, {"--well-known", wellKnown}}
+			for _, check := range realDates {
+				if check.set {
+					return fmt.Errorf("%s checks dates against the time, so can't be used with --deterministic.", check.flag)
+				}
+			}
 		}
 
-		// Construct our rules for following links.
-		follower := UnanimousFollower{}
+		if preflight {
+			transport, err := newSiteTransport(initUrl, opts)
+			if err != nil {
+				return err
+			}
+			jar, err := newCookieJar(initUrl, opts)
+			if err != nil {
+				return err
+			}
+			client := &http.Client{Transport: transport, CheckRedirect: checkRedirect, Jar: jar, Timeout: time.Minute}
+			result, err := RunPreflight(client, initUrl, opts, 3)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+			result.Print(os.Stdout)
+		}
 
-		logger.Info("Ignoring external links")
-		follower = append(follower, &LocalFollower{})
+		// Reporting.
+		reporters := Reporters{}
+		probes := &ProbeTransport{}
+		opts.Probes = probes
+		if longOutput {
+			opts.CollectForms, opts.CollectContacts, opts.CollectSchemas = true, true, true
+		}
+		if !opts.FollowTraps {
+			opts.Traps = NewTrapFollower()
+			reporters = append(reporters, NewTrapReporter(opts.Traps))
+		}
+		if opts.BreakerFailures > 0 {
+			opts.Breaker = NewCircuitBreaker(opts.BreakerFailures, opts.BreakerCooldown)
+			reporters = append(reporters, NewBreakerReporter(opts.Breaker))
+		}
+		if followStats {
+			opts.Follows = NewFollowerStats()
+			reporters = append(reporters, NewFollowerReporter(opts.Follows))
+		}
+		if tlsReport {
+			reporters = append(reporters, NewTLSReporter(time.Duration(tlsExpiryDays)*24*time.Hour))
+		}
+		if mixedContent {
+			reporters = append(reporters, NewMixedContentReporter())
+		}
+		if hreflang {
+			reporters = append(reporters, NewHreflangReporter())
+		}
+		if len(opts.AllowHosts) > 0 {
+			reporters = append(reporters, NewHostReporter())
+		}
+		if checkExternal {
+			transport, err := newSiteTransport(initUrl, opts)
+			if err != nil {
+				return err
+			}
+			externalReporter := NewExternalReporter(&http.Client{Transport: transport, Timeout: 30 * time.Second})
+			externalReporter.Workers = opts.NumConns
+			reporters = append(reporters, externalReporter)
+		} else if externalDomains {
+			reporters = append(reporters, NewExternalReporter(nil))
+		}
+		if mobile {
+			reporters = append(reporters, NewMobileReporter())
+		}
+		if forms {
+			opts.CollectForms = true
+			reporters = append(reporters, NewFormReporter())
+		}
+		if contacts {
+			opts.CollectContacts = true
+			reporters = append(reporters, NewContactReporter())
+		}
+		if len(schemes) > 0 {
+			reporters = append(reporters, NewSchemeReporter(schemes...))
+		}
+		if len(opts.Grep) > 0 {
+			reporters = append(reporters, NewGrepReporter(opts.Grep...))
+		}
+		if redirectHops > 0 {
+			reporters = append(reporters, NewRedirectReporter(redirectHops))
+		}
+		if pagination {
+			reporters = append(reporters, NewPaginationReporter())
+		}
+		if stats {
+			reporters = append(reporters, NewStatsReporter())
+		}
+		if clickDepth > 0 {
+			reporters = append(reporters, NewDepthReporter(uint16(clickDepth)))
+		}
+		if authority > 0 {
+			reporters = append(reporters, NewAuthorityReporter(authority))
+		}
+		if linkStructure {
+			reporters = append(reporters, NewLinkStructureReporter())
+		}
+		if opts.CheckFragments {
+			reporters = append(reporters, NewFragmentReporter())
+		}
+		if opts.CheckHrefs {
+			reporters = append(reporters, NewHrefReporter())
+		}
+		if opts.Accessibility {
+			reporters = append(reporters, NewAccessibilityReporter())
+		}
+		if dnsReport {
+			reporters = append(reporters, NewDNSReporter())
+		}
+		if structuredData || len(expectSchemas) > 0 {
+			opts.CollectSchemas = true
+			expectations := []SchemaExpectation{}
+			for _, rule := range expectSchemas {
+				expectation, err := ParseSchemaExpectation(rule)
+				if err != nil {
+					return err
+				}
+				expectations = append(expectations, expectation)
+			}
+			reporters = append(reporters, NewStructuredDataReporter(expectations...))
+		}
+		if len(assertHeaders) > 0 {
+			assertions := []HeaderAssertion{}
+			for _, rule := range assertHeaders {
+				assertion, err := ParseHeaderAssertion(rule)
+				if err != nil {
+					return err
+				}
+				assertions = append(assertions, assertion)
+			}
+			reporters = append(reporters, NewHeaderAssertionReporter(assertions...))
+		}
+		if len(budgets) > 0 {
+			pageBudgets := []Budget{}
+			for _, rule := range budgets {
+				budget, err := ParseBudget(rule)
+				if err != nil {
+					return err
+				}
+				pageBudgets = append(pageBudgets, budget)
+			}
+			reporters = append(reporters, NewBudgetReporter(pageBudgets...))
+		}
+		if images {
+			opts.CollectImages = true
+			maxSize, err := parseSize(maxImageSize)
+			if err != nil {
+				return err
+			}
+			imageReporter := NewImageReporter(&http.Client{Transport: probes}, int64(maxSize))
+			imageReporter.Workers = opts.NumConns
+			reporters = append(reporters, imageReporter)
+		}
+		if sharedAssets > 0 {
+			assetReporter := NewAssetReporter(&http.Client{Transport: probes}, sharedAssets)
+			assetReporter.Workers = opts.NumConns
+			reporters = append(reporters, assetReporter)
+		}
+		if social {
+			opts.CollectSocial = true
+			socialReporter := NewSocialReporter(&http.Client{Transport: probes})
+			socialReporter.Workers = opts.NumConns
+			reporters = append(reporters, socialReporter)
+		}
+		if icons {
+			opts.CollectIcons = true
+			iconReporter := NewIconReporter(&http.Client{Transport: probes}, initUrl)
+			iconReporter.Workers = opts.NumConns
+			reporters = append(reporters, iconReporter)
+		}
+		if wellKnown {
+			reporters = append(reporters, NewWellKnownReporter(&http.Client{Transport: probes}, initUrl))
+		}
+		if caching {
+			reporters = append(reporters, NewCachingReporter())
+		}
+		if securityHeaders || failChecks["missing-security-headers"] {
+			securityReporter := NewSecurityHeaderReporter()
+			securityReporter.Fail = failChecks["missing-security-headers"]
+			reporters = append(reporters, securityReporter)
+		}
+		if csp {
+			reporters = append(reporters, NewCSPReporter())
+		}
+		if auditCookies {
+			reporters = append(reporters, NewCookieReporter())
+		}
+		if caseDuplicates {
+			reporters = append(reporters, NewCaseDuplicateReporter())
+		}
+		if trailingSlashes {
+			slashReporter := NewTrailingSlashReporter(&http.Client{Transport: probes})
+			slashReporter.Workers = opts.NumConns
+			reporters = append(reporters, slashReporter)
+		}
+		if soft404 {
+			transport, err := newSiteTransport(initUrl, opts)
+			if err != nil {
+				return err
+			}
+			client := &http.Client{Transport: transport, CheckRedirect: checkRedirect}
+			fingerprint, err := ProbeSoft404(&HTTPFetcher{Client: client, Parser: &RegexPageParser{ExtractText: true}}, initUrl)
+			if err != nil {
+				logger.Warn("Not reporting soft 404s without a missing page", "error", err)
+			} else if fingerprint != nil {
+				opts.ExtractText = true
+				reporters = append(reporters, NewSoft404Reporter(fingerprint))
+			}
+		}
+		if orphans {
+			transport, err := newSiteTransport(initUrl, opts)
+			if err != nil {
+				return err
+			}
+			locs, err := fetchSitemaps(&http.Client{Transport: transport}, initUrl, sitemaps)
+			if err != nil {
+				logger.Warn("Not reporting orphan pages without a sitemap", "error", err)
+			} else {
+				reporters = append(reporters, NewSitemapReporter(locs...))
+			}
+		}
 
-		if maxDepth >= 0 {
-			logger.Info("Ignoring deep links", "maxDepth", maxDepth)
-			follower = append(follower, &ShallowFollower{maxDepth})
+		labels, err := ParseLabels(labelPairs)
+		if err != nil {
+			return err
 		}
 
-		if len(disallow) > 0 {
-			disallowFollower := NewRobotsDisallowFollower(disallow...)
-			logger.Info("Ignoring paths", "disallow", disallowFollower.Rules)
-			follower = append(follower, disallowFollower)
+		var output *PageWriter
+		if outputFile != "" {
+			splitSize := 0.0
+			if outputSplitSize != "" {
+				if splitSize, err = parseSize(outputSplitSize); err != nil {
+					return err
+				}
+			}
+			output = NewPageWriter(outputFile, outputSplit, int64(splitSize))
+			output.Labels = labels
+			defer output.Close()
+		} else if outputSplit > 0 || outputSplitSize != "" {
+			return errors.New("--output-split and --output-split-size require an --output file.")
+		}
+		var uploader *Uploader
+		if uploadURL != "" {
+			if uploader, err = ParseUploadURL(uploadURL); err != nil {
+				return err
+			}
+		}
+		var sink *NATSSink
+		if sinkURL != "" {
+			if sink, err = ParseSink(sinkURL); err != nil {
+				return err
+			}
+			sink.Labels = labels
+			if err := sink.Dial(); err != nil {
+				return fmt.Errorf("Failed to connect to --sink %s: %s", sink.URL.Host, err)
+			}
+			defer sink.Close()
 		}
 
-		logger.Info("Ignoring previously seen paths")
-		follower = append(follower, NewUnseenFollower(initUrl))
+		var manifest *Manifest
+		if manifestFile != "" {
+			manifest = NewManifest(cmd.Flags(), initUrl)
+			manifest.Labels = labels
+		}
 
-		// Crawling.
-		pages := make(chan Page, 10)
-		go func() {
-			crawl(fetcher, initUrl, pages, follower)
-			close(pages)
-			if stoppable, ok := fetcher.(Stopper); ok {
-				stoppable.Stop()
-			}
-		}()
+		opts.Referrers = NewReferrers()
+		pages, _, err := startCrawl(initUrl, opts)
+		if err != nil {
+			return err
+		}
 
 		// Output.
 		for page := range pages {
-			fmt.Printf("URL: %s, Depth: %d, Links: %d, Assets: %d\n", page.URL, page.Depth, len(page.Links), len(page.Assets))
+			reporters.Observe(page)
+			if manifest != nil {
+				manifest.Observe(page)
+			}
+			if output != nil {
+				if err := output.Write(page); err != nil {
+					return fmt.Errorf("Failed to write %s: %s", outputFile, err)
+				}
+			}
+			if sink != nil {
+				if err := sink.Write(page); err != nil {
+					return fmt.Errorf("Failed to publish to NATS subject %s: %s", sink.Subject, err)
+				}
+			}
+			fmt.Printf("URL: %s, Depth: %d, Links: %d, Assets: %d", page.URL, page.Depth, len(page.Links), len(page.Assets))
+			if page.Frame {
+				fmt.Print(", Frame")
+			}
+			if page.NoIndex {
+				fmt.Print(", NoIndex")
+			}
+			if page.NoFollow {
+				fmt.Print(", NoFollow")
+			}
+			if page.Unchanged {
+				fmt.Print(", Unchanged")
+			}
+			if page.Unfollowed != "" {
+				fmt.Printf(", Unfollowed: %s, Status: %d", page.Unfollowed, page.StatusCode)
+			}
+			fmt.Println()
+			for _, name := range captureHeaders {
+				for _, value := range page.Header.Values(name) {
+					fmt.Printf("- header %s: %s\n", name, value)
+				}
+			}
 			if longOutput {
+				if page.StatusCode != 0 {
+					fmt.Printf("- size: %d bytes, ttfb: %s\n", page.Size, page.TTFB)
+				}
+				if page.Snippet != "" {
+					fmt.Printf("- snippet: %q\n", page.Snippet)
+				}
+				for _, name := range CachingHeaders {
+					if value := page.Header.Get(name); value != "" {
+						fmt.Printf("- caching %s: %s\n", name, value)
+					}
+				}
+				for _, pattern := range opts.Grep {
+					if count := page.Matches[pattern]; count > 0 {
+						fmt.Printf("- grep %s: %d matches\n", pattern, count)
+					}
+				}
+				if page.Accessibility != nil {
+					for _, problem := range page.Accessibility.Problems() {
+						fmt.Printf("- accessibility: %s\n", problem)
+					}
+				}
+				if len(page.Schemas) > 0 {
+					fmt.Printf("- schemas: %s\n", strings.Join(page.Schemas, ", "))
+				}
+				if opts.ExtractText && page.Processed {
+					fmt.Printf("- text: %d words\n", len(strings.Fields(page.Text)))
+				}
 				for _, link := range page.Links {
-					fmt.Printf("- %s: %s\n", link.Type, link.URL)
+					if link.IsBoilerplate() {
+						fmt.Printf("- %s (%s): %s\n", link.Type, link.Region, link.URL)
+					} else {
+						fmt.Printf("- %s: %s\n", link.Type, link.URL)
+					}
 				}
 				for _, link := range page.Assets {
 					fmt.Printf("- %s: %s\n", link.Type, link.URL)
 				}
+				for _, link := range page.Endpoints {
+					fmt.Printf("- %s: %s\n", link.Type, link.URL)
+				}
+				for _, alternate := range page.Alternates {
+					if alternate.HrefLang != "" {
+						fmt.Printf("- %s %s: %s\n", alternate.Type(), alternate.HrefLang, alternate.URL)
+					} else {
+						fmt.Printf("- %s: %s\n", alternate.Type(), alternate.URL)
+					}
+				}
+				for _, link := range page.Contacts {
+					fmt.Printf("- %s: %s\n", link.Type, link.Contact())
+				}
+				for _, form := range page.Forms {
+					fmt.Printf("- form %s: %s (%s)\n", form.Method, form.Action, strings.Join(form.Inputs, ", "))
+				}
+				for _, redirect := range page.Redirects {
+					fmt.Printf("- redirect: %s\n", redirect)
+				}
+				for _, referrer := range page.Referrers {
+					fmt.Printf("- referrer: %s\n", referrer)
+				}
+			}
+		}
+		// Complete the referrers of pages which more were found linking to
+		// after they were crawled.
+		for _, late := range opts.Referrers.Late() {
+			if output != nil {
+				if err := output.WriteReferrers(late); err != nil {
+					return fmt.Errorf("Failed to write %s: %s", outputFile, err)
+				}
+			}
+			if longOutput {
+				fmt.Printf("Referrers: %s\n", late.URL)
+				for _, referrer := range late.Referrers {
+					fmt.Printf("- referrer: %s\n", referrer)
+				}
+			}
+		}
+		report := &bytes.Buffer{}
+		reporters.Report(io.MultiWriter(os.Stdout, report))
+
+		// Artifacts.
+		artifacts := []string{}
+		if output != nil {
+			if err := output.Close(); err != nil {
+				return fmt.Errorf("Failed to write %s: %s", outputFile, err)
+			}
+			artifacts = append(artifacts, output.Files...)
+		}
+		if opts.WARCFile != "" {
+			artifacts = append(artifacts, opts.WARCFile)
+		}
+		if manifest != nil {
+			if err := manifest.Save(manifestFile, artifacts, reporters.Failed()); err != nil {
+				return fmt.Errorf("Failed to write %s: %s", manifestFile, err)
+			}
+			artifacts = append(artifacts, manifestFile)
+		}
+		if uploader != nil {
+			if err := uploadCrawl(uploader, report.Bytes(), artifacts); err != nil {
+				return err
 			}
 		}
 
+		if reporters.Failed() {
+			cmd.SilenceUsage = true
+			return errors.New("Crawl failed checks.")
+		}
 		return nil
 	}
 
-	cmd.Execute()
+	cmd.AddCommand(newWatchCommand(&opts))
+	cmd.AddCommand(newServeCommand(&opts))
+	cmd.AddCommand(newRobotsCommand(&opts))
+	cmd.AddCommand(newWorkerCommand(&opts))
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// logHandler returns the log15 handler writing logs in the given format to
+// the file, or to stderr if no file is given. Logs to stderr default to the
+// terminal format, and logs to files to logfmt.
+func logHandler(format string, file string) (log.Handler, error) {
+	if format == "" {
+		if file == "" {
+			format = "terminal"
+		} else {
+			format = "logfmt"
+		}
+	}
+
+	var logFormat log.Format
+	switch format {
+	case "json":
+		logFormat = log.JsonFormat()
+	case "logfmt":
+		logFormat = log.LogfmtFormat()
+	case "terminal":
+		logFormat = log.TerminalFormat()
+	default:
+		return nil, fmt.Errorf("Unknown --log-format %q.", format)
+	}
+
+	if file == "" {
+		return log.StreamHandler(os.Stderr, logFormat), nil
+	}
+	return log.FileHandler(file, logFormat)
+}
+
+// parseURLArg ensures that the user has provided exactly one valid URL.
+func parseURLArg(args []string) (*url.URL, error) {
+	if len(args) < 1 {
+		return nil, errors.New("URL argument required.")
+	} else if len(args) > 1 {
+		return nil, errors.New("Unexpected arguments after URL.")
+	}
+
+	initUrl, err := url.Parse(args[0])
+	if err != nil || (initUrl.Scheme != "http" && initUrl.Scheme != "https") {
+		return nil, errors.New("Expected URL of the form http[s]://...")
+	}
+	return initUrl, nil
+}
+
+// newPageParser returns the parser of the pages of a crawl with the options.
+func newPageParser(opts CrawlOptions) (*RegexPageParser, error) {
+	parser := &RegexPageParser{
+		FollowEndpoints:    opts.FollowEndpoints,
+		FollowMobile:       opts.FollowMobile,
+		FollowForms:        opts.FollowForms,
+		ExtractText:        opts.ExtractText,
+		IgnoreRobotsTag:    opts.IgnoreRobotsTag,
+		CheckAccessibility: opts.Accessibility,
+		CollectFragments:   opts.CheckFragments,
+		CheckHrefs:         opts.CheckHrefs,
+		FollowDocuments:    opts.FollowDocuments,
+		CollectImages:      opts.CollectImages,
+		CollectForms:       opts.CollectForms,
+		CollectContacts:    opts.CollectContacts,
+		CollectSchemas:     opts.CollectSchemas,
+		CollectSocial:      opts.CollectSocial,
+		CollectIcons:       opts.CollectIcons,
+	}
+	var err error
+	if parser.Elements, err = NewElementParser(opts.Parser); err != nil {
+		return nil, err
+	}
+	if parser.Elements != nil {
+		logger.Info("Parsing pages", "parser", opts.Parser)
+	}
+	for _, pattern := range opts.Grep {
+		grep, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --grep %q: %s", pattern, err)
+		}
+		parser.Grep = append(parser.Grep, grep)
+	}
+	if len(opts.Assets) > 0 {
+		parser.AssetRules = append([]AssetRule{}, DefaultAssetRules...)
+		for _, rule := range opts.Assets {
+			assetRule, err := ParseAssetRule(rule)
+			if err != nil {
+				return nil, err
+			}
+			parser.AssetRules = append(parser.AssetRules, assetRule)
+		}
+		logger.Info("Extracting additional assets", "asset", opts.Assets)
+	}
+	if opts.StreamOver != "" {
+		streamOver, err := parseSize(opts.StreamOver)
+		if err != nil {
+			return nil, err
+		}
+		parser.StreamOver = int64(streamOver)
+		logger.Info("Streaming large pages", "over", opts.StreamOver)
+	}
+	return parser, nil
+}
+
+// startCrawl begins crawling from initUrl in the background, returning the
+// channel of crawled pages and the control of the crawl. The channel is closed
+// once the crawl is complete.
+func startCrawl(initUrl *url.URL, opts CrawlOptions) (<-chan Page, *CrawlControl, error) {
+	if opts.NumConns < 1 {
+		return nil, nil, errors.New("--connections must be at least 1.")
+	}
+	disallow := append([]string{}, opts.Disallow...)
+	delay := opts.Delay
+
+	// Prepare the HTTP Client with a series of connections.
+	transport, err := newSiteTransport(initUrl, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Replaying.
+	var snapshot *Snapshot
+	offline := opts.DryRun != "" || opts.FromArchive != ""
+	if opts.DryRun != "" && opts.FromArchive != "" {
+		return nil, nil, errors.New("--dry-run and --from-archive are mutually exclusive options.")
+	} else if offline && opts.WARCFile != "" {
+		return nil, nil, errors.New("--warc can't archive a crawl which makes no requests.")
+	} else if opts.DryRun != "" {
+		transport, snapshot, err = dryRunTransport(opts.DryRun)
+	} else if opts.FromArchive != "" {
+		transport, err = newFileTransport(opts.FromArchive)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Warming up connections.
+	if opts.WarmUp && !offline {
+		warmUp(transport, warmUpURLs(initUrl, opts.AllowHosts), opts.NumConns)
+	}
+
+	// Bandwidth throttling.
+	if opts.MaxBandwidth != "" {
+		rate, err := parseBandwidth(opts.MaxBandwidth)
+		if err != nil {
+			return nil, nil, err
+		}
+		logger.Info("Throttling bandwidth", "bytesPerSecond", rate)
+		transport = &ThrottledTransport{transport, NewTokenBucket(rate)}
+	}
+
+	// Probes, made once the archive is closed, aren't archived, nor are they
+	// conditional requests.
+	probeTransport := transport
+
+	// Archiving.
+	var warc *WARCWriter
+	if opts.WARCFile != "" {
+		warc, err = NewWARCWriter(opts.WARCFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		logger.Info("Archiving to WARC", "file", opts.WARCFile)
+		transport = &WARCTransport{transport, warc}
+	}
+
+	// Skipping unchanged pages.
+	var previous *Snapshot
+	if opts.ChangedOnly != "" {
+		if offline {
+			return nil, nil, errors.New("--changed-only can't make conditional requests in a crawl which makes no requests.")
+		}
+		if previous, err = readPreviousSnapshot(opts.ChangedOnly); err != nil {
+			return nil, nil, fmt.Errorf("Failed to read snapshot %s: %s", opts.ChangedOnly, err)
+		}
+		if previous != nil {
+			logger.Info("Only fetching changed pages", "snapshot", opts.ChangedOnly, "pages", len(previous.Pages))
+			transport = &ConditionalTransport{transport, previous}
+		} else {
+			logger.Info("No snapshot to compare against", "dir", opts.ChangedOnly)
+		}
+	}
+
+	jar, err := newCookieJar(initUrl, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	client := &http.Client{Transport: transport, CheckRedirect: checkRedirect, Jar: jar}
+
+	if opts.RobotsFile != "" {
+		if opts.ZeroBothers {
+			return nil, nil, errors.New("--zero and --robots-file are mutually exclusive options.")
+		}
+		robots, err := ioutil.ReadFile(opts.RobotsFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		logger.Info("Using robots.txt from file", "file", opts.RobotsFile)
+		disallow = append(disallow, readDisallowRules(robots)...)
+		if delay < 0 {
+			delay = readCrawlDelay(robots)
+		}
+	} else if !opts.ZeroBothers {
+		// Be a good citizen: fetch the target's preferred defaults.
+		robots, err := fetchRobots(client, initUrl)
+		if err == nil {
+			disallow = append(disallow, readDisallowRules(robots)...)
+			if delay < 0 {
+				delay = readCrawlDelay(robots)
+			}
+		} else {
+			logger.Info("Failed to fetch robots.txt", "error", err)
+		}
+	}
+
+	parser, err := newPageParser(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	var responseParser ResponsePageParser = parser
+	var parsePool *ParsePool
+	if opts.Parsers > 0 {
+		logger.Info("Parsing pages separately from fetching", "parsers", opts.Parsers)
+		parsePool = NewParsePool(parser, opts.Parsers)
+		parsePool.MaxBuffer = parser.StreamOver
+		responseParser = parsePool
+	}
+	var fetcher Fetcher = &HTTPFetcher{Client: client, Parser: responseParser, HeadFirst: opts.HeadFirst, SnippetSize: opts.ErrorSnippet}
+	var coordinator *Coordinator
+	var coordinate net.Listener
+	if opts.Coordinate != "" {
+		if coordinate, err = net.Listen("tcp", opts.Coordinate); err != nil {
+			return nil, nil, err
+		}
+		logger.Info("Coordinating workers", "addr", coordinate.Addr(), "lease", opts.Lease)
+		if opts.CoordinateToken == "" && !isLoopback(opts.Coordinate) {
+			logger.Warn("Coordinating workers beyond localhost without a --coordinate-token")
+		}
+		coordinator = NewCoordinator(initUrl, opts.Lease)
+		coordinator.Token = opts.CoordinateToken
+		go http.Serve(coordinate, coordinator)
+		fetcher = coordinator
+	}
+	if snapshot != nil {
+		fetcher = NewSnapshotFetcher(snapshot)
+	}
+	if previous != nil {
+		fetcher = NewChangedOnlyFetcher(fetcher, previous)
+	}
+	if opts.Slots != nil {
+		fetcher = &LimitedFetcher{opts.Slots, fetcher}
+	}
+
+	if opts.BreakerFailures > 0 && !offline {
+		breaker := opts.Breaker
+		if breaker == nil {
+			breaker = NewCircuitBreaker(opts.BreakerFailures, opts.BreakerCooldown)
+		}
+		logger.Info("Pausing failing hosts", "failures", opts.BreakerFailures, "cooldown", opts.BreakerCooldown)
+		fetcher = &CircuitBreakerFetcher{breaker, fetcher}
+	}
+
+	if opts.AutoConcurrency && !offline {
+		logger.Info("Adjusting concurrency to the server's latency", "target", opts.TargetLatency, "max", opts.NumConns)
+		fetcher = NewAutoConcurrencyFetcher(opts.TargetLatency, opts.NumConns, fetcher)
+	}
+
+	// Rate-limiting.
+	if offline {
+		logger.Info("Not rate-limiting a crawl which makes no requests")
+	} else if opts.AdaptiveDelay {
+		duration := time.Duration(math.Max(delay, 0) * 1e9)
+		fetcher = NewAdaptiveRateLimitedFetcher(duration, fetcher)
+		logger.Info("Using adaptive rate-limiting", "minInterval", duration)
+	} else if delay > 0 {
+		duration := time.Duration(delay * 1e9)
+		fetcher = NewRateLimitedFetcher(duration, fetcher)
+		logger.Info("Using rate-limiting", "interval", duration)
+	}
+	// The control changes the delay of the rate-limiting, which is stopped once
+	// the crawl is done.
+	limiter := fetcher
+
+	// Retries wait out their Retry-After ahead of the rate-limiting, so that
+	// they're spaced out like the rest of the requests once the pause is over.
+	switch opts.RetryAfter {
+	case "crawl", "host":
+		if !offline {
+			fetcher = NewRetryAfterFetcher(opts.RetryAfter == "host", opts.MaxRetryAfter, opts.RetryAttempts, fetcher)
+		}
+	case "none", "":
+	default:
+		return nil, nil, fmt.Errorf("Expected --retry-after of crawl, host or none, got %q.", opts.RetryAfter)
+	}
+	if opts.Probes != nil {
+		var probeDelay time.Duration
+		if !offline {
+			probeDelay = time.Duration(math.Max(delay, 0) * 1e9)
+		}
+		opts.Probes.Follow(probeTransport, initUrl, probeDelay, NewRobotsDisallowFollower(disallow...))
+	}
+
+	// Rewriting.
+	rewriter := Rewriters{}
+	if len(opts.Rewrite) > 0 {
+		regexRewriter := RegexRewriter{}
+		for _, rule := range opts.Rewrite {
+			rewriteRule, err := ParseRewriteRule(rule)
+			if err != nil {
+				return nil, nil, err
+			}
+			regexRewriter = append(regexRewriter, rewriteRule)
+		}
+		logger.Info("Rewriting URLs", "rewrite", opts.Rewrite)
+		rewriter = append(rewriter, regexRewriter)
+	}
+	if opts.UpgradeHTTP {
+		logger.Info("Treating http URLs as https", "host", initUrl.Host)
+		rewriter = append(rewriter, &UpgradeRewriter{initUrl.Host})
+	}
+
+	// Construct our rules for following links.
+	follower := UnanimousFollower{}
+
+	logger.Info("Ignoring external links")
+	follower = append(follower, &LocalFollower{Hosts: opts.AllowHosts})
+	if len(opts.AllowHosts) > 0 {
+		logger.Info("Following links to other hosts", "hosts", opts.AllowHosts)
+	}
+
+	if opts.MaxDepth >= 0 {
+		logger.Info("Ignoring deep links", "maxDepth", opts.MaxDepth)
+		follower = append(follower, &ShallowFollower{opts.MaxDepth})
+	}
+
+	if opts.MaxURLLength > 0 {
+		logger.Info("Ignoring long URLs", "maxURLLength", opts.MaxURLLength)
+		follower = append(follower, &URLLengthFollower{opts.MaxURLLength})
+	}
+
+	if opts.MaxPathSegments > 0 {
+		logger.Info("Ignoring deep paths", "maxPathSegments", opts.MaxPathSegments)
+		follower = append(follower, &PathSegmentsFollower{opts.MaxPathSegments})
+	}
+
+	if len(disallow) > 0 {
+		disallowFollower := NewRobotsDisallowFollower(disallow...)
+		logger.Info("Ignoring paths", "disallow", disallowFollower.Rules)
+		follower = append(follower, disallowFollower)
+	}
+
+	if !opts.FollowTraps {
+		traps := opts.Traps
+		if traps == nil {
+			traps = NewTrapFollower()
+		}
+		logger.Info("Avoiding URL traps")
+		follower = append(follower, traps)
+	}
+
+	if opts.SkipBoilerplate {
+		logger.Info("Ignoring navigation, header, footer and sidebar links")
+		follower = append(follower, &BoilerplateFollower{})
+	}
+
+	if opts.MaxPagination > 0 {
+		logger.Info("Limiting pagination", "maxPagination", opts.MaxPagination)
+		follower = append(follower, &PaginationFollower{opts.MaxPagination})
+	}
+
+	var redis *RedisClient
+	if opts.Redis != "" {
+		if len(opts.Priority) > 0 || opts.MaxFrontier > 0 {
+			return nil, nil, errors.New("--priority and --max-frontier can't be used with --redis.")
+		}
+		redis = NewRedisClient(opts.Redis)
+		if _, err := redis.Do("PING"); err != nil {
+			return nil, nil, fmt.Errorf("Failed to connect to --redis %s: %s", opts.Redis, err)
+		}
+		logger.Info("Keeping frontier and seen paths in Redis", "addr", opts.Redis, "key", opts.RedisKey)
+		follower = append(follower, NewRedisSeenFollower(redis, opts.RedisKey+":seen", rewriter.Rewrite(initUrl)))
+	} else {
+		logger.Info("Ignoring previously seen paths")
+		follower = append(follower, NewUnseenFollower(rewriter.Rewrite(initUrl)))
+	}
+
+	if opts.MaxPerSection > 0 {
+		logger.Info("Limiting pages per section", "maxPerSection", opts.MaxPerSection)
+		follower = append(follower, NewSectionFollower(opts.MaxPerSection))
+	}
+
+	// Scheduling.
+	frontier := NewPriorityFrontier()
+	for _, rule := range opts.Priority {
+		priorityRule, err := ParsePriorityRule(rule)
+		if err != nil {
+			return nil, nil, err
+		}
+		frontier.Rules = append(frontier.Rules, priorityRule)
+	}
+	if len(frontier.Rules) > 0 {
+		logger.Info("Prioritising paths", "priority", opts.Priority)
+	}
+	if opts.MaxFrontier > 0 {
+		logger.Info("Spilling frontier to disk", "maxTasks", opts.MaxFrontier)
+		frontier.MaxTasks = opts.MaxFrontier
+	}
+
+	// Crawling.
+	crawler := &Crawler{
+		Fetcher:   fetcher,
+		Follower:  follower,
+		Frontier:  frontier,
+		Workers:   opts.NumConns,
+		Referrers: opts.Referrers,
+	}
+	var redisFrontier *RedisFrontier
+	if redis != nil {
+		redisFrontier = NewRedisFrontier(redis, opts.RedisKey+":frontier")
+		crawler.Frontier = redisFrontier
+	}
+	if opts.Follows != nil {
+		crawler.Follower = opts.Follows.Counting(follower)
+	}
+	if len(opts.CheckUnfollowed) > 0 {
+		for _, rule := range opts.CheckUnfollowed {
+			if !containsString(FollowerRules, rule) {
+				return nil, nil, fmt.Errorf("Expected --check-unfollowed of %s, got %q.", strings.Join(FollowerRules, ", "), rule)
+			}
+		}
+		logger.Info("Checking the status of unfollowed links", "rules", opts.CheckUnfollowed)
+		crawler.Check = append([]string{}, opts.CheckUnfollowed...)
+		sort.Strings(crawler.Check)
+	}
+	if opts.DryRun != "" || opts.Deterministic {
+		// A single worker crawls in a repeatable order.
+		crawler.Workers = 1
+	} else if parsePool != nil {
+		// Pages can be fetched on every connection while others are parsed.
+		crawler.Workers = opts.NumConns + opts.Parsers
+	}
+	if len(rewriter) > 0 {
+		crawler.Rewriter = rewriter
+	}
+	if opts.MaxMemory != "" {
+		limit, err := parseSize(opts.MaxMemory)
+		if err != nil {
+			return nil, nil, err
+		}
+		logger.Info("Limiting memory", "limit", opts.MaxMemory)
+		crawler.Memory = NewMemoryGuard(uint64(limit))
+	}
+
+	// Only once nothing else can fail is the Redis frontier taken from any
+	// crawl which was interrupted.
+	var redisLock *RedisLock
+	if redisFrontier != nil {
+		redisLock, err = AcquireRedisLock(redis, opts.RedisKey+":lock", 15*time.Second)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to lock --redis-key %s, which crawls can't share: %s", opts.RedisKey, err)
+		}
+		requeued, err := redisFrontier.Requeue()
+		if err != nil {
+			redisLock.Release()
+			return nil, nil, fmt.Errorf("Failed to requeue unfinished tasks in --redis %s: %s", opts.Redis, err)
+		} else if requeued > 0 {
+			logger.Info("Requeued unfinished tasks", "count", requeued)
+		}
+	}
+
+	// Controlling.
+	crawler.Control = NewCrawlControl(crawler.Frontier, limiter)
+	var control net.Listener
+	if opts.Control != "" {
+		control, err = listenControl(opts.Control)
+		if err != nil {
+			if redisLock != nil {
+				redisLock.Release()
+			}
+			return nil, nil, err
+		}
+		logger.Info("Listening for control commands", "addr", control.Addr())
+		go crawler.Control.Serve(control)
+	}
+
+	pages := make(chan Page, 10)
+	go func() {
+		crawler.Crawl(initUrl, pages)
+		if control != nil {
+			control.Close()
+		}
+		if coordinator != nil {
+			coordinator.Close()
+			coordinate.Close()
+		}
+		if redis != nil {
+			// A finished crawl is cleared for the next to start afresh, but a
+			// cancelled one is kept for the next to resume.
+			if crawler.Control.Cancelled() {
+				logger.Info("Keeping cancelled crawl in Redis", "key", opts.RedisKey)
+			} else if _, err := redis.Do("DEL", opts.RedisKey+":frontier", opts.RedisKey+":frontier:processing", opts.RedisKey+":seen"); err != nil {
+				logger.Warn("Failed to clear Redis", "key", opts.RedisKey, "error", err)
+			}
+			redisLock.Release()
+			redis.Close()
+		}
+		if warc != nil {
+			warc.Close()
+		}
+		close(pages)
+		if stoppable, ok := limiter.(Stopper); ok {
+			stoppable.Stop()
+		}
+		if parsePool != nil {
+			parsePool.Stop()
+		}
+	}()
+
+	if webhook := opts.Webhook(); webhook != nil {
+		logger.Info("Notifying webhook", "url", webhook.URL)
+		return webhook.Watch(initUrl, pages), crawler.Control, nil
+	}
+	return pages, crawler.Control, nil
 }
 
 // fetchRobots gets the body of robots.txt pertaining to the given URL.
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// LinkGraph records the internal links between the pages of a crawl. Links
+// from nofollow pages aren't recorded.
+type LinkGraph struct {
+	// pages records whether each crawled page was parsed for links.
+	pages map[string]bool
+	links map[string][]string
+	home  string
+	lock  sync.Mutex
+}
+
+func NewLinkGraph() *LinkGraph {
+	return &LinkGraph{pages: make(map[string]bool), links: make(map[string][]string)}
+}
+
+// graphKey identifies the page of a URL, ignoring its fragment.
+func graphKey(u *url.URL) string {
+	dupe := *u
+	dupe.Fragment = ""
+	return dupe.String()
+}
+
+func (g *LinkGraph) Observe(page Page) {
+	if page.StatusCode == 0 {
+		return
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	from := graphKey(page.URL)
+	g.pages[from] = page.Processed
+	if page.Depth == 0 && g.home == "" {
+		g.home = from
+	}
+	if page.NoFollow {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, link := range page.Links {
+		to := graphKey(link.URL)
+		if link.External || to == from || seen[to] {
+			continue
+		}
+		seen[to] = true
+		g.links[from] = append(g.links[from], to)
+	}
+}
+
+// Pages returns the crawled pages of the graph, sorted.
+func (g *LinkGraph) Pages() []string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	pages := make([]string, 0, len(g.pages))
+	for page := range g.pages {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+	return pages
+}
+
+// Links returns the crawled pages linked to by the page.
+func (g *LinkGraph) Links(page string) (links []string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, to := range g.links[page] {
+		if _, crawled := g.pages[to]; crawled {
+			links = append(links, to)
+		}
+	}
+	return
+}
+
+// DeadEnds returns the parsed pages without links to any other crawled page.
+func (g *LinkGraph) DeadEnds() (pages []string) {
+	for _, page := range g.Pages() {
+		g.lock.Lock()
+		processed := g.pages[page]
+		g.lock.Unlock()
+		if processed && len(g.Links(page)) == 0 {
+			pages = append(pages, page)
+		}
+	}
+	return
+}
+
+// Unreachable returns the crawled pages which can't be reached by following
+// links from the home page, the first page of the crawl.
+func (g *LinkGraph) Unreachable() (pages []string) {
+	g.lock.Lock()
+	home := g.home
+	g.lock.Unlock()
+
+	reached := map[string]bool{home: true}
+	queue := []string{home}
+	for len(queue) > 0 {
+		page := queue[0]
+		queue = queue[1:]
+		for _, to := range g.Links(page) {
+			if !reached[to] {
+				reached[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+
+	for _, page := range g.Pages() {
+		if !reached[page] {
+			pages = append(pages, page)
+		}
+	}
+	return
+}
+
+// IsolatedClusters returns the groups of pages which all link to one another
+// but not to any page outside the group, so that visitors and crawlers
+// entering them can't find their way out. The home page's group isn't
+// included.
+func (g *LinkGraph) IsolatedClusters() (clusters [][]string) {
+	g.lock.Lock()
+	home := g.home
+	g.lock.Unlock()
+
+	for _, component := range g.components() {
+		if len(component) < 2 {
+			continue
+		}
+		members := make(map[string]bool, len(component))
+		for _, page := range component {
+			members[page] = true
+		}
+		if members[home] {
+			continue
+		}
+		isolated := true
+		for _, page := range component {
+			for _, to := range g.Links(page) {
+				isolated = isolated && members[to]
+			}
+		}
+		if isolated {
+			sort.Strings(component)
+			clusters = append(clusters, component)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i][0] < clusters[j][0] })
+	return
+}
+
+// components returns the strongly connected components of the graph, found
+// with Tarjan's algorithm.
+func (g *LinkGraph) components() (components [][]string) {
+	index := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	stack := []string{}
+
+	var connect func(page string)
+	connect = func(page string) {
+		index[page] = len(index)
+		lowlink[page] = index[page]
+		stack = append(stack, page)
+		onStack[page] = true
+
+		for _, to := range g.Links(page) {
+			if _, visited := index[to]; !visited {
+				connect(to)
+				if lowlink[to] < lowlink[page] {
+					lowlink[page] = lowlink[to]
+				}
+			} else if onStack[to] && index[to] < lowlink[page] {
+				lowlink[page] = index[to]
+			}
+		}
+
+		if lowlink[page] == index[page] {
+			component := []string{}
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == page {
+					break
+				}
+			}
+			components = append(components, component)
+		}
+	}
+
+	for _, page := range g.Pages() {
+		if _, visited := index[page]; !visited {
+			connect(page)
+		}
+	}
+	return
+}
+
+// pageRankDamping is the probability of a visitor following a link rather
+// than jumping to a random page.
+const pageRankDamping = 0.85
+
+// PageRank returns the PageRank of each crawled page, summing to 1, and the
+// number of crawled pages linking to each.
+func (g *LinkGraph) PageRank(iterations int) (ranks map[string]float64, inbound map[string]int) {
+	pages := g.Pages()
+	links := make(map[string][]string, len(pages))
+	inbound = make(map[string]int, len(pages))
+	for _, page := range pages {
+		links[page] = g.Links(page)
+		for _, to := range links[page] {
+			inbound[to]++
+		}
+	}
+
+	n := float64(len(pages))
+	ranks = make(map[string]float64, len(pages))
+	for _, page := range pages {
+		ranks[page] = 1 / n
+	}
+	for i := 0; i < iterations; i++ {
+		// The rank of pages without links is shared between all pages.
+		dangling := 0.0
+		for _, page := range pages {
+			if len(links[page]) == 0 {
+				dangling += ranks[page]
+			}
+		}
+
+		next := make(map[string]float64, len(pages))
+		for _, page := range pages {
+			next[page] = (1-pageRankDamping)/n + pageRankDamping*dangling/n
+		}
+		for _, page := range pages {
+			for _, to := range links[page] {
+				next[to] += pageRankDamping * ranks[page] / float64(len(links[page]))
+			}
+		}
+		ranks = next
+	}
+	return
+}
+
+// AuthorityReporter lists the pages which the site's internal linking
+// emphasises most, by their PageRank over the internal link graph.
+type AuthorityReporter struct {
+	// Top is the number of pages to list.
+	Top   int
+	Graph *LinkGraph
+}
+
+func NewAuthorityReporter(top int) *AuthorityReporter {
+	return &AuthorityReporter{Top: top, Graph: NewLinkGraph()}
+}
+
+func (a *AuthorityReporter) Observe(page Page) {
+	a.Graph.Observe(page)
+}
+
+func (a *AuthorityReporter) Report(w io.Writer) {
+	ranks, inbound := a.Graph.PageRank(50)
+
+	pages := make([]string, 0, len(ranks))
+	for page := range ranks {
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if ranks[pages[i]] != ranks[pages[j]] {
+			return ranks[pages[i]] > ranks[pages[j]]
+		}
+		return pages[i] < pages[j]
+	})
+	if len(pages) > a.Top {
+		pages = pages[:a.Top]
+	}
+
+	fmt.Fprintf(w, "Link authority: %d pages\n", len(ranks))
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s: %.4f (%d inbound links)\n", page, ranks[page], inbound[page])
+	}
+}
+
+// LinkStructureReporter reports the problems with the site's internal link
+// graph: dead-end pages without links to the rest of the site, pages which
+// can't be reached from the home page once nofollow rules are applied, and
+// isolated clusters of pages which only link among themselves.
+type LinkStructureReporter struct {
+	Graph *LinkGraph
+}
+
+func NewLinkStructureReporter() *LinkStructureReporter {
+	return &LinkStructureReporter{Graph: NewLinkGraph()}
+}
+
+func (l *LinkStructureReporter) Observe(page Page) {
+	l.Graph.Observe(page)
+}
+
+func (l *LinkStructureReporter) Report(w io.Writer) {
+	deadEnds := l.Graph.DeadEnds()
+	fmt.Fprintf(w, "Dead ends: %d pages\n", len(deadEnds))
+	for _, page := range deadEnds {
+		fmt.Fprintf(w, "- %s\n", page)
+	}
+
+	unreachable := l.Graph.Unreachable()
+	fmt.Fprintf(w, "Unreachable from home page: %d pages\n", len(unreachable))
+	for _, page := range unreachable {
+		fmt.Fprintf(w, "- %s\n", page)
+	}
+
+	clusters := l.Graph.IsolatedClusters()
+	fmt.Fprintf(w, "Isolated clusters: %d clusters\n", len(clusters))
+	for _, cluster := range clusters {
+		fmt.Fprintf(w, "- %d pages\n", len(cluster))
+		for _, page := range cluster {
+			fmt.Fprintf(w, "  - %s\n", page)
+		}
+	}
+}
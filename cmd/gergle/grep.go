@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// GrepReporter lists the pages matching each of the --grep patterns, with the
+// number of matches on each.
+type GrepReporter struct {
+	Patterns []string
+
+	matches map[string]map[string]int
+	lock    sync.Mutex
+}
+
+func NewGrepReporter(patterns ...string) *GrepReporter {
+	return &GrepReporter{Patterns: patterns, matches: make(map[string]map[string]int)}
+}
+
+func (g *GrepReporter) Observe(page Page) {
+	if len(page.Matches) == 0 {
+		return
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for pattern, count := range page.Matches {
+		if g.matches[pattern] == nil {
+			g.matches[pattern] = make(map[string]int)
+		}
+		g.matches[pattern][page.URL.String()] = count
+	}
+}
+
+func (g *GrepReporter) Report(w io.Writer) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for _, pattern := range g.Patterns {
+		pages := make([]string, 0, len(g.matches[pattern]))
+		total := 0
+		for page, count := range g.matches[pattern] {
+			pages = append(pages, page)
+			total += count
+		}
+		sort.Strings(pages)
+
+		fmt.Fprintf(w, "Grep %s: %d matches on %d pages\n", pattern, total, len(pages))
+		for _, page := range pages {
+			fmt.Fprintf(w, "- %s (%d)\n", page, g.matches[pattern][page])
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/icio/gergle/crawlspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"math"
+)
+
+// CrawlService is the gRPC API for running crawls, serving the same crawls as
+// the HTTP API of its server.
+type CrawlService struct {
+	crawlspb.UnimplementedCrawlsServer
+	Server *CrawlServer
+}
+
+// ServerOptions returns the options of the gRPC server of the service, which
+// require the token of its server in each call's "authorization" metadata.
+func (c *CrawlService) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := c.authorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := c.authorize(stream.Context()); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	}
+}
+
+func (c *CrawlService) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := ""
+	if values := md.Get("authorization"); len(values) > 0 {
+		header = values[0]
+	}
+	if !c.Server.Authorized(header) {
+		return status.Error(codes.Unauthenticated, "Expected an authorization: Bearer token.")
+	}
+	return nil
+}
+
+func (c *CrawlService) StartCrawl(ctx context.Context, req *crawlspb.CrawlRequest) (*crawlspb.CrawlStatus, error) {
+	request, err := NewJobRequest(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	job, err := c.Server.Start(request)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return NewCrawlStatus(job.Status()), nil
+}
+
+func (c *CrawlService) GetCrawl(ctx context.Context, id *crawlspb.CrawlID) (*crawlspb.CrawlStatus, error) {
+	job, err := c.job(id)
+	if err != nil {
+		return nil, err
+	}
+	return NewCrawlStatus(job.Status()), nil
+}
+
+// StreamPages sends each of the job's pages, returning once the job has
+// finished.
+func (c *CrawlService) StreamPages(id *crawlspb.CrawlID, stream crawlspb.Crawls_StreamPagesServer) error {
+	job, err := c.job(id)
+	if err != nil {
+		return err
+	}
+
+	offset := 0
+	for {
+		pages, finished, changed := job.Pages(offset)
+		for _, page := range pages {
+			if err := stream.Send(NewCrawlPage(page)); err != nil {
+				return err
+			}
+		}
+		offset += len(pages)
+
+		if finished {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+func (c *CrawlService) Cancel(ctx context.Context, id *crawlspb.CrawlID) (*crawlspb.CrawlStatus, error) {
+	job, err := c.job(id)
+	if err != nil {
+		return nil, err
+	}
+	job.Cancel()
+	return NewCrawlStatus(job.Status()), nil
+}
+
+func (c *CrawlService) job(id *crawlspb.CrawlID) (*Job, error) {
+	job, found := c.Server.Job(id.GetId())
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "No crawl %q.", id.GetId())
+	}
+	return job, nil
+}
+
+// NewJobRequest returns the JobRequest of a gRPC CrawlRequest.
+func NewJobRequest(req *crawlspb.CrawlRequest) (JobRequest, error) {
+	request := JobRequest{
+		URL:      req.GetUrl(),
+		Delay:    req.Delay,
+		Disallow: req.GetDisallow(),
+		Webhook:  req.Webhook,
+	}
+	if req.Depth != nil {
+		if *req.Depth > math.MaxUint16 {
+			return request, fmt.Errorf("Expected depth of at most %d, got %d.", math.MaxUint16, *req.Depth)
+		}
+		depth := uint16(*req.Depth)
+		request.Depth = &depth
+	}
+	if req.Connections != nil {
+		connections := int(*req.Connections)
+		request.Connections = &connections
+	}
+	return request, nil
+}
+
+// NewCrawlStatus returns the gRPC representation of a JobStatus.
+func NewCrawlStatus(s JobStatus) *crawlspb.CrawlStatus {
+	crawlStatus := &crawlspb.CrawlStatus{
+		Id:      s.ID,
+		Url:     s.URL,
+		State:   s.State,
+		Error:   s.Error,
+		Started: timestamppb.New(s.Started),
+		Pages:   int32(s.Pages),
+		Broken:  int32(s.Broken),
+	}
+	if s.Finished != nil {
+		crawlStatus.Finished = timestamppb.New(*s.Finished)
+	}
+	return crawlStatus
+}
+
+// NewCrawlPage returns the gRPC representation of a JobPage.
+func NewCrawlPage(p JobPage) *crawlspb.Page {
+	return &crawlspb.Page{
+		Url:       p.URL,
+		Depth:     uint32(p.Depth),
+		Links:     int32(p.Links),
+		Assets:    int32(p.Assets),
+		Status:    int32(p.StatusCode),
+		Error:     p.Error,
+		Checksum:  p.Checksum,
+		Broken:    p.Broken,
+		Redirects: p.Redirects,
+		Referrers: p.Referrers,
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A HeaderAssertion requires the value of a response header to match a
+// pattern. Missing headers are treated as having an empty value.
+type HeaderAssertion struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// ParseHeaderAssertion reads an assertion of the form NAME=REGEXP, where the
+// regular expression must match the whole header value.
+func ParseHeaderAssertion(rule string) (HeaderAssertion, error) {
+	eq := strings.Index(rule, "=")
+	if eq <= 0 {
+		return HeaderAssertion{}, fmt.Errorf("Expected --assert-header of the form NAME=REGEXP, got %q.", rule)
+	}
+	pattern, err := regexp.Compile("^(?:" + rule[eq+1:] + ")$")
+	if err != nil {
+		return HeaderAssertion{}, err
+	}
+	return HeaderAssertion{strings.TrimSpace(rule[:eq]), pattern}, nil
+}
+
+// HeaderAssertionReporter lists the pages whose responses violate any of its
+// assertions, failing the crawl if there are any.
+type HeaderAssertionReporter struct {
+	Assertions []HeaderAssertion
+
+	violations map[string][]string
+	lock       sync.Mutex
+}
+
+func NewHeaderAssertionReporter(assertions ...HeaderAssertion) *HeaderAssertionReporter {
+	return &HeaderAssertionReporter{Assertions: assertions, violations: make(map[string][]string)}
+}
+
+func (h *HeaderAssertionReporter) Observe(page Page) {
+	if page.StatusCode == 0 {
+		return
+	}
+
+	var violations []string
+	for _, assertion := range h.Assertions {
+		value := strings.Join(page.Header.Values(assertion.Name), ", ")
+		if !assertion.Pattern.MatchString(value) {
+			violations = append(violations, fmt.Sprintf("%s: %q does not match %s", assertion.Name, value, assertion.Pattern))
+		}
+	}
+	if len(violations) == 0 {
+		return
+	}
+
+	h.lock.Lock()
+	h.violations[page.URL.String()] = violations
+	h.lock.Unlock()
+}
+
+func (h *HeaderAssertionReporter) Report(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	pages := make([]string, 0, len(h.violations))
+	for page := range h.violations {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	fmt.Fprintf(w, "Header assertion failures: %d pages\n", len(pages))
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s\n", page)
+		for _, violation := range h.violations[page] {
+			fmt.Fprintf(w, "  - %s\n", violation)
+		}
+	}
+}
+
+func (h *HeaderAssertionReporter) Failed() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.violations) > 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// hostTotals are the totals of the pages crawled from a host.
+type hostTotals struct {
+	Pages   int
+	Errors  int
+	Fetched int
+	TTFB    time.Duration
+	Bytes   int
+}
+
+func (h *hostTotals) add(page Page) {
+	h.Pages++
+	if page.Broken() {
+		h.Errors++
+	}
+	if page.TTFB > 0 {
+		h.Fetched++
+		h.TTFB += page.TTFB
+	}
+	h.Bytes += page.Size
+}
+
+func (h *hostTotals) String() string {
+	var ttfb time.Duration
+	if h.Fetched > 0 {
+		ttfb = (h.TTFB / time.Duration(h.Fetched)).Round(time.Millisecond)
+	}
+	return fmt.Sprintf("%d pages, %d errors, %s average ttfb, %d bytes", h.Pages, h.Errors, ttfb, h.Bytes)
+}
+
+// HostReporter breaks the crawl down by host, for crawls following links to
+// other hosts, totalling the pages, errors, time to first byte and bytes of
+// each.
+type HostReporter struct {
+	hosts map[string]*hostTotals
+	total hostTotals
+	lock  sync.Mutex
+}
+
+func NewHostReporter() *HostReporter {
+	return &HostReporter{hosts: make(map[string]*hostTotals)}
+}
+
+func (h *HostReporter) Observe(page Page) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	host := asciiHost(page.URL.Host)
+	if h.hosts[host] == nil {
+		h.hosts[host] = &hostTotals{}
+	}
+	h.hosts[host].add(page)
+	h.total.add(page)
+}
+
+func (h *HostReporter) Report(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	hosts := make([]string, 0, len(h.hosts))
+	for host := range h.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if h.hosts[hosts[i]].Pages != h.hosts[hosts[j]].Pages {
+			return h.hosts[hosts[i]].Pages > h.hosts[hosts[j]].Pages
+		}
+		return hosts[i] < hosts[j]
+	})
+
+	fmt.Fprintf(w, "Hosts: %d hosts, %s\n", len(hosts), &h.total)
+	for _, host := range hosts {
+		fmt.Fprintf(w, "- %s: %s\n", host, h.hosts[host])
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// HreflangReporter validates that the hreflang alternates declared by each
+// page declare the page as an alternate in return, as search engines require.
+type HreflangReporter struct {
+	pages map[string][]*Alternate
+	lock  sync.Mutex
+}
+
+func NewHreflangReporter() *HreflangReporter {
+	return &HreflangReporter{pages: make(map[string][]*Alternate)}
+}
+
+func (h *HreflangReporter) Observe(page Page) {
+	if !page.Processed {
+		return
+	}
+
+	h.lock.Lock()
+	h.pages[sanitizeURL(page.URL)] = page.Alternates
+	h.lock.Unlock()
+}
+
+// Problems returns the descriptions of every missing reciprocal annotation,
+// keyed by the page declaring the alternate.
+func (h *HreflangReporter) Problems() map[string][]string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	problems := make(map[string][]string)
+	for page, alternates := range h.pages {
+		for _, alternate := range alternates {
+			href := sanitizeURL(alternate.URL)
+			if alternate.HrefLang == "" || href == page {
+				continue
+			}
+
+			returns, crawled := h.pages[href]
+			if !crawled {
+				problems[page] = append(problems[page], fmt.Sprintf("%s alternate %s was not crawled", alternate.HrefLang, alternate.URL))
+				continue
+			}
+
+			reciprocated := false
+			for _, ret := range returns {
+				if ret.HrefLang != "" && sanitizeURL(ret.URL) == page {
+					reciprocated = true
+					break
+				}
+			}
+			if !reciprocated {
+				problems[page] = append(problems[page], fmt.Sprintf("%s alternate %s does not link back", alternate.HrefLang, alternate.URL))
+			}
+		}
+	}
+	return problems
+}
+
+func (h *HreflangReporter) Report(w io.Writer) {
+	problems := h.Problems()
+
+	pages := make([]string, 0, len(problems))
+	for page := range problems {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	fmt.Fprintf(w, "Hreflang problems: %d pages\n", len(pages))
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s\n", page)
+		for _, problem := range problems[page] {
+			fmt.Fprintf(w, "  - %s\n", problem)
+		}
+	}
+}
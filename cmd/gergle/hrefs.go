@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// hrefProblem describes why the raw href or src of an element isn't a validly
+// encoded URL, or returns "" if it is. Browsers forgive unencoded spaces,
+// control characters and stray percent signs, and so does url.Parse, but
+// other clients don't. The leading and trailing whitespace which HTML strips
+// from URLs, and the code and content of javascript: and data: URLs, are
+// ignored.
+func hrefProblem(href string) string {
+	href = strings.Trim(href, " \t\n\f\r")
+	if match := hrefSchemeRegex.FindStringSubmatch(href); match != nil && opaqueSchemes[strings.ToLower(match[1])] {
+		return ""
+	}
+	for i := 0; i < len(href); i++ {
+		switch c := href[i]; {
+		case c == ' ':
+			return "unencoded space"
+		case c < 0x20 || c == 0x7f:
+			return fmt.Sprintf("control character %U", rune(c))
+		case c == '%' && (i+2 >= len(href) || !isHex(href[i+1]) || !isHex(href[i+2])):
+			end := i + 3
+			if end > len(href) {
+				end = len(href)
+			}
+			return fmt.Sprintf("invalid percent-encoding %q", href[i:end])
+		}
+	}
+	return ""
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+var hrefAttrRegex = regexp.MustCompile("(?is)\\s(?:href|src)\\s*=")
+
+// parseBadHrefs returns the raw href and src attributes of the page's elements
+// which aren't validly encoded URLs.
+func parseBadHrefs(body []byte) (hrefs []string) {
+	n := bytes.IndexByte(body, 0)
+	for _, element := range elementRegex.FindAll(body, n) {
+		if !hrefAttrRegex.Match(element) {
+			continue
+		}
+		attrs := parseAttrs(element)
+		for _, name := range []string{"href", "src"} {
+			if href, found := attrs[name]; found && hrefProblem(href) != "" {
+				hrefs = append(hrefs, href)
+			}
+		}
+	}
+	return
+}
+
+// HrefReporter lists the pages with hrefs which aren't validly encoded URLs,
+// along with the problem of each.
+type HrefReporter struct {
+	hrefs map[string][]string
+	lock  sync.Mutex
+}
+
+func NewHrefReporter() *HrefReporter {
+	return &HrefReporter{hrefs: make(map[string][]string)}
+}
+
+func (h *HrefReporter) Observe(page Page) {
+	if len(page.BadHrefs) == 0 {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.hrefs[page.URL.String()] = page.BadHrefs
+}
+
+func (h *HrefReporter) Report(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	pages := make([]string, 0, len(h.hrefs))
+	count := 0
+	for page, hrefs := range h.hrefs {
+		pages = append(pages, page)
+		count += len(hrefs)
+	}
+	sort.Strings(pages)
+
+	fmt.Fprintf(w, "Malformed hrefs: %d hrefs on %d pages\n", count, len(pages))
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s\n", page)
+		for _, href := range h.hrefs[page] {
+			fmt.Fprintf(w, "  - %q: %s\n", href, hrefProblem(href))
+		}
+	}
+}
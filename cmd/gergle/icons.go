@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// iconRels are the rels of <link> tags declaring an icon for the page.
+var iconRels = []string{"icon", "apple-touch-icon", "mask-icon"}
+
+// manifestDisplays are the valid values of a web app manifest's display.
+var manifestDisplays = map[string]bool{"fullscreen": true, "standalone": true, "minimal-ui": true, "browser": true}
+
+// maxManifestSize caps the size of web app manifests which are read.
+const maxManifestSize = 1 << 20
+
+// parseIcons returns the icons declared by the page's <link rel="icon"> tags,
+// and its <link rel="manifest">.
+func parseIcons(base *url.URL, body []byte) (icons []*url.URL, manifest *url.URL) {
+	n := bytes.IndexByte(body, 0)
+	for _, tag := range linkTagRegex.FindAll(body, n) {
+		attrs := parseAttrs(tag)
+		href, err := url.Parse(attrs["href"])
+		if err != nil || attrs["href"] == "" {
+			continue
+		}
+		if hasRel(attrs["rel"], "manifest") && manifest == nil {
+			manifest = base.ResolveReference(href)
+		}
+		for _, rel := range iconRels {
+			if hasRel(attrs["rel"], rel) {
+				icons = append(icons, base.ResolveReference(href))
+				break
+			}
+		}
+	}
+	return
+}
+
+// A webManifest is the part of a web app manifest which is checked.
+type webManifest struct {
+	Name      string `json:"name"`
+	ShortName string `json:"short_name"`
+	StartURL  string `json:"start_url"`
+	Display   string `json:"display"`
+	Icons     []struct {
+		Src   string `json:"src"`
+		Sizes string `json:"sizes"`
+	} `json:"icons"`
+}
+
+// manifestProblems returns the problems of a web app manifest, and the icons
+// it declares, resolved against the manifest's URL.
+func manifestProblems(manifestUrl *url.URL, body []byte) (problems []string, icons []string) {
+	var manifest webManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return []string{fmt.Sprintf("invalid JSON: %s", err)}, nil
+	}
+
+	if manifest.Name == "" && manifest.ShortName == "" {
+		problems = append(problems, "missing name or short_name")
+	}
+	if manifest.StartURL == "" {
+		problems = append(problems, "missing start_url")
+	}
+	if manifest.Display != "" && !manifestDisplays[manifest.Display] {
+		problems = append(problems, fmt.Sprintf("invalid display %q", manifest.Display))
+	}
+	if len(manifest.Icons) == 0 {
+		problems = append(problems, "missing icons")
+	}
+	for i, icon := range manifest.Icons {
+		src, err := url.Parse(icon.Src)
+		if err != nil || icon.Src == "" {
+			problems = append(problems, fmt.Sprintf("icon %d has an invalid src %q", i+1, icon.Src))
+			continue
+		}
+		if icon.Sizes == "" {
+			problems = append(problems, fmt.Sprintf("icon %s is missing sizes", icon.Src))
+		}
+		icons = append(icons, manifestUrl.ResolveReference(src).String())
+	}
+	return
+}
+
+// IconReporter summarises the site's favicon, icons and web app manifests:
+// whether /favicon.ico exists, how many pages declare an icon, and the
+// problems of each icon and manifest. Icons and manifests are fetched once
+// the crawl is complete.
+type IconReporter struct {
+	Client *http.Client
+	// Workers is the number of icons to probe at once.
+	Workers int
+
+	site      *url.URL
+	pages     int
+	iconless  []string
+	icons     map[string]bool
+	manifests map[string]bool
+	lock      sync.Mutex
+}
+
+func NewIconReporter(client *http.Client, site *url.URL) *IconReporter {
+	return &IconReporter{
+		Client:    client,
+		Workers:   4,
+		site:      site,
+		icons:     make(map[string]bool),
+		manifests: make(map[string]bool),
+	}
+}
+
+func (i *IconReporter) Observe(page Page) {
+	if !page.Processed || !strings.Contains(page.MediaType, "html") {
+		return
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.pages++
+	if len(page.Icons) == 0 {
+		i.iconless = append(i.iconless, page.URL.String())
+	}
+	for _, icon := range page.Icons {
+		i.icons[icon.String()] = true
+	}
+	if page.Manifest != nil {
+		i.manifests[page.Manifest.String()] = true
+	}
+}
+
+// checkManifest fetches the web app manifest, returning its problems and its
+// icons.
+func (i *IconReporter) checkManifest(href string) ([]string, []string) {
+	manifestUrl, err := url.Parse(href)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	resp, err := i.Client.Get(href)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return []string{fmt.Sprintf("Manifest responded %d", resp.StatusCode)}, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	return manifestProblems(manifestUrl, body)
+}
+
+func (i *IconReporter) Report(w io.Writer) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	problems := make(map[string][]string)
+	icons := make(map[string]bool, len(i.icons))
+	for icon := range i.icons {
+		icons[icon] = true
+	}
+	for manifest := range i.manifests {
+		manifestProblems, manifestIcons := i.checkManifest(manifest)
+		if len(manifestProblems) > 0 {
+			problems[manifest] = manifestProblems
+		}
+		for _, icon := range manifestIcons {
+			icons[icon] = true
+		}
+	}
+
+	favicon := i.site.ResolveReference(&url.URL{Path: "/favicon.ico"}).String()
+	hrefs := []string{favicon}
+	for icon := range icons {
+		if icon != favicon {
+			hrefs = append(hrefs, icon)
+		}
+	}
+	logger.Info("Probing icons", "count", len(hrefs))
+	_, errs := probeImages(i.Client, i.Workers, hrefs)
+	for href, err := range errs {
+		problems[href] = append(problems[href], err.Error())
+	}
+
+	faviconStatus := "found"
+	if errs[favicon] != nil {
+		faviconStatus = "missing"
+	}
+	fmt.Fprintf(w, "Site icons: favicon.ico %s, %d of %d pages declare an icon, %d manifests\n",
+		faviconStatus, i.pages-len(i.iconless), i.pages, len(i.manifests))
+
+	urls := make([]string, 0, len(problems))
+	for href := range problems {
+		urls = append(urls, href)
+	}
+	sort.Strings(urls)
+	fmt.Fprintf(w, "Icon problems: %d URLs\n", len(urls))
+	for _, href := range urls {
+		fmt.Fprintf(w, "- %s\n", href)
+		for _, problem := range problems[href] {
+			fmt.Fprintf(w, "  - %s\n", problem)
+		}
+	}
+
+	sort.Strings(i.iconless)
+	fmt.Fprintf(w, "Pages without an icon: %d pages\n", len(i.iconless))
+	for _, page := range i.iconless {
+		fmt.Fprintf(w, "- %s\n", page)
+	}
+}
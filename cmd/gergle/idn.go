@@ -0,0 +1,44 @@
+package main
+
+import (
+	"golang.org/x/net/idna"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// isExternal reports whether the URL is on a different scheme or host to the
+// page it was found on, comparing internationalized hosts in their ASCII form.
+func isExternal(u *url.URL, page *url.URL) bool {
+	return u.Scheme != page.Scheme || asciiHost(u.Host) != asciiHost(page.Host)
+}
+
+// asciiHost returns the host, with any port, lowercased and with its
+// internationalized labels encoded as punycode, so that the Unicode and ASCII
+// forms of a domain name are equal: bücher.example is xn--bcher-kva.example.
+// Hosts which aren't valid domain names are only lowercased.
+func asciiHost(host string) string {
+	host = strings.ToLower(host)
+	if isASCII(host) {
+		return host
+	}
+
+	port := ""
+	if colon := strings.LastIndex(host, ":"); colon >= 0 {
+		host, port = host[:colon], host[colon:]
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return host + port
+	}
+	return ascii + port
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
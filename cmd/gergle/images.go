@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// imageProbeBytes is how much of each image is downloaded to find its format
+// and dimensions.
+const imageProbeBytes = 64 << 10
+
+// ImageInfo describes an image, as found by ProbeImage.
+type ImageInfo struct {
+	Format string
+	Width  int
+	Height int
+	// Size is the full size of the image in bytes, or -1 if unknown.
+	Size int64
+}
+
+var contentRangeRegex = regexp.MustCompile(`/(\d+)$`)
+
+// ProbeImage fetches the start of the image to find its size, format and
+// dimensions, without downloading all of it.
+func ProbeImage(client *http.Client, href string) (ImageInfo, error) {
+	req, err := http.NewRequest("GET", href, nil)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", imageProbeBytes-1))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	info := ImageInfo{Size: resp.ContentLength}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPartialContent:
+		info.Size = -1
+		if match := contentRangeRegex.FindStringSubmatch(resp.Header.Get("Content-Range")); match != nil {
+			info.Size, _ = strconv.ParseInt(match[1], 10, 64)
+		}
+	default:
+		return info, fmt.Errorf("Image responded %d", resp.StatusCode)
+	}
+
+	config, format, err := image.DecodeConfig(io.LimitReader(resp.Body, imageProbeBytes))
+	if err == nil {
+		info.Format, info.Width, info.Height = format, config.Width, config.Height
+	} else if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); strings.HasPrefix(mediaType, "image/") {
+		// Formats such as SVG and WebP are recognised, but not measured.
+		info.Format = strings.TrimPrefix(mediaType, "image/")
+	}
+	return info, nil
+}
+
+// ImageReporter audits the images of the crawled pages, listing the format,
+// dimensions and size of each, those larger than MaxSize bytes, and the
+// <img> tags missing alt text. Images are probed once the crawl is complete.
+type ImageReporter struct {
+	Client  *http.Client
+	MaxSize int64
+	// Workers is the number of images to probe at once.
+	Workers int
+
+	images     map[string]bool
+	missingAlt map[string][]string
+	lock       sync.Mutex
+}
+
+func NewImageReporter(client *http.Client, maxSize int64) *ImageReporter {
+	return &ImageReporter{
+		Client:     client,
+		MaxSize:    maxSize,
+		Workers:    4,
+		images:     make(map[string]bool),
+		missingAlt: make(map[string][]string),
+	}
+}
+
+func (i *ImageReporter) Observe(page Page) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for _, img := range page.Images {
+		if img.URL.Scheme != "http" && img.URL.Scheme != "https" {
+			continue
+		}
+		i.images[img.URL.String()] = true
+		if !img.HasAlt {
+			i.missingAlt[page.URL.String()] = append(i.missingAlt[page.URL.String()], img.URL.String())
+		}
+	}
+}
+
+// probeImages fetches the info of all of the images, keyed by URL, probing
+// the given number of images at once.
+func probeImages(client *http.Client, concurrency int, hrefs []string) (map[string]ImageInfo, map[string]error) {
+	infos := make(map[string]ImageInfo, len(hrefs))
+	errs := make(map[string]error)
+	lock := sync.Mutex{}
+
+	probeEach(concurrency, len(hrefs), func(i int) {
+		logger.Debug("Probing image", "url", hrefs[i])
+		info, err := ProbeImage(client, hrefs[i])
+		lock.Lock()
+		if err != nil {
+			errs[hrefs[i]] = err
+		} else {
+			infos[hrefs[i]] = info
+		}
+		lock.Unlock()
+	})
+	return infos, errs
+}
+
+func (i *ImageReporter) Report(w io.Writer) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	hrefs := make([]string, 0, len(i.images))
+	for href := range i.images {
+		hrefs = append(hrefs, href)
+	}
+	sort.Strings(hrefs)
+
+	logger.Info("Probing images", "count", len(hrefs))
+	infos, errs := probeImages(i.Client, i.Workers, hrefs)
+
+	oversized := []string{}
+	fmt.Fprintf(w, "Images: %d images\n", len(hrefs))
+	for _, href := range hrefs {
+		if err, failed := errs[href]; failed {
+			fmt.Fprintf(w, "- %s: %s\n", href, err)
+			continue
+		}
+		info := infos[href]
+		format := info.Format
+		if format == "" {
+			format = "unknown format"
+		}
+		if info.Width > 0 {
+			format = fmt.Sprintf("%s %dx%d", format, info.Width, info.Height)
+		}
+		fmt.Fprintf(w, "- %s: %s, %d bytes\n", href, format, info.Size)
+		if i.MaxSize > 0 && info.Size > i.MaxSize {
+			oversized = append(oversized, href)
+		}
+	}
+
+	fmt.Fprintf(w, "Oversized images: %d images\n", len(oversized))
+	for _, href := range oversized {
+		fmt.Fprintf(w, "- %s (%d bytes)\n", href, infos[href].Size)
+	}
+
+	pages := make([]string, 0, len(i.missingAlt))
+	for page := range i.missingAlt {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+	fmt.Fprintf(w, "Images missing alt text: %d pages\n", len(pages))
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s\n", page)
+		for _, href := range i.missingAlt[page] {
+			fmt.Fprintf(w, "  - %s\n", href)
+		}
+	}
+}
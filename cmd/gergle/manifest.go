@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Manifest summarises a crawl for the tooling which consumes its output: how
+// it was configured, when it ran, what it found and the files it wrote.
+type Manifest struct {
+	Seeds  []string          `json:"seeds"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Options are the flags the crawl was given, with credentials redacted.
+	Options  map[string]string `json:"options"`
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	Seconds  float64           `json:"seconds"`
+	Pages    int               `json:"pages"`
+	Broken   int               `json:"broken"`
+	Bytes    int64             `json:"bytes"`
+	// Statuses counts the pages by status code.
+	Statuses map[string]int `json:"statuses"`
+	// Errors counts the pages which couldn't be fetched by kind of error.
+	Errors    map[string]int `json:"errors"`
+	Failed    bool           `json:"failed"`
+	Artifacts []string       `json:"artifacts"`
+
+	lock sync.Mutex
+}
+
+// redactedFlags are the flags whose values are credentials.
+var redactedFlags = []string{"bearer-token", "cookie", "oauth-client-secret"}
+
+func NewManifest(flags *pflag.FlagSet, seeds ...*url.URL) *Manifest {
+	m := &Manifest{
+		Options:   make(map[string]string),
+		Started:   clock(),
+		Statuses:  make(map[string]int),
+		Errors:    make(map[string]int),
+		Artifacts: []string{},
+	}
+	for _, seed := range seeds {
+		m.Seeds = append(m.Seeds, seed.String())
+	}
+	flags.Visit(func(flag *pflag.Flag) {
+		value := flag.Value.String()
+		if containsString(redactedFlags, flag.Name) {
+			value = "REDACTED"
+		} else if u, err := url.Parse(value); err == nil && u.User != nil {
+			u.User = url.User("REDACTED")
+			value = u.String()
+		}
+		m.Options[flag.Name] = value
+	})
+	return m
+}
+
+func (m *Manifest) Observe(page Page) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Pages++
+	m.Bytes += int64(page.Size)
+	if page.Broken() {
+		m.Broken++
+	}
+	if page.StatusCode != 0 {
+		m.Statuses[strconv.Itoa(page.StatusCode)]++
+	} else if page.Error != nil {
+		m.Errors[classifyError(*page.Error)]++
+	}
+}
+
+// classifyError describes the kind of failure which caused a fetch to fail.
+func classifyError(err error) string {
+	var netErr net.Error
+	switch {
+	case classifyDNSError(err) != "":
+		return "dns " + classifyDNSError(err)
+	case isCertificateError(err):
+		return "certificate"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// Save records the end of the crawl, and writes the manifest to the file as
+// JSON.
+func (m *Manifest) Save(filename string, artifacts []string, failed bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Finished = clock()
+	m.Seconds = m.Finished.Sub(m.Started).Seconds()
+	m.Artifacts = append(m.Artifacts[:0], artifacts...)
+	m.Failed = failed
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
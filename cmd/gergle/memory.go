@@ -0,0 +1,101 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// MemoryGuard applies backpressure to a crawl whose memory use crosses its
+// Limit: once the heap exceeds the limit, even after garbage collection, only
+// one page is fetched at a time until it falls back below 90% of the limit.
+// This stops a crawl from outgrowing its memory while the reporters and output
+// catch up, without stalling it entirely.
+type MemoryGuard struct {
+	// Limit is the heap size, in bytes, beyond which the crawl is throttled.
+	Limit uint64
+	// Interval is how often the heap size is checked.
+	Interval time.Duration
+
+	// usage returns the current heap size.
+	usage  func() uint64
+	over   bool
+	active int
+	lock   sync.Mutex
+	cond   *sync.Cond
+}
+
+func NewMemoryGuard(limit uint64) *MemoryGuard {
+	g := &MemoryGuard{Limit: limit, Interval: time.Second, usage: heapSize}
+	g.cond = sync.NewCond(&g.lock)
+	return g
+}
+
+// heapSize returns the bytes of the heap in use.
+func heapSize() uint64 {
+	stats := runtime.MemStats{}
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// Acquire blocks while the crawl is over its memory limit and another page
+// is being fetched.
+func (g *MemoryGuard) Acquire() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for g.over && g.active > 0 {
+		g.cond.Wait()
+	}
+	g.active++
+}
+
+// Release marks a page acquired for as fetched.
+func (g *MemoryGuard) Release() {
+	g.lock.Lock()
+	g.active--
+	g.lock.Unlock()
+	g.cond.Broadcast()
+}
+
+// Watch checks the memory use of the crawl every Interval until stop is
+// closed.
+func (g *MemoryGuard) Watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			g.check()
+		}
+	}
+}
+
+// check throttles or releases the crawl according to its memory use.
+func (g *MemoryGuard) check() {
+	usage := g.usage()
+	g.lock.Lock()
+	over := g.over
+	g.lock.Unlock()
+
+	if !over && usage > g.Limit {
+		// Only throttle if collecting garbage doesn't get back under.
+		debug.FreeOSMemory()
+		if usage = g.usage(); usage > g.Limit {
+			logger.Warn("Memory over limit, throttling crawl", "heap", usage, "limit", g.Limit)
+			g.setOver(true)
+		}
+	} else if over && usage < g.Limit/10*9 {
+		logger.Info("Memory back under limit, resuming crawl", "heap", usage, "limit", g.Limit)
+		g.setOver(false)
+	}
+}
+
+func (g *MemoryGuard) setOver(over bool) {
+	g.lock.Lock()
+	g.over = over
+	g.lock.Unlock()
+	g.cond.Broadcast()
+}
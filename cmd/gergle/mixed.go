@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// MixedContentReporter lists the links and assets of secure pages which are
+// served over plain http, and so are blocked or warned about by browsers.
+type MixedContentReporter struct {
+	pages map[string][]*Link
+	lock  sync.Mutex
+}
+
+func NewMixedContentReporter() *MixedContentReporter {
+	return &MixedContentReporter{pages: make(map[string][]*Link)}
+}
+
+func (m *MixedContentReporter) Observe(page Page) {
+	if page.URL.Scheme != "https" {
+		return
+	}
+
+	var insecure []*Link
+	for _, links := range [][]*Link{page.Assets, page.Links} {
+		for _, link := range links {
+			if link.URL.Scheme == "http" {
+				insecure = append(insecure, link)
+			}
+		}
+	}
+	if len(insecure) == 0 {
+		return
+	}
+
+	m.lock.Lock()
+	m.pages[page.URL.String()] = insecure
+	m.lock.Unlock()
+}
+
+func (m *MixedContentReporter) Report(w io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	pages := make([]string, 0, len(m.pages))
+	for page := range m.pages {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	fmt.Fprintf(w, "Mixed content: %d pages\n", len(pages))
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s\n", page)
+		for _, link := range m.pages[page] {
+			fmt.Fprintf(w, "  - %s: %s\n", link.Type, link.URL)
+		}
+	}
+}
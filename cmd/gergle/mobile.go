@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// MobileReporter pairs each page with its AMP and mobile alternates, and lists
+// the pairs whose alternate is broken or was never crawled.
+type MobileReporter struct {
+	alternates map[string][]*Alternate
+	pages      map[string]Page
+	lock       sync.Mutex
+}
+
+func NewMobileReporter() *MobileReporter {
+	return &MobileReporter{
+		alternates: make(map[string][]*Alternate),
+		pages:      make(map[string]Page),
+	}
+}
+
+func (m *MobileReporter) Observe(page Page) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// Only the outcome of fetching alternates is needed, not their content.
+	m.pages[sanitizeURL(page.URL)] = Page{StatusCode: page.StatusCode, Error: page.Error}
+
+	for _, alternate := range page.Alternates {
+		if alternate.IsMobile() {
+			m.alternates[page.URL.String()] = append(m.alternates[page.URL.String()], alternate)
+		}
+	}
+}
+
+func (m *MobileReporter) Report(w io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	pages := make([]string, 0, len(m.alternates))
+	for page := range m.alternates {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	fmt.Fprintf(w, "Mobile alternates: %d pages\n", len(pages))
+	for _, page := range pages {
+		for _, alternate := range m.alternates[page] {
+			fetched, crawled := m.pages[sanitizeURL(alternate.URL)]
+			if !crawled {
+				fmt.Fprintf(w, "- %s: %s %s not crawled\n", page, alternate.Type(), alternate.URL)
+			} else if fetched.Broken() {
+				fmt.Fprintf(w, "- %s: %s %s broken (%d)\n", page, alternate.Type(), alternate.URL, fetched.StatusCode)
+			}
+		}
+	}
+}
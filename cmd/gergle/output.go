@@ -0,0 +1,153 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PageWriter writes each crawled page as a line of JSON, in the format of the
+// serve API, to its file. If SplitPages or SplitBytes are set, it starts a new
+// numbered file whenever the current one would exceed them, so that huge
+// crawls don't produce a single unmanageable file. Files named .gz are
+// compressed.
+type PageWriter struct {
+	Filename   string
+	SplitPages int
+	// SplitBytes is the maximum uncompressed size of each file.
+	SplitBytes int64
+	// Files are the names of the files written so far.
+	Files []string
+	// Labels are attached to each page written.
+	Labels map[string]string
+
+	file  *os.File
+	gzip  *gzip.Writer
+	out   io.Writer
+	files int
+	pages int
+	bytes int64
+}
+
+func NewPageWriter(filename string, splitPages int, splitBytes int64) *PageWriter {
+	return &PageWriter{Filename: filename, SplitPages: splitPages, SplitBytes: splitBytes}
+}
+
+// splitFilename returns the name of the nth file of a split output, numbered
+// before its extension: pages.jsonl.gz becomes pages-00001.jsonl.gz.
+func splitFilename(filename string, n int) string {
+	base, gz := filename, ""
+	if strings.HasSuffix(base, ".gz") {
+		base, gz = strings.TrimSuffix(base, ".gz"), ".gz"
+	}
+	ext := filepath.Ext(base)
+	return fmt.Sprintf("%s-%05d%s%s", strings.TrimSuffix(base, ext), n, ext, gz)
+}
+
+func (w *PageWriter) Write(page Page) error {
+	record := NewJobPage(page)
+	record.Labels = w.Labels
+	return w.write(record)
+}
+
+// ReferrersRecord follows the record of a page which more pages were found
+// linking to after it was written, listing all of its referrers.
+type ReferrersRecord struct {
+	Type      string            `json:"type"`
+	URL       string            `json:"url"`
+	Referrers []string          `json:"referrers"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// WriteReferrers writes a ReferrersRecord of the page's referrers.
+func (w *PageWriter) WriteReferrers(page PageReferrers) error {
+	return w.write(ReferrersRecord{"referrers", page.URL.String(), urlStrings(page.Referrers), w.Labels})
+}
+
+// write writes the record as a line of JSON.
+func (w *PageWriter) write(record interface{}) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	full := (w.SplitPages > 0 && w.pages >= w.SplitPages) ||
+		(w.SplitBytes > 0 && w.pages > 0 && w.bytes+int64(len(line)) > w.SplitBytes)
+	if w.file != nil && full {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.out.Write(line); err != nil {
+		return err
+	}
+	w.pages++
+	w.bytes += int64(len(line))
+	return nil
+}
+
+// ParseLabels reads the --label pairs of the form KEY=VALUE.
+func ParseLabels(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		eq := strings.Index(pair, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("Expected --label of the form KEY=VALUE, got %q.", pair)
+		}
+		labels[pair[:eq]] = pair[eq+1:]
+	}
+	return labels, nil
+}
+
+// open starts the next file of the output.
+func (w *PageWriter) open() error {
+	filename := w.Filename
+	if w.SplitPages > 0 || w.SplitBytes > 0 {
+		filename = splitFilename(w.Filename, w.files+1)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	logger.Debug("Writing pages", "file", filename)
+
+	w.file, w.out = file, file
+	w.Files = append(w.Files, filename)
+	if strings.HasSuffix(filename, ".gz") {
+		w.gzip = gzip.NewWriter(file)
+		w.out = w.gzip
+	}
+	w.files++
+	w.pages, w.bytes = 0, 0
+	return nil
+}
+
+// Close finishes the current file, if any.
+func (w *PageWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	if w.gzip != nil {
+		if err := w.gzip.Close(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	err := w.file.Close()
+	w.file, w.gzip, w.out = nil, nil, nil
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPageWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gergle-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	labels, err := ParseLabels([]string{"env=staging", "branch=feature=x"})
+	if err != nil {
+		t.Fatalf("ParseLabels should not return error: %s", err)
+	}
+	if _, err := ParseLabels([]string{"=staging"}); err == nil {
+		t.Error("ParseLabels should return error for a label without a key")
+	}
+
+	w := NewPageWriter(filepath.Join(dir, "pages.jsonl.gz"), 2, 0)
+	w.Labels = labels
+	for _, path := range []string{"/", "/a", "/b", "/c", "/d"} {
+		if err := w.Write(Page{URL: &url.URL{Scheme: "http", Host: "example.com", Path: path}, StatusCode: 200}); err != nil {
+			t.Fatalf("Failed to write page: %s", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close output: %s", err)
+	}
+
+	expected := map[string][]string{
+		"pages-00001.jsonl.gz": {"http://example.com/", "http://example.com/a"},
+		"pages-00002.jsonl.gz": {"http://example.com/b", "http://example.com/c"},
+		"pages-00003.jsonl.gz": {"http://example.com/d"},
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != len(expected) {
+		t.Errorf("Expected %d files but got %d", len(expected), len(files))
+	}
+	for name, urls := range expected {
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("Expected output file %s: %s", name, err)
+			continue
+		}
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("Expected %s to be gzipped: %s", name, err)
+		}
+		decoder := json.NewDecoder(gz)
+		found := []string{}
+		for page := (JobPage{}); decoder.Decode(&page) == nil; page = (JobPage{}) {
+			found = append(found, page.URL)
+			if !reflect.DeepEqual(page.Labels, labels) {
+				t.Errorf("Expected %s to be labelled %v but got %v", page.URL, labels, page.Labels)
+			}
+		}
+		file.Close()
+		if !reflect.DeepEqual(found, urls) {
+			t.Errorf("Expected %s to hold %v but got %v", name, urls, found)
+		}
+	}
+
+	if name := splitFilename("out/crawl.json", 12); name != "out/crawl-00012.json" {
+		t.Errorf("Expected out/crawl-00012.json but got %s", name)
+	}
+}
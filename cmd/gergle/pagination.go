@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// PaginationReporter lists the paginated sequences found during the crawl,
+// following each from its first page through its rel="next" links.
+type PaginationReporter struct {
+	next map[string]string
+	lock sync.Mutex
+}
+
+func NewPaginationReporter() *PaginationReporter {
+	return &PaginationReporter{next: make(map[string]string)}
+}
+
+func (p *PaginationReporter) Observe(page Page) {
+	if page.Next == nil {
+		return
+	}
+	p.lock.Lock()
+	p.next[sanitizeURL(page.URL)] = sanitizeURL(page.Next)
+	p.lock.Unlock()
+}
+
+// Sequences returns the length of each sequence, keyed by its first page.
+// Sequences with no first page, because they loop, are keyed by the earliest
+// of their pages.
+func (p *PaginationReporter) Sequences() map[string]int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pages := make([]string, 0, len(p.next))
+	linked := make(map[string]bool)
+	for page, next := range p.next {
+		pages = append(pages, page)
+		linked[next] = true
+	}
+	sort.Strings(pages)
+
+	// Visit the first pages before those of loops.
+	sort.SliceStable(pages, func(i, j int) bool { return !linked[pages[i]] && linked[pages[j]] })
+
+	sequences := make(map[string]int)
+	seen := make(map[string]bool)
+	for _, first := range pages {
+		if seen[first] {
+			continue
+		}
+		length := 0
+		for page := first; page != "" && !seen[page]; page = p.next[page] {
+			seen[page] = true
+			length++
+		}
+		sequences[first] = length
+	}
+	return sequences
+}
+
+func (p *PaginationReporter) Report(w io.Writer) {
+	sequences := p.Sequences()
+	firsts := make([]string, 0, len(sequences))
+	for first := range sequences {
+		firsts = append(firsts, first)
+	}
+	sort.Strings(firsts)
+
+	fmt.Fprintf(w, "Pagination: %d sequences\n", len(firsts))
+	for _, first := range firsts {
+		fmt.Fprintf(w, "- %s (%d pages)\n", first, sequences[first])
+	}
+}
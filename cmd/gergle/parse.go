@@ -4,11 +4,18 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"html"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -39,6 +46,30 @@ func readCrawlDelay(body []byte) float64 {
 	return delay
 }
 
+// readRobotsTag parses the X-Robots-Tag header values of a response, ignoring
+// directives aimed at specific user agents.
+func readRobotsTag(values []string) (noIndex bool, noFollow bool) {
+	for _, value := range values {
+		if colon := strings.Index(value, ":"); colon >= 0 {
+			agent := strings.TrimSpace(value[:colon])
+			if !strings.ContainsAny(agent, ", ") {
+				continue
+			}
+		}
+		for _, directive := range strings.Split(value, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "noindex":
+				noIndex = true
+			case "nofollow":
+				noFollow = true
+			case "none":
+				noIndex, noFollow = true, true
+			}
+		}
+	}
+	return
+}
+
 // parseDisallowRule transforms a Disallow rule pattern into a regexp.Regexp
 func parseDisallowRule(rule string) *regexp.Regexp {
 	return regexp.MustCompile("^/?" + strings.Replace(regexp.QuoteMeta(strings.TrimLeft(rule, "/")), "\\*", "*", -1))
@@ -57,7 +88,80 @@ type ResponsePageParser interface {
 	Parse(*Task, *http.Response) Page
 }
 
-type RegexPageParser struct{}
+// A ContentTypeParser reports which responses it reads the body of, so that
+// the bodies of others needn't be downloaded.
+type ContentTypeParser interface {
+	Parses(contentType string) bool
+}
+
+// parses reports whether the parser reads the body of responses of the
+// Content-Type, which is assumed of HTML and XML unless the parser says
+// otherwise.
+func parses(parser ResponsePageParser, contentType string) bool {
+	if p, ok := parser.(ContentTypeParser); ok {
+		return p.Parses(contentType)
+	}
+	return looksParseable(contentType)
+}
+
+type RegexPageParser struct {
+	// FollowEndpoints has page-like endpoints found in scripts and data
+	// attributes followed as links.
+	FollowEndpoints bool
+	// FollowMobile has AMP and mobile alternates of pages followed as links.
+	FollowMobile bool
+	// IgnoreRobotsTag has links extracted even from responses with an
+	// X-Robots-Tag: nofollow header.
+	IgnoreRobotsTag bool
+	// FollowForms has the actions of GET forms followed as links.
+	FollowForms bool
+	// ExtractText has the visible text of HTML pages extracted into the
+	// Page's Text, for Reporters to analyse.
+	ExtractText bool
+	// CheckAccessibility has the accessibility problems of HTML pages counted
+	// into the Page's Accessibility.
+	CheckAccessibility bool
+	// CollectFragments has the ids and <a name> targets of HTML pages
+	// collected into the Page's Fragments.
+	CollectFragments bool
+	// CheckHrefs has the hrefs and srcs of HTML pages which aren't validly
+	// encoded URLs collected into the Page's BadHrefs.
+	CheckHrefs bool
+	// FollowDocuments has the URLs embedded in PDF and Office documents
+	// extracted as links.
+	FollowDocuments bool
+	// CollectImages has the <img> tags of HTML pages collected into the
+	// Page's Images.
+	CollectImages bool
+	// CollectForms has the forms of HTML pages collected into the Page's
+	// Forms.
+	CollectForms bool
+	// CollectContacts has the mailto: and tel: links of HTML pages collected
+	// into the Page's Contacts.
+	CollectContacts bool
+	// CollectSchemas has the schema.org types of the structured data of HTML
+	// pages collected into the Page's Schemas.
+	CollectSchemas bool
+	// CollectSocial has the Open Graph and Twitter card metadata of HTML
+	// pages collected into the Page's Social.
+	CollectSocial bool
+	// CollectIcons has the icons and web app manifest of HTML pages collected
+	// into the Page's Icons and Manifest.
+	CollectIcons bool
+	// Grep patterns have their matches within each page body counted.
+	Grep []*regexp.Regexp
+	// AssetRules are the tags and attributes which assets are extracted from,
+	// defaulting to DefaultAssetRules.
+	AssetRules []AssetRule
+	// Elements, if set, finds the links, assets, frames, feeds, endpoints,
+	// alternates, images, forms and contacts of HTML pages in place of the
+	// regular expressions.
+	Elements ElementParser
+	// StreamOver, if set, has HTML pages larger than this many bytes parsed as
+	// they're read rather than held in memory, finding only their links,
+	// assets and frames.
+	StreamOver int64
+}
 
 func (r *RegexPageParser) Parse(task *Task, resp *http.Response) Page {
 	if resp.StatusCode != 200 {
@@ -66,26 +170,190 @@ func (r *RegexPageParser) Parse(task *Task, resp *http.Response) Page {
 	}
 
 	mime := resp.Header.Get("Content-Type")
-	if !strings.Contains(strings.ToLower(mime), "html") {
+	isHTML := strings.Contains(strings.ToLower(mime), "html")
+	if r.FollowDocuments && isDocument(mime) {
+		return r.parseDocument(task, resp)
+	}
+	if isPlainText(mime) {
+		return r.parseText(task, resp)
+	}
+	if !looksParseable(mime) {
 		logger.Debug("Doesn't look like HTML", "url", task.URL, "content-type", mime)
 		return ErrorPage(task.URL, task.Depth, errors.New("Doesn't look like HTML"))
 	}
 
+	var reader io.Reader = resp.Body
+	if r.StreamOver > 0 && isHTML {
+		reader = io.LimitReader(resp.Body, r.StreamOver+1)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		logger.Warn("Failed to read body", "url", task.URL)
+		return ErrorPage(task.URL, task.Depth, err)
+	}
+
+	var page Page
+	if r.StreamOver > 0 && isHTML && int64(len(body)) > r.StreamOver {
+		logger.Debug("Streaming large page", "url", task.URL)
+		page = r.parseStream(task, resp, io.MultiReader(bytes.NewReader(body), resp.Body))
+		if !page.Processed {
+			return page
+		}
+	} else if isHTML || isXHTML(body) {
+		page = r.parseHTML(task, resp, unprefixXHTML(body))
+		page.Size = len(body)
+		page.Matches = r.grep(body)
+	} else if isFeed(body) {
+		page = parseFeed(task, resp, body)
+		page.Size = len(body)
+		page.Matches = r.grep(body)
+	} else {
+		logger.Debug("Doesn't look like HTML", "url", task.URL, "content-type", mime)
+		return ErrorPage(task.URL, task.Depth, errors.New("Doesn't look like HTML"))
+	}
+
+	page.NoIndex, page.NoFollow = readRobotsTag(resp.Header["X-Robots-Tag"])
+	if page.NoFollow && !r.IgnoreRobotsTag {
+		logger.Debug("Not extracting links from nofollow page", "url", task.URL)
+		page.Links = []*Link{}
+		return page
+	}
+
+	if r.FollowMobile {
+		for _, alternate := range page.Alternates {
+			if alternate.IsMobile() {
+				page.Links = append(page.Links, alternate.Link(task.URL, task.Depth+1))
+			}
+		}
+	}
+
+	if r.FollowForms {
+		for _, form := range page.Forms {
+			if form.Method == "GET" {
+				page.Links = append(page.Links, form.Link(task.URL, task.Depth+1))
+			}
+		}
+	}
+
+	if r.FollowEndpoints {
+		for _, endpoint := range page.Endpoints {
+			if looksLikePage(endpoint.URL) {
+				page.Links = append(page.Links, endpoint)
+			}
+		}
+	}
+
+	return page
+}
+
+func (r *RegexPageParser) Parses(contentType string) bool {
+	return looksParseable(contentType) || isPlainText(contentType) || r.FollowDocuments && isDocument(contentType)
+}
+
+// parseDocument reads the links of a PDF or Office document, unless its
+// X-Robots-Tag asks that they not be followed.
+func (r *RegexPageParser) parseDocument(task *Task, resp *http.Response) Page {
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		logger.Warn("Failed to read body", "url", task.URL)
 		return ErrorPage(task.URL, task.Depth, err)
 	}
 
+	page := parseDocument(task, resp, body)
+	page.NoIndex, page.NoFollow = readRobotsTag(resp.Header["X-Robots-Tag"])
+	if page.NoFollow && !r.IgnoreRobotsTag {
+		logger.Debug("Not extracting links from nofollow document", "url", task.URL)
+		page.Links = []*Link{}
+	}
+	return page
+}
+
+// looksParseable reports whether responses of the Content-Type may be parsed,
+// as HTML, or as XML feeds and XHTML pages.
+func looksParseable(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.Contains(contentType, "html") || strings.Contains(contentType, "xml")
+}
+
+// grep counts the matches of each of the Grep patterns within the body,
+// omitting those which don't match.
+func (r *RegexPageParser) grep(body []byte) map[string]int {
+	if len(r.Grep) == 0 {
+		return nil
+	}
+	matches := make(map[string]int)
+	for _, pattern := range r.Grep {
+		if count := len(pattern.FindAllIndex(body, -1)); count > 0 {
+			matches[pattern.String()] = count
+		}
+	}
+	return matches
+}
+
+// parseHTML returns the Page described by an HTML response body.
+func (r *RegexPageParser) parseHTML(task *Task, resp *http.Response, body []byte) Page {
 	base := r.parseBase(resp, body)
-	return Page{
+	page := Page{
 		URL:       task.URL,
+		Checksum:  fmt.Sprintf("%x", sha1.Sum(body)),
 		Processed: true,
 		Depth:     task.Depth,
-		Links:     r.parseLinks(base, body, task.Depth+1),
-		Assets:    r.parseAssets(base, body, task.Depth+1),
 		Error:     nil,
 	}
+	if r.Elements != nil {
+		r.elementDetails(&page, r.Elements.Elements(body), base)
+	} else {
+		r.regexDetails(&page, base, body)
+	}
+	page.Language = parseLanguage(resp, body)
+	page.Template = templateHash(body)
+	if r.CollectSchemas {
+		page.Schemas = parseSchemas(body)
+	}
+	if r.CollectSocial {
+		page.Social = parseSocial(body)
+	}
+	if r.CollectIcons {
+		page.Icons, page.Manifest = parseIcons(base, body)
+	}
+	if r.CheckAccessibility {
+		page.Accessibility = checkAccessibility(body, page.Images)
+	}
+	if r.CollectFragments {
+		page.Fragments = parseFragments(body)
+	}
+	if r.CheckHrefs {
+		page.BadHrefs = parseBadHrefs(body)
+	}
+	if r.ExtractText {
+		page.Text = extractText(body)
+	}
+	page.Next, page.Prev = r.parsePagination(base, body)
+	page.Links = paginate(page.Links, page.Next, "next", task)
+	page.Links = paginate(page.Links, page.Prev, "prev", task)
+	return page
+}
+
+// regexDetails fills in the links, assets, frames, feeds, endpoints,
+// alternates, images, forms and contacts of the page with the regular
+// expressions.
+func (r *RegexPageParser) regexDetails(page *Page, base *url.URL, body []byte) {
+	page.Links = r.parseLinks(base, body, page.Depth+1)
+	page.Assets = r.parseAssets(base, body, page.Depth+1)
+	page.Endpoints = r.parseEndpoints(base, body, page.Depth+1)
+	page.Alternates = r.parseAlternates(base, body)
+	page.NonHTTP = r.parseNonHTTP(base, body)
+	if r.CollectImages || r.CheckAccessibility {
+		page.Images = r.parseImages(base, body)
+	}
+	if r.CollectForms || r.FollowForms {
+		page.Forms = r.parseForms(base, body)
+	}
+	if r.CollectContacts {
+		page.Contacts = r.parseContacts(base, body)
+	}
+	page.Links = append(page.Links, r.parseFrames(base, body, page.Depth)...)
+	page.Links = append(page.Links, r.parseFeeds(base, body, page.Depth+1)...)
 }
 
 var baseRegex = regexp.MustCompile("(?is)<base[^>]+href=[\"']?(.+?)['\"\\s>]")
@@ -107,34 +375,719 @@ func (r *RegexPageParser) parseBase(resp *http.Response, body []byte) *url.URL {
 // Attribution: definitely not http://stackoverflow.com/a/1732454/123600.
 var anchorRegex = regexp.MustCompile("(?is)<a[^>]+href=[\"']?(.+?)['\"\\s>]")
 
-// parseLinks returns all of the anchor links on the given page.
+// parseLinks returns all of the anchor links on the given page, with the
+// region of the page each was found in.
 func (r *RegexPageParser) parseLinks(base *url.URL, body []byte, depth uint16) (links []*Link) {
 	n := bytes.IndexByte(body, 0)
-	for _, anchor := range anchorRegex.FindAllSubmatch(body, n) {
-		link, err := AnchorLink(string(anchor[1]), base, depth)
+	regions := parseRegions(body)
+	for _, anchor := range anchorRegex.FindAllSubmatchIndex(body, n) {
+		href := body[anchor[2]:anchor[3]]
+		link, err := AnchorLink(string(href), base, depth)
 		if err != nil {
-			logger.Debug("Failed to parse href", "href", anchor[1])
+			logger.Debug("Failed to parse href", "href", href)
 			continue
 		}
+		if !link.IsHTTP() {
+			continue
+		}
+		link.Region = regions.At(anchor[0])
 		links = append(links, link)
 	}
 
 	return
 }
 
-var assetRegex = regexp.MustCompile("(?is)<(script|img|embed|audio|video|iframe)[^>]+src=[\"']?(.+?)['\"\\s>]")
+// boilerplateRegexes match the elements whose links are repeated across the
+// pages of a site, rather than being part of each page's content. Elements
+// nested within another of the same name end early.
+var boilerplateRegexes = map[string]*regexp.Regexp{
+	"nav":    regexp.MustCompile("(?is)<nav\\b.*?</nav\\s*>"),
+	"header": regexp.MustCompile("(?is)<header\\b.*?</header\\s*>"),
+	"footer": regexp.MustCompile("(?is)<footer\\b.*?</footer\\s*>"),
+	"aside":  regexp.MustCompile("(?is)<aside\\b.*?</aside\\s*>"),
+}
+
+// pageRegion is a span of the page body belonging to a boilerplate element.
+type pageRegion struct {
+	Name       string
+	Start, End int
+}
 
+type pageRegions []pageRegion
+
+// parseRegions returns the spans of the page's boilerplate elements.
+func parseRegions(body []byte) (regions pageRegions) {
+	for name, regex := range boilerplateRegexes {
+		for _, span := range regex.FindAllIndex(body, -1) {
+			regions = append(regions, pageRegion{name, span[0], span[1]})
+		}
+	}
+	return
+}
+
+// At returns the name of the innermost boilerplate region containing the
+// offset, or "content" if there is none.
+func (p pageRegions) At(offset int) string {
+	region := pageRegion{Name: "content", Start: -1}
+	for _, r := range p {
+		if r.Start <= offset && offset < r.End && r.Start > region.Start {
+			region = r
+		}
+	}
+	return region.Name
+}
+
+// parseContacts returns the mailto: and tel: links of the page, typed by their
+// scheme.
+func (r *RegexPageParser) parseContacts(base *url.URL, body []byte) (contacts []*Link) {
+	n := bytes.IndexByte(body, 0)
+	for _, anchor := range anchorRegex.FindAllSubmatch(body, n) {
+		link, err := AnchorLink(string(anchor[1]), base, 0)
+		if err != nil || !link.IsContact() {
+			continue
+		}
+		contacts = append(contacts, link)
+	}
+
+	return
+}
+
+// parseNonHTTP returns the anchor links of the page which aren't crawled
+// because their scheme isn't http or https, such as javascript: and ftp:
+// links, typed by their scheme.
+func (r *RegexPageParser) parseNonHTTP(base *url.URL, body []byte) (links []*Link) {
+	n := bytes.IndexByte(body, 0)
+	for _, anchor := range anchorRegex.FindAllSubmatch(body, n) {
+		link, err := AnchorLink(string(anchor[1]), base, 0)
+		if err != nil || link.IsHTTP() {
+			continue
+		}
+		links = append(links, link)
+	}
+
+	return
+}
+
+var frameRegex = regexp.MustCompile("(?is)<(frame|iframe)\\s[^>]*src=[\"']?(.+?)['\"\\s>]")
+
+// parseFrames returns all of the documents framed by the given page. Frames are
+// displayed as part of the page, so share its depth rather than being a click
+// further away.
+func (r *RegexPageParser) parseFrames(base *url.URL, body []byte, depth uint16) (frames []*Link) {
+	n := bytes.IndexByte(body, 0)
+	for _, frameTag := range frameRegex.FindAllSubmatch(body, n) {
+		frame, err := AssetLink(strings.ToLower(string(frameTag[1])), string(frameTag[2]), base, depth)
+		if err != nil {
+			logger.Debug("Failed to parse frame source", "src", frameTag[2])
+			continue
+		}
+		frames = append(frames, frame)
+	}
+
+	return
+}
+
+// An AssetRule describes an attribute of a tag which refers to an asset, such
+// as the src of <img> tags. Rules with a Rel only apply to tags whose rel
+// attribute includes it, and type their assets by it.
+type AssetRule struct {
+	Tag  string
+	Attr string
+	Rel  string
+}
+
+// DefaultAssetRules are the assets extracted unless configured otherwise.
+var DefaultAssetRules = []AssetRule{
+	{Tag: "script", Attr: "src"},
+	{Tag: "img", Attr: "src"},
+	{Tag: "embed", Attr: "src"},
+	{Tag: "audio", Attr: "src"},
+	{Tag: "video", Attr: "src"},
+}
+
+// ParseAssetRule reads a rule of the form TAG:ATTR or TAG:ATTR:REL, such as
+// track:src or link:href:preload.
+func ParseAssetRule(rule string) (AssetRule, error) {
+	parts := strings.Split(strings.ToLower(rule), ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return AssetRule{}, fmt.Errorf("Expected --asset of the form TAG:ATTR or TAG:ATTR:REL, got %q.", rule)
+	}
+	assetRule := AssetRule{Tag: parts[0], Attr: parts[1]}
+	if len(parts) == 3 {
+		assetRule.Rel = parts[2]
+	}
+	return assetRule, nil
+}
+
+var elementRegex = regexp.MustCompile("(?is)<([a-z][a-z0-9-]*)\\s[^>]*>")
+
+// parseAssets returns the assets of the page described by the AssetRules, or
+// by the DefaultAssetRules if there are none.
 func (r *RegexPageParser) parseAssets(base *url.URL, body []byte, depth uint16) (assets []*Link) {
-	// TODO: Consider <link>, <object> tags.
+	tagRules := r.tagAssetRules()
 	n := bytes.IndexByte(body, 0)
-	for _, assetTag := range assetRegex.FindAllSubmatch(body, n) {
-		asset, err := AssetLink(string(assetTag[1]), string(assetTag[2]), base, depth)
+	for _, element := range elementRegex.FindAllSubmatch(body, n) {
+		tag := strings.ToLower(string(element[1]))
+		if tagRules[tag] == nil {
+			continue
+		}
+		assets = append(assets, elementAssets(tagRules[tag], tag, parseAttrs(element[0]), base, depth)...)
+	}
+
+	return
+}
+
+// tagAssetRules returns the AssetRules of each tag.
+func (r *RegexPageParser) tagAssetRules() map[string][]AssetRule {
+	rules := r.AssetRules
+	if rules == nil {
+		rules = DefaultAssetRules
+	}
+	tagRules := make(map[string][]AssetRule)
+	for _, rule := range rules {
+		tagRules[rule.Tag] = append(tagRules[rule.Tag], rule)
+	}
+	return tagRules
+}
+
+// elementAssets returns the assets which the rules find in the attributes of
+// an element.
+func elementAssets(rules []AssetRule, tag string, attrs map[string]string, base *url.URL, depth uint16) (assets []*Link) {
+	for _, rule := range rules {
+		src := attrs[rule.Attr]
+		if src == "" || (rule.Rel != "" && !hasRel(attrs["rel"], rule.Rel)) {
+			continue
+		}
+		assetType := tag
+		if rule.Rel != "" {
+			assetType = rule.Rel
+		}
+		asset, err := AssetLink(assetType, src, base, depth)
 		if err != nil {
-			logger.Debug("Failed to parse asset source", "src", assetTag[2])
+			logger.Debug("Failed to parse asset source", "src", src)
 			continue
 		}
 		assets = append(assets, asset)
 	}
+	return
+}
+
+var jsonScriptRegex = regexp.MustCompile("(?is)<script[^>]+type=[\"']?application/(?:ld\\+)?json[\"']?[^>]*>(.*?)</script>")
+var dataURLRegex = regexp.MustCompile("(?is)<[^>]+\\sdata-(?:href|url)=[\"']?(.+?)['\"\\s>]")
+
+// parseEndpoints returns the same-origin URLs mentioned within inline JSON and
+// JSON-LD scripts, and data-href/data-url attributes. These are often the
+// endpoints a page's scripts will request.
+func (r *RegexPageParser) parseEndpoints(base *url.URL, body []byte, depth uint16) (endpoints []*Link) {
+	n := bytes.IndexByte(body, 0)
+
+	var hrefs []string
+	for _, script := range jsonScriptRegex.FindAllSubmatch(body, n) {
+		var data interface{}
+		if err := json.Unmarshal(script[1], &data); err != nil {
+			logger.Debug("Failed to parse inline JSON", "error", err)
+			continue
+		}
+		hrefs = append(hrefs, jsonURLs(data)...)
+	}
+	for _, attr := range dataURLRegex.FindAllSubmatch(body, n) {
+		hrefs = append(hrefs, string(attr[1]))
+	}
+	return endpointLinks(hrefs, base, depth)
+}
+
+// isJSONScript reports whether the type of a <script> is inline JSON or
+// JSON-LD.
+func isJSONScript(scriptType string) bool {
+	scriptType = strings.ToLower(strings.TrimSpace(scriptType))
+	return scriptType == "application/json" || scriptType == "application/ld+json"
+}
+
+// endpointLinks returns the endpoints of the hrefs which are on the same
+// origin as the page.
+func endpointLinks(hrefs []string, base *url.URL, depth uint16) (endpoints []*Link) {
+	for _, href := range hrefs {
+		endpoint, err := AssetLink("endpoint", href, base, depth)
+		if err != nil {
+			logger.Debug("Failed to parse endpoint", "href", href)
+			continue
+		}
+		if !endpoint.External {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return
+}
+
+// jsonURLs returns all of the strings within the decoded JSON value which look
+// like absolute or root-relative URLs.
+func jsonURLs(data interface{}) (hrefs []string) {
+	switch value := data.(type) {
+	case string:
+		if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") ||
+			(strings.HasPrefix(value, "/") && !strings.ContainsAny(value, " \t\n")) {
+			hrefs = append(hrefs, value)
+		}
+	case []interface{}:
+		for _, item := range value {
+			hrefs = append(hrefs, jsonURLs(item)...)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			hrefs = append(hrefs, jsonURLs(value[key])...)
+		}
+	}
+	return
+}
+
+// looksLikePage guesses, from its path, whether a URL is for a web page rather
+// than an API endpoint or other resource.
+func looksLikePage(u *url.URL) bool {
+	if strings.HasPrefix(u.Path, "/api/") {
+		return false
+	}
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case "", ".html", ".htm", ".xhtml", ".php", ".asp", ".aspx", ".jsp":
+		return true
+	}
+	return false
+}
+
+var linkTagRegex = regexp.MustCompile("(?is)<link\\s[^>]*>")
+var attrRegex = regexp.MustCompile("(?is)([\\w:-]+)\\s*=\\s*(?:\"([^\"]*)\"|'([^']*)'|([^\\s\"'>]+))")
+
+// parseAttrs returns the attributes of an HTML tag, keyed by lower-case name.
+func parseAttrs(tag []byte) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range attrRegex.FindAllSubmatch(tag, -1) {
+		name := strings.ToLower(string(attr[1]))
+		if _, found := attrs[name]; !found {
+			attrs[name] = string(attr[2]) + string(attr[3]) + string(attr[4])
+		}
+	}
+	return attrs
+}
+
+// hasRel reports whether the space-separated rel attribute includes the value.
+func hasRel(rel string, value string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if r == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAlternates returns the alternate versions of the page declared by
+// <link rel="alternate"> tags with a hreflang or media attribute, and by
+// <link rel="amphtml"> tags.
+func (r *RegexPageParser) parseAlternates(base *url.URL, body []byte) (alternates []*Alternate) {
+	n := bytes.IndexByte(body, 0)
+	for _, tag := range linkTagRegex.FindAll(body, n) {
+		if alternate := parseAlternateAttrs(parseAttrs(tag), base); alternate != nil {
+			alternates = append(alternates, alternate)
+		}
+	}
+	return
+}
+
+// parseAlternateAttrs returns the alternate declared by the attributes of a
+// <link> tag, or nil if it doesn't declare one.
+func parseAlternateAttrs(attrs map[string]string, base *url.URL) *Alternate {
+	alternate := &Alternate{HrefLang: attrs["hreflang"], Media: attrs["media"]}
+	if hasRel(attrs["rel"], "amphtml") {
+		alternate.AMP = true
+	} else if !hasRel(attrs["rel"], "alternate") || (alternate.HrefLang == "" && alternate.Media == "") {
+		return nil
+	}
+
+	href, err := url.Parse(attrs["href"])
+	if err != nil {
+		logger.Debug("Failed to parse alternate href", "href", attrs["href"])
+		return nil
+	}
+	alternate.URL = base.ResolveReference(href)
+	return alternate
+}
+
+// parseFeeds returns the RSS and Atom feeds advertised by the page's
+// <link rel="alternate"> tags.
+func (r *RegexPageParser) parseFeeds(base *url.URL, body []byte, depth uint16) (feeds []*Link) {
+	n := bytes.IndexByte(body, 0)
+	for _, tag := range linkTagRegex.FindAll(body, n) {
+		if feed := parseFeedAttrs(parseAttrs(tag), base, depth); feed != nil {
+			feeds = append(feeds, feed)
+		}
+	}
+	return
+}
+
+// parseFeedAttrs returns the feed advertised by the attributes of a <link>
+// tag, or nil if it doesn't advertise one.
+func parseFeedAttrs(attrs map[string]string, base *url.URL, depth uint16) *Link {
+	mime := strings.ToLower(attrs["type"])
+	if !hasRel(attrs["rel"], "alternate") || !(strings.Contains(mime, "rss") || strings.Contains(mime, "atom")) {
+		return nil
+	}
+	feed, err := AssetLink("feed", attrs["href"], base, depth)
+	if err != nil {
+		logger.Debug("Failed to parse feed href", "href", attrs["href"])
+		return nil
+	}
+	return feed
+}
+
+var imgTagRegex = regexp.MustCompile("(?is)<img\\s[^>]*>")
+
+// parseImages returns the images of the page's <img> tags, with their alt
+// text.
+func (r *RegexPageParser) parseImages(base *url.URL, body []byte) (images []*Image) {
+	n := bytes.IndexByte(body, 0)
+	for _, tag := range imgTagRegex.FindAll(body, n) {
+		if image := parseImageAttrs(parseAttrs(tag), base); image != nil {
+			images = append(images, image)
+		}
+	}
+	return
+}
+
+// parseImageAttrs returns the image of the attributes of an <img> tag, or nil
+// if it has no valid src.
+func parseImageAttrs(attrs map[string]string, base *url.URL) *Image {
+	src, err := url.Parse(strings.TrimSpace(attrs["src"]))
+	if err != nil || attrs["src"] == "" {
+		logger.Debug("Failed to parse image src", "src", attrs["src"])
+		return nil
+	}
+	alt, hasAlt := attrs["alt"]
+	return &Image{URL: base.ResolveReference(src), Alt: alt, HasAlt: hasAlt}
+}
+
+var formRegex = regexp.MustCompile("(?is)<form\\b([^>]*)>(.*?)(?:</form>|$)")
+var inputRegex = regexp.MustCompile("(?is)<(?:input|select|textarea|button)\\b[^>]*>")
 
+// parseForms returns the forms of the page, with the names of their inputs.
+// Forms without an action submit to the page itself.
+func (r *RegexPageParser) parseForms(base *url.URL, body []byte) (forms []*Form) {
+	n := bytes.IndexByte(body, 0)
+	for _, formTag := range formRegex.FindAllSubmatch(body, n) {
+		form := parseFormAttrs(parseAttrs(formTag[1]), base)
+		if form == nil {
+			continue
+		}
+		for _, input := range inputRegex.FindAll(formTag[2], -1) {
+			if name := parseAttrs(input)["name"]; name != "" {
+				form.Inputs = append(form.Inputs, name)
+			}
+		}
+		forms = append(forms, form)
+	}
 	return
 }
+
+// parseFormAttrs returns the form of the attributes of a <form> tag, without
+// its inputs, or nil if its action is invalid.
+func parseFormAttrs(attrs map[string]string, base *url.URL) *Form {
+	action, err := url.Parse(attrs["action"])
+	if err != nil {
+		logger.Debug("Failed to parse form action", "action", attrs["action"])
+		return nil
+	}
+	form := &Form{Action: base.ResolveReference(action), Method: strings.ToUpper(attrs["method"])}
+	if form.Method == "" {
+		form.Method = "GET"
+	}
+	return form
+}
+
+var invisibleRegex = regexp.MustCompile("(?is)<!--.*?-->|<head\\b.*?</head\\s*>|<script\\b.*?</script\\s*>|<style\\b.*?</style\\s*>|<noscript\\b.*?</noscript\\s*>|<template\\b.*?</template\\s*>")
+var tagRegex = regexp.MustCompile("(?s)<[^>]*>")
+
+// extractText returns the visible text of an HTML page: that outside of tags
+// other than the <head>, scripts and styles, with entities unescaped and
+// whitespace collapsed.
+func extractText(body []byte) string {
+	text := invisibleRegex.ReplaceAll(body, []byte(" "))
+	text = tagRegex.ReplaceAll(text, []byte(" "))
+	return strings.Join(strings.Fields(html.UnescapeString(string(text))), " ")
+}
+
+var anchorTagRegex = regexp.MustCompile("(?is)<a\\s[^>]*>")
+
+// parsePagination returns the next and previous pages of a paginated
+// sequence, as given by the rel="next" and rel="prev" of <link> and <a> tags.
+func (r *RegexPageParser) parsePagination(base *url.URL, body []byte) (next *url.URL, prev *url.URL) {
+	n := bytes.IndexByte(body, 0)
+	tags := append(linkTagRegex.FindAll(body, n), anchorTagRegex.FindAll(body, n)...)
+	for _, tag := range tags {
+		attrs := parseAttrs(tag)
+		isNext, isPrev := hasRel(attrs["rel"], "next"), hasRel(attrs["rel"], "prev") || hasRel(attrs["rel"], "previous")
+		if !isNext && !isPrev {
+			continue
+		}
+
+		href, err := url.Parse(attrs["href"])
+		if err != nil {
+			logger.Debug("Failed to parse pagination href", "href", attrs["href"])
+			continue
+		}
+		if isNext && next == nil {
+			next = base.ResolveReference(href)
+		} else if isPrev && prev == nil {
+			prev = base.ResolveReference(href)
+		}
+	}
+	return
+}
+
+// paginate marks the links to the given page of the task's pagination
+// sequence as one step further along it, adding a link if there isn't one.
+func paginate(links []*Link, u *url.URL, linkType string, task *Task) []*Link {
+	if u == nil {
+		return links
+	}
+
+	found := false
+	for _, link := range links {
+		if link.URL.String() == u.String() {
+			link.Pagination = task.Pagination + 1
+			found = true
+		}
+	}
+	if found {
+		return links
+	}
+	return append(links, &Link{
+		Type:       linkType,
+		URL:        u,
+		External:   isExternal(u, task.URL),
+		Depth:      task.Depth + 1,
+		Pagination: task.Pagination + 1,
+	})
+}
+
+var htmlTagRegex = regexp.MustCompile("(?is)<html\\b[^>]*>")
+
+// parseLanguage returns the language of the page, given by the lang attribute
+// of its <html> tag, or else its Content-Language header.
+func parseLanguage(resp *http.Response, body []byte) string {
+	if tag := htmlTagRegex.Find(body); tag != nil {
+		attrs := parseAttrs(tag)
+		for _, name := range []string{"lang", "xml:lang"} {
+			if lang := strings.TrimSpace(attrs[name]); lang != "" {
+				return strings.ToLower(lang)
+			}
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Language")))
+}
+
+var elementTagRegex = regexp.MustCompile("<(/?)([a-zA-Z][a-zA-Z0-9-]*)")
+
+// voidElements are those which have no closing tag, and so no children.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// templateHash fingerprints the structure of the page by which elements are
+// found within which, ignoring their content and attributes, and how often
+// each is repeated so that list lengths don't matter. Pages built from the
+// same template tend to share a hash.
+func templateHash(body []byte) string {
+	pairs := make(map[string]bool)
+	stack := []string{""}
+	for _, tag := range elementTagRegex.FindAllSubmatch(invisibleRegex.ReplaceAll(body, nil), -1) {
+		name := strings.ToLower(string(tag[2]))
+		if len(tag[1]) > 0 {
+			// Close the element, and any left unclosed within it.
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i] == name {
+					stack = stack[:i]
+					break
+				}
+			}
+			continue
+		}
+		pairs[stack[len(stack)-1]+">"+name] = true
+		if !voidElements[name] {
+			stack = append(stack, name)
+		}
+	}
+
+	structure := make([]string, 0, len(pairs))
+	for pair := range pairs {
+		structure = append(structure, pair)
+	}
+	sort.Strings(structure)
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(structure, " "))))[:8]
+}
+
+var ldJSONRegex = regexp.MustCompile("(?is)<script[^>]+type=[\"']?application/ld\\+json[\"']?[^>]*>(.*?)</script>")
+var itemTypeRegex = regexp.MustCompile("(?is)<[^>]+\\sitemtype=[\"']?([^\"'>]+)")
+var typeOfRegex = regexp.MustCompile("(?is)<[^>]+\\stypeof=[\"']?([^\"'>]+)")
+
+// parseSchemas returns the sorted schema.org types of the structured data of
+// the page, given as JSON-LD, microdata itemtypes or RDFa typeofs.
+func parseSchemas(body []byte) []string {
+	var types []string
+	for _, script := range ldJSONRegex.FindAllSubmatch(body, -1) {
+		var data interface{}
+		if err := json.Unmarshal(script[1], &data); err != nil {
+			logger.Debug("Failed to parse JSON-LD", "error", err)
+			continue
+		}
+		types = append(types, jsonLDTypes(data)...)
+	}
+	for _, regex := range []*regexp.Regexp{itemTypeRegex, typeOfRegex} {
+		for _, attr := range regex.FindAllSubmatch(body, -1) {
+			types = append(types, strings.Fields(string(attr[1]))...)
+		}
+	}
+
+	unique := make(map[string]bool)
+	for _, t := range types {
+		// Reduce URLs and CURIEs, such as https://schema.org/Article and
+		// schema:Article, to the type name.
+		if slash := strings.LastIndexAny(t, "/:#"); slash >= 0 {
+			t = t[slash+1:]
+		}
+		if t != "" {
+			unique[t] = true
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+	schemas := make([]string, 0, len(unique))
+	for t := range unique {
+		schemas = append(schemas, t)
+	}
+	sort.Strings(schemas)
+	return schemas
+}
+
+// jsonLDTypes returns the @type values of all of the nodes within the decoded
+// JSON-LD value.
+func jsonLDTypes(data interface{}) (types []string) {
+	switch value := data.(type) {
+	case []interface{}:
+		for _, item := range value {
+			types = append(types, jsonLDTypes(item)...)
+		}
+	case map[string]interface{}:
+		for key, item := range value {
+			if key != "@type" {
+				types = append(types, jsonLDTypes(item)...)
+				continue
+			}
+			switch t := item.(type) {
+			case string:
+				types = append(types, t)
+			case []interface{}:
+				for _, t := range t {
+					if s, ok := t.(string); ok {
+						types = append(types, s)
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+var metaTagRegex = regexp.MustCompile("(?is)<meta\\s[^>]*>")
+
+// parseSocial returns the Open Graph (og:*) and Twitter card (twitter:*)
+// properties of the page's <meta> tags, keeping the first of any repeated.
+func parseSocial(body []byte) map[string]string {
+	social := make(map[string]string)
+	for _, tag := range metaTagRegex.FindAll(body, -1) {
+		attrs := parseAttrs(tag)
+		// Open Graph uses property attributes, and Twitter name attributes,
+		// but each is often found in the other.
+		property := strings.ToLower(attrs["property"])
+		if property == "" {
+			property = strings.ToLower(attrs["name"])
+		}
+		if !strings.HasPrefix(property, "og:") && !strings.HasPrefix(property, "twitter:") {
+			continue
+		}
+		if _, found := social[property]; !found {
+			social[property] = html.UnescapeString(attrs["content"])
+		}
+	}
+	if len(social) == 0 {
+		return nil
+	}
+	return social
+}
+
+var startTagRegex = regexp.MustCompile("(?is)<([a-z][a-z0-9-]*)\\s[^>]*>")
+
+// parseFragments returns the sorted, unique targets which links to the page
+// can scroll to: the ids of its elements and the names of its <a> tags.
+func parseFragments(body []byte) (fragments []string) {
+	seen := make(map[string]bool)
+	for _, tag := range startTagRegex.FindAllSubmatch(body, -1) {
+		attrs := parseAttrs(tag[0])
+		targets := []string{attrs["id"]}
+		if strings.EqualFold(string(tag[1]), "a") {
+			targets = append(targets, attrs["name"])
+		}
+		for _, target := range targets {
+			target = html.UnescapeString(target)
+			if target != "" && !seen[target] {
+				seen[target] = true
+				fragments = append(fragments, target)
+			}
+		}
+	}
+	sort.Strings(fragments)
+	return
+}
+
+var anchorElementRegex = regexp.MustCompile("(?is)(<a\\s[^>]*>)(.*?)</a\\s*>")
+
+// checkAccessibility counts the images of the page without alt attributes,
+// and the links without text or a label, and checks that the <html> element
+// declares the language of the page.
+func checkAccessibility(body []byte, images []*Image) *Accessibility {
+	a := &Accessibility{}
+	for _, image := range images {
+		if !image.HasAlt {
+			a.MissingAlt++
+		}
+	}
+
+	for _, anchor := range anchorElementRegex.FindAllSubmatch(body, -1) {
+		attrs := parseAttrs(anchor[1])
+		if _, isLink := attrs["href"]; !isLink {
+			continue
+		}
+		if strings.TrimSpace(attrs["aria-label"]+attrs["aria-labelledby"]+attrs["title"]) != "" {
+			continue
+		}
+		if extractText(anchor[2]) != "" {
+			continue
+		}
+		labelled := false
+		for _, img := range imgTagRegex.FindAll(anchor[2], -1) {
+			if strings.TrimSpace(parseAttrs(img)["alt"]) != "" {
+				labelled = true
+			}
+		}
+		if !labelled {
+			a.EmptyLinks++
+		}
+	}
+
+	tag := htmlTagRegex.Find(body)
+	a.MissingLang = tag == nil || strings.TrimSpace(parseAttrs(tag)["lang"]) == ""
+	return a
+}
@@ -0,0 +1,875 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRegexPageParserFrames(t *testing.T) {
+	base, _ := url.Parse("http://example.com/dir/")
+	body := []byte(`<frameset rows="50%,50%">
+		<frame src="top.html">
+		<FRAME name="bottom" src='/bottom.html'>
+	</frameset>
+	<iframe width="100" src="http://other.com/embed"></iframe>`)
+
+	frames := (&RegexPageParser{}).parseFrames(base, body, 3)
+
+	expected := []struct {
+		typ string
+		url string
+	}{
+		{"frame", "http://example.com/dir/top.html"},
+		{"frame", "http://example.com/bottom.html"},
+		{"iframe", "http://other.com/embed"},
+	}
+	if len(frames) != len(expected) {
+		t.Fatalf("Expected %d frames but found %d", len(expected), len(frames))
+	}
+	for i, frame := range frames {
+		if frame.Type != expected[i].typ || frame.URL.String() != expected[i].url {
+			t.Errorf("Expected %s %s but got %s %s", expected[i].typ, expected[i].url, frame.Type, frame.URL)
+		}
+		if frame.Depth != 3 {
+			t.Errorf("Expected frame %s to share the depth of its page, but got %d", frame.URL, frame.Depth)
+		}
+		if !frame.IsFrame() {
+			t.Errorf("Expected %s to be a frame", frame.URL)
+		}
+	}
+}
+
+func TestRegexPageParserAssets(t *testing.T) {
+	base, _ := url.Parse("http://example.com/")
+	body := []byte(`<link rel="stylesheet" href="/style.css">
+	<link rel="preload" as="font" href="/font.woff2">
+	<script src="/app.js"></script>
+	<img data-src="/lazy.png" src="/logo.png">
+	<video src="/intro.mp4"><track kind="captions" src="/intro.vtt"></video>`)
+
+	expected := []string{"script /app.js", "img /logo.png", "video /intro.mp4"}
+	assets := (&RegexPageParser{}).parseAssets(base, body, 1)
+	if len(assets) != len(expected) {
+		t.Fatalf("Expected %d default assets but found %d: %v", len(expected), len(assets), assets)
+	}
+	for i, asset := range assets {
+		if actual := asset.Type + " " + asset.URL.Path; actual != expected[i] {
+			t.Errorf("Expected asset %s but got %s", expected[i], actual)
+		}
+	}
+
+	rules := append([]AssetRule{}, DefaultAssetRules...)
+	for _, rule := range []string{"link:href:preload", "TRACK:src"} {
+		assetRule, err := ParseAssetRule(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, assetRule)
+	}
+	expected = []string{"preload /font.woff2", "script /app.js", "img /logo.png", "video /intro.mp4", "track /intro.vtt"}
+	assets = (&RegexPageParser{AssetRules: rules}).parseAssets(base, body, 1)
+	if len(assets) != len(expected) {
+		t.Fatalf("Expected %d configured assets but found %d: %v", len(expected), len(assets), assets)
+	}
+	for i, asset := range assets {
+		if actual := asset.Type + " " + asset.URL.Path; actual != expected[i] {
+			t.Errorf("Expected asset %s but got %s", expected[i], actual)
+		}
+	}
+
+	for _, rule := range []string{"link", "link:", ":href", "link:href:preload:extra"} {
+		if _, err := ParseAssetRule(rule); err == nil {
+			t.Errorf("Expected an error parsing asset rule %q", rule)
+		}
+	}
+}
+
+func TestRegexPageParserLinkRegions(t *testing.T) {
+	base, _ := url.Parse("http://example.com/")
+	body := []byte(`<header><a href="/">Home</a>
+		<nav class="main"><a href="/about">About</a></nav>
+	</header>
+	<main><a href="/post">Post</a><aside><a href="/related">Related</a></aside></main>
+	<footer><a href="/terms">Terms</a></footer>`)
+
+	expected := map[string]string{
+		"/":        "header",
+		"/about":   "nav",
+		"/post":    "content",
+		"/related": "aside",
+		"/terms":   "footer",
+	}
+	links := (&RegexPageParser{}).parseLinks(base, body, 1)
+	if len(links) != len(expected) {
+		t.Fatalf("Expected %d links but found %d", len(expected), len(links))
+	}
+	for _, link := range links {
+		if region := expected[link.URL.Path]; link.Region != region {
+			t.Errorf("Expected %s in region %s but got %s", link.URL.Path, region, link.Region)
+		}
+	}
+}
+
+func TestRegexPageParserEndpoints(t *testing.T) {
+	base, _ := url.Parse("http://example.com/")
+	body := []byte(`<script type="application/ld+json">{"url": "http://example.com/about", "logo": {"src": "/logo.png"}}</script>
+	<script type="application/json">["\/api\/items", "not a url", "http://other.com/elsewhere"]</script>
+	<div class="more" data-href="/load-more?page=2">More</div>
+	<button data-url='/api/like'>Like</button>`)
+
+	endpoints := (&RegexPageParser{}).parseEndpoints(base, body, 1)
+
+	expected := []string{
+		"http://example.com/logo.png",
+		"http://example.com/about",
+		"http://example.com/api/items",
+		"http://example.com/load-more?page=2",
+		"http://example.com/api/like",
+	}
+	if len(endpoints) != len(expected) {
+		t.Fatalf("Expected %d endpoints but found %d: %v", len(expected), len(endpoints), endpoints)
+	}
+	for i, endpoint := range endpoints {
+		if endpoint.Type != "endpoint" || endpoint.URL.String() != expected[i] {
+			t.Errorf("Expected endpoint %s but got %s %s", expected[i], endpoint.Type, endpoint.URL)
+		}
+	}
+}
+
+func TestLooksLikePage(t *testing.T) {
+	results := map[string]bool{
+		"/":              true,
+		"/about":         true,
+		"/index.html":    true,
+		"/search.php":    true,
+		"/api/items":     false,
+		"/data.json":     false,
+		"/logo.png":      false,
+		"/static/app.js": false,
+	}
+	for path, ok := range results {
+		if looksLikePage(&url.URL{Path: path}) != ok {
+			t.Errorf("looksLikePage(%q) should be %v", path, ok)
+		}
+	}
+}
+
+func TestReadRobotsTag(t *testing.T) {
+	results := []struct {
+		values   []string
+		noIndex  bool
+		noFollow bool
+	}{
+		{nil, false, false},
+		{[]string{"all"}, false, false},
+		{[]string{"noindex"}, true, false},
+		{[]string{"NoFollow"}, false, true},
+		{[]string{"noindex, nofollow"}, true, true},
+		{[]string{"none"}, true, true},
+		{[]string{"noindex", "nofollow"}, true, true},
+		{[]string{"googlebot: nofollow"}, false, false},
+	}
+	for _, test := range results {
+		noIndex, noFollow := readRobotsTag(test.values)
+		if noIndex != test.noIndex || noFollow != test.noFollow {
+			t.Errorf("readRobotsTag(%q) should be (%v, %v) but got (%v, %v)", test.values, test.noIndex, test.noFollow, noIndex, noFollow)
+		}
+	}
+}
+
+func TestRegexPageParserAlternates(t *testing.T) {
+	base, _ := url.Parse("http://example.com/en/")
+	body := []byte(`<link rel="stylesheet" href="/style.css">
+	<link hreflang="fr" href="/fr/" rel="alternate">
+	<LINK REL='alternate' HREFLANG='x-default' HREF='http://example.com/'>
+	<link rel="alternate" type="application/rss+xml" href="/feed">
+	<link rel="amphtml" href="/en/amp/">
+	<link rel="alternate" media="only screen and (max-width: 640px)" href="http://m.example.com/en/">`)
+
+	alternates := (&RegexPageParser{}).parseAlternates(base, body)
+	expected := []struct {
+		typ      string
+		hreflang string
+		url      string
+	}{
+		{"hreflang", "fr", "http://example.com/fr/"},
+		{"hreflang", "x-default", "http://example.com/"},
+		{"amphtml", "", "http://example.com/en/amp/"},
+		{"mobile", "", "http://m.example.com/en/"},
+	}
+	if len(alternates) != len(expected) {
+		t.Fatalf("Expected %d alternates but found %d", len(expected), len(alternates))
+	}
+	for i, alternate := range alternates {
+		if alternate.Type() != expected[i].typ || alternate.HrefLang != expected[i].hreflang || alternate.URL.String() != expected[i].url {
+			t.Errorf("Expected alternate %s %s %s but got %s %s %s", expected[i].typ, expected[i].hreflang, expected[i].url, alternate.Type(), alternate.HrefLang, alternate.URL)
+		}
+	}
+}
+
+func TestRegexPageParserForms(t *testing.T) {
+	base, _ := url.Parse("http://example.com/dir/page")
+	body := []byte(`<form action="/search">
+		<input type="text" name="q"><input type="submit" value="Go">
+	</form>
+	<FORM method="post" action='login'>
+		<input name="user"> <input type="password" name="pass">
+		<select name="remember"><option>yes</option></select>
+	</FORM>
+	<form><textarea name="comment"></textarea></form>`)
+
+	forms := (&RegexPageParser{}).parseForms(base, body)
+
+	expected := []Form{
+		{nil, "GET", []string{"q"}},
+		{nil, "POST", []string{"user", "pass", "remember"}},
+		{nil, "GET", []string{"comment"}},
+	}
+	actions := []string{"http://example.com/search", "http://example.com/dir/login", "http://example.com/dir/page"}
+	if len(forms) != len(expected) {
+		t.Fatalf("Expected %d forms but found %d", len(expected), len(forms))
+	}
+	for i, form := range forms {
+		if form.Action.String() != actions[i] || form.Method != expected[i].Method || !reflect.DeepEqual(form.Inputs, expected[i].Inputs) {
+			t.Errorf("Expected %s %s %v but got %s %s %v", expected[i].Method, actions[i], expected[i].Inputs, form.Method, form.Action, form.Inputs)
+		}
+	}
+}
+
+func TestRegexPageParserContacts(t *testing.T) {
+	base, _ := url.Parse("http://example.com/")
+	body := []byte(`<a href="/about">About</a>
+	<a href="mailto:Hello@Example.com?subject=Hi">Email</a>
+	<a href='tel:+44%2020%207946%200000'>Call</a>`)
+
+	parser := &RegexPageParser{}
+	if links := parser.parseLinks(base, body, 1); len(links) != 1 || links[0].URL.String() != "http://example.com/about" {
+		t.Errorf("Expected only the /about link to be followable but got %v", links)
+	}
+
+	contacts := parser.parseContacts(base, body)
+	expected := []string{"mailto: hello@example.com", "tel: +44 20 7946 0000"}
+	if len(contacts) != len(expected) {
+		t.Fatalf("Expected %d contacts but found %d", len(expected), len(contacts))
+	}
+	for i, link := range contacts {
+		if contact := link.Type + ": " + link.Contact(); contact != expected[i] {
+			t.Errorf("Expected contact %q but got %q", expected[i], contact)
+		}
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	body := []byte(`<html><head><title>Title</title><style>p { color: red; }</style></head>
+	<body>
+		<!-- A comment -->
+		<h1>Hello,   world</h1>
+		<script>var hidden = "<p>not text</p>";</script>
+		<p>Fish &amp; chips<br>for   two.</p>
+	</body></html>`)
+
+	if text := extractText(body); text != "Hello, world Fish & chips for two." {
+		t.Errorf("Expected only the visible text but got %q", text)
+	}
+}
+
+func TestRegexPageParserCollect(t *testing.T) {
+	base, _ := url.Parse("http://example.com/")
+	body := []byte(`<head><link rel="icon" href="/icon.png"><meta property="og:title" content="Home">
+	<script type="application/ld+json">{"@type": "Organization"}</script></head>
+	<body><img src="/logo.png"> <a href="mailto:hello@example.com">Email</a>
+	<form action="/search"><input name="q"></form></body>`)
+	req, _ := http.NewRequest("GET", base.String(), nil)
+	resp := &http.Response{Request: req, Header: http.Header{}}
+
+	page := (&RegexPageParser{}).parseHTML(&Task{URL: base}, resp, body)
+	if page.Images != nil || page.Forms != nil || page.Contacts != nil || page.Schemas != nil || page.Social != nil || page.Icons != nil {
+		t.Errorf("Expected no details to be collected without asking, but got %+v", page)
+	}
+
+	parser := &RegexPageParser{CollectImages: true, CollectForms: true, CollectContacts: true, CollectSchemas: true, CollectSocial: true, CollectIcons: true}
+	page = parser.parseHTML(&Task{URL: base}, resp, body)
+	if len(page.Images) != 1 || len(page.Forms) != 1 || len(page.Contacts) != 1 || len(page.Schemas) != 1 || len(page.Social) != 1 || len(page.Icons) != 1 {
+		t.Errorf("Expected each detail to be collected, but got %+v", page)
+	}
+
+	// Following forms and checking accessibility need the forms and images.
+	page = (&RegexPageParser{FollowForms: true, CheckAccessibility: true}).parseHTML(&Task{URL: base}, resp, body)
+	if len(page.Forms) != 1 || len(page.Images) != 1 {
+		t.Errorf("Expected the forms and images to be collected, but got %+v", page)
+	}
+}
+
+func TestRegexPageParserPagination(t *testing.T) {
+	base, _ := url.Parse("http://example.com/blog/page/2")
+	body := []byte(`<head><link rel="prev" href="/blog/page/1"><link rel="next" href="/blog/page/3"></head>
+	<body><a href="/blog/page/1" rel="prev">Newer</a> <a href="/about">About</a></body>`)
+
+	req, _ := http.NewRequest("GET", base.String(), nil)
+	resp := &http.Response{Request: req, Header: http.Header{}}
+	page := (&RegexPageParser{}).parseHTML(&Task{URL: base, Depth: 2, Pagination: 4}, resp, body)
+
+	if page.Next == nil || page.Next.String() != "http://example.com/blog/page/3" {
+		t.Errorf("Expected next page /blog/page/3 but got %v", page.Next)
+	}
+	if page.Prev == nil || page.Prev.String() != "http://example.com/blog/page/1" {
+		t.Errorf("Expected previous page /blog/page/1 but got %v", page.Prev)
+	}
+
+	pagination := map[string]uint16{}
+	for _, link := range page.Links {
+		pagination[link.Type+" "+link.URL.Path] = link.Pagination
+	}
+	expected := map[string]uint16{"anchor /blog/page/1": 5, "anchor /about": 0, "next /blog/page/3": 5}
+	if !reflect.DeepEqual(pagination, expected) {
+		t.Errorf("Expected links %v but got %v", expected, pagination)
+	}
+}
+
+func TestFeedLinks(t *testing.T) {
+	rss := []byte(`<?xml version="1.0"?>
+	<rss version="2.0"><channel>
+		<title>Blog</title><link>http://example.com/</link>
+		<item><title>One</title><link>http://example.com/one</link></item>
+		<item><title>Two</title><link><![CDATA[/two]]></link></item>
+	</channel></rss>`)
+	atom := []byte(`<?xml version="1.0" encoding="utf-8"?>
+	<feed xmlns="http://www.w3.org/2005/Atom">
+		<link href="http://example.com/feed" rel="self"/>
+		<entry><link href="http://example.com/three"/></entry>
+		<entry><link rel="alternate" href="/four"/><link rel="edit" href="/four/edit"/></entry>
+	</feed>`)
+
+	results := []struct {
+		body  []byte
+		hrefs []string
+	}{
+		{rss, []string{"http://example.com/", "http://example.com/one", "/two"}},
+		{atom, []string{"http://example.com/three", "/four"}},
+	}
+	for _, test := range results {
+		if !isFeed(test.body) {
+			t.Errorf("Expected %s to be recognised as a feed", test.body)
+		}
+		hrefs := feedLinks(test.body)
+		if !reflect.DeepEqual(hrefs, test.hrefs) {
+			t.Errorf("Expected feed links %q but got %q", test.hrefs, hrefs)
+		}
+	}
+
+	if isFeed([]byte(`<?xml version="1.0"?><sitemap></sitemap>`)) {
+		t.Error("Expected a sitemap not to be recognised as a feed")
+	}
+}
+
+func TestTextLinks(t *testing.T) {
+	text := []byte("See https://example.com/docs, or (http://example.com/wiki/Go_(language)).\nMail help@example.com.")
+	if hrefs, expected := textURLs(text), []string{"https://example.com/docs", "http://example.com/wiki/Go_(language)"}; !reflect.DeepEqual(hrefs, expected) {
+		t.Errorf("Expected text links %q but got %q", expected, hrefs)
+	}
+
+	markdown := []byte("# Project\n\n" +
+		"Read the [guide](docs/guide.md \"Guide\") and the [FAQ][faq].\n" +
+		"![Logo](/logo.png)\n\n" +
+		"[faq]: https://example.com/faq\n\n" +
+		"Or visit https://example.com/.\n\n" +
+		"```\ncurl http://localhost:8080/\n```\n" +
+		"Run `wget http://localhost/`.\n")
+	hrefs, srcs := markdownLinks(markdown)
+	if expected := []string{"docs/guide.md", "https://example.com/faq", "https://example.com/"}; !reflect.DeepEqual(hrefs, expected) {
+		t.Errorf("Expected Markdown links %q but got %q", expected, hrefs)
+	}
+	if expected := []string{"/logo.png"}; !reflect.DeepEqual(srcs, expected) {
+		t.Errorf("Expected Markdown images %q but got %q", expected, srcs)
+	}
+
+	parser := &RegexPageParser{}
+	if !parser.Parses("text/markdown; charset=utf-8") || !parser.Parses("text/plain") {
+		t.Error("Expected plain text and Markdown to be parsed")
+	}
+	u, _ := url.Parse("http://example.com/project/README.md")
+	page := parser.Parse(&Task{URL: u}, &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"text/plain"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(markdown)),
+		Request:    &http.Request{URL: u},
+	})
+	if !page.Processed || len(page.Links) != 3 || page.Links[0].URL.String() != "http://example.com/project/docs/guide.md" || len(page.Assets) != 1 {
+		t.Errorf("Expected the README to have 3 links and an image but got %v and %v", page.Links, page.Assets)
+	}
+}
+
+func TestDocumentLinks(t *testing.T) {
+	var compressed bytes.Buffer
+	z := zlib.NewWriter(&compressed)
+	z.Write([]byte(`<< /Type /Annot /A << /S /URI /URI (http://example.com/compressed) >> >>`))
+	z.Close()
+	pdf := []byte("%PDF-1.7\n" +
+		"1 0 obj << /A << /S /URI /URI (http://example.com/a\\(1\\)) >> >> endobj\n" +
+		"2 0 obj << /A << /URI <687474703A2F2F6578616D706C652E636F6D2F686578> >> >> endobj\n" +
+		"3 0 obj << /Filter /FlateDecode >> stream\n" + compressed.String() + "\nendstream endobj\n" +
+		"4 0 obj << /A << /S /URI /URI (http://example.com/a\\(1\\)) >> >> endobj\n")
+	if hrefs, expected := pdfLinks(pdf), []string{"http://example.com/a(1)", "http://example.com/hex", "http://example.com/compressed"}; !reflect.DeepEqual(hrefs, expected) {
+		t.Errorf("Expected PDF links %q but got %q", expected, hrefs)
+	}
+
+	var docx bytes.Buffer
+	archive := zip.NewWriter(&docx)
+	rels, _ := archive.Create("word/_rels/document.xml.rels")
+	rels.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+	<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+		<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+		<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink" Target="http://example.com/docx" TargetMode="External"/>
+	</Relationships>`))
+	archive.Close()
+	if hrefs, expected := officeLinks(docx.Bytes()), []string{"http://example.com/docx"}; !reflect.DeepEqual(hrefs, expected) {
+		t.Errorf("Expected Office links %q but got %q", expected, hrefs)
+	}
+
+	parser := &RegexPageParser{FollowDocuments: true}
+	if !parser.Parses("application/pdf") || (&RegexPageParser{}).Parses("application/pdf") {
+		t.Error("Expected PDFs to be parsed only when following documents")
+	}
+	u, _ := url.Parse("http://example.com/brochure.pdf")
+	page := parser.Parse(&Task{URL: u}, &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"application/pdf"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(pdf)),
+		Request:    &http.Request{URL: u},
+	})
+	if !page.Processed || len(page.Links) != 3 || page.Links[0].Type != "document" {
+		t.Errorf("Expected the PDF to have 3 document links but got %v", page.Links)
+	}
+}
+
+func TestParseSitemap(t *testing.T) {
+	index, locs, err := parseSitemap([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://example.com/</loc></url>
+  <url><loc> http://example.com/about </loc><lastmod>2020-01-01</lastmod></url>
+</urlset>`))
+	if err != nil {
+		t.Fatalf("parseSitemap should not return error: %s", err)
+	}
+	if index {
+		t.Error("Expected a urlset not to be a sitemap index")
+	}
+	if expected := []string{"http://example.com/", "http://example.com/about"}; !reflect.DeepEqual(locs, expected) {
+		t.Errorf("Expected sitemap locs %v but got %v", expected, locs)
+	}
+
+	index, locs, err = parseSitemap([]byte(`<sitemapindex><sitemap><loc>http://example.com/a.xml</loc></sitemap></sitemapindex>`))
+	if err != nil || !index || len(locs) != 1 {
+		t.Errorf("Expected a sitemap index of 1 sitemap but got %v, %v, %v", index, locs, err)
+	}
+
+	if _, _, err := parseSitemap([]byte(`<html><body>Not found</body></html>`)); err == nil {
+		t.Error("Expected an HTML page not to parse as a sitemap")
+	}
+}
+
+func TestReadSitemapURLs(t *testing.T) {
+	robots := []byte("User-agent: *\nDisallow: /admin\nSitemap: http://example.com/sitemap.xml\nsitemap: http://example.com/news.xml\n")
+	expected := []string{"http://example.com/sitemap.xml", "http://example.com/news.xml"}
+	if hrefs := readSitemapURLs(robots); !reflect.DeepEqual(hrefs, expected) {
+		t.Errorf("Expected sitemaps %v but got %v", expected, hrefs)
+	}
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	robots := ParseRobots([]byte(`# Example
+User-agent: *
+Disallow: /private/
+Allow: /private/public
+Disallow: /*.pdf$
+
+User-agent: Gergle
+User-agent: other
+Disallow: /
+Allow: /docs # Trailing comment
+
+Sitemap: http://example.com/sitemap.xml
+
+User-agent: gergle
+Disallow:
+Allow: /page
+Disallow: /page
+`))
+	for _, test := range []struct {
+		agent   string
+		path    string
+		allowed bool
+		rule    string
+	}{
+		{"Mozilla/5.0", "/", true, ""},
+		{"Mozilla/5.0", "/private/secret", false, "Disallow: /private/"},
+		{"Mozilla/5.0", "/private/public/page", true, "Allow: /private/public"},
+		{"Mozilla/5.0", "/file.pdf", false, "Disallow: /*.pdf$"},
+		{"Mozilla/5.0", "/file.pdf?download=1", true, ""},
+		{"gergle/1.0", "/private/secret", false, "Disallow: /"},
+		{"gergle/1.0", "/docs/index.html", true, "Allow: /docs"},
+		{"gergle/1.0", "/page", true, "Allow: /page"},
+		{"gergle/1.0", "/robots.txt", true, ""},
+	} {
+		allowed, rule := robots.Allowed(test.agent, test.path)
+		ruleString := ""
+		if rule != nil {
+			ruleString = rule.String()
+		}
+		if allowed != test.allowed || ruleString != test.rule {
+			t.Errorf("Expected %s to be allowed for %s: %t (%q), but got %t (%q)", test.path, test.agent, test.allowed, test.rule, allowed, ruleString)
+		}
+	}
+}
+
+func TestXHTMLPages(t *testing.T) {
+	xhtml := `<?xml version="1.0" encoding="utf-8"?>
+	<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">
+	<h:html xmlns:h="http://www.w3.org/1999/xhtml" xml:lang="fr"><h:body>
+		<h:a href="/one">One</h:a>
+		<h:img src="/logo.png" alt="" />
+		<svg:a xmlns:svg="http://www.w3.org/2000/svg" href="/svg">SVG</svg:a>
+	</h:body></h:html>`
+
+	u, _ := url.Parse("http://example.com/")
+	for _, contentType := range []string{"application/xhtml+xml", "application/xml", "text/xml; charset=utf-8"} {
+		page := (&RegexPageParser{}).Parse(&Task{URL: u}, &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Content-Type": {contentType}},
+			Body:       ioutil.NopCloser(strings.NewReader(xhtml)),
+			Request:    &http.Request{URL: u},
+		})
+		if !page.Processed || len(page.Links) != 1 || page.Links[0].URL.Path != "/one" {
+			t.Errorf("Expected the %s page to link to /one but got %v", contentType, page.Links)
+		}
+		if len(page.Assets) != 1 || page.Assets[0].URL.Path != "/logo.png" || page.Language != "fr" {
+			t.Errorf("Expected the %s page to be in fr with the asset /logo.png but got %q and %v", contentType, page.Language, page.Assets)
+		}
+	}
+
+	if isXHTML([]byte(`<?xml version="1.0"?><urlset></urlset>`)) {
+		t.Error("Expected a sitemap not to be recognised as XHTML")
+	}
+}
+
+func TestParseLanguage(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"Content-Language": {"de"}}}
+	if lang := parseLanguage(resp, []byte(`<!DOCTYPE html><HTML LANG="en-GB"><body></body></html>`)); lang != "en-gb" {
+		t.Errorf("Expected the lang attribute en-gb but got %q", lang)
+	}
+	if lang := parseLanguage(resp, []byte(`<html><body></body></html>`)); lang != "de" {
+		t.Errorf("Expected the Content-Language de but got %q", lang)
+	}
+}
+
+func TestTemplateHash(t *testing.T) {
+	short := templateHash([]byte(`<html><body><h1>One</h1><ul><li><a href="/a">A</a></li></ul></body></html>`))
+	long := templateHash([]byte(`<html><body><h1 class="x">Two</h1><ul><li><a href="/b">B</a></li><li><a href="/c">C</a></li></ul></body></html>`))
+	other := templateHash([]byte(`<html><body><table><tr><td>Three</td></tr></table></body></html>`))
+
+	if short != long {
+		t.Errorf("Expected pages of the same template to share a hash but got %s and %s", short, long)
+	}
+	if short == other {
+		t.Errorf("Expected pages of different templates to have different hashes but both got %s", short)
+	}
+}
+
+func TestParseSchemas(t *testing.T) {
+	body := []byte(`<script type="application/ld+json">
+	{"@context": "https://schema.org", "@graph": [
+		{"@type": "Article", "author": {"@type": "Person", "name": "Ann"}},
+		{"@type": ["BreadcrumbList", "schema:ItemList"]}
+	]}
+	</script>
+	<div itemscope itemtype="https://schema.org/Product"><span itemprop="offers" itemscope itemtype="http://schema.org/Offer"></span></div>
+	<div vocab="https://schema.org/" typeof="Event">`)
+
+	expected := []string{"Article", "BreadcrumbList", "Event", "ItemList", "Offer", "Person", "Product"}
+	if schemas := parseSchemas(body); !reflect.DeepEqual(schemas, expected) {
+		t.Errorf("Expected schemas %v but got %v", expected, schemas)
+	}
+	if schemas := parseSchemas([]byte("<p>None</p>")); schemas != nil {
+		t.Errorf("Expected no schemas but got %v", schemas)
+	}
+}
+
+func TestParseSocial(t *testing.T) {
+	body := []byte(`<meta property="og:title" content="Fish &amp; Chips">
+	<meta property="og:image" content="http://example.com/1.png">
+	<meta property="og:image" content="http://example.com/2.png">
+	<meta name="twitter:card" content="summary">
+	<meta name="description" content="Not social">`)
+
+	expected := map[string]string{
+		"og:title":     "Fish & Chips",
+		"og:image":     "http://example.com/1.png",
+		"twitter:card": "summary",
+	}
+	if social := parseSocial(body); !reflect.DeepEqual(social, expected) {
+		t.Errorf("Expected social metadata %v but got %v", expected, social)
+	}
+}
+
+func TestCheckAccessibility(t *testing.T) {
+	body := []byte(`<html>
+	<a href="/home"><img src="/logo.png" alt="Home"></a>
+	<a href="/search" aria-label="Search"><svg></svg></a>
+	<a href="/empty"> <img src="/icon.png"> </a>
+	<a name="anchor"></a>
+	<a href="/text">Text</a>
+	<img src="/decoration.png" alt="">
+	</html>`)
+	base, _ := url.Parse("http://example.com/")
+
+	expected := &Accessibility{MissingAlt: 1, EmptyLinks: 1, MissingLang: true}
+	if a := checkAccessibility(body, (&RegexPageParser{}).parseImages(base, body)); !reflect.DeepEqual(a, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, a)
+	}
+	if a := checkAccessibility([]byte(`<html lang="en">`), nil); a.MissingLang {
+		t.Error("Expected the lang attribute to be found")
+	}
+}
+
+func TestAnchorLinkSchemes(t *testing.T) {
+	base, _ := url.Parse("https://example.com/a/")
+	tests := []struct {
+		href, Type, url string
+	}{
+		{"//cdn.example.com/x.js", "anchor", "https://cdn.example.com/x.js"},
+		{"b", "anchor", "https://example.com/a/b"},
+		{"javascript:void(0)", "javascript", "javascript:void(0)"},
+		{" JavaScript:alert('100%')", "javascript", "javascript:alert('100%')"},
+		{"data:text/plain;base64,SGk=", "data", "data:text/plain;base64,SGk="},
+		{"ftp://ftp.example.com/file.zip", "ftp", "ftp://ftp.example.com/file.zip"},
+		{"mailto:hello@example.com", "mailto", "mailto:hello@example.com"},
+	}
+	for _, test := range tests {
+		link, err := AnchorLink(test.href, base, 1)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %s", test.href, err)
+			continue
+		}
+		if link.Type != test.Type || link.URL.String() != test.url {
+			t.Errorf("Expected %q to be %s link to %s but got %s link to %s", test.href, test.Type, test.url, link.Type, link.URL)
+		}
+		if link.IsHTTP() != (test.Type == "anchor") {
+			t.Errorf("Expected IsHTTP of %q to be %v", test.href, test.Type == "anchor")
+		}
+	}
+
+	body := []byte(`<a href="/about">About</a> <a href="javascript:void(0)">Menu</a> <a href="ftp://ftp.example.com/">FTP</a>`)
+	parser := &RegexPageParser{}
+	if links := parser.parseLinks(base, body, 1); len(links) != 1 {
+		t.Errorf("Expected only the /about link to be followable but got %v", links)
+	}
+	if links := parser.parseNonHTTP(base, body); len(links) != 2 || links[0].Type != "javascript" || links[1].Type != "ftp" {
+		t.Errorf("Expected the javascript: and ftp: links but got %v", links)
+	}
+}
+
+func TestParseFragments(t *testing.T) {
+	body := []byte(`<h1 id="intro">Intro</h1>
+	<a name="legacy"></a><input name="q">
+	<section class="x" id='usage'><h2 id=usage>Usage</h2></section>
+	<div id="caf&eacute;"></div>`)
+
+	expected := []string{"café", "intro", "legacy", "usage"}
+	if fragments := parseFragments(body); !reflect.DeepEqual(fragments, expected) {
+		t.Errorf("Expected fragments %v but got %v", expected, fragments)
+	}
+}
+
+func TestParseBadHrefs(t *testing.T) {
+	body := []byte(`<a href="/fine%20page">Fine</a><a href=" /trimmed ">Trimmed</a>
+	<a href="/two words">Spaced</a><img src="/100%.png"><a href="/tab	here">Tab</a>
+	<a href="javascript:go('a b')">Script</a><link rel="stylesheet" href="/style.css?v=%zz">`)
+
+	expected := []string{"/two words", "/100%.png", "/tab\there", "/style.css?v=%zz"}
+	if hrefs := parseBadHrefs(body); !reflect.DeepEqual(hrefs, expected) {
+		t.Errorf("Expected bad hrefs %q but got %q", expected, hrefs)
+	}
+	problems := []string{"unencoded space", `invalid percent-encoding "%.p"`, "control character U+0009", `invalid percent-encoding "%zz"`}
+	for i, href := range expected {
+		if problem := hrefProblem(href); problem != problems[i] {
+			t.Errorf("Expected %q to have problem %q but got %q", href, problems[i], problem)
+		}
+	}
+}
+
+// parserTestPage has markup which the regex parser misreads.
+const parserTestPage = `<!DOCTYPE html>
+<html><head><script src="/app.js"></script><script>var s = "<a href='/fake'>";</script></head>
+<body>
+	<!-- <a href="/commented">Old</a> -->
+	<nav><ul><li><a href=/home>Home</a><li><a href="/about">About</a></ul></nav>
+	<p>See <a href="/search?a=1&amp;b=2">results</a><br>and <img src="/photo.png" alt="Photo">.
+	<template><a href="/template">Row</a></template>
+	<footer><a href="/contact">Contact</a></footer>
+</body></html>`
+
+func TestElementParsers(t *testing.T) {
+	base, _ := url.Parse("http://example.com/")
+	expected := map[string][]string{
+		"regex":     {"/fake", "/commented", "/home (nav)", "/about (nav)", "/search?a=1&amp;b=2", "/template", "/contact (footer)"},
+		"tokenizer": {"/home (nav)", "/about (nav)", "/search?a=1&b=2", "/template", "/contact (footer)"},
+		"dom":       {"/home (nav)", "/about (nav)", "/search?a=1&b=2", "/contact (footer)"},
+	}
+	for _, backend := range ParserBackends {
+		elements, err := NewElementParser(backend)
+		if err != nil {
+			t.Fatalf("NewElementParser(%q) should not return error: %s", backend, err)
+		}
+		parser := &RegexPageParser{Elements: elements}
+		resp := &http.Response{Request: &http.Request{URL: base}}
+		page := parser.parseHTML(&Task{URL: base}, resp, []byte(parserTestPage))
+
+		links := []string{}
+		for _, link := range page.Links {
+			href := link.URL.RequestURI()
+			if link.IsBoilerplate() {
+				href += " (" + link.Region + ")"
+			}
+			links = append(links, href)
+		}
+		if !reflect.DeepEqual(links, expected[backend]) {
+			t.Errorf("Expected the %s parser to find %v but got %v", backend, expected[backend], links)
+		}
+		if len(page.Assets) != 2 || page.Assets[0].URL.Path != "/app.js" || page.Assets[1].URL.Path != "/photo.png" {
+			t.Errorf("Expected the %s parser to find /app.js and /photo.png but got %v", backend, page.Assets)
+		}
+	}
+
+	if _, err := NewElementParser("soup"); err == nil {
+		t.Error("NewElementParser should return error for unknown backends")
+	}
+}
+
+func TestElementParsersDetails(t *testing.T) {
+	base, _ := url.Parse("http://example.com/")
+	body := []byte(`<html><head>
+	<link rel="alternate" hreflang="fr" href="/fr/"><link rel="alternate" type="application/rss+xml" href="/feed.xml">
+	<script type="application/ld+json">{"url": "/data.json"}</script></head>
+	<body><iframe src="/embed"></iframe> <div data-href="/panel"></div>
+	<img src="/logo.png"> <a href="mailto:hello@example.com">Email</a> <a href="javascript:void(0)">Menu</a>
+	<form action="/search"><input name="q"><button name="go">Go</button></form> <input name="outside">
+	</body></html>`)
+	req, _ := http.NewRequest("GET", base.String(), nil)
+	resp := &http.Response{Request: req, Header: http.Header{}}
+
+	for _, backend := range ParserBackends {
+		elements, _ := NewElementParser(backend)
+		parser := &RegexPageParser{Elements: elements, CollectImages: true, CollectForms: true, CollectContacts: true}
+		page := parser.parseHTML(&Task{URL: base, Depth: 1}, resp, body)
+
+		links := []string{}
+		for _, link := range page.Links {
+			links = append(links, fmt.Sprintf("%s %s %d", link.Type, link.URL.Path, link.Depth))
+		}
+		if expected := []string{"iframe /embed 1", "feed /feed.xml 2"}; !reflect.DeepEqual(links, expected) {
+			t.Errorf("Expected the %s parser to find links %v but got %v", backend, expected, links)
+		}
+		endpoints := []string{}
+		for _, endpoint := range page.Endpoints {
+			endpoints = append(endpoints, endpoint.URL.Path)
+		}
+		sort.Strings(endpoints)
+		if expected := []string{"/data.json", "/panel"}; !reflect.DeepEqual(endpoints, expected) {
+			t.Errorf("Expected the %s parser to find endpoints %v but got %v", backend, expected, endpoints)
+		}
+		if len(page.Alternates) != 1 || page.Alternates[0].HrefLang != "fr" {
+			t.Errorf("Expected the %s parser to find the fr alternate but got %v", backend, page.Alternates)
+		}
+		if len(page.Images) != 1 || page.Images[0].URL.Path != "/logo.png" {
+			t.Errorf("Expected the %s parser to find /logo.png but got %v", backend, page.Images)
+		}
+		if len(page.Forms) != 1 || page.Forms[0].Action.Path != "/search" || !reflect.DeepEqual(page.Forms[0].Inputs, []string{"q", "go"}) {
+			t.Errorf("Expected the %s parser to find the search form but got %v", backend, page.Forms)
+		}
+		if len(page.Contacts) != 1 || page.Contacts[0].Type != "mailto" || len(page.NonHTTP) != 2 {
+			t.Errorf("Expected the %s parser to find the contact and 2 non-HTTP links but got %v, %v", backend, page.Contacts, page.NonHTTP)
+		}
+	}
+}
+
+func TestRegexPageParserStream(t *testing.T) {
+	base, _ := url.Parse("http://example.com/")
+	resp := &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+		Body:       ioutil.NopCloser(strings.NewReader(parserTestPage)),
+		Request:    &http.Request{URL: base},
+	}
+	page := (&RegexPageParser{StreamOver: 64}).Parse(&Task{URL: base}, resp)
+
+	links := []string{}
+	for _, link := range page.Links {
+		href := link.URL.RequestURI()
+		if link.IsBoilerplate() {
+			href += " (" + link.Region + ")"
+		}
+		links = append(links, href)
+	}
+	expected := []string{"/home (nav)", "/about (nav)", "/search?a=1&b=2", "/template", "/contact (footer)"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("Expected the streamed page to link to %v but got %v", expected, links)
+	}
+	if len(page.Assets) != 2 {
+		t.Errorf("Expected the streamed page to have 2 assets but got %v", page.Assets)
+	}
+	if !page.Processed || page.Size != len(parserTestPage) || page.Checksum != fmt.Sprintf("%x", sha1.Sum([]byte(parserTestPage))) {
+		t.Errorf("Expected the streamed page to be processed with the size and checksum of its body, but got %t, %d, %s", page.Processed, page.Size, page.Checksum)
+	}
+}
+
+func TestStreamHTML(t *testing.T) {
+	body := parserTestPage + `<p title="a > b">Text</p><![CDATA[x]]><a href='/quoted>'>`
+	expected := []htmlToken{}
+	tokenizeHTML([]byte(body), func(token htmlToken) {
+		expected = append(expected, token)
+	})
+	tokens := []htmlToken{}
+	if err := streamHTML(strings.NewReader(body), func(token htmlToken) {
+		tokens = append(tokens, token)
+	}); err != nil {
+		t.Fatalf("streamHTML should not return error: %s", err)
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens but got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i := range tokens {
+		sameAttrs := tokens[i].End || reflect.DeepEqual(tokens[i].Attrs, expected[i].Attrs)
+		if tokens[i].Tag != expected[i].Tag || tokens[i].End != expected[i].End || !sameAttrs {
+			t.Errorf("Expected token %d to be %v but got %v", i, expected[i], tokens[i])
+		}
+	}
+}
+
+func BenchmarkParsers(b *testing.B) {
+	base, _ := url.Parse("http://example.com/")
+	body := []byte(strings.Repeat(parserTestPage, 50))
+	for _, backend := range ParserBackends {
+		elements, _ := NewElementParser(backend)
+		parser := &RegexPageParser{Elements: elements}
+		b.Run(backend, func(b *testing.B) {
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				if elements == nil {
+					parser.regexDetails(&Page{}, base, body)
+				} else {
+					parser.elementDetails(&Page{}, elements.Elements(body), base)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+)
+
+// ParsePool parses responses with its own fixed number of workers, so that
+// parsing scales independently of the number of connections. The body of each
+// response is read in full, releasing its connection, before it is queued for
+// a parser.
+type ParsePool struct {
+	Parser ResponsePageParser
+	// MaxBuffer, if set, has responses declaring a larger Content-Length
+	// parsed as they're read, rather than buffered for the pool.
+	MaxBuffer int64
+
+	jobs chan parseJob
+}
+
+type parseJob struct {
+	task *Task
+	resp *http.Response
+	page chan Page
+}
+
+// NewParsePool starts the workers of the pool, which has room for as many
+// responses to be queued as it has workers.
+func NewParsePool(parser ResponsePageParser, workers int) *ParsePool {
+	p := &ParsePool{Parser: parser, jobs: make(chan parseJob, workers)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range p.jobs {
+				job.page <- p.Parser.Parse(job.task, job.resp)
+			}
+		}()
+	}
+	return p
+}
+
+func (p *ParsePool) Parse(task *Task, resp *http.Response) Page {
+	if resp.StatusCode != http.StatusOK || !parses(p.Parser, resp.Header.Get("Content-Type")) {
+		// The parser won't read the body, so needn't wait for a worker.
+		return p.Parser.Parse(task, resp)
+	}
+	if p.MaxBuffer > 0 && resp.ContentLength > p.MaxBuffer {
+		return p.Parser.Parse(task, resp)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		logger.Warn("Failed to read body", "url", task.URL)
+		return ErrorPage(task.URL, task.Depth, err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	job := parseJob{task, resp, make(chan Page, 1)}
+	p.jobs <- job
+	return <-job.page
+}
+
+func (p *ParsePool) Parses(contentType string) bool {
+	return parses(p.Parser, contentType)
+}
+
+// Stop ends the workers of the pool once the queued responses are parsed.
+func (p *ParsePool) Stop() {
+	close(p.jobs)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Preflight is the result of requesting the crawl's first page a few times
+// before crawling, to catch misconfigurations before they waste a crawl.
+type Preflight struct {
+	URL        *url.URL
+	StatusCode int
+	// Final is the URL of the page after any redirects.
+	Final     *url.URL
+	Latencies []time.Duration
+	Warnings  []string
+}
+
+var (
+	preflightLinks   = regexp.MustCompile(`(?i)<a\s[^>]*href\s*=`)
+	preflightScripts = regexp.MustCompile(`(?i)<script[\s>]`)
+	preflightAppRoot = regexp.MustCompile(`(?i)<div[^>]+id=["']?(root|app|__next|__nuxt)["']?[^>]*>\s*</div>|<noscript>[^<]*enable javascript`)
+)
+
+// RunPreflight requests the page the number of times, returning an error if
+// it can't be fetched, or responds with an error, and warnings of anything
+// which would make a crawl with the options less useful than expected.
+func RunPreflight(client *http.Client, u *url.URL, opts CrawlOptions, requests int) (*Preflight, error) {
+	credentials := opts.BearerToken != "" || opts.OAuthTokenURL != "" || opts.ClientCert != "" ||
+		len(opts.Cookies) > 0 || opts.CookiesFile != ""
+
+	preflight := &Preflight{URL: u, Final: u}
+	var body []byte
+	for i := 0; i < requests; i++ {
+		start := time.Now()
+		resp, err := client.Get(u.String())
+		if err != nil {
+			if kind := classifyDNSError(err); kind != "" {
+				return nil, fmt.Errorf("Preflight: %s doesn't resolve (%s).", u.Hostname(), kind)
+			}
+			return nil, fmt.Errorf("Preflight: failed to fetch %s: %s", u, classifyError(err))
+		}
+		preflight.Latencies = append(preflight.Latencies, time.Since(start))
+		preflight.StatusCode, preflight.Final = resp.StatusCode, resp.Request.URL
+		if i == 0 {
+			body, _ = ioutil.ReadAll(io.LimitReader(resp.Body, 2<<20))
+		}
+		resp.Body.Close()
+	}
+
+	switch status := preflight.StatusCode; {
+	case (status == http.StatusUnauthorized || status == http.StatusForbidden) && credentials:
+		return nil, fmt.Errorf("Preflight: %s rejected the credentials with %d.", u, status)
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return nil, fmt.Errorf("Preflight: %s responded %d: authenticate with --bearer-token, --oauth-token-url, --cookie or --client-cert.", u, status)
+	case status == http.StatusTooManyRequests:
+		preflight.warn("%s is rate-limiting requests: slow down with --delay or --adaptive", u)
+	case status >= 400:
+		return nil, fmt.Errorf("Preflight: %s responded %d.", u, status)
+	}
+
+	if preflight.Final.Host != u.Host {
+		preflight.warn("%s redirects to %s, whose links are external to the crawl: crawl %s instead, or --allow-host %s", u, preflight.Final, preflight.Final, preflight.Final.Hostname())
+	}
+	if latency := preflight.Latency(); latency > 2*time.Second {
+		preflight.warn("%s is slow to respond (%s): use --adaptive to back off when it struggles", u, latency.Round(time.Millisecond))
+	}
+	if links := len(preflightLinks.FindAll(body, -1)); links < 2 && (preflightAppRoot.Match(body) || len(preflightScripts.FindAll(body, -1)) > 0) {
+		preflight.warn("%s looks rendered by JavaScript (%d links in its HTML): only links in the HTML served are crawled", u, links)
+	}
+
+	if !opts.ZeroBothers {
+		var robots []byte
+		var err error
+		if opts.RobotsFile != "" {
+			robots, err = ioutil.ReadFile(opts.RobotsFile)
+		} else {
+			robots, err = fetchRobots(client, u)
+		}
+		if err == nil {
+			disallow := NewRobotsDisallowFollower(readDisallowRules(robots)...)
+			if !disallow.Follow(&Link{URL: preflight.Final}).Allowed {
+				preflight.warn("robots.txt disallows %s: its links won't be followed without --zero", preflight.Final.Path)
+			}
+		}
+	}
+	return preflight, nil
+}
+
+func (p *Preflight) warn(format string, args ...interface{}) {
+	p.Warnings = append(p.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Latency returns the median time taken to respond.
+func (p *Preflight) Latency() time.Duration {
+	latencies := append([]time.Duration{}, p.Latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[len(latencies)/2]
+}
+
+func (p *Preflight) Print(w io.Writer) {
+	fmt.Fprintf(w, "Preflight: %s responded %d in %s (median of %d requests)\n", p.URL, p.StatusCode, p.Latency().Round(time.Millisecond), len(p.Latencies))
+	for _, warning := range p.Warnings {
+		fmt.Fprintf(w, "- %s\n", warning)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"strings"
+)
+
+// A Preset bundles the flags of a common kind of crawl, as NAME=VALUE pairs.
+type Preset struct {
+	Name  string
+	Flags []string
+}
+
+var Presets = []Preset{
+	{"seo-audit", []string{
+		"hreflang=true",
+		"mobile=true",
+		"orphans=true",
+		"soft-404=true",
+		"pagination=true",
+		"structured-data=true",
+		"social=true",
+		"images=true",
+		"link-structure=true",
+		"click-depth=4",
+		"redirect-chains=3",
+		"case-duplicates=true",
+		"trailing-slashes=true",
+		"stats=true",
+	}},
+	{"link-check", []string{
+		"check-fragments=true",
+		"check-hrefs=true",
+		"redirect-chains=5",
+		"mixed-content=true",
+		"dns-errors=true",
+		"tls=true",
+	}},
+	{"mirror", []string{
+		"warc=crawl.warc.gz",
+		"output=pages.jsonl.gz",
+		"manifest=manifest.json",
+		"follow-documents=true",
+		"follow-mobile=true",
+		"parser=tokenizer",
+	}},
+}
+
+// applyPreset sets the flags of the named preset, leaving those given
+// explicitly as they are.
+func applyPreset(flags *pflag.FlagSet, name string) error {
+	names := make([]string, len(Presets))
+	for i, preset := range Presets {
+		names[i] = preset.Name
+		if preset.Name != name {
+			continue
+		}
+
+		applied := []string{}
+		for _, flag := range preset.Flags {
+			eq := strings.Index(flag, "=")
+			if flags.Changed(flag[:eq]) {
+				continue
+			}
+			if err := flags.Set(flag[:eq], flag[eq+1:]); err != nil {
+				return fmt.Errorf("Invalid --%s of --preset %s: %s", flag[:eq], name, err)
+			}
+			applied = append(applied, flag)
+		}
+		logger.Info("Applying preset", "preset", name, "flags", applied)
+		return nil
+	}
+	return fmt.Errorf("Expected --preset of %s, got %q.", strings.Join(names, ", "), name)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// probeEach calls probe with the index of each of n items, from the given
+// number of workers at once, returning once all of them are probed.
+func probeEach(workers int, n int, probe func(i int)) {
+	queue := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers || w == 0; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				probe(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// ProbeTransport carries the requests reporters make of the site themselves,
+// such as for its images and assets once the crawl is complete. The crawl
+// hands it its transport, and has it wait the crawl's delay between requests
+// and decline the paths of the site which the crawl's robots.txt and
+// --disallow rules don't allow, as the crawl's own requests do.
+type ProbeTransport struct {
+	Transport http.RoundTripper
+
+	site     *url.URL
+	delay    time.Duration
+	disallow Follower
+	next     time.Time
+	lock     sync.Mutex
+}
+
+// Follow has the transport make its requests as the crawl of the site does,
+// through its transport, waiting the delay between requests and declining
+// those to the site's paths the disallow follower won't follow.
+func (p *ProbeTransport) Follow(transport http.RoundTripper, site *url.URL, delay time.Duration, disallow Follower) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.Transport, p.site, p.delay, p.disallow = transport, site, delay, disallow
+}
+
+func (p *ProbeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p.lock.Lock()
+	transport := p.Transport
+	if transport == nil {
+		p.lock.Unlock()
+		return nil, errors.New("Not probing the site before the crawl starts")
+	}
+	if p.disallow != nil && !isExternal(req.URL, p.site) {
+		if decision := p.disallow.Follow(&Link{URL: req.URL}); !decision.Allowed {
+			p.lock.Unlock()
+			return nil, fmt.Errorf("Not requesting %s: %s", req.URL, decision.Reason)
+		}
+	}
+	now := time.Now()
+	start := p.next
+	if start.Before(now) {
+		start = now
+	}
+	p.next = start.Add(p.delay)
+	p.lock.Unlock()
+
+	time.Sleep(start.Sub(now))
+	return transport.RoundTrip(req)
+}
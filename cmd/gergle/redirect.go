@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxRedirects is the number of redirects followed before giving up, as with
+// the http.Client's default policy.
+const maxRedirects = 10
+
+var ErrRedirectLoop = errors.New("Redirect loop")
+
+// checkRedirect is the http.Client redirect policy, which stops redirects
+// which return to an earlier URL of the chain rather than going around the
+// loop until the redirect limit.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("Stopped after %d redirects", maxRedirects)
+	}
+	for _, prev := range via {
+		if prev.URL.String() == req.URL.String() {
+			return ErrRedirectLoop
+		}
+	}
+	return nil
+}
+
+// redirectChain returns the URLs redirected through on the way to the
+// response, from the URL originally requested to that of the response, or nil
+// if there were no redirects.
+func redirectChain(resp *http.Response) []*url.URL {
+	chain := []*url.URL{resp.Request.URL}
+	for req := resp.Request; req.Response != nil; req = req.Response.Request {
+		chain = append([]*url.URL{req.Response.Request.URL}, chain...)
+	}
+	if len(chain) == 1 {
+		return nil
+	}
+	return chain
+}
+
+// RedirectReporter lists the redirect loops, and the redirect chains longer
+// than MaxHops, found during the crawl.
+type RedirectReporter struct {
+	MaxHops int
+
+	chains map[string][]string
+	loops  map[string][]string
+	lock   sync.Mutex
+}
+
+func NewRedirectReporter(maxHops int) *RedirectReporter {
+	return &RedirectReporter{
+		MaxHops: maxHops,
+		chains:  make(map[string][]string),
+		loops:   make(map[string][]string),
+	}
+}
+
+func (r *RedirectReporter) Observe(page Page) {
+	isLoop := page.Error != nil && errors.Is(*page.Error, ErrRedirectLoop)
+	if !isLoop && len(page.Redirects)-1 <= r.MaxHops {
+		return
+	}
+
+	chain := urlStrings(page.Redirects)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if isLoop {
+		r.loops[page.URL.String()] = chain
+	} else {
+		r.chains[page.URL.String()] = chain
+	}
+}
+
+func (r *RedirectReporter) Report(w io.Writer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	fmt.Fprintf(w, "Redirect loops: %d pages\n", len(r.loops))
+	printRedirects(w, r.loops)
+	fmt.Fprintf(w, "Redirect chains over %d hops: %d pages\n", r.MaxHops, len(r.chains))
+	printRedirects(w, r.chains)
+}
+
+func printRedirects(w io.Writer, redirects map[string][]string) {
+	pages := make([]string, 0, len(redirects))
+	for page := range redirects {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s (%d hops)\n", strings.Join(redirects[page], " -> "), len(redirects[page])-1)
+	}
+}
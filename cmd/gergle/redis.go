@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisClient runs commands against a Redis server, speaking just enough of
+// its protocol (RESP) to keep a crawl's frontier and seen pages there. Idle
+// connections are kept for reuse.
+type RedisClient struct {
+	Addr        string
+	DialTimeout time.Duration
+	// Timeout caps the time taken by each command, including any it spends
+	// blocking, so that a stalled server fails the command rather than
+	// hanging the crawl.
+	Timeout time.Duration
+
+	idle []*redisConn
+	lock sync.Mutex
+}
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func NewRedisClient(addr string) *RedisClient {
+	return &RedisClient{Addr: addr, DialTimeout: 10 * time.Second, Timeout: 10 * time.Second}
+}
+
+func (r *RedisClient) conn() (*redisConn, error) {
+	r.lock.Lock()
+	if n := len(r.idle); n > 0 {
+		conn := r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		r.lock.Unlock()
+		return conn, nil
+	}
+	r.lock.Unlock()
+
+	conn, err := net.DialTimeout("tcp", r.Addr, r.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &redisConn{conn, bufio.NewReader(conn)}, nil
+}
+
+// Do runs the command, returning its reply: a string, an int64, nil, or a
+// []interface{} of those. Error replies are returned as errors.
+func (r *RedisClient) Do(args ...string) (interface{}, error) {
+	conn, err := r.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(r.Timeout))
+	}
+	command := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		command += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(conn, command); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reply, err := readRedisReply(conn.reader)
+	if _, failed := err.(redisError); err != nil && !failed {
+		// The connection is in an unknown state.
+		conn.Close()
+		return nil, err
+	}
+
+	r.lock.Lock()
+	r.idle = append(r.idle, conn)
+	r.lock.Unlock()
+	return reply, err
+}
+
+// Close closes the idle connections.
+func (r *RedisClient) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, conn := range r.idle {
+		conn.Close()
+	}
+	r.idle = nil
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+func readRedisReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("Invalid Redis reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			if replies[i], err = readRedisReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("Invalid Redis reply %q", string(kind)+line)
+}
+
+// RedisFrontier is a first-in, first-out Frontier held in a Redis list, so
+// that the tasks waiting to be crawled outlive the process. Each task popped
+// is moved to a list of those underway until it is Done, so that a later
+// process can Requeue the tasks which were underway when an earlier one
+// stopped. Tasks which can't be pushed to Redis are held in memory instead.
+//
+// Only one process may use the frontier at a time, holding its RedisLock:
+// the crawler counts the tasks it has yet to explore in memory, so it can't
+// share them with another.
+type RedisFrontier struct {
+	Client *RedisClient
+	Key    string
+	// Processing is the key of the list of tasks underway.
+	Processing string
+	// Wait is how long each Pop blocks on Redis before checking whether the
+	// frontier has been closed. It must be shorter than the client's Timeout.
+	Wait time.Duration
+
+	memory []Task
+	closed bool
+	lock   sync.Mutex
+}
+
+func NewRedisFrontier(client *RedisClient, key string) *RedisFrontier {
+	return &RedisFrontier{Client: client, Key: key, Processing: key + ":processing", Wait: time.Second}
+}
+
+func (r *RedisFrontier) Push(task Task) {
+	data, err := redisRecord(task)
+	if err == nil {
+		_, err = r.Client.Do("RPUSH", r.Key, data)
+	}
+	if err != nil {
+		logger.Error("Failed to push task to Redis", "url", task.URL, "error", err)
+		r.lock.Lock()
+		r.memory = append(r.memory, task)
+		r.lock.Unlock()
+	}
+}
+
+func (r *RedisFrontier) Pop() (Task, bool) {
+	wait := strconv.Itoa(int((r.Wait + time.Second - 1) / time.Second))
+	for {
+		r.lock.Lock()
+		if r.closed {
+			r.lock.Unlock()
+			return Task{}, false
+		}
+		if len(r.memory) > 0 {
+			task := r.memory[0]
+			r.memory = r.memory[1:]
+			r.lock.Unlock()
+			return task, true
+		}
+		r.lock.Unlock()
+
+		reply, err := r.Client.Do("BLMOVE", r.Key, r.Processing, "LEFT", "RIGHT", wait)
+		if err != nil {
+			logger.Error("Failed to pop task from Redis", "key", r.Key, "error", err)
+			time.Sleep(r.Wait)
+			continue
+		}
+		data, ok := reply.(string)
+		if !ok {
+			continue
+		}
+		task, err := redisTask(data)
+		if err != nil {
+			logger.Error("Ignoring invalid task in Redis", "task", data, "error", err)
+			r.Client.Do("LREM", r.Processing, "1", data)
+			continue
+		}
+		return task, true
+	}
+}
+
+// Done removes the task from those underway, once it has been crawled.
+func (r *RedisFrontier) Done(task Task) {
+	data, err := redisRecord(task)
+	if err == nil {
+		_, err = r.Client.Do("LREM", r.Processing, "1", data)
+	}
+	if err != nil {
+		logger.Error("Failed to remove finished task from Redis", "url", task.URL, "error", err)
+	}
+}
+
+// Requeue returns the tasks which were underway when an earlier process
+// stopped to the front of the frontier, in the order they were popped.
+func (r *RedisFrontier) Requeue() (int, error) {
+	n := 0
+	for {
+		reply, err := r.Client.Do("LMOVE", r.Processing, r.Key, "RIGHT", "LEFT")
+		if err != nil || reply == nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// redisRecord returns the task as it is kept in Redis.
+func redisRecord(task Task) (string, error) {
+	record := spilledTask{
+		URL:        task.URL.String(),
+		Depth:      task.Depth,
+		Frame:      task.Frame,
+		Pagination: task.Pagination,
+		Unfollowed: task.Unfollowed,
+	}
+	if task.Referrer != nil {
+		record.Referrer = task.Referrer.String()
+	}
+	data, err := json.Marshal(record)
+	return string(data), err
+}
+
+func redisTask(data string) (Task, error) {
+	var record spilledTask
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return Task{}, err
+	}
+	u, err := url.Parse(record.URL)
+	if err != nil {
+		return Task{}, err
+	}
+	task := Task{URL: u, Depth: record.Depth, Frame: record.Frame, Pagination: record.Pagination, Unfollowed: record.Unfollowed}
+	if record.Referrer != "" {
+		if task.Referrer, err = url.Parse(record.Referrer); err != nil {
+			return Task{}, err
+		}
+	}
+	return task, nil
+}
+
+func (r *RedisFrontier) Close() {
+	r.lock.Lock()
+	r.closed = true
+	r.lock.Unlock()
+}
+
+// Len returns the number of tasks waiting in the frontier, including those
+// left by an earlier process.
+func (r *RedisFrontier) Len() int {
+	reply, err := r.Client.Do("LLEN", r.Key)
+	if err != nil {
+		logger.Error("Failed to count tasks in Redis", "key", r.Key, "error", err)
+	}
+	n, _ := reply.(int64)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return int(n) + len(r.memory)
+}
+
+// RedisLock is held by the one crawl using a set of Redis keys at a time, so
+// that a second crawl of them is refused rather than taking the first's
+// tasks. It expires after TTL unless it is refreshed, so that the crawl of a
+// process which died can be resumed shortly afterwards.
+type RedisLock struct {
+	Client *RedisClient
+	Key    string
+	TTL    time.Duration
+
+	token string
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// AcquireRedisLock takes the lock of the key, refreshing it until it is
+// released, or returns an error if another process holds it.
+func AcquireRedisLock(client *RedisClient, key string, ttl time.Duration) (*RedisLock, error) {
+	hostname, _ := os.Hostname()
+	l := &RedisLock{
+		Client: client,
+		Key:    key,
+		TTL:    ttl,
+		token:  fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano()),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	reply, err := client.Do("SET", key, l.token, "NX", "PX", l.ttl())
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		holder, _ := client.Do("GET", key)
+		return nil, fmt.Errorf("%s is held by %v", key, holder)
+	}
+	go l.refresh()
+	return l, nil
+}
+
+func (l *RedisLock) ttl() string {
+	return strconv.FormatInt(int64(l.TTL/time.Millisecond), 10)
+}
+
+func (l *RedisLock) refresh() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if _, err := l.Client.Do("PEXPIRE", l.Key, l.ttl()); err != nil {
+				logger.Warn("Failed to refresh Redis lock", "key", l.Key, "error", err)
+			}
+		}
+	}
+}
+
+// Release gives up the lock, unless it has expired and been taken by another
+// process since.
+func (l *RedisLock) Release() {
+	close(l.stop)
+	<-l.done
+	if holder, err := l.Client.Do("GET", l.Key); err == nil && holder == l.token {
+		l.Client.Do("DEL", l.Key)
+	}
+}
+
+// RedisSeenFollower follows links whose URLs it hasn't seen before, like the
+// UnseenFollower, recording the URLs in a Redis set so that they outlive the
+// process. If Redis can't be reached it falls back to recording them in
+// memory.
+type RedisSeenFollower struct {
+	Client *RedisClient
+	Key    string
+
+	memory *UnseenFollower
+}
+
+func NewRedisSeenFollower(client *RedisClient, key string, seen ...*url.URL) *RedisSeenFollower {
+	follower := &RedisSeenFollower{Client: client, Key: key, memory: NewUnseenFollower()}
+	for _, u := range seen {
+		follower.Follow(&Link{URL: u})
+	}
+	return follower
+}
+
+func (r *RedisSeenFollower) Follow(link *Link) FollowDecision {
+	href := sanitizeURL(link.URL)
+	reply, err := r.Client.Do("SADD", r.Key, href)
+	if err != nil {
+		logger.Error("Failed to record seen link in Redis", "url", link.URL, "error", err)
+		return r.memory.Follow(link)
+	}
+	if added, _ := reply.(int64); added == 0 {
+		return Deny("seen", "Not following seen link")
+	}
+	r.memory.recordSeen(href)
+	return Allow
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Reporter observes every crawled page, and summarises its findings once
+// the crawl is complete.
+type Reporter interface {
+	Observe(page Page)
+	Report(w io.Writer)
+}
+
+// A Failer is a Reporter whose findings can fail the crawl.
+type Failer interface {
+	Failed() bool
+}
+
+// Reporters observes pages on behalf of, and reports the summaries of, all of
+// its Reporters in turn.
+type Reporters []Reporter
+
+func (all Reporters) Observe(page Page) {
+	for _, reporter := range all {
+		reporter.Observe(page)
+	}
+}
+
+func (all Reporters) Report(w io.Writer) {
+	for _, reporter := range all {
+		reporter.Report(w)
+	}
+}
+
+// Failed reports whether any of the Reporters found the crawl to have failed.
+func (all Reporters) Failed() bool {
+	for _, reporter := range all {
+		if failer, ok := reporter.(Failer); ok && failer.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// FailOnChecks are the findings which --fail-on can fail the crawl on.
+var FailOnChecks = []string{"missing-security-headers"}
+
+// parseFailOn returns the set of --fail-on checks, rejecting unknown ones.
+func parseFailOn(checks []string) (map[string]bool, error) {
+	failOn := make(map[string]bool)
+	for _, check := range checks {
+		known := false
+		for _, name := range FailOnChecks {
+			known = known || check == name
+		}
+		if !known {
+			return nil, fmt.Errorf("Expected --fail-on of %s, got %q.", strings.Join(FailOnChecks, ", "), check)
+		}
+		failOn[check] = true
+	}
+	return failOn, nil
+}
@@ -0,0 +1,746 @@
+package main
+
+import (
+	"bytes"
+	"github.com/spf13/pflag"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHreflangReporter(t *testing.T) {
+	page := func(href string, alternates ...string) Page {
+		u, _ := url.Parse(href)
+		p := Page{URL: u, Processed: true}
+		for i := 0; i < len(alternates); i += 2 {
+			alt, _ := url.Parse(alternates[i+1])
+			p.Alternates = append(p.Alternates, &Alternate{URL: alt, HrefLang: alternates[i]})
+		}
+		return p
+	}
+
+	h := NewHreflangReporter()
+	h.Observe(page("http://example.com/en", "en", "http://example.com/en", "fr", "http://example.com/fr", "de", "http://example.com/de"))
+	h.Observe(page("http://example.com/fr", "fr", "http://example.com/fr", "en", "http://example.com/en"))
+	h.Observe(page("http://example.com/de", "de", "http://example.com/de"))
+
+	expected := map[string][]string{
+		"http://example.com/en": {"de alternate http://example.com/de does not link back"},
+	}
+	if problems := h.Problems(); !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v but got %v", expected, problems)
+	}
+
+	h.Observe(page("http://example.com/es", "en", "http://example.com/en/"))
+	expected["http://example.com/es"] = []string{"en alternate http://example.com/en/ does not link back"}
+	if problems := h.Problems(); !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %v but got %v", expected, problems)
+	}
+}
+
+func TestHeaderAssertionReporter(t *testing.T) {
+	assertion, err := ParseHeaderAssertion("Strict-Transport-Security=max-age=\\d+.*")
+	if err != nil {
+		t.Fatalf("ParseHeaderAssertion should not return error: %s", err)
+	}
+	if assertion.Name != "Strict-Transport-Security" {
+		t.Errorf("Expected assertion on Strict-Transport-Security but got %s", assertion.Name)
+	}
+
+	h := NewHeaderAssertionReporter(assertion)
+	h.Observe(Page{URL: &url.URL{Path: "/ok"}, StatusCode: 200, Header: http.Header{"Strict-Transport-Security": {"max-age=31536000; includeSubDomains"}}})
+	h.Observe(Page{URL: &url.URL{Path: "/unfetched"}})
+	if h.Failed() {
+		t.Error("Expected no failures for pages matching the assertion")
+	}
+
+	h.Observe(Page{URL: &url.URL{Path: "/missing"}, StatusCode: 200, Header: http.Header{}})
+	if !h.Failed() {
+		t.Error("Expected a failure for a page missing the header")
+	}
+
+	if _, err := ParseHeaderAssertion("=.+"); err == nil {
+		t.Error("ParseHeaderAssertion should return an error without a header name")
+	}
+}
+
+func TestBudgetReporter(t *testing.T) {
+	sizeBudget, err := ParseBudget("html-size=1KB")
+	if err != nil {
+		t.Fatalf("ParseBudget should not return error: %s", err)
+	}
+	assetsBudget, err := ParseBudget("assets=1")
+	if err != nil {
+		t.Fatalf("ParseBudget should not return error: %s", err)
+	}
+
+	b := NewBudgetReporter(sizeBudget, assetsBudget)
+	b.Observe(Page{URL: &url.URL{Path: "/small"}, Processed: true, Size: 1024, Assets: []*Link{{}}})
+	if b.Failed() {
+		t.Error("Expected no failures for pages within budget")
+	}
+
+	b.Observe(Page{URL: &url.URL{Path: "/large"}, Processed: true, Size: 1025, Assets: []*Link{{}, {}}})
+	if !b.Failed() {
+		t.Error("Expected a failure for a page over budget")
+	}
+	if violations := b.violations["/large"]; len(violations) != 2 {
+		t.Errorf("Expected 2 violations for /large but got %v", violations)
+	}
+
+	for _, rule := range []string{"assets", "weight=1KB", "assets=many", "ttfb=slow"} {
+		if _, err := ParseBudget(rule); err == nil {
+			t.Errorf("ParseBudget(%q) should return error", rule)
+		}
+	}
+}
+
+func TestGrepReporter(t *testing.T) {
+	parser := &RegexPageParser{Grep: []*regexp.Regexp{regexp.MustCompile(`UA-\d+`), regexp.MustCompile(`TODO`)}}
+	g := NewGrepReporter(`UA-\d+`, `TODO`)
+	g.Observe(Page{URL: &url.URL{Path: "/a"}, Matches: parser.grep([]byte(`ga('create', 'UA-1234'); ga('create', 'UA-5678');`))})
+	g.Observe(Page{URL: &url.URL{Path: "/b"}, Matches: parser.grep([]byte(`<p>Nothing to see here</p>`))})
+
+	out := &bytes.Buffer{}
+	g.Report(out)
+	expected := "Grep UA-\\d+: 2 matches on 1 pages\n- /a (2)\nGrep TODO: 0 matches on 0 pages\n"
+	if out.String() != expected {
+		t.Errorf("Expected report %q but got %q", expected, out.String())
+	}
+}
+
+func TestSoft404Reporter(t *testing.T) {
+	missing, _ := url.Parse("http://example.com/gergle-missing-1234")
+	fingerprint := &Soft404Fingerprint{Words: pageWords("Sorry, we couldn't find gergle-missing-1234. Try the home page.", missing)}
+	s := NewSoft404Reporter(fingerprint)
+
+	s.Observe(Page{URL: &url.URL{Path: "/old-product"}, StatusCode: 200, Processed: true, Text: "Sorry, we couldn't find old-product. Try the home page."})
+	s.Observe(Page{URL: &url.URL{Path: "/about"}, StatusCode: 200, Processed: true, Text: "We make websites. Try the home page."})
+	s.Observe(Page{URL: &url.URL{Path: "/gone"}, StatusCode: 404, Processed: false})
+
+	if expected := []string{"/old-product"}; !reflect.DeepEqual(s.pages, expected) {
+		t.Errorf("Expected soft 404s %v but got %v", expected, s.pages)
+	}
+
+	home, _ := url.Parse("http://example.com/")
+	redirected := &Soft404Fingerprint{Redirect: home}
+	if !redirected.Matches(Page{URL: &url.URL{Path: "/old"}, StatusCode: 200, Processed: true, Redirects: []*url.URL{{Path: "/old"}, home}}) {
+		t.Error("Expected a page redirecting home to match a fingerprint redirecting home")
+	}
+	if redirected.Matches(Page{URL: home, StatusCode: 200, Processed: true}) {
+		t.Error("Expected the home page itself not to match a fingerprint redirecting home")
+	}
+}
+
+func TestPaginationReporter(t *testing.T) {
+	p := NewPaginationReporter()
+	for _, pair := range [][2]string{{"/news", "/news/2"}, {"/news/2", "/news/3"}, {"/loop/a", "/loop/b"}, {"/loop/b", "/loop/a"}} {
+		p.Observe(Page{URL: &url.URL{Path: pair[0]}, Next: &url.URL{Path: pair[1]}})
+	}
+
+	expected := map[string]int{"/news": 3, "/loop/a": 2}
+	if sequences := p.Sequences(); !reflect.DeepEqual(sequences, expected) {
+		t.Errorf("Expected sequences %v but got %v", expected, sequences)
+	}
+}
+
+func TestImageReporter(t *testing.T) {
+	data := &bytes.Buffer{}
+	if err := png.Encode(data, image.NewRGBA(image.Rect(0, 0, 300, 200))); err != nil {
+		t.Fatalf("Failed to encode test image: %s", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "image.png", time.Time{}, bytes.NewReader(data.Bytes()))
+	}))
+	defer server.Close()
+
+	info, err := ProbeImage(http.DefaultClient, server.URL+"/image.png")
+	if err != nil {
+		t.Fatalf("ProbeImage should not return error: %s", err)
+	}
+	if info.Format != "png" || info.Width != 300 || info.Height != 200 || info.Size != int64(data.Len()) {
+		t.Errorf("Expected a %d byte 300x200 png but got %+v", data.Len(), info)
+	}
+
+	src, _ := url.Parse(server.URL + "/image.png")
+	i := NewImageReporter(http.DefaultClient, 10)
+	i.Observe(Page{URL: &url.URL{Path: "/a"}, Images: []*Image{{URL: src, HasAlt: true}}})
+	i.Observe(Page{URL: &url.URL{Path: "/b"}, Images: []*Image{{URL: src}}})
+
+	out := &bytes.Buffer{}
+	i.Report(out)
+	for _, expected := range []string{"Oversized images: 1 images\n", "Images missing alt text: 1 pages\n- /b\n"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected report to contain %q but got %q", expected, out.String())
+		}
+	}
+}
+
+func TestAssetReporter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app.js", "/app.js.v2":
+			io.WriteString(w, "console.log('app');")
+		case "/site.css":
+			io.WriteString(w, "body {}")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	asset := func(path string) *Link {
+		u, _ := url.Parse(server.URL + path)
+		return &Link{URL: u, Type: "script"}
+	}
+	a := NewAssetReporter(http.DefaultClient, 1)
+	a.Observe(Page{URL: &url.URL{Path: "/a"}, Assets: []*Link{asset("/app.js"), asset("/site.css")}})
+	a.Observe(Page{URL: &url.URL{Path: "/b"}, Assets: []*Link{asset("/app.js.v2"), asset("/site.css")}})
+	a.Observe(Page{URL: &url.URL{Path: "/c"}, Assets: []*Link{asset("/site.css"), asset("/missing.js")}})
+
+	out := &bytes.Buffer{}
+	a.Report(out)
+	for _, expected := range []string{
+		"Shared assets: 4 assets\n- " + server.URL + "/site.css: 3 pages\nAsset variations",
+		"Asset variations: 1 files under several URLs\n",
+		"  - " + server.URL + "/app.js\n  - " + server.URL + "/app.js.v2\n",
+		"Unique asset weight: 26 bytes in 2 files\n",
+		"Assets not fetched: 1 assets\n- " + server.URL + "/missing.js: Asset responded 404\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected report to contain %q but got %q", expected, out.String())
+		}
+	}
+}
+
+func TestExternalReporter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/head-only" && r.Method != "HEAD":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/get-only" && r.Method == "HEAD":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/gone":
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer server.Close()
+
+	link := func(href string) *Link {
+		u, _ := url.Parse(href)
+		return &Link{URL: u, External: true}
+	}
+	e := NewExternalReporter(http.DefaultClient)
+	e.Observe(Page{URL: &url.URL{Path: "/a"}, Links: []*Link{link(server.URL + "/head-only"), link(server.URL + "/gone#top"), link("mailto:a@example.com")}})
+	e.Observe(Page{URL: &url.URL{Path: "/b"}, Links: []*Link{link(server.URL + "/get-only"), link(server.URL + "/gone")}})
+
+	out := &bytes.Buffer{}
+	e.Report(out)
+	host := strings.TrimPrefix(server.URL, "http://")
+	expected := "External domains: 1 domains, 3 links\n" +
+		"- " + host[:strings.Index(host, ":")] + ": 3 links from 2 pages, 1 dead\n" +
+		"Dead external links: 1 links\n" +
+		"- " + server.URL + "/gone: Responded 410\n" +
+		"  - /a\n" +
+		"  - /b\n"
+	if out.String() != expected {
+		t.Errorf("Expected report:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestStructuredDataReporter(t *testing.T) {
+	expectation, err := ParseSchemaExpectation("/blog/*=Article")
+	if err != nil {
+		t.Fatalf("ParseSchemaExpectation should not return error: %s", err)
+	}
+
+	s := NewStructuredDataReporter(expectation)
+	s.Observe(Page{URL: &url.URL{Path: "/"}, Processed: true, Schemas: []string{"Organization"}})
+	s.Observe(Page{URL: &url.URL{Path: "/blog/post"}, Processed: true, Schemas: []string{"Article", "Person"}})
+	if s.Failed() {
+		t.Error("Expected no failures when pages have their expected schemas")
+	}
+
+	s.Observe(Page{URL: &url.URL{Path: "/blog/draft"}, Processed: true, Schemas: []string{"WebPage"}})
+	if !s.Failed() {
+		t.Error("Expected a failure for a page missing its expected schema")
+	}
+	out := &bytes.Buffer{}
+	s.Report(out)
+	if !strings.Contains(out.String(), "Missing Article structured data (/blog/*=Article): 1 pages\n- /blog/draft\n") {
+		t.Errorf("Expected /blog/draft to be reported missing an Article, but got %q", out.String())
+	}
+
+	for _, rule := range []string{"Article", "/blog/*=", "=Article"} {
+		if _, err := ParseSchemaExpectation(rule); err == nil {
+			t.Errorf("ParseSchemaExpectation(%q) should return error", rule)
+		}
+	}
+}
+
+func TestSocialReporter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/card.png" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	complete := map[string]string{
+		"og:title": "Title", "og:type": "article", "og:url": "http://example.com/a",
+		"og:image": server.URL + "/card.png", "twitter:card": "summary",
+	}
+	s := NewSocialReporter(http.DefaultClient)
+	s.Observe(Page{URL: &url.URL{Path: "/a"}, Processed: true, Social: complete})
+	s.Observe(Page{URL: &url.URL{Path: "/b"}, Processed: true, Social: map[string]string{
+		"og:title": "Title", "og:type": "article", "og:url": "/b",
+		"og:image": server.URL + "/missing.png", "twitter:card": "huge",
+	}})
+
+	out := &bytes.Buffer{}
+	s.Report(out)
+	expected := "Social metadata problems: 1 pages\n- /b\n" +
+		"  - invalid og:url \"/b\": not an absolute URL\n" +
+		"  - invalid twitter:card \"huge\"\n" +
+		"  - broken og:image " + server.URL + "/missing.png: Image responded 404\n"
+	if out.String() != expected {
+		t.Errorf("Expected report %q but got %q", expected, out.String())
+	}
+}
+
+func TestIconReporter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/favicon.ico", "/icon.png":
+		case "/site.webmanifest":
+			w.Write([]byte(`{"name": "Site", "display": "window", "icons": [{"src": "/missing-192.png"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	site, _ := url.Parse(server.URL + "/")
+	body := []byte(`<link rel="shortcut icon" href="/icon.png"><link rel="manifest" href="site.webmanifest">`)
+	icons, manifest := parseIcons(site, body)
+	if len(icons) != 1 || icons[0].String() != server.URL+"/icon.png" || manifest.String() != server.URL+"/site.webmanifest" {
+		t.Fatalf("Expected an icon and a manifest but got %v and %v", icons, manifest)
+	}
+
+	i := NewIconReporter(http.DefaultClient, site)
+	i.Observe(Page{URL: site, Processed: true, MediaType: "text/html", Icons: icons, Manifest: manifest})
+	i.Observe(Page{URL: &url.URL{Path: "/bare"}, Processed: true, MediaType: "text/html"})
+	i.Observe(Page{URL: &url.URL{Path: "/feed"}, Processed: true, MediaType: "application/rss+xml"})
+
+	out := &bytes.Buffer{}
+	i.Report(out)
+	expected := "Site icons: favicon.ico found, 1 of 2 pages declare an icon, 1 manifests\n" +
+		"Icon problems: 2 URLs\n" +
+		"- " + server.URL + "/missing-192.png\n  - Image responded 404\n" +
+		"- " + server.URL + "/site.webmanifest\n" +
+		"  - missing start_url\n" +
+		"  - invalid display \"window\"\n" +
+		"  - icon /missing-192.png is missing sizes\n" +
+		"Pages without an icon: 1 pages\n- /bare\n"
+	if out.String() != expected {
+		t.Errorf("Expected report %q but got %q", expected, out.String())
+	}
+}
+
+func TestWellKnownReporter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/security.txt":
+			w.Write([]byte("# Security\nContact: mailto:security@example.com\nExpires: 2001-01-01T00:00:00Z\n"))
+		case "/.well-known/change-password":
+			http.Redirect(w, r, "/account/password", http.StatusFound)
+		case "/robots.txt":
+			w.Write([]byte("User-agent: *\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	site, _ := url.Parse(server.URL + "/")
+	out := &bytes.Buffer{}
+	NewWellKnownReporter(http.DefaultClient, site).Report(out)
+	expected := "Well-known URLs: 3 of 4 found\n" +
+		"- " + server.URL + "/.well-known/security.txt: 200\n  - expired 2001-01-01T00:00:00Z\n" +
+		"- " + server.URL + "/.well-known/change-password: 302 to /account/password\n" +
+		"- " + server.URL + "/robots.txt: 200\n" +
+		"- " + server.URL + "/sitemap.xml: 404\n"
+	if out.String() != expected {
+		t.Errorf("Expected report %q but got %q", expected, out.String())
+	}
+
+	if problems := securityTxtProblems([]byte("Expires: soon\n")); !reflect.DeepEqual(problems, []string{"missing Contact", `invalid Expires "soon"`}) {
+		t.Errorf("Expected security.txt problems but got %q", problems)
+	}
+}
+
+func TestCachingReporter(t *testing.T) {
+	defer func(c func() time.Time) { clock = c }(clock)
+	clock = fixedClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	page := func(path string, header ...string) Page {
+		p := Page{URL: &url.URL{Path: path}, StatusCode: 200, Header: http.Header{}}
+		for i := 0; i < len(header); i += 2 {
+			p.Header.Add(header[i], header[i+1])
+		}
+		return p
+	}
+	c := NewCachingReporter()
+	c.Observe(page("/fine", "ETag", `"abc"`, "Cache-Control", "max-age=60"))
+	c.Observe(page("/private", "Cache-Control", "no-store"))
+	c.Observe(page("/bare"))
+	c.Observe(page("/mixed", "Last-Modified", "Wed, 01 Jan 2025 00:00:00 GMT", "Cache-Control", "public, private, max-age=60", "Expires", "Tue, 01 Jan 2019 00:00:00 GMT"))
+	c.Observe(page("/stored", "ETag", `"abc"`, "Cache-Control", "no-store, max-age=3600", "Expires", "soon"))
+
+	out := &bytes.Buffer{}
+	c.Report(out)
+	expected := "Caching problems: 3 pages\n" +
+		"- contradictory Cache-Control: 2 pages\n" +
+		"- contradictory caching: 1 pages\n" +
+		"- future Last-Modified: 1 pages\n" +
+		"- invalid Expires: 1 pages\n" +
+		"- missing freshness: 1 pages\n" +
+		"- missing validator: 1 pages\n" +
+		"- /bare\n  - missing validator: no ETag or Last-Modified\n  - missing freshness: no Cache-Control or Expires\n" +
+		"- /mixed\n  - contradictory Cache-Control: public and private\n" +
+		"  - contradictory caching: Expires Tue, 01 Jan 2019 00:00:00 GMT has passed, but max-age is 60\n" +
+		"  - future Last-Modified: Wed, 01 Jan 2025 00:00:00 GMT\n" +
+		"- /stored\n  - contradictory Cache-Control: no-store with no-store, max-age=3600\n  - invalid Expires \"soon\"\n"
+	if out.String() != expected {
+		t.Errorf("Expected report %q but got %q", expected, out.String())
+	}
+}
+
+func TestSecurityHeaderReporter(t *testing.T) {
+	page := func(href string, header ...string) Page {
+		u, _ := url.Parse(href)
+		p := Page{URL: u, StatusCode: 200, MediaType: "text/html", Header: http.Header{}}
+		for i := 0; i < len(header); i += 2 {
+			p.Header.Add(header[i], header[i+1])
+		}
+		return p
+	}
+	s := NewSecurityHeaderReporter()
+	s.Observe(page("https://example.com/",
+		"Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'",
+		"Strict-Transport-Security", "max-age=31536000",
+		"X-Content-Type-Options", "nosniff",
+		"Referrer-Policy", "no-referrer"))
+	s.Observe(page("https://example.com/bare"))
+	s.Observe(page("http://example.com/plain", "Content-Security-Policy", "default-src 'self'", "X-Content-Type-Options", "nosniff"))
+	if s.Failed() {
+		t.Errorf("Expected missing security headers not to fail without --fail-on")
+	}
+
+	out := &bytes.Buffer{}
+	s.Report(out)
+	expected := "Security headers: 2 of 3 pages missing headers\n" +
+		"- Referrer-Policy: 2 pages\n" +
+		"- X-Frame-Options: 2 pages\n" +
+		"- Content-Security-Policy: 1 pages\n" +
+		"- Strict-Transport-Security: 1 pages\n" +
+		"- X-Content-Type-Options: 1 pages\n" +
+		"- http://example.com/plain\n  - missing Referrer-Policy\n  - missing X-Frame-Options\n" +
+		"- https://example.com/bare\n" +
+		"  - missing Content-Security-Policy\n  - missing Strict-Transport-Security\n  - missing X-Content-Type-Options\n" +
+		"  - missing Referrer-Policy\n  - missing X-Frame-Options\n"
+	if out.String() != expected {
+		t.Errorf("Expected report %q but got %q", expected, out.String())
+	}
+
+	s.Fail = true
+	if !s.Failed() {
+		t.Errorf("Expected missing security headers to fail with --fail-on")
+	}
+	if _, err := parseFailOn([]string{"missing-security-headers", "typos"}); err == nil {
+		t.Errorf("Expected an unknown --fail-on check to be rejected")
+	}
+}
+
+func TestCSPReporter(t *testing.T) {
+	page, _ := url.Parse("https://example.com/page")
+	asset := func(assetType, href string) *Link {
+		link, _ := AssetLink(assetType, href, page, 1)
+		return link
+	}
+	header := http.Header{}
+	header.Add("Content-Security-Policy", "default-src 'self'; script-src 'self' https://cdn.example.net/js/ *.analytics.com; img-src * data:; frame-src 'none'")
+	c := NewCSPReporter()
+	c.Observe(Page{URL: page, StatusCode: 200, Header: header,
+		Assets: []*Link{
+			asset("script", "/app.js"),
+			asset("script", "https://cdn.example.net/js/lib.js"),
+			asset("script", "https://cdn.example.net/other.js"),
+			asset("script", "https://www.analytics.com/a.js"),
+			asset("script", "http://www.analytics.com/a.js"),
+			asset("img", "https://images.example.org/a.png"),
+			asset("img", "data:image/png;base64,AAAA"),
+			asset("stylesheet", "https://fonts.example.net/a.css"),
+			asset("stylesheet", "/site.css"),
+		},
+		Links: []*Link{asset("iframe", "/embed"), asset("anchor", "https://elsewhere.com/")},
+	})
+	c.Observe(Page{URL: &url.URL{Scheme: "https", Host: "example.com", Path: "/open"}, StatusCode: 200, Header: http.Header{},
+		Assets: []*Link{asset("script", "https://anywhere.com/a.js")}})
+
+	out := &bytes.Buffer{}
+	c.Report(out)
+	expected := "Content-Security-Policy violations: 1 of 1 pages\n" +
+		"- default-src: 1 pages\n- frame-src: 1 pages\n- script-src: 1 pages\n" +
+		"- https://example.com/page\n" +
+		"  - script https://cdn.example.net/other.js blocked by script-src 'self' https://cdn.example.net/js/ *.analytics.com\n" +
+		"  - script http://www.analytics.com/a.js blocked by script-src 'self' https://cdn.example.net/js/ *.analytics.com\n" +
+		"  - stylesheet https://fonts.example.net/a.css blocked by default-src 'self'\n" +
+		"  - iframe https://example.com/embed blocked by frame-src 'none'\n"
+	if out.String() != expected {
+		t.Errorf("Expected report %q but got %q", expected, out.String())
+	}
+}
+
+func TestCookieReporter(t *testing.T) {
+	page := func(href string, setCookies ...string) Page {
+		u, _ := url.Parse(href)
+		return Page{URL: u, StatusCode: 200, Header: http.Header{"Set-Cookie": setCookies}}
+	}
+	c := NewCookieReporter()
+	c.Observe(page("https://example.com/", "session=1; Path=/; Secure; HttpOnly; SameSite=Lax", "theme=dark"))
+	c.Observe(page("https://example.com/about", "session=2; Path=/; Secure; HttpOnly; SameSite=Lax"))
+	c.Observe(page("https://example.com/shop/cart", "basket=3; Domain=.Example.com; SameSite=None; Secure"))
+	c.Observe(page("https://example.com/about"))
+
+	out := &bytes.Buffer{}
+	c.Report(out)
+	expected := "Cookies: 3 cookies, 2 missing Secure, HttpOnly or SameSite\n" +
+		"- example.com/\n" +
+		"  - session: set by 2 pages\n" +
+		"  - theme: missing Secure, HttpOnly, SameSite, set by 1 pages\n" +
+		"- example.com/shop\n" +
+		"  - basket: missing HttpOnly, set by 1 pages\n"
+	if out.String() != expected {
+		t.Errorf("Expected report %q but got %q", expected, out.String())
+	}
+}
+
+func TestCaseDuplicateReporter(t *testing.T) {
+	page := func(href, checksum string) Page {
+		u, _ := url.Parse(href)
+		return Page{URL: u, StatusCode: 200, Checksum: checksum}
+	}
+	c := NewCaseDuplicateReporter()
+	c.Observe(page("http://example.com/About", "a"))
+	c.Observe(page("http://example.com/about", "a"))
+	c.Observe(page("http://example.com/ABOUT#team", "a"))
+	c.Observe(page("http://example.com/about#team", "a"))
+	c.Observe(page("http://example.com/Blog", "b"))
+	c.Observe(page("http://example.com/blog", "c"))
+	c.Observe(page("http://example.com/contact", "d"))
+
+	out := &bytes.Buffer{}
+	c.Report(out)
+	expected := "Case-insensitive duplicates: 1 groups\n" +
+		"- http://example.com/ABOUT\n  - http://example.com/About\n  - http://example.com/about\n"
+	if out.String() != expected {
+		t.Errorf("Expected report %q but got %q", expected, out.String())
+	}
+}
+
+func TestTrailingSlashReporter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a/", "/b/", "/c/", "/d", "/e", "/e/", "/f":
+		case "/a", "/b", "/c":
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		case "/d/":
+			http.Redirect(w, r, "/d", http.StatusMovedPermanently)
+		case "/f/":
+			http.Redirect(w, r, "/", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tr := NewTrailingSlashReporter(http.DefaultClient)
+	tr.Workers = 2
+	for _, path := range []string{"/", "/a/", "/b/", "/c/", "/d", "/e/", "/f", "/g"} {
+		u, _ := url.Parse(server.URL + path)
+		tr.Observe(Page{URL: u, StatusCode: 200})
+	}
+
+	out := &bytes.Buffer{}
+	tr.Report(out)
+	expected := "Trailing slashes: 3 redirects add the slash, 1 remove it, 1 pages respond 200 either way\n" +
+		"- " + server.URL + "/d/: redirects to " + server.URL + "/d, unlike most of the site\n" +
+		"- " + server.URL + "/e: " + server.URL + "/e/ also responds 200\n" +
+		"- " + server.URL + "/f/: redirects to " + server.URL + "/, not " + server.URL + "/f\n"
+	if out.String() != expected {
+		t.Errorf("Expected report %q but got %q", expected, out.String())
+	}
+}
+
+func TestApplyPreset(t *testing.T) {
+	flags := pflag.NewFlagSet("gergle", pflag.ContinueOnError)
+	checkFragments := flags.Bool("check-fragments", false, "")
+	flags.Bool("check-hrefs", false, "")
+	redirectHops := flags.Int("redirect-chains", 0, "")
+	flags.Bool("mixed-content", false, "")
+	flags.Bool("dns-errors", false, "")
+	flags.Bool("tls", false, "")
+	flags.Parse([]string{"--redirect-chains", "1"})
+
+	if err := applyPreset(flags, "link-check"); err != nil {
+		t.Fatalf("Expected link-check preset to apply: %s", err)
+	}
+	if !*checkFragments {
+		t.Error("Expected the preset to enable --check-fragments")
+	}
+	if *redirectHops != 1 {
+		t.Errorf("Expected the explicit --redirect-chains 1 to take precedence, got %d", *redirectHops)
+	}
+	if err := applyPreset(flags, "nope"); err == nil {
+		t.Error("Expected an unknown preset to fail")
+	}
+}
+
+func TestHostReporter(t *testing.T) {
+	h := NewHostReporter()
+	for _, page := range []Page{
+		{URL: &url.URL{Host: "example.com", Path: "/"}, StatusCode: 200, TTFB: 100 * time.Millisecond, Size: 1000},
+		{URL: &url.URL{Host: "example.com", Path: "/a"}, StatusCode: 500, TTFB: 300 * time.Millisecond, Size: 10},
+		{URL: &url.URL{Host: "blog.example.com", Path: "/"}, StatusCode: 200, TTFB: 50 * time.Millisecond, Size: 500},
+	} {
+		h.Observe(page)
+	}
+
+	out := &bytes.Buffer{}
+	h.Report(out)
+	expected := "Hosts: 2 hosts, 3 pages, 1 errors, 150ms average ttfb, 1510 bytes\n" +
+		"- example.com: 2 pages, 1 errors, 200ms average ttfb, 1010 bytes\n" +
+		"- blog.example.com: 1 pages, 0 errors, 50ms average ttfb, 500 bytes\n"
+	if out.String() != expected {
+		t.Errorf("Expected report %q but got %q", expected, out.String())
+	}
+}
+
+func TestDepthReporter(t *testing.T) {
+	page := func(path string, depth uint16) Page {
+		return Page{URL: &url.URL{Scheme: "http", Host: "example.com", Path: path}, StatusCode: 200, Depth: depth}
+	}
+
+	d := NewDepthReporter(2)
+	d.Observe(page("/", 0))
+	d.Observe(page("/blog", 1))
+	d.Observe(page("/blog/2019/post", 3))
+	d.Observe(page("/about", 1))
+	d.Observe(Page{URL: &url.URL{Path: "/unfetched"}, Depth: 9})
+
+	expected := map[string]float64{"/": 0, "/blog": 2, "/about": 1}
+	if averages := d.AverageDepths(); !reflect.DeepEqual(averages, expected) {
+		t.Errorf("Expected average depths %v but got %v", expected, averages)
+	}
+
+	var out bytes.Buffer
+	d.Report(&out)
+	for _, line := range []string{"- 1 clicks: 2 pages", "- /blog: 2.0 clicks (2 pages)", "Deeper than 2 clicks: 1 pages\n- http://example.com/blog/2019/post (3 clicks)"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected report to contain %q but got:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestAuthorityReporter(t *testing.T) {
+	a := NewAuthorityReporter(2)
+	for _, page := range []Page{
+		mockPage("http://example.com/", "/a", "/b", "/c", "http://other.com/"),
+		mockPage("http://example.com/a", "/", "/b"),
+		mockPage("http://example.com/b", "/", "/b#top"),
+		mockPage("http://example.com/c"),
+	} {
+		page.StatusCode = 200
+		a.Observe(page)
+	}
+
+	ranks, inbound := a.Graph.PageRank(50)
+	total := 0.0
+	for _, rank := range ranks {
+		total += rank
+	}
+	if total < 0.999 || total > 1.001 {
+		t.Errorf("Expected ranks to sum to 1 but got %f", total)
+	}
+	if !(ranks["http://example.com/"] > ranks["http://example.com/b"] && ranks["http://example.com/b"] > ranks["http://example.com/a"]) {
+		t.Errorf("Expected / to outrank /b to outrank /a but got %v", ranks)
+	}
+	expected := map[string]int{"http://example.com/": 2, "http://example.com/a": 1, "http://example.com/b": 2, "http://example.com/c": 1}
+	if !reflect.DeepEqual(inbound, expected) {
+		t.Errorf("Expected inbound links %v but got %v", expected, inbound)
+	}
+
+	var out bytes.Buffer
+	a.Report(&out)
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 3 || lines[0] != "Link authority: 4 pages" || !strings.HasPrefix(lines[1], "- http://example.com/: ") {
+		t.Errorf("Expected the top 2 of 4 pages but got:\n%s", out.String())
+	}
+}
+
+func TestLinkStructureReporter(t *testing.T) {
+	l := NewLinkStructureReporter()
+	pages := []Page{
+		mockPage("http://example.com/", "/a", "/b", "/hidden"),
+		mockPage("http://example.com/a", "/", "/loop/1"),
+		mockPage("http://example.com/b", "http://other.com/"),
+		mockPage("http://example.com/hidden", "/secret"),
+		mockPage("http://example.com/secret", "/"),
+		mockPage("http://example.com/loop/1", "/loop/2"),
+		mockPage("http://example.com/loop/2", "/loop/1"),
+	}
+	pages[3].NoFollow = true
+	for i, page := range pages {
+		page.StatusCode = 200
+		if i > 0 {
+			page.Depth = 1
+		}
+		l.Observe(page)
+	}
+
+	if deadEnds := l.Graph.DeadEnds(); !reflect.DeepEqual(deadEnds, []string{"http://example.com/b", "http://example.com/hidden"}) {
+		t.Errorf("Expected /b and the nofollow /hidden to be dead ends but got %v", deadEnds)
+	}
+	if unreachable := l.Graph.Unreachable(); !reflect.DeepEqual(unreachable, []string{"http://example.com/secret"}) {
+		t.Errorf("Expected /secret to be unreachable but got %v", unreachable)
+	}
+	expected := [][]string{{"http://example.com/loop/1", "http://example.com/loop/2"}}
+	if clusters := l.Graph.IsolatedClusters(); !reflect.DeepEqual(clusters, expected) {
+		t.Errorf("Expected isolated clusters %v but got %v", expected, clusters)
+	}
+}
+
+func TestFragmentReporter(t *testing.T) {
+	f := NewFragmentReporter()
+	home := mockPage("http://example.com/", "#intro", "#missing", "#top", "#!/route", "/docs#usage", "/docs#gone", "/other#x", "http://other.com/#x")
+	home.Fragments = []string{"intro"}
+	docs := mockPage("http://example.com/docs")
+	docs.Fragments = []string{"usage"}
+	f.Observe(home)
+	f.Observe(docs)
+
+	expected := map[string][]string{
+		"http://example.com/": {"http://example.com/#missing", "http://example.com/docs#gone"},
+	}
+	if broken := f.Broken(); !reflect.DeepEqual(broken, expected) {
+		t.Errorf("Expected broken fragments %v but got %v", expected, broken)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// A Rewriter rewrites the URLs of discovered links before they are followed.
+type Rewriter interface {
+	Rewrite(u *url.URL) *url.URL
+}
+
+// A RewriteRule replaces the matches of its pattern within a URL.
+type RewriteRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// ParseRewriteRule reads a rule of the form REGEXP=>REPLACEMENT, where the
+// replacement may refer to submatches as $1 or ${name}.
+func ParseRewriteRule(rule string) (RewriteRule, error) {
+	arrow := strings.Index(rule, "=>")
+	if arrow <= 0 {
+		return RewriteRule{}, fmt.Errorf("Expected --rewrite of the form REGEXP=>REPLACEMENT, got %q.", rule)
+	}
+	pattern, err := regexp.Compile(rule[:arrow])
+	if err != nil {
+		return RewriteRule{}, err
+	}
+	return RewriteRule{pattern, rule[arrow+2:]}, nil
+}
+
+// RegexRewriter applies each of its rules to the full URL in turn.
+type RegexRewriter []RewriteRule
+
+func (r RegexRewriter) Rewrite(u *url.URL) *url.URL {
+	href := u.String()
+	for _, rule := range r {
+		href = rule.Pattern.ReplaceAllString(href, rule.Replacement)
+	}
+	if href == u.String() {
+		return u
+	}
+
+	rewritten, err := url.Parse(href)
+	if err != nil {
+		logger.Warn("Ignoring invalid rewritten URL", "url", u, "rewritten", href, "error", err)
+		return u
+	}
+	logger.Debug("Rewrote URL", "url", u, "rewritten", rewritten)
+	return rewritten
+}
+
+// Rewriters applies each of its rewriters to the URL in turn.
+type Rewriters []Rewriter
+
+func (r Rewriters) Rewrite(u *url.URL) *url.URL {
+	for _, rewriter := range r {
+		u = rewriter.Rewrite(u)
+	}
+	return u
+}
+
+// UpgradeRewriter rewrites the http URLs of its host to https, so that sites
+// which redirect http to https have the two treated as the same page rather
+// than each being crawled.
+type UpgradeRewriter struct {
+	Host string
+}
+
+func (r *UpgradeRewriter) Rewrite(u *url.URL) *url.URL {
+	if u.Scheme != "http" || asciiHost(u.Host) != asciiHost(r.Host) {
+		return u
+	}
+	upgraded := *u
+	upgraded.Scheme = "https"
+	return &upgraded
+}
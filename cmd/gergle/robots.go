@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// A robotsRule is an Allow or Disallow line of a robots.txt group.
+type robotsRule struct {
+	Allow   bool
+	Pattern string
+	regexp  *regexp.Regexp
+}
+
+func (r *robotsRule) String() string {
+	if r.Allow {
+		return "Allow: " + r.Pattern
+	}
+	return "Disallow: " + r.Pattern
+}
+
+// A robotsGroup is the rules of robots.txt which apply to its user agents.
+type robotsGroup struct {
+	Agents []string
+	Rules  []*robotsRule
+}
+
+// Robots is a robots.txt, parsed per RFC 9309 into groups of rules for each
+// user agent.
+type Robots struct {
+	Groups []*robotsGroup
+}
+
+// ParseRobots parses the groups of a robots.txt body, ignoring the lines it
+// doesn't understand.
+func ParseRobots(body []byte) *Robots {
+	robots := &Robots{}
+	var group *robotsGroup
+	inAgents := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if hash := strings.Index(line, "#"); hash >= 0 {
+			line = line[:hash]
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		field := strings.ToLower(strings.TrimSpace(line[:colon]))
+		value := strings.TrimSpace(line[colon+1:])
+
+		switch field {
+		case "user-agent":
+			if !inAgents {
+				group = &robotsGroup{}
+				robots.Groups = append(robots.Groups, group)
+				inAgents = true
+			}
+			group.Agents = append(group.Agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inAgents = false
+			if group == nil || value == "" {
+				// An empty Disallow allows everything, as does no rule.
+				continue
+			}
+			rule := &robotsRule{Allow: field == "allow", Pattern: value}
+			rule.regexp = compileRobotsRule(value)
+			group.Rules = append(group.Rules, rule)
+		default:
+			inAgents = false
+		}
+	}
+	return robots
+}
+
+// compileRobotsRule transforms the path pattern of a rule, where * matches
+// anything and a trailing $ anchors the end of the path, into a
+// regexp.Regexp.
+func compileRobotsRule(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	expr := "^" + strings.Replace(regexp.QuoteMeta(pattern), "\\*", ".*", -1)
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// Group returns the rules which apply to the user agent: those of every group
+// naming the agent's product token, or else of the * groups.
+func (r *Robots) Group(userAgent string) *robotsGroup {
+	product := strings.ToLower(userAgent)
+	if slash := strings.Index(product, "/"); slash >= 0 {
+		product = product[:slash]
+	}
+
+	matched := &robotsGroup{}
+	wildcard := &robotsGroup{}
+	for _, group := range r.Groups {
+		for _, agent := range group.Agents {
+			if agent == product {
+				matched.Agents = append(matched.Agents, agent)
+				matched.Rules = append(matched.Rules, group.Rules...)
+				break
+			} else if agent == "*" {
+				wildcard.Agents = append(wildcard.Agents, agent)
+				wildcard.Rules = append(wildcard.Rules, group.Rules...)
+				break
+			}
+		}
+	}
+	if len(matched.Agents) > 0 {
+		return matched
+	}
+	return wildcard
+}
+
+// Allowed reports whether the user agent may fetch the path, which may include
+// a query, and the rule deciding so, if any. The longest matching rule wins,
+// with Allow winning ties.
+func (r *Robots) Allowed(userAgent string, path string) (bool, *robotsRule) {
+	if path == "/robots.txt" {
+		return true, nil
+	}
+
+	var match *robotsRule
+	for _, rule := range r.Group(userAgent).Rules {
+		if !rule.regexp.MatchString(path) {
+			continue
+		}
+		if match == nil || len(rule.Pattern) > len(match.Pattern) ||
+			(len(rule.Pattern) == len(match.Pattern) && rule.Allow) {
+			match = rule
+		}
+	}
+	return match == nil || match.Allow, match
+}
+
+// newRobotsCommand returns the command which tests whether paths are allowed
+// by a site's robots.txt.
+func newRobotsCommand(opts *CrawlOptions) *cobra.Command {
+	var paths []string
+	var userAgent string
+
+	cmd := &cobra.Command{
+		Use:   "robots URL",
+		Short: "Test whether paths are allowed by a website's robots.txt.",
+	}
+	cmd.Flags().StringSliceVarP(&paths, "test", "", nil, "Paths to test against the robots.txt rules.")
+	cmd.Flags().StringVarP(&userAgent, "user-agent", "", "gergle", "The user agent whose robots.txt rules apply.")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		initUrl, err := parseURLArg(args)
+		if err != nil {
+			return err
+		}
+
+		var body []byte
+		source := initUrl.ResolveReference(&url.URL{Path: "/robots.txt"}).String()
+		if opts.RobotsFile != "" {
+			source = opts.RobotsFile
+			if body, err = ioutil.ReadFile(opts.RobotsFile); err != nil {
+				return err
+			}
+		} else {
+			transport, err := newSiteTransport(initUrl, *opts)
+			if err != nil {
+				return err
+			}
+			if body, err = fetchRobots(&http.Client{Transport: transport, CheckRedirect: checkRedirect}, initUrl); err != nil {
+				return err
+			}
+		}
+
+		robots := ParseRobots(body)
+		group := robots.Group(userAgent)
+		agent := "*"
+		if len(group.Agents) > 0 {
+			agent = group.Agents[0]
+		}
+		fmt.Printf("Rules of %s for %s: User-agent: %s, %d rules\n", source, userAgent, agent, len(group.Rules))
+		for _, path := range paths {
+			allowed, rule := robots.Allowed(userAgent, path)
+			switch {
+			case rule == nil:
+				fmt.Printf("- %s: allowed\n", path)
+			case allowed:
+				fmt.Printf("- %s: allowed by %s\n", path, rule)
+			default:
+				fmt.Printf("- %s: disallowed by %s\n", path, rule)
+			}
+		}
+		return nil
+	}
+
+	return cmd
+}
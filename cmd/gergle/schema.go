@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A SchemaExpectation requires the pages whose paths match its pattern to have
+// structured data of the schema.org type.
+type SchemaExpectation struct {
+	Rule    string
+	Pattern *regexp.Regexp
+	Type    string
+}
+
+// ParseSchemaExpectation reads an expectation of the form PATTERN=TYPE, where
+// PATTERN is a path in the style of a robots.txt rule, such as /blog/*=Article.
+func ParseSchemaExpectation(rule string) (SchemaExpectation, error) {
+	eq := strings.LastIndex(rule, "=")
+	if eq <= 0 || eq == len(rule)-1 {
+		return SchemaExpectation{}, fmt.Errorf("Expected --expect-schema of the form PATTERN=TYPE, got %q.", rule)
+	}
+	pattern, err := compileRobotsPattern(rule[:eq])
+	if err != nil {
+		return SchemaExpectation{}, err
+	}
+	return SchemaExpectation{rule, pattern, rule[eq+1:]}, nil
+}
+
+// StructuredDataReporter counts the pages with each schema.org type of
+// structured data, and lists the pages missing the types they're expected to
+// have, failing the crawl if there are any.
+type StructuredDataReporter struct {
+	Expectations []SchemaExpectation
+
+	types   map[string]int
+	missing map[string][]string
+	lock    sync.Mutex
+}
+
+func NewStructuredDataReporter(expectations ...SchemaExpectation) *StructuredDataReporter {
+	return &StructuredDataReporter{
+		Expectations: expectations,
+		types:        make(map[string]int),
+		missing:      make(map[string][]string),
+	}
+}
+
+func (s *StructuredDataReporter) Observe(page Page) {
+	if !page.Processed {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, t := range page.Schemas {
+		s.types[t]++
+	}
+	for _, expectation := range s.Expectations {
+		if !expectation.Pattern.MatchString(page.URL.Path) || containsString(page.Schemas, expectation.Type) {
+			continue
+		}
+		s.missing[expectation.Rule] = append(s.missing[expectation.Rule], page.URL.String())
+	}
+}
+
+// containsString reports whether the sorted strings include s.
+func containsString(sorted []string, s string) bool {
+	i := sort.SearchStrings(sorted, s)
+	return i < len(sorted) && sorted[i] == s
+}
+
+func (s *StructuredDataReporter) Report(w io.Writer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fmt.Fprintf(w, "Structured data: %d types\n", len(s.types))
+	printCounts(w, s.types)
+
+	for _, expectation := range s.Expectations {
+		pages := s.missing[expectation.Rule]
+		sort.Strings(pages)
+		fmt.Fprintf(w, "Missing %s structured data (%s): %d pages\n", expectation.Type, expectation.Rule, len(pages))
+		for _, page := range pages {
+			fmt.Fprintf(w, "- %s\n", page)
+		}
+	}
+}
+
+func (s *StructuredDataReporter) Failed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.missing) > 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SchemeReporter lists the pages with links of each of its schemes, such as
+// javascript: pseudo-links and ftp: downloads, which the crawl doesn't follow.
+type SchemeReporter struct {
+	Schemes []string
+
+	pages map[string]map[string]int
+	lock  sync.Mutex
+}
+
+func NewSchemeReporter(schemes ...string) *SchemeReporter {
+	pages := make(map[string]map[string]int)
+	for i, scheme := range schemes {
+		schemes[i] = strings.TrimSuffix(strings.ToLower(scheme), ":")
+		pages[schemes[i]] = make(map[string]int)
+	}
+	return &SchemeReporter{Schemes: schemes, pages: pages}
+}
+
+func (s *SchemeReporter) Observe(page Page) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, link := range page.NonHTTP {
+		if pages, found := s.pages[link.URL.Scheme]; found {
+			pages[page.URL.String()]++
+		}
+	}
+}
+
+func (s *SchemeReporter) Report(w io.Writer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, scheme := range s.Schemes {
+		pages := make([]string, 0, len(s.pages[scheme]))
+		for page := range s.pages[scheme] {
+			pages = append(pages, page)
+		}
+		sort.Strings(pages)
+
+		fmt.Fprintf(w, "Links to %s: URLs: %d pages\n", scheme, len(pages))
+		for _, page := range pages {
+			fmt.Fprintf(w, "- %s (%d links)\n", page, s.pages[scheme][page])
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SecurityHeaders are the response headers which every page of a site is
+// expected to set.
+var SecurityHeaders = []string{
+	"Content-Security-Policy",
+	"Strict-Transport-Security",
+	"X-Content-Type-Options",
+	"Referrer-Policy",
+	"X-Frame-Options",
+}
+
+// missingSecurityHeaders returns the SecurityHeaders missing from a response
+// of the page. Strict-Transport-Security is only expected over https, and
+// X-Frame-Options isn't needed when the Content-Security-Policy has a
+// frame-ancestors directive.
+func missingSecurityHeaders(page Page) (missing []string) {
+	for _, name := range SecurityHeaders {
+		if page.Header.Get(name) != "" {
+			continue
+		}
+		switch name {
+		case "Strict-Transport-Security":
+			if page.URL.Scheme != "https" {
+				continue
+			}
+		case "X-Frame-Options":
+			if strings.Contains(strings.ToLower(page.Header.Get("Content-Security-Policy")), "frame-ancestors") {
+				continue
+			}
+		}
+		missing = append(missing, name)
+	}
+	return
+}
+
+// SecurityHeaderReporter lists the HTML pages which are missing any of the
+// SecurityHeaders, with a count of the pages missing each. With Fail set, any
+// missing header fails the crawl.
+type SecurityHeaderReporter struct {
+	Fail bool
+
+	pages   int
+	missing map[string][]string
+	counts  map[string]int
+	lock    sync.Mutex
+}
+
+func NewSecurityHeaderReporter() *SecurityHeaderReporter {
+	return &SecurityHeaderReporter{missing: make(map[string][]string), counts: make(map[string]int)}
+}
+
+func (s *SecurityHeaderReporter) Observe(page Page) {
+	if page.StatusCode != http.StatusOK || !strings.Contains(page.MediaType, "html") {
+		return
+	}
+	missing := missingSecurityHeaders(page)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pages++
+	if len(missing) == 0 {
+		return
+	}
+	s.missing[page.URL.String()] = missing
+	for _, name := range missing {
+		s.counts[name]++
+	}
+}
+
+func (s *SecurityHeaderReporter) Report(w io.Writer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pages := make([]string, 0, len(s.missing))
+	for page := range s.missing {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	fmt.Fprintf(w, "Security headers: %d of %d pages missing headers\n", len(pages), s.pages)
+	printCounts(w, s.counts)
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s\n", page)
+		for _, name := range s.missing[page] {
+			fmt.Fprintf(w, "  - missing %s\n", name)
+		}
+	}
+}
+
+func (s *SecurityHeaderReporter) Failed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.Fail && len(s.missing) > 0
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/icio/gergle/crawlspb"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// newServeCommand returns the command which runs crawls on behalf of HTTP API
+// clients.
+func newServeCommand(opts *CrawlOptions) *cobra.Command {
+	var listen string
+	var grpcListen string
+	var maxJobs int
+	var maxConns int
+	var token string
+	var keepJobs int
+	var jobTTL time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve an HTTP API for starting, streaming and cancelling crawls.",
+		Long: `Serve an HTTP API for starting, streaming and cancelling crawls:
+
+  POST   /crawls             Start a crawl of {"url": "..."}, optionally with its
+                             own "depth", "connections", "delay", "disallow"
+                             and "webhook".
+  GET    /crawls             List all crawls.
+  GET    /crawls/ID          Get the status of a crawl.
+  GET    /crawls/ID/pages    Stream the pages of a crawl as server-sent events.
+  DELETE /crawls/ID          Cancel a crawl.
+
+Crawls use the crawl options given to serve unless they give their own. Each
+crawl has its own seen pages, rate-limiting and webhook, and archives to its
+own --warc file, named with the crawl's ID. Finished crawls are forgotten once
+they are older than --crawl-ttl, or are beyond the newest --keep-crawls.
+
+The API crawls and sends webhooks to whichever URLs its clients ask for, so it
+only listens on localhost unless --listen says otherwise. With --token, clients
+must send an "Authorization: Bearer TOKEN" header.
+
+With --grpc-listen, the same crawls are also served by the Crawls gRPC service
+of crawlspb/crawls.proto, for clients generated in other languages.`,
+		Args: cobra.NoArgs,
+	}
+	cmd.Flags().StringVarP(&listen, "listen", "", "127.0.0.1:8080", "The address to serve the API on.")
+	cmd.Flags().StringVarP(&grpcListen, "grpc-listen", "", "", "The address to serve the gRPC API on, alongside the HTTP API.")
+	cmd.Flags().IntVarP(&maxJobs, "max-jobs", "", 4, "Maximum number of crawls to run at once, queueing the rest.")
+	cmd.Flags().IntVarP(&maxConns, "max-connections", "", 0, "Maximum number of requests to make at once across all crawls.")
+	cmd.Flags().StringVarP(&token, "token", "", "", "The bearer token which clients of the API must present.")
+	cmd.Flags().IntVarP(&keepJobs, "keep-crawls", "", 100, "Maximum number of finished crawls to keep, forgetting the oldest, or 0 for no maximum.")
+	cmd.Flags().DurationVarP(&jobTTL, "crawl-ttl", "", 24*time.Hour, "How long to keep finished crawls for.")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if maxJobs < 1 {
+			return errors.New("--max-jobs must be at least 1.")
+		}
+		if keepJobs < 0 {
+			return errors.New("--keep-crawls must be at least 0.")
+		}
+		if jobTTL <= 0 {
+			return errors.New("--crawl-ttl must be positive.")
+		}
+		crawlOpts := *opts
+		if crawlOpts.Control != "" {
+			logger.Warn("Ignoring --control: crawls are controlled through the API")
+			crawlOpts.Control = ""
+		}
+		if crawlOpts.Coordinate != "" {
+			logger.Warn("Ignoring --coordinate: crawls can't share workers")
+			crawlOpts.Coordinate = ""
+		}
+		if crawlOpts.Redis != "" {
+			logger.Warn("Ignoring --redis: crawls can't share a frontier")
+			crawlOpts.Redis = ""
+		}
+		if maxConns > 0 {
+			crawlOpts.Slots = NewSemaphore(maxConns)
+		}
+
+		server := NewCrawlServer(crawlOpts)
+		server.Jobs = NewSemaphore(maxJobs)
+		server.Token = token
+		server.Keep = keepJobs
+		server.TTL = jobTTL
+		if token == "" && (!isLoopback(listen) || (grpcListen != "" && !isLoopback(grpcListen))) {
+			logger.Warn("Serving the API beyond localhost without a --token")
+		}
+		errs := make(chan error, 2)
+		if grpcListen != "" {
+			lis, err := net.Listen("tcp", grpcListen)
+			if err != nil {
+				return err
+			}
+			service := &CrawlService{Server: server}
+			grpcServer := grpc.NewServer(service.ServerOptions()...)
+			crawlspb.RegisterCrawlsServer(grpcServer, service)
+			logger.Info("Serving gRPC API", "listen", grpcListen)
+			go func() { errs <- grpcServer.Serve(lis) }()
+		}
+		logger.Info("Serving API", "listen", listen, "maxJobs", maxJobs, "maxConnections", maxConns)
+		go func() { errs <- http.ListenAndServe(listen, server) }()
+		return <-errs
+	}
+
+	return cmd
+}
+
+// A Job is a crawl started through the API. It is queued until the server
+// can run it.
+type Job struct {
+	ID      string
+	URL     *url.URL
+	Options CrawlOptions
+
+	control  *CrawlControl
+	state    string
+	err      error
+	started  time.Time
+	finished time.Time
+	pages    []JobPage
+	broken   int
+	// changed is closed, and replaced, whenever the job is updated.
+	changed chan struct{}
+	lock    sync.Mutex
+}
+
+// JobStatus is the API representation of a Job.
+type JobStatus struct {
+	ID       string     `json:"id"`
+	URL      string     `json:"url"`
+	State    string     `json:"state"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Pages    int        `json:"pages"`
+	Broken   int        `json:"broken"`
+}
+
+// JobPage is the API representation of a page crawled by a Job.
+type JobPage struct {
+	URL           string         `json:"url"`
+	Depth         uint16         `json:"depth"`
+	Links         int            `json:"links"`
+	Assets        int            `json:"assets"`
+	Accessibility *Accessibility `json:"accessibility,omitempty"`
+	SnapshotPage
+	// Labels are the --label pairs of the crawl, to tell the pages of many
+	// crawls apart once they are merged.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+func NewJobPage(page Page) JobPage {
+	return JobPage{
+		URL:           page.URL.String(),
+		Depth:         page.Depth,
+		Links:         len(page.Links),
+		Assets:        len(page.Assets),
+		Accessibility: page.Accessibility,
+		SnapshotPage:  NewSnapshotPage(page),
+	}
+}
+
+// JobRequest is the API request to start a Job, overriding the server's crawl
+// options with any of its own.
+type JobRequest struct {
+	URL         string   `json:"url"`
+	Depth       *uint16  `json:"depth"`
+	Connections *int     `json:"connections"`
+	Delay       *float64 `json:"delay"`
+	Disallow    []string `json:"disallow"`
+	Webhook     *string  `json:"webhook"`
+}
+
+// Options returns the crawl options of the job.
+func (r JobRequest) Options(opts CrawlOptions) CrawlOptions {
+	if r.Depth != nil {
+		opts.MaxDepth = *r.Depth
+	}
+	if r.Connections != nil {
+		opts.NumConns = *r.Connections
+	}
+	if r.Delay != nil {
+		opts.Delay = *r.Delay
+	}
+	if r.Disallow != nil {
+		opts.Disallow = r.Disallow
+	}
+	if r.Webhook != nil {
+		opts.WebhookURL = *r.Webhook
+	}
+	return opts
+}
+
+// run crawls once a slot is free, unless the job is cancelled first.
+func (j *Job) run(slots Semaphore) {
+	if slots != nil {
+		slots.Acquire()
+		defer slots.Release()
+	}
+
+	j.lock.Lock()
+	queued := j.state == "queued"
+	if !queued {
+		j.finish()
+	}
+	j.lock.Unlock()
+	if !queued {
+		return
+	}
+
+	opts := j.Options
+	opts.Referrers = NewReferrers()
+	pages, control, err := startCrawl(j.URL, opts)
+
+	j.lock.Lock()
+	if err != nil {
+		j.state = "failed"
+		j.err = err
+		j.finish()
+		j.lock.Unlock()
+		logger.Warn("Crawl failed to start", "id", j.ID, "url", j.URL, "error", err)
+		return
+	}
+	if j.state == "cancelled" {
+		control.Cancel()
+	} else {
+		j.state = "running"
+	}
+	j.control = control
+	j.notify()
+	j.lock.Unlock()
+	logger.Info("Crawl started", "id", j.ID, "url", j.URL)
+
+	for page := range pages {
+		j.lock.Lock()
+		j.pages = append(j.pages, NewJobPage(page))
+		if page.Broken() {
+			j.broken++
+		}
+		j.notify()
+		j.lock.Unlock()
+	}
+
+	j.lock.Lock()
+	j.addReferrers(opts.Referrers)
+	if j.state == "running" {
+		j.state = "complete"
+	}
+	j.finish()
+	j.lock.Unlock()
+	logger.Info("Crawl finished", "id", j.ID, "url", j.URL, "state", j.state)
+}
+
+// addReferrers completes the referrers of the job's pages which more pages
+// were found linking to after they were crawled. The lock must be held.
+func (j *Job) addReferrers(referrers *Referrers) {
+	pages := make(map[string]int, len(j.pages))
+	for i, page := range j.pages {
+		pages[page.URL] = i
+	}
+	for _, late := range referrers.Late() {
+		if i, found := pages[late.URL.String()]; found {
+			j.pages[i].Referrers = urlStrings(late.Referrers)
+		}
+	}
+}
+
+// finish marks the job finished. The lock must be held.
+func (j *Job) finish() {
+	j.finished = time.Now()
+	j.notify()
+}
+
+// notify wakes everyone waiting on the job. The lock must be held.
+func (j *Job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+func (j *Job) Cancel() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.state == "queued" || j.state == "running" {
+		j.state = "cancelled"
+	}
+	if j.control != nil {
+		j.control.Cancel()
+	}
+}
+
+func (j *Job) Status() JobStatus {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	status := JobStatus{
+		ID:      j.ID,
+		URL:     j.URL.String(),
+		State:   j.state,
+		Started: j.started,
+		Pages:   len(j.pages),
+		Broken:  j.broken,
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		status.Finished = &finished
+	}
+	if j.err != nil {
+		status.Error = j.err.Error()
+	}
+	return status
+}
+
+// finishedAt returns when the job finished, or zero if it hasn't.
+func (j *Job) finishedAt() time.Time {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.finished
+}
+
+// Pages returns the job's pages from the offset onwards, whether the job has
+// finished, and a channel which is closed once there is more to read.
+func (j *Job) Pages(offset int) ([]JobPage, bool, <-chan struct{}) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.pages[offset:], !j.finished.IsZero(), j.changed
+}
+
+// CrawlServer is the HTTP API for running crawls.
+type CrawlServer struct {
+	Options CrawlOptions
+	// Jobs, if set, caps the number of crawls running at once.
+	Jobs Semaphore
+	// Token, if set, is the bearer token which clients must present.
+	Token string
+	// Keep, if set, is the number of finished crawls to keep, forgetting the
+	// oldest beyond it.
+	Keep int
+	// TTL, if set, is how long finished crawls are kept for.
+	TTL time.Duration
+
+	jobs map[string]*Job
+	seq  int
+	lock sync.Mutex
+}
+
+func NewCrawlServer(opts CrawlOptions) *CrawlServer {
+	return &CrawlServer{Options: opts, jobs: make(map[string]*Job)}
+}
+
+func (s *CrawlServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.Authorized(r.Header.Get("Authorization")) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, errors.New("Expected an Authorization: Bearer token."))
+		return
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "crawls" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, errors.New("Not found."))
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case "GET":
+			s.listJobs(w)
+		case "POST":
+			s.startJob(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, errors.New("Expected GET or POST."))
+		}
+		return
+	}
+
+	job, found := s.Job(parts[1])
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No crawl %q.", parts[1]))
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && parts[2] == "pages" && r.Method == "GET":
+		streamPages(w, r, job)
+	case len(parts) == 2 && r.Method == "GET":
+		writeJSON(w, http.StatusOK, job.Status())
+	case len(parts) == 2 && r.Method == "DELETE":
+		job.Cancel()
+		writeJSON(w, http.StatusOK, job.Status())
+	default:
+		writeError(w, http.StatusNotFound, errors.New("Not found."))
+	}
+}
+
+// Authorized returns whether the Authorization header presents the server's
+// token, if it has one.
+func (s *CrawlServer) Authorized(header string) bool {
+	return bearerAuthorized(header, s.Token)
+}
+
+// bearerAuthorized returns whether the Authorization header presents the
+// bearer token, if there is one.
+func bearerAuthorized(header, token string) bool {
+	if token == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(header), []byte("Bearer "+token)) == 1
+}
+
+func (s *CrawlServer) listJobs(w http.ResponseWriter) {
+	s.lock.Lock()
+	s.prune()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	s.lock.Unlock()
+
+	statuses := make([]JobStatus, len(jobs))
+	for i, job := range jobs {
+		statuses[i] = job.Status()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Started.Before(statuses[j].Started) })
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *CrawlServer) startJob(w http.ResponseWriter, r *http.Request) {
+	var request JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Expected a JSON body: %s", err))
+		return
+	}
+	job, err := s.Start(request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Location", "/crawls/"+job.ID)
+	writeJSON(w, http.StatusCreated, job.Status())
+}
+
+// Start queues the crawl of the request, returning its job.
+func (s *CrawlServer) Start(request JobRequest) (*Job, error) {
+	initUrl, err := parseURLArg([]string{request.URL})
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	s.prune()
+	s.seq++
+	job := &Job{
+		ID:      strconv.Itoa(s.seq),
+		URL:     initUrl,
+		Options: request.Options(s.Options),
+		state:   "queued",
+		started: time.Now(),
+		changed: make(chan struct{}),
+	}
+	if job.Options.WARCFile != "" {
+		job.Options.WARCFile = jobFilename(job.Options.WARCFile, job.ID)
+	}
+	s.jobs[job.ID] = job
+	s.lock.Unlock()
+
+	logger.Info("Crawl queued", "id", job.ID, "url", job.URL)
+	go job.run(s.Jobs)
+	return job, nil
+}
+
+// Job returns the job of the ID, and whether there is one.
+func (s *CrawlServer) Job(id string) (*Job, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.prune()
+	job, found := s.jobs[id]
+	return job, found
+}
+
+// prune forgets the finished jobs which are older than the TTL, or beyond the
+// newest Keep of them. The lock must be held.
+func (s *CrawlServer) prune() {
+	type finishedJob struct {
+		id       string
+		finished time.Time
+	}
+	var finished []finishedJob
+	for id, job := range s.jobs {
+		if at := job.finishedAt(); !at.IsZero() {
+			finished = append(finished, finishedJob{id, at})
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].finished.After(finished[j].finished) })
+
+	for i, job := range finished {
+		if (s.Keep > 0 && i >= s.Keep) || (s.TTL > 0 && time.Since(job.finished) > s.TTL) {
+			delete(s.jobs, job.id)
+			logger.Debug("Crawl forgotten", "id", job.id)
+		}
+	}
+}
+
+// isLoopback returns whether the listen address only accepts connections
+// from the local host.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// jobFilename returns the job's own file of the server's output file, with
+// the job's ID ahead of its extension: crawl.warc.gz is crawl-1.warc.gz for
+// the first job.
+func jobFilename(filename, id string) string {
+	dir, base := filepath.Split(filename)
+	stem, ext := base, ""
+	if i := strings.Index(base, "."); i > 0 {
+		stem, ext = base[:i], base[i:]
+	}
+	return filepath.Join(dir, stem+"-"+id+ext)
+}
+
+// streamPages sends each of the job's pages as a server-sent "page" event,
+// followed by a "done" event with the job's status once it has finished.
+func streamPages(w http.ResponseWriter, r *http.Request, job *Job) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("Streaming unsupported."))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	offset := 0
+	for {
+		pages, finished, changed := job.Pages(offset)
+		for _, page := range pages {
+			data, _ := json.Marshal(page)
+			fmt.Fprintf(w, "event: page\ndata: %s\n\n", data)
+		}
+		offset += len(pages)
+
+		if finished {
+			data, _ := json.Marshal(job.Status())
+			fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
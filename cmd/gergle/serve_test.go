@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/icio/gergle/crawlspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrawlServer(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/a">A</a> <a href="/missing">Missing</a>`)
+		} else if r.URL.Path == "/a" {
+			fmt.Fprint(w, `<a href="/">Home</a>`)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	defer site.Close()
+
+	api := httptest.NewServer(NewCrawlServer(CrawlOptions{MaxDepth: 10, NumConns: 2, Delay: -1, ZeroBothers: true}))
+	defer api.Close()
+
+	resp, err := http.Post(api.URL+"/crawls", "application/json", strings.NewReader(`{"url": "`+site.URL+`/"}`))
+	if err != nil {
+		t.Fatalf("Failed to start crawl: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") != "/crawls/1" {
+		t.Fatalf("Expected crawl 1 to be created but got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	resp, err = http.Get(api.URL + "/crawls/1/pages")
+	if err != nil {
+		t.Fatalf("Failed to stream pages: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	events := string(body)
+	if pages := strings.Count(events, "event: page\n"); pages != 3 {
+		t.Errorf("Expected 3 page events but got %d: %s", pages, events)
+	}
+	if !strings.Contains(events, `"state":"complete","started"`) || !strings.Contains(events, `"pages":3,"broken":1`) {
+		t.Errorf("Expected a done event for the complete crawl but got: %s", events)
+	}
+
+	resp, err = http.Post(api.URL+"/crawls", "application/json", strings.NewReader(`{"url": "ftp://example.com/"}`))
+	if err != nil {
+		t.Fatalf("Failed to start crawl: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid URL to be rejected but got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(api.URL + "/crawls/2")
+	if err != nil {
+		t.Fatalf("Failed to get crawl: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected an unknown crawl to be not found but got %d", resp.StatusCode)
+	}
+}
+
+func TestCrawlServerQueue(t *testing.T) {
+	server := NewCrawlServer(CrawlOptions{MaxDepth: 10, NumConns: 1, Delay: -1, ZeroBothers: true})
+	server.Jobs = NewSemaphore(1)
+	api := httptest.NewServer(server)
+	defer api.Close()
+
+	// Occupy the only job slot, so that new jobs are queued.
+	server.Jobs.Acquire()
+	resp, err := http.Post(api.URL+"/crawls", "application/json", strings.NewReader(`{"url": "http://example.com/", "connections": 0}`))
+	if err != nil {
+		t.Fatalf("Failed to start crawl: %s", err)
+	}
+	resp.Body.Close()
+	if status := server.jobs["1"].Status(); status.State != "queued" {
+		t.Errorf("Expected the crawl to be queued but got %s", status.State)
+	}
+
+	// Once it runs, the job's own options are found to be invalid.
+	server.Jobs.Release()
+	resp, err = http.Get(api.URL + "/crawls/1/pages")
+	if err != nil {
+		t.Fatalf("Failed to stream pages: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `"state":"failed","error":"--connections must be at least 1."`) {
+		t.Errorf("Expected the crawl to fail but got: %s", body)
+	}
+}
+
+func TestCrawlService(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/a">A</a> <a href="/missing">Missing</a>`)
+		} else if r.URL.Path == "/a" {
+			fmt.Fprint(w, `<a href="/">Home</a>`)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	defer site.Close()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crawls := NewCrawlServer(CrawlOptions{MaxDepth: 10, NumConns: 2, Delay: -1, ZeroBothers: true})
+	crawls.Token = "secret"
+	service := &CrawlService{Server: crawls}
+	server := grpc.NewServer(service.ServerOptions()...)
+	crawlspb.RegisterCrawlsServer(server, service)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := crawlspb.NewCrawlsClient(conn)
+	ctx := context.Background()
+
+	if _, err := client.GetCrawl(ctx, &crawlspb.CrawlID{Id: "1"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected a call without the token to be unauthenticated but got %v", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+
+	depth := uint32(5)
+	crawl, err := client.StartCrawl(ctx, &crawlspb.CrawlRequest{Url: site.URL + "/", Depth: &depth})
+	if err != nil {
+		t.Fatalf("Failed to start crawl: %s", err)
+	}
+	if crawl.Id != "1" || crawl.State != "queued" {
+		t.Fatalf("Expected crawl 1 to be queued but got %v", crawl)
+	}
+
+	stream, err := client.StreamPages(ctx, &crawlspb.CrawlID{Id: crawl.Id})
+	if err != nil {
+		t.Fatalf("Failed to stream pages: %s", err)
+	}
+	pages, broken := 0, 0
+	for {
+		page, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Failed to stream pages: %s", err)
+		}
+		pages++
+		if page.Broken {
+			broken++
+			if page.Status != http.StatusNotFound {
+				t.Errorf("Expected the broken page to be not found but got %v", page)
+			}
+		}
+	}
+	if pages != 3 || broken != 1 {
+		t.Errorf("Expected 3 pages, 1 broken, but got %d pages, %d broken", pages, broken)
+	}
+
+	crawl, err = client.GetCrawl(ctx, &crawlspb.CrawlID{Id: "1"})
+	if err != nil {
+		t.Fatalf("Failed to get crawl: %s", err)
+	}
+	if crawl.State != "complete" || crawl.Pages != 3 || crawl.Broken != 1 || crawl.Finished == nil {
+		t.Errorf("Expected the crawl to be complete but got %v", crawl)
+	}
+
+	if _, err := client.StartCrawl(ctx, &crawlspb.CrawlRequest{Url: "ftp://example.com/"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected an invalid URL to be rejected but got %v", err)
+	}
+	if _, err := client.Cancel(ctx, &crawlspb.CrawlID{Id: "2"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected an unknown crawl to be not found but got %v", err)
+	}
+}
+
+func TestCrawlServerToken(t *testing.T) {
+	server := NewCrawlServer(CrawlOptions{})
+	server.Token = "secret"
+	api := httptest.NewServer(server)
+	defer api.Close()
+
+	for header, expected := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		req, _ := http.NewRequest("GET", api.URL+"/crawls", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("Expected Authorization %q to respond %d but got %d", header, expected, resp.StatusCode)
+		}
+	}
+}
+
+func TestCrawlServerPrune(t *testing.T) {
+	server := NewCrawlServer(CrawlOptions{})
+	server.Keep = 2
+	server.TTL = time.Hour
+	now := time.Now()
+	for id, finished := range map[string]time.Time{
+		"1": now.Add(-2 * time.Hour),
+		"2": now.Add(-3 * time.Minute),
+		"3": now.Add(-2 * time.Minute),
+		"4": {},
+		"5": now.Add(-1 * time.Minute),
+	} {
+		server.jobs[id] = &Job{ID: id, finished: finished, pages: []JobPage{{URL: "http://example.com/"}}}
+	}
+
+	for id, expected := range map[string]bool{"1": false, "2": false, "3": true, "4": true, "5": true} {
+		if _, found := server.Job(id); found != expected {
+			t.Errorf("Expected crawl %s to be found %v but got %v", id, expected, found)
+		}
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for addr, expected := range map[string]bool{
+		"127.0.0.1:8080": true,
+		"localhost:8080": true,
+		"[::1]:8080":     true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"10.0.0.1:8080":  false,
+	} {
+		if loopback := isLoopback(addr); loopback != expected {
+			t.Errorf("Expected isLoopback(%q) to be %v but got %v", addr, expected, loopback)
+		}
+	}
+}
+
+func TestJobFilename(t *testing.T) {
+	for filename, expected := range map[string]string{
+		"crawl.warc.gz":       "crawl-3.warc.gz",
+		"archive/crawl.warc":  "archive/crawl-3.warc",
+		"crawl":               "crawl-3",
+		"./archive/.hidden.x": "archive/.hidden.x-3",
+	} {
+		if actual := jobFilename(filename, "3"); actual != expected {
+			t.Errorf("Expected the job's file of %q to be %q, but got %q", filename, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATSSink publishes each crawled page as a JSON message, in the format of the
+// serve API, to a NATS subject, so that other systems can consume the crawl as
+// it happens. It speaks just enough of the NATS client protocol to publish.
+type NATSSink struct {
+	URL     *url.URL
+	Subject string
+	// Labels are attached to each page published.
+	Labels map[string]string
+
+	conn   net.Conn
+	writer *bufio.Writer
+	// err is the first error the server reported, if any.
+	err    error
+	pong   chan struct{}
+	closed chan struct{}
+	lock   sync.Mutex
+}
+
+// ParseSink returns the sink of a --sink URL, of the form
+// nats://[USER:PASS@]HOST[:PORT]/SUBJECT.
+func ParseSink(raw string) (*NATSSink, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "nats" || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("Expected --sink of the form nats://HOST[:PORT]/SUBJECT, got %q.", raw)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	return &NATSSink{URL: u, Subject: strings.Replace(strings.Trim(u.Path, "/"), "/", ".", -1)}, nil
+}
+
+// Dial connects to the NATS server, returning once it has accepted the
+// connection.
+func (n *NATSSink) Dial() error {
+	conn, err := net.DialTimeout("tcp", n.URL.Host, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	info, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("Expected NATS INFO, got %q", info)
+	}
+
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "gergle"}
+	if n.URL.User != nil {
+		options["user"] = n.URL.User.Username()
+		options["pass"], _ = n.URL.User.Password()
+	}
+	connect, _ := json.Marshal(options)
+	n.conn, n.writer = conn, bufio.NewWriter(conn)
+	n.pong, n.closed = make(chan struct{}, 1), make(chan struct{})
+	go n.read(reader)
+
+	// The server answers the PING only once it has processed the CONNECT.
+	fmt.Fprintf(n.writer, "CONNECT %s\r\nPING\r\n", connect)
+	if err := n.flush(); err != nil {
+		conn.Close()
+		return err
+	}
+	logger.Info("Publishing pages to NATS", "addr", n.URL.Host, "subject", n.Subject)
+	return nil
+}
+
+// read answers the server's PINGs and records its errors.
+func (n *NATSSink) read(reader *bufio.Reader) {
+	defer close(n.closed)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			n.fail(err)
+			return
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			n.lock.Lock()
+			n.writer.WriteString("PONG\r\n")
+			n.writer.Flush()
+			n.lock.Unlock()
+		case line == "PONG":
+			select {
+			case n.pong <- struct{}{}:
+			default:
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			n.fail(fmt.Errorf("NATS error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR"))))
+		}
+	}
+}
+
+func (n *NATSSink) fail(err error) {
+	n.lock.Lock()
+	if n.err == nil {
+		n.err = err
+	}
+	n.lock.Unlock()
+}
+
+// flush sends the buffered messages, waiting for the server to answer a PING
+// sent after them so that any error they caused is seen.
+func (n *NATSSink) flush() error {
+	n.lock.Lock()
+	n.writer.WriteString("PING\r\n")
+	err := n.writer.Flush()
+	n.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-n.pong:
+	case <-n.closed:
+	case <-time.After(10 * time.Second):
+		return errors.New("Timed out waiting for NATS")
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.err
+}
+
+// Write publishes the page, returning the first error the server reported.
+func (n *NATSSink) Write(page Page) error {
+	record := NewJobPage(page)
+	record.Labels = n.Labels
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	fmt.Fprintf(n.writer, "PUB %s %d\r\n", n.Subject, len(data))
+	n.writer.Write(data)
+	n.writer.WriteString("\r\n")
+	return n.writer.Flush()
+}
+
+// Close sends the remaining messages and disconnects.
+func (n *NATSSink) Close() error {
+	if n.conn == nil {
+		return nil
+	}
+	err := n.flush()
+	n.conn.Close()
+	n.conn = nil
+	return err
+}
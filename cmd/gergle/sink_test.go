@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNATSSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// The server records the subject and URL of each message published.
+	published := make(chan []string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {}\r\n"))
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(published)
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "PING":
+				conn.Write([]byte("PONG\r\n"))
+			case "PUB":
+				var n int
+				fmt.Sscan(fields[2], &n)
+				data := make([]byte, n+2)
+				io.ReadFull(reader, data)
+				var page JobPage
+				json.Unmarshal(data[:n], &page)
+				published <- []string{fields[1], page.URL}
+			}
+		}
+	}()
+
+	sink, err := ParseSink("nats://" + listener.Addr().String() + "/crawls/pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Dial(); err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	go func() {
+		for _, path := range []string{"/", "/a"} {
+			sink.Write(Page{URL: &url.URL{Scheme: "http", Host: "example.com", Path: path}, StatusCode: 200})
+		}
+		sink.Close()
+	}()
+
+	found := [][]string{}
+	for message := range published {
+		found = append(found, message)
+	}
+	expected := [][]string{{"crawls.pages", "http://example.com/"}, {"crawls.pages", "http://example.com/a"}}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected messages %v, got %v", expected, found)
+	}
+
+	for _, sink := range []string{"kafka://broker/topic", "nats://broker", "nats:///subject"} {
+		if _, err := ParseSink(sink); err == nil {
+			t.Errorf("Expected ParseSink(%q) to fail", sink)
+		}
+	}
+}
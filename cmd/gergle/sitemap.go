@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxSitemaps limits the number of sitemaps read through sitemap indexes.
+const maxSitemaps = 100
+
+var robotsTxtSitemapRegex = regexp.MustCompile("(?im)^\\s*Sitemap:\\s*(\\S+)")
+
+// readSitemapURLs extracts all of the Sitemap directives from a robots.txt body.
+func readSitemapURLs(body []byte) (hrefs []string) {
+	for _, match := range robotsTxtSitemapRegex.FindAllSubmatch(body, -1) {
+		hrefs = append(hrefs, string(match[1]))
+	}
+	return
+}
+
+// fetchSitemaps returns the URLs listed in the sitemaps of the website: those
+// given, or else those named by robots.txt, or else /sitemap.xml. Sitemap
+// indexes are followed to the sitemaps they list.
+func fetchSitemaps(client *http.Client, u *url.URL, hrefs []string) ([]string, error) {
+	if len(hrefs) == 0 {
+		if robots, err := fetchRobots(client, u); err == nil {
+			hrefs = readSitemapURLs(robots)
+		}
+	}
+	if len(hrefs) == 0 {
+		sitemapPath, _ := url.Parse("/sitemap.xml")
+		hrefs = []string{u.ResolveReference(sitemapPath).String()}
+	}
+
+	var locs []string
+	fetched := 0
+	for len(hrefs) > 0 && fetched < maxSitemaps {
+		href := hrefs[0]
+		hrefs = hrefs[1:]
+		fetched++
+
+		logger.Info("Fetching sitemap", "url", href)
+		body, err := fetchSitemap(client, href)
+		if err != nil {
+			return nil, err
+		}
+		index, entries, err := parseSitemap(body)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse sitemap %s: %s", href, err)
+		}
+		if index {
+			hrefs = append(hrefs, entries...)
+		} else {
+			locs = append(locs, entries...)
+		}
+	}
+	if len(hrefs) > 0 {
+		logger.Warn("Not reading all sitemaps", "max", maxSitemaps, "remaining", len(hrefs))
+	}
+	return locs, nil
+}
+
+// fetchSitemap gets the body of the sitemap, decompressing it if gzipped.
+func fetchSitemap(client *http.Client, href string) ([]byte, error) {
+	resp, err := client.Get(href)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Sitemap %s not found (%d)", href, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(reader)
+	}
+	return body, nil
+}
+
+// parseSitemap returns the <loc> entries of a sitemap, and whether it is a
+// sitemap index whose entries are themselves sitemaps.
+func parseSitemap(body []byte) (index bool, locs []string, err error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+
+	root := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return false, nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		name := strings.ToLower(start.Name.Local)
+		if root == "" {
+			root = name
+			if root != "urlset" && root != "sitemapindex" {
+				return false, nil, errors.New("expected <urlset> or <sitemapindex>")
+			}
+		} else if name == "loc" {
+			var loc string
+			if err := decoder.DecodeElement(&loc, &start); err != nil {
+				return false, nil, err
+			}
+			if loc = strings.TrimSpace(loc); loc != "" {
+				locs = append(locs, loc)
+			}
+		}
+	}
+	return root == "sitemapindex", locs, nil
+}
+
+// SitemapReporter compares the pages reached by following links against the
+// pages listed in the sitemap, listing the sitemap's orphan pages which no
+// link led to, and the crawled pages missing from the sitemap.
+type SitemapReporter struct {
+	sitemap map[string]string
+	crawled map[string]string
+	lock    sync.Mutex
+}
+
+func NewSitemapReporter(locs ...string) *SitemapReporter {
+	s := &SitemapReporter{
+		sitemap: make(map[string]string, len(locs)),
+		crawled: make(map[string]string),
+	}
+	for _, loc := range locs {
+		u, err := url.Parse(loc)
+		if err != nil {
+			logger.Debug("Failed to parse sitemap URL", "loc", loc)
+			continue
+		}
+		s.sitemap[sanitizeURL(u)] = loc
+	}
+	return s
+}
+
+func (s *SitemapReporter) Observe(page Page) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Error pages are still reached by links, but only real pages belong in
+	// the sitemap.
+	if page.Broken() || !page.Processed || page.NoIndex {
+		s.crawled[sanitizeURL(page.URL)] = ""
+	} else {
+		s.crawled[sanitizeURL(page.URL)] = page.URL.String()
+	}
+}
+
+func (s *SitemapReporter) Report(w io.Writer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	orphans := []string{}
+	for key, loc := range s.sitemap {
+		if _, crawled := s.crawled[key]; !crawled {
+			orphans = append(orphans, loc)
+		}
+	}
+	sort.Strings(orphans)
+
+	missing := []string{}
+	for key, href := range s.crawled {
+		if _, listed := s.sitemap[key]; !listed && href != "" {
+			missing = append(missing, href)
+		}
+	}
+	sort.Strings(missing)
+
+	fmt.Fprintf(w, "Orphan pages: %d pages\n", len(orphans))
+	for _, loc := range orphans {
+		fmt.Fprintf(w, "- %s\n", loc)
+	}
+	fmt.Fprintf(w, "Missing from sitemap: %d pages\n", len(missing))
+	for _, href := range missing {
+		fmt.Fprintf(w, "- %s\n", href)
+	}
+}
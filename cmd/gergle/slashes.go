@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// slashVariant returns the URL with its trailing slash removed, or added if it
+// has none. The root path has no variant.
+func slashVariant(u *url.URL) *url.URL {
+	if u.Path == "" || u.Path == "/" {
+		return nil
+	}
+	variant := *u
+	variant.RawPath = ""
+	variant.Fragment = ""
+	if strings.HasSuffix(u.Path, "/") {
+		variant.Path = strings.TrimSuffix(u.Path, "/")
+	} else {
+		variant.Path = u.Path + "/"
+	}
+	return &variant
+}
+
+// A slashProbe is the response to the trailing slash variant of a page.
+type slashProbe struct {
+	Page     string
+	Variant  string
+	Status   int
+	Location string
+	Err      error
+}
+
+// Direction returns "adds" or "removes" for variants which redirect to their
+// page, adding or removing the trailing slash, or "" otherwise.
+func (s *slashProbe) Direction() string {
+	if s.Status < 300 || s.Status >= 400 || s.Location != s.Page {
+		return ""
+	}
+	if strings.HasSuffix(s.Variant, "/") {
+		return "removes"
+	}
+	return "adds"
+}
+
+// probeSlashVariant requests the variant of the page without following
+// redirects, resolving the Location of any redirect.
+func probeSlashVariant(client *http.Client, page, variant *url.URL) slashProbe {
+	probe := slashProbe{Page: page.String(), Variant: variant.String()}
+	noRedirects := *client
+	noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	logger.Debug("Probing trailing slash", "url", probe.Variant)
+	resp, err := noRedirects.Get(probe.Variant)
+	if err != nil {
+		probe.Err = err
+		return probe
+	}
+	resp.Body.Close()
+	probe.Status = resp.StatusCode
+	if location, err := resp.Location(); err == nil {
+		probe.Location = location.String()
+	}
+	return probe
+}
+
+// TrailingSlashReporter probes the trailing slash variant of each page which
+// responded 200 once the crawl is complete, listing the paths which respond
+// 200 both with and without it, and those which redirect in the opposite
+// direction to most of the site, as each is a source of duplicate URLs.
+type TrailingSlashReporter struct {
+	Client *http.Client
+	// Workers is the number of variants to probe at once.
+	Workers int
+
+	pages map[string]*url.URL
+	lock  sync.Mutex
+}
+
+func NewTrailingSlashReporter(client *http.Client) *TrailingSlashReporter {
+	return &TrailingSlashReporter{Client: client, Workers: 1, pages: make(map[string]*url.URL)}
+}
+
+func (t *TrailingSlashReporter) Observe(page Page) {
+	if page.StatusCode != http.StatusOK || len(page.Redirects) > 0 || slashVariant(page.URL) == nil {
+		return
+	}
+
+	u := *page.URL
+	u.Fragment = ""
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.pages[graphKey(&u)] = &u
+}
+
+// probe returns the responses to the variants of the crawled pages, taking
+// those crawled themselves to respond 200 without probing them.
+func (t *TrailingSlashReporter) probe() []slashProbe {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	keys := make([]string, 0, len(t.pages))
+	for key := range t.pages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	logger.Info("Probing trailing slash variants", "count", len(keys))
+	probes := make([]slashProbe, len(keys))
+	probeEach(t.Workers, len(keys), func(i int) {
+		page := t.pages[keys[i]]
+		variant := slashVariant(page)
+		if _, crawled := t.pages[graphKey(variant)]; crawled {
+			probes[i] = slashProbe{Page: page.String(), Variant: variant.String(), Status: http.StatusOK}
+		} else {
+			probes[i] = probeSlashVariant(t.Client, page, variant)
+		}
+	})
+	return probes
+}
+
+func (t *TrailingSlashReporter) Report(w io.Writer) {
+	probes := t.probe()
+
+	directions := make(map[string]int)
+	for _, probe := range probes {
+		if direction := probe.Direction(); direction != "" {
+			directions[direction]++
+		}
+	}
+	// The site's policy is the direction most of its redirects take.
+	policy := "adds"
+	if directions["removes"] > directions["adds"] {
+		policy = "removes"
+	}
+
+	problems := make(map[string]string)
+	both := 0
+	for _, probe := range probes {
+		switch direction := probe.Direction(); {
+		case probe.Status == http.StatusOK:
+			// Each pair of pages is reported once, under the URL without
+			// the slash.
+			u, slashed := probe.Page, probe.Variant
+			if strings.HasSuffix(u, "/") {
+				u, slashed = slashed, u
+			}
+			if _, found := problems[u]; !found {
+				problems[u] = fmt.Sprintf("%s also responds 200", slashed)
+				both++
+			}
+		case direction != "" && direction != policy:
+			problems[probe.Variant] = fmt.Sprintf("redirects to %s, unlike most of the site", probe.Page)
+		case probe.Status >= 300 && probe.Status < 400 && direction == "":
+			problems[probe.Variant] = fmt.Sprintf("redirects to %s, not %s", probe.Location, probe.Page)
+		}
+	}
+
+	urls := make([]string, 0, len(problems))
+	for u := range problems {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	fmt.Fprintf(w, "Trailing slashes: %d redirects add the slash, %d remove it, %d pages respond 200 either way\n", directions["adds"], directions["removes"], both)
+	for _, u := range urls {
+		fmt.Fprintf(w, "- %s: %s\n", u, problems[u])
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// A Snapshot records the outcome of every page fetched during a crawl, so that
+// it can be compared against later crawls of the same site.
+type Snapshot struct {
+	Time  time.Time               `json:"time"`
+	Pages map[string]SnapshotPage `json:"pages"`
+}
+
+// SnapshotPage is the record of a single page within a Snapshot.
+type SnapshotPage struct {
+	StatusCode   int      `json:"status"`
+	Error        string   `json:"error,omitempty"`
+	Snippet      string   `json:"snippet,omitempty"`
+	Checksum     string   `json:"checksum,omitempty"`
+	ETag         string   `json:"etag,omitempty"`
+	LastModified string   `json:"last_modified,omitempty"`
+	CacheControl string   `json:"cache_control,omitempty"`
+	Expires      string   `json:"expires,omitempty"`
+	Broken       bool     `json:"broken,omitempty"`
+	Redirects    []string `json:"redirects,omitempty"`
+	Referrers    []string `json:"referrers,omitempty"`
+
+	// History is the page's changes of status code over the previous crawls,
+	// most recent last.
+	History []StatusChange `json:"history,omitempty"`
+}
+
+// maxStatusHistory is the number of status changes kept for each page.
+const maxStatusHistory = 20
+
+// A StatusChange records the status code of a page changing between the
+// crawl at Since and the one at Time.
+type StatusChange struct {
+	Since time.Time `json:"since"`
+	Time  time.Time `json:"time"`
+	From  int       `json:"from"`
+	To    int       `json:"to"`
+}
+
+func NewSnapshot() *Snapshot {
+	return &Snapshot{Time: clock(), Pages: make(map[string]SnapshotPage)}
+}
+
+// NewSnapshotPage returns the record of the crawled page.
+func NewSnapshotPage(page Page) SnapshotPage {
+	record := SnapshotPage{
+		StatusCode:   page.StatusCode,
+		Checksum:     page.Checksum,
+		ETag:         page.Header.Get("ETag"),
+		LastModified: page.Header.Get("Last-Modified"),
+		CacheControl: page.Header.Get("Cache-Control"),
+		Expires:      page.Header.Get("Expires"),
+		Broken:       page.Broken(),
+		Snippet:      page.Snippet,
+		Redirects:    urlStrings(page.Redirects),
+		Referrers:    urlStrings(page.Referrers),
+	}
+	if page.Error != nil {
+		record.Error = (*page.Error).Error()
+	}
+	return record
+}
+
+// Add records the crawled page in the snapshot.
+func (s *Snapshot) Add(page Page) {
+	s.Pages[page.URL.String()] = NewSnapshotPage(page)
+}
+
+// AddReferrers completes the referrers of the pages which more pages were
+// found linking to after they were crawled.
+func (s *Snapshot) AddReferrers(referrers *Referrers) {
+	for _, late := range referrers.Late() {
+		if page, found := s.Pages[late.URL.String()]; found {
+			page.Referrers = urlStrings(late.Referrers)
+			s.Pages[late.URL.String()] = page
+		}
+	}
+}
+
+// RecordHistory carries the status history of each page over from the
+// previous snapshot, recording the pages whose status code has changed since.
+func (s *Snapshot) RecordHistory(prev *Snapshot) {
+	for href, page := range s.Pages {
+		prevPage, found := prev.Pages[href]
+		if !found {
+			continue
+		}
+		page.History = append([]StatusChange{}, prevPage.History...)
+		if page.StatusCode != prevPage.StatusCode {
+			page.History = append(page.History, StatusChange{prev.Time, s.Time, prevPage.StatusCode, page.StatusCode})
+		}
+		if len(page.History) > maxStatusHistory {
+			page.History = page.History[len(page.History)-maxStatusHistory:]
+		}
+		if len(page.History) == 0 {
+			page.History = nil
+		}
+		s.Pages[href] = page
+	}
+}
+
+// Save writes the snapshot into the directory, named by the time it was taken.
+func (s *Snapshot) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(filepath.Join(dir, s.Time.UTC().Format("20060102T150405Z")+".json"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(s)
+}
+
+// LatestSnapshot reads the most recent snapshot saved into the directory, if
+// there is one.
+func LatestSnapshot(dir string) (*Snapshot, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	sort.Strings(files)
+	return ReadSnapshot(files[len(files)-1])
+}
+
+// ReadSnapshot reads the snapshot saved to the file.
+func ReadSnapshot(filename string) (*Snapshot, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	snapshot := &Snapshot{}
+	if err := json.NewDecoder(file).Decode(snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// SnapshotDiff lists the URLs which differ between two snapshots.
+type SnapshotDiff struct {
+	NewlyBroken []string `json:"newly_broken"`
+	Fixed       []string `json:"fixed"`
+	Status      []string `json:"status_changed"`
+	Changed     []string `json:"changed"`
+	Added       []string `json:"added"`
+	Removed     []string `json:"removed"`
+}
+
+func DiffSnapshots(prev, next *Snapshot) SnapshotDiff {
+	diff := SnapshotDiff{}
+
+	for href, page := range next.Pages {
+		prevPage, found := prev.Pages[href]
+		switch {
+		case !found:
+			diff.Added = append(diff.Added, href)
+			if page.Broken {
+				diff.NewlyBroken = append(diff.NewlyBroken, href)
+			}
+		case page.Broken && !prevPage.Broken:
+			diff.NewlyBroken = append(diff.NewlyBroken, href)
+		case !page.Broken && prevPage.Broken:
+			diff.Fixed = append(diff.Fixed, href)
+		case page.Checksum != prevPage.Checksum:
+			diff.Changed = append(diff.Changed, href)
+		}
+		if found && page.StatusCode != prevPage.StatusCode {
+			diff.Status = append(diff.Status, href)
+		}
+	}
+	for href := range prev.Pages {
+		if _, found := next.Pages[href]; !found {
+			diff.Removed = append(diff.Removed, href)
+		}
+	}
+
+	sort.Strings(diff.NewlyBroken)
+	sort.Strings(diff.Fixed)
+	sort.Strings(diff.Status)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// Empty reports whether the snapshots were equivalent.
+func (d SnapshotDiff) Empty() bool {
+	return len(d.NewlyBroken)+len(d.Fixed)+len(d.Status)+len(d.Changed)+len(d.Added)+len(d.Removed) == 0
+}
+
+func (d SnapshotDiff) Print(w io.Writer, next *Snapshot) {
+	for _, href := range d.NewlyBroken {
+		page := next.Pages[href]
+		fmt.Fprintf(w, "Broken: %s (%d %s)\n", href, page.StatusCode, page.Error)
+		for _, referrer := range page.Referrers {
+			fmt.Fprintf(w, "- referrer: %s\n", referrer)
+		}
+	}
+	for _, href := range d.Fixed {
+		fmt.Fprintf(w, "Fixed: %s\n", href)
+	}
+	for _, href := range d.Status {
+		page := next.Pages[href]
+		if len(page.History) == 0 {
+			fmt.Fprintf(w, "Status: %s now %d\n", href, page.StatusCode)
+			continue
+		}
+		change := page.History[len(page.History)-1]
+		fmt.Fprintf(w, "Status: %s %d to %d between %s and %s\n", href, change.From, change.To, change.Since.Format(time.RFC3339), change.Time.Format(time.RFC3339))
+		for _, earlier := range page.History[:len(page.History)-1] {
+			fmt.Fprintf(w, "- %d to %d between %s and %s\n", earlier.From, earlier.To, earlier.Since.Format(time.RFC3339), earlier.Time.Format(time.RFC3339))
+		}
+	}
+	for _, href := range d.Changed {
+		fmt.Fprintf(w, "Changed: %s\n", href)
+	}
+	for _, href := range d.Added {
+		fmt.Fprintf(w, "Added: %s\n", href)
+	}
+	for _, href := range d.Removed {
+		fmt.Fprintf(w, "Removed: %s\n", href)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	prev := NewSnapshot()
+	prev.Pages["/same"] = SnapshotPage{StatusCode: 200, Checksum: "a"}
+	prev.Pages["/edited"] = SnapshotPage{StatusCode: 200, Checksum: "a"}
+	prev.Pages["/breaks"] = SnapshotPage{StatusCode: 200, Checksum: "a"}
+	prev.Pages["/fixed"] = SnapshotPage{StatusCode: 500, Broken: true}
+	prev.Pages["/gone"] = SnapshotPage{StatusCode: 200, Checksum: "a"}
+
+	next := NewSnapshot()
+	next.Pages["/same"] = SnapshotPage{StatusCode: 200, Checksum: "a"}
+	next.Pages["/edited"] = SnapshotPage{StatusCode: 200, Checksum: "b"}
+	next.Pages["/breaks"] = SnapshotPage{StatusCode: 404, Broken: true}
+	next.Pages["/fixed"] = SnapshotPage{StatusCode: 200, Checksum: "a"}
+	next.Pages["/new"] = SnapshotPage{StatusCode: 200, Checksum: "a"}
+	next.Pages["/new-broken"] = SnapshotPage{StatusCode: 404, Broken: true}
+
+	diff := DiffSnapshots(prev, next)
+	expected := SnapshotDiff{
+		NewlyBroken: []string{"/breaks", "/new-broken"},
+		Fixed:       []string{"/fixed"},
+		Status:      []string{"/breaks", "/fixed"},
+		Changed:     []string{"/edited"},
+		Added:       []string{"/new", "/new-broken"},
+		Removed:     []string{"/gone"},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected diff %#v but got %#v", expected, diff)
+	}
+
+	if !DiffSnapshots(next, next).Empty() {
+		t.Error("Expected a snapshot to have no differences from itself.")
+	}
+}
+
+func TestSnapshotHistory(t *testing.T) {
+	first, second, third := NewSnapshot(), NewSnapshot(), NewSnapshot()
+	first.Time, second.Time, third.Time = deterministicTime, deterministicTime.Add(time.Hour), deterministicTime.Add(2*time.Hour)
+	first.Pages["/flaky"] = SnapshotPage{StatusCode: 200}
+	second.Pages["/flaky"] = SnapshotPage{StatusCode: 500, Broken: true}
+	second.Pages["/steady"] = SnapshotPage{StatusCode: 200}
+	third.Pages["/flaky"] = SnapshotPage{StatusCode: 404, Broken: true}
+	third.Pages["/steady"] = SnapshotPage{StatusCode: 200}
+
+	second.RecordHistory(first)
+	third.RecordHistory(second)
+	expected := []StatusChange{
+		{first.Time, second.Time, 200, 500},
+		{second.Time, third.Time, 500, 404},
+	}
+	if history := third.Pages["/flaky"].History; !reflect.DeepEqual(history, expected) {
+		t.Errorf("Expected status history %v but got %v", expected, history)
+	}
+	if history := third.Pages["/steady"].History; history != nil {
+		t.Errorf("Expected no status history of an unchanged page but got %v", history)
+	}
+
+	out := &bytes.Buffer{}
+	DiffSnapshots(second, third).Print(out, third)
+	expectedOutput := "Status: /flaky 500 to 404 between 2030-01-01T01:00:00Z and 2030-01-01T02:00:00Z\n" +
+		"- 200 to 500 between 2030-01-01T00:00:00Z and 2030-01-01T01:00:00Z\n"
+	if out.String() != expectedOutput {
+		t.Errorf("Expected diff %q but got %q", expectedOutput, out.String())
+	}
+}
+
+func TestSnapshotFetcher(t *testing.T) {
+	snapshot := NewSnapshot()
+	snapshot.Pages["http://example.com/"] = SnapshotPage{StatusCode: 200}
+	snapshot.Pages["http://example.com/b"] = SnapshotPage{StatusCode: 200, Referrers: []string{"http://example.com/"}}
+	snapshot.Pages["http://example.com/a"] = SnapshotPage{StatusCode: 200, Referrers: []string{"http://example.com/"}}
+	snapshot.Pages["http://example.com/private/c"] = SnapshotPage{StatusCode: 200, Referrers: []string{"http://example.com/a"}}
+	snapshot.Pages["http://example.com/a/d"] = SnapshotPage{StatusCode: 404, Broken: true, Referrers: []string{"http://example.com/a", "http://example.com/b"}}
+
+	initUrl, _ := url.Parse("http://example.com/")
+	crawler := &Crawler{
+		Fetcher:  NewSnapshotFetcher(snapshot),
+		Follower: UnanimousFollower{NewRobotsDisallowFollower("/private/"), NewUnseenFollower(initUrl)},
+		Frontier: NewPriorityFrontier(),
+		Workers:  1,
+	}
+	out := make(chan Page, 10)
+	go func() {
+		crawler.Crawl(initUrl, out)
+		close(out)
+	}()
+
+	crawled := []string{}
+	for page := range out {
+		crawled = append(crawled, page.URL.String())
+	}
+	expected := []string{"http://example.com/", "http://example.com/a", "http://example.com/b", "http://example.com/a/d"}
+	if !reflect.DeepEqual(crawled, expected) {
+		t.Errorf("Expected the snapshot to be replayed as %v but got %v", expected, crawled)
+	}
+}
+
+func TestReplayMakesNoRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected the replay to make no requests, but %s was requested", r.URL)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gergle-dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "about"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte(`<a href="/about/">About</a> <img src="/logo.png"> <link rel="icon" href="/icon.png">`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "about", "index.html"), []byte(`<meta property="og:image" content="/og.png"> <script src="/app.js"></script>`), 0644)
+
+	for _, opts := range []CrawlOptions{
+		{MaxDepth: 5, NumConns: 1, Delay: -1, DryRun: dir},
+		{MaxDepth: 5, NumConns: 1, Delay: -1, FromArchive: dir},
+	} {
+		opts.CollectImages, opts.CollectSocial, opts.CollectIcons = true, true, true
+		initUrl, _ := url.Parse(server.URL + "/")
+		probes := &ProbeTransport{}
+		opts.Probes = probes
+		probeClient := &http.Client{Transport: probes}
+		reporters := Reporters{
+			NewImageReporter(probeClient, 0),
+			NewAssetReporter(probeClient, 5),
+			NewSocialReporter(probeClient),
+			NewIconReporter(probeClient, initUrl),
+			NewWellKnownReporter(probeClient, initUrl),
+			NewTrailingSlashReporter(probeClient),
+		}
+		pages, _, err := startCrawl(initUrl, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crawled := 0
+		for page := range pages {
+			reporters.Observe(page)
+			crawled++
+		}
+		reporters.Report(ioutil.Discard)
+		if crawled != 2 {
+			t.Errorf("Expected the fixtures' 2 pages to be replayed by %+v, but got %d", opts, crawled)
+		}
+	}
+}
+
+func TestChangedOnlyFetcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" && r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="/new">New</a>`))
+	}))
+	defer server.Close()
+
+	snapshot := NewSnapshot()
+	snapshot.Pages[server.URL+"/"] = SnapshotPage{StatusCode: 200, Checksum: "abc", ETag: `"v1"`}
+	snapshot.Pages[server.URL+"/old"] = SnapshotPage{StatusCode: 200, Referrers: []string{server.URL + "/"}}
+	client := &http.Client{Transport: &ConditionalTransport{http.DefaultTransport, snapshot}}
+	fetcher := NewChangedOnlyFetcher(&HTTPFetcher{Client: client, Parser: &RegexPageParser{}}, snapshot)
+
+	u, _ := url.Parse(server.URL + "/")
+	page := fetcher.Fetch(&Task{URL: u})
+	if !page.Unchanged || page.StatusCode != 200 || page.Checksum != "abc" || len(page.Links) != 1 || page.Links[0].URL.Path != "/old" {
+		t.Errorf("Expected the unchanged page to be replayed from the snapshot, but got %+v", page)
+	}
+
+	u, _ = url.Parse(server.URL + "/other")
+	if page := fetcher.Fetch(&Task{URL: u}); page.Unchanged || len(page.Links) != 1 || page.Links[0].URL.Path != "/new" {
+		t.Errorf("Expected the new page to be fetched, but got %+v", page)
+	}
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	defer func(c func() time.Time) { clock = c }(clock)
+	clock = fixedClock(deterministicTime)
+
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("ETag", `"`+r.URL.Path+`"`)
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/a">A</a> <a href="/b">B</a> <a href="/c">C</a> <a href="/missing">Missing</a>`)
+		case "/a":
+			fmt.Fprint(w, `<a href="/b">B</a> <a href="/c">C</a> <img src="/logo.png">`)
+		case "/b":
+			fmt.Fprint(w, `<a href="/">Home</a> <a href="/a">A</a>`)
+		case "/c":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer site.Close()
+	initUrl, _ := url.Parse(site.URL + "/")
+
+	dir, err := ioutil.TempDir("", "gergle-deterministic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// crawl returns the pages and snapshot output by a deterministic crawl of
+	// the site.
+	crawl := func(run string) []byte {
+		opts := CrawlOptions{MaxDepth: 5, NumConns: 3, Delay: -1, Deterministic: true, Referrers: NewReferrers()}
+		pages, _, err := startCrawl(initUrl, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := NewPageWriter(filepath.Join(dir, run+".jsonl"), 0, 0)
+		snapshot := NewSnapshot()
+		for page := range pages {
+			output.Write(page)
+			snapshot.Add(page)
+		}
+		for _, late := range opts.Referrers.Late() {
+			output.WriteReferrers(late)
+		}
+		snapshot.AddReferrers(opts.Referrers)
+		if err := output.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := snapshot.Save(filepath.Join(dir, run)); err != nil {
+			t.Fatal(err)
+		}
+
+		pagesOut, err := ioutil.ReadFile(filepath.Join(dir, run+".jsonl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshotOut, err := ioutil.ReadFile(filepath.Join(dir, run, "20300101T000000Z.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(pagesOut, snapshotOut...)
+	}
+
+	first, second := crawl("first"), crawl("second")
+	if !bytes.Equal(first, second) {
+		t.Errorf("Expected deterministic crawls to output the same bytes, but got:\n%s\nand:\n%s", first, second)
+	}
+	if n := bytes.Count(first, []byte(`"url":`)); n < 5 {
+		t.Errorf("Expected the output to hold the fixture's pages, but got: %s", first)
+	}
+}
+
+func TestDeterministicClock(t *testing.T) {
+	defer func(c func() time.Time) { clock = c }(clock)
+	clock = fixedClock(deterministicTime)
+
+	if snapshot := NewSnapshot(); !snapshot.Time.Equal(deterministicTime) {
+		t.Errorf("Expected snapshot at %s but got %s", deterministicTime, snapshot.Time)
+	}
+	traps := NewTrapFollower()
+	if u, _ := url.Parse("http://example.com/events/2031-06"); !traps.Follow(&Link{URL: u}).Allowed {
+		t.Error("Expected dates shortly after the fixed clock not to be traps")
+	}
+}
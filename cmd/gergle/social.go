@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// requiredOpenGraph are the properties every page must have, per ogp.me.
+var requiredOpenGraph = []string{"og:title", "og:type", "og:image", "og:url"}
+
+// twitterCards are the valid values of twitter:card.
+var twitterCards = map[string]bool{"summary": true, "summary_large_image": true, "app": true, "player": true}
+
+// socialProblems returns the missing and invalid Open Graph and Twitter card
+// properties of the page.
+func socialProblems(social map[string]string) (problems []string) {
+	for _, property := range requiredOpenGraph {
+		if social[property] == "" {
+			problems = append(problems, "missing "+property)
+		}
+	}
+	for _, property := range []string{"og:url", "og:image"} {
+		if value := social[property]; value != "" && !isAbsoluteHTTP(value) {
+			problems = append(problems, fmt.Sprintf("invalid %s %q: not an absolute URL", property, value))
+		}
+	}
+
+	if card, found := social["twitter:card"]; !found {
+		problems = append(problems, "missing twitter:card")
+	} else if !twitterCards[card] {
+		problems = append(problems, fmt.Sprintf("invalid twitter:card %q", card))
+	}
+	return
+}
+
+// isAbsoluteHTTP reports whether the href is an absolute http or https URL.
+func isAbsoluteHTTP(href string) bool {
+	u, err := url.Parse(href)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// SocialReporter audits the Open Graph and Twitter card metadata of pages,
+// listing the pages missing required properties, those with invalid values,
+// and those whose og:image doesn't resolve. Images are probed once the crawl
+// is complete.
+type SocialReporter struct {
+	Client *http.Client
+	// Workers is the number of images to probe at once.
+	Workers int
+
+	problems map[string][]string
+	images   map[string][]string
+	lock     sync.Mutex
+}
+
+func NewSocialReporter(client *http.Client) *SocialReporter {
+	return &SocialReporter{
+		Client:   client,
+		Workers:  4,
+		problems: make(map[string][]string),
+		images:   make(map[string][]string),
+	}
+}
+
+func (s *SocialReporter) Observe(page Page) {
+	if !page.Processed {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if problems := socialProblems(page.Social); len(problems) > 0 {
+		s.problems[page.URL.String()] = problems
+	}
+	if image := page.Social["og:image"]; isAbsoluteHTTP(image) {
+		s.images[image] = append(s.images[image], page.URL.String())
+	}
+}
+
+func (s *SocialReporter) Report(w io.Writer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hrefs := make([]string, 0, len(s.images))
+	for href := range s.images {
+		hrefs = append(hrefs, href)
+	}
+	logger.Info("Probing og:images", "count", len(hrefs))
+	_, errs := probeImages(s.Client, s.Workers, hrefs)
+
+	problems := make(map[string][]string, len(s.problems))
+	for page, pageProblems := range s.problems {
+		problems[page] = append(problems[page], pageProblems...)
+	}
+	for href, err := range errs {
+		for _, page := range s.images[href] {
+			problems[page] = append(problems[page], fmt.Sprintf("broken og:image %s: %s", href, err))
+		}
+	}
+
+	pages := make([]string, 0, len(problems))
+	for page := range problems {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	fmt.Fprintf(w, "Social metadata problems: %d pages\n", len(pages))
+	for _, page := range pages {
+		fmt.Fprintf(w, "- %s\n", page)
+		for _, problem := range problems[page] {
+			fmt.Fprintf(w, "  - %s\n", problem)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// soft404Similarity is how alike the words of a page must be to those of the
+// missing page for it to be considered a soft 404.
+const soft404Similarity = 0.9
+
+// A Soft404Fingerprint describes how the server responds to a page which
+// doesn't exist, when it doesn't respond 404.
+type Soft404Fingerprint struct {
+	// Redirect is where the missing page redirected to, if it did.
+	Redirect *url.URL
+	// Words are those of the missing page, if it wasn't a redirect.
+	Words map[string]bool
+}
+
+// ProbeSoft404 fetches a random page, which shouldn't exist, from the website
+// and fingerprints the response. It returns nil if the server responds with
+// an error, as it ought to. The fetcher must extract page text.
+func ProbeSoft404(fetcher Fetcher, initUrl *url.URL) (*Soft404Fingerprint, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	probePath, _ := url.Parse(fmt.Sprintf("/gergle-missing-%x", random))
+	probeUrl := initUrl.ResolveReference(probePath)
+
+	logger.Info("Fetching missing page", "url", probeUrl)
+	page := fetcher.Fetch(&Task{URL: probeUrl})
+	if page.StatusCode != 200 {
+		if page.StatusCode == 0 && page.Error != nil {
+			return nil, *page.Error
+		}
+		logger.Info("Missing page returned an error, as expected", "status", page.StatusCode)
+		return nil, nil
+	}
+
+	if len(page.Redirects) > 0 {
+		return &Soft404Fingerprint{Redirect: page.Redirects[len(page.Redirects)-1]}, nil
+	}
+	if !page.Processed {
+		logger.Info("Missing page isn't HTML, so can't be fingerprinted")
+		return nil, nil
+	}
+	return &Soft404Fingerprint{Words: pageWords(page.Text, probeUrl)}, nil
+}
+
+// Matches reports whether the page looks like the missing page.
+func (f *Soft404Fingerprint) Matches(page Page) bool {
+	if page.StatusCode != 200 || !page.Processed {
+		return false
+	}
+	if f.Redirect != nil {
+		return len(page.Redirects) > 0 && page.Redirects[len(page.Redirects)-1].String() == f.Redirect.String()
+	}
+	return similarity(f.Words, pageWords(page.Text, page.URL)) >= soft404Similarity
+}
+
+var wordRegex = regexp.MustCompile(`\w+`)
+
+// pageWords returns the set of lower-case words in the text of the page,
+// excluding those of its URL, which missing pages often mention.
+func pageWords(text string, u *url.URL) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range wordRegex.FindAllString(strings.ToLower(text), -1) {
+		words[word] = true
+	}
+	for _, word := range wordRegex.FindAllString(strings.ToLower(u.String()), -1) {
+		delete(words, word)
+	}
+	return words
+}
+
+// similarity returns the Jaccard index of the two sets of words.
+func similarity(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	common := 0
+	for word := range a {
+		if b[word] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// Soft404Reporter lists the pages which respond 200 but look like the
+// website's response to a missing page.
+type Soft404Reporter struct {
+	Fingerprint *Soft404Fingerprint
+
+	pages []string
+	lock  sync.Mutex
+}
+
+func NewSoft404Reporter(fingerprint *Soft404Fingerprint) *Soft404Reporter {
+	return &Soft404Reporter{Fingerprint: fingerprint}
+}
+
+func (s *Soft404Reporter) Observe(page Page) {
+	if !s.Fingerprint.Matches(page) {
+		return
+	}
+	s.lock.Lock()
+	s.pages = append(s.pages, page.URL.String())
+	s.lock.Unlock()
+}
+
+func (s *Soft404Reporter) Report(w io.Writer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sort.Strings(s.pages)
+	fmt.Fprintf(w, "Soft 404s: %d pages\n", len(s.pages))
+	for _, page := range s.pages {
+		fmt.Fprintf(w, "- %s\n", page)
+	}
+}
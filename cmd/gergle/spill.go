@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+)
+
+// spilledTask is the representation of a task on disk.
+type spilledTask struct {
+	URL        string `json:"url"`
+	Depth      uint16 `json:"depth"`
+	Referrer   string `json:"referrer,omitempty"`
+	Frame      bool   `json:"frame,omitempty"`
+	Pagination uint16 `json:"pagination,omitempty"`
+	Unfollowed string `json:"unfollowed,omitempty"`
+	Priority   int    `json:"priority"`
+	Seq        uint64 `json:"seq"`
+}
+
+// taskSpill is a first-in, first-out queue of tasks held in a temporary file.
+// Its temporary file is created by the first Push.
+type taskSpill struct {
+	file     *os.File
+	readFile *os.File
+	writer   *bufio.Writer
+	reader   *bufio.Reader
+	head     *prioritisedTask
+	pending  int
+	// read is the offset of the end of the last record read whole.
+	read int64
+}
+
+func (s *taskSpill) Push(task prioritisedTask) error {
+	if s.file == nil {
+		file, err := ioutil.TempFile("", "gergle-frontier-")
+		if err != nil {
+			return err
+		}
+		reader, err := os.Open(file.Name())
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return err
+		}
+		logger.Debug("Spilling tasks to disk", "file", file.Name(), "priority", task.priority)
+		s.file, s.readFile = file, reader
+		s.writer, s.reader = bufio.NewWriter(file), bufio.NewReader(reader)
+	}
+
+	record := spilledTask{
+		URL:        task.URL.String(),
+		Depth:      task.Depth,
+		Frame:      task.Frame,
+		Pagination: task.Pagination,
+		Unfollowed: task.Unfollowed,
+		Priority:   task.priority,
+		Seq:        task.seq,
+	}
+	if task.Referrer != nil {
+		record.Referrer = task.Referrer.String()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.pending++
+	return nil
+}
+
+// Peek returns the next task of the queue without removing it.
+func (s *taskSpill) Peek() (prioritisedTask, error) {
+	if s.head != nil {
+		return *s.head, nil
+	}
+
+	if err := s.writer.Flush(); err != nil {
+		return prioritisedTask{}, err
+	}
+	line, err := s.reader.ReadBytes('\n')
+	if err != nil {
+		// Leave the record to be read again, rather than lose its task.
+		s.rewind()
+		return prioritisedTask{}, err
+	}
+	record := spilledTask{}
+	if err := json.Unmarshal(line, &record); err != nil {
+		s.rewind()
+		return prioritisedTask{}, err
+	}
+	task := prioritisedTask{Task{Depth: record.Depth, Frame: record.Frame, Pagination: record.Pagination, Unfollowed: record.Unfollowed}, record.Priority, record.Seq}
+	if task.URL, err = url.Parse(record.URL); err != nil {
+		s.rewind()
+		return prioritisedTask{}, err
+	}
+	if record.Referrer != "" {
+		if task.Referrer, err = url.Parse(record.Referrer); err != nil {
+			s.rewind()
+			return prioritisedTask{}, err
+		}
+	}
+	s.read += int64(len(line))
+	s.head = &task
+	return task, nil
+}
+
+// rewind reopens the temporary file at the start of the next record, so that
+// the next Peek reads it afresh.
+func (s *taskSpill) rewind() {
+	reader, err := os.Open(s.file.Name())
+	if err == nil {
+		_, err = reader.Seek(s.read, io.SeekStart)
+	}
+	if err != nil {
+		logger.Warn("Failed to reopen spilled tasks", "file", s.file.Name(), "error", err)
+		if reader != nil {
+			reader.Close()
+		}
+		return
+	}
+	s.readFile.Close()
+	s.readFile = reader
+	s.reader.Reset(reader)
+}
+
+// Pop removes the next task from the queue.
+func (s *taskSpill) Pop() (prioritisedTask, error) {
+	task, err := s.Peek()
+	if err != nil {
+		return task, err
+	}
+	s.head = nil
+	s.pending--
+	return task, nil
+}
+
+// Len returns the number of tasks in the queue.
+func (s *taskSpill) Len() int {
+	return s.pending
+}
+
+// Close removes the queue's temporary file.
+func (s *taskSpill) Close() {
+	if s.file == nil {
+		return
+	}
+	s.file.Close()
+	s.readFile.Close()
+	os.Remove(s.file.Name())
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// StatsReporter counts the pages of each language, media type and template.
+type StatsReporter struct {
+	languages  map[string]int
+	mediaTypes map[string]int
+	templates  map[string][]string
+	lock       sync.Mutex
+}
+
+func NewStatsReporter() *StatsReporter {
+	return &StatsReporter{
+		languages:  make(map[string]int),
+		mediaTypes: make(map[string]int),
+		templates:  make(map[string][]string),
+	}
+}
+
+func (s *StatsReporter) Observe(page Page) {
+	if page.StatusCode == 0 {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if page.MediaType != "" {
+		s.mediaTypes[page.MediaType]++
+	}
+	if page.Processed {
+		language := page.Language
+		if language == "" {
+			language = "unknown"
+		}
+		s.languages[language]++
+	}
+	if page.Template != "" {
+		s.templates[page.Template] = append(s.templates[page.Template], page.URL.String())
+	}
+}
+
+func (s *StatsReporter) Report(w io.Writer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fmt.Fprintf(w, "Languages: %d languages\n", len(s.languages))
+	printCounts(w, s.languages)
+	fmt.Fprintf(w, "Media types: %d types\n", len(s.mediaTypes))
+	printCounts(w, s.mediaTypes)
+
+	templates := make(map[string]int, len(s.templates))
+	for template, pages := range s.templates {
+		sort.Strings(pages)
+		templates[template+" (e.g. "+pages[0]+")"] = len(pages)
+	}
+	fmt.Fprintf(w, "Templates: %d templates\n", len(templates))
+	printCounts(w, templates)
+}
+
+// printCounts lists the counts, most common first.
+func printCounts(w io.Writer, counts map[string]int) {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "- %s: %d pages\n", key, counts[key])
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// streamHTML calls fn with each start and end tag read from the page, like
+// tokenizeHTML, but holding no more than a single tag of the page in memory.
+func streamHTML(r io.Reader, fn func(htmlToken)) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	for {
+		if err := skipPast(br, []byte("<")); err != nil {
+			return ignoreEOF(err)
+		}
+		next, err := br.Peek(3)
+		if len(next) == 0 {
+			return ignoreEOF(err)
+		}
+
+		switch c := next[0]; {
+		case bytes.HasPrefix(next, []byte("!--")):
+			br.Discard(3)
+			if err := skipPast(br, []byte("-->")); err != nil {
+				return ignoreEOF(err)
+			}
+		case c == '!' || c == '?':
+			if err := skipPast(br, []byte(">")); err != nil {
+				return ignoreEOF(err)
+			}
+		case c == '/' || isASCIILetter(c):
+			tag, err := readStreamedTag(br)
+			if err != nil {
+				return ignoreEOF(err)
+			}
+			token, n := readTag(tag)
+			if n == 0 {
+				continue
+			}
+			fn(token)
+			if !token.End && rawTextElements[token.Tag] {
+				if err := skipPast(br, []byte("</"+token.Tag)); err != nil {
+					return ignoreEOF(err)
+				}
+				fn(htmlToken{Tag: token.Tag, End: true})
+			}
+		}
+	}
+}
+
+// skipPast discards the page up to and including the next case-insensitive
+// occurrence of the delimiter.
+func skipPast(br *bufio.Reader, delim []byte) error {
+	window := make([]byte, 0, len(delim))
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if len(window) == len(delim) {
+			window = append(window[:0], window[1:]...)
+		}
+		window = append(window, c)
+		if bytes.EqualFold(window, delim) {
+			return nil
+		}
+	}
+}
+
+// readStreamedTag reads the rest of a tag whose "<" has already been read, up
+// to the first ">" outside of a quoted attribute value, returning it whole.
+func readStreamedTag(br *bufio.Reader) ([]byte, error) {
+	tag := []byte{'<'}
+	var quote, last byte
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		tag = append(tag, c)
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && last == '=':
+			quote = c
+		case c == '>':
+			return tag, nil
+		}
+		if !isHTMLSpace(c) {
+			last = c
+		}
+	}
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// byteCounter counts the bytes written to it.
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// parseStream finds the links, assets and frames of an HTML page as its body
+// is read, for pages too large to be held in memory. The rest of the page's
+// details, which need the whole body, are left empty.
+func (r *RegexPageParser) parseStream(task *Task, resp *http.Response, body io.Reader) Page {
+	hash := sha1.New()
+	var size byteCounter
+	base, seenBase := resp.Request.URL, false
+	page := Page{URL: task.URL, Depth: task.Depth}
+
+	tagRules := r.tagAssetRules()
+	open := openElements{}
+	err := streamHTML(io.TeeReader(body, io.MultiWriter(hash, &size)), func(token htmlToken) {
+		if token.End {
+			open.Pop(token.Tag)
+			return
+		}
+		open.Push(token.Tag)
+
+		switch token.Tag {
+		case "base":
+			// Only the first <base> counts, and it must come before any links.
+			if href, found := token.Attrs["href"]; found && !seenBase {
+				seenBase = true
+				if baseUrl, err := url.Parse(href); err == nil {
+					base = resp.Request.URL.ResolveReference(baseUrl)
+				}
+			}
+		case "a":
+			if href, found := token.Attrs["href"]; found {
+				link, err := AnchorLink(href, base, task.Depth+1)
+				if err != nil {
+					logger.Debug("Failed to parse href", "href", href)
+				} else if link.IsHTTP() {
+					link.Region = innermostRegion(open)
+					page.Links = append(page.Links, link)
+				}
+			}
+		case "frame", "iframe":
+			if src, found := token.Attrs["src"]; found {
+				frame, err := AssetLink(token.Tag, src, base, task.Depth)
+				if err != nil {
+					logger.Debug("Failed to parse frame source", "src", src)
+				} else {
+					page.Links = append(page.Links, frame)
+				}
+			}
+		}
+		if tagRules[token.Tag] != nil {
+			page.Assets = append(page.Assets, elementAssets(tagRules[token.Tag], token.Tag, token.Attrs, base, task.Depth+1)...)
+		}
+	})
+	if err != nil {
+		logger.Warn("Failed to read body", "url", task.URL)
+		return ErrorPage(task.URL, task.Depth, err)
+	}
+
+	page.Processed = true
+	page.Size = int(size)
+	page.Checksum = fmt.Sprintf("%x", hash.Sum(nil))
+	return page
+}
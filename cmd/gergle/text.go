@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// isPlainText reports whether responses of the Content-Type are plain text or
+// Markdown, whose links are the URLs written within them.
+func isPlainText(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/plain" || strings.Contains(mediaType, "markdown")
+}
+
+// isMarkdown reports whether the plain text response is Markdown, by its
+// Content-Type or, for servers which serve it as text/plain, its extension.
+func isMarkdown(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch strings.ToLower(path.Ext(resp.Request.URL.Path)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return strings.Contains(mediaType, "markdown")
+}
+
+var (
+	textURLRegex           = regexp.MustCompile("https?://[^\\s<>\"'`]+")
+	markdownCodeRegex      = regexp.MustCompile("(?ms)^ {0,3}```.*?^ {0,3}```|^ {0,3}~~~.*?^ {0,3}~~~|`[^`\n]+`")
+	markdownLinkRegex      = regexp.MustCompile("(!?)\\[[^\\]\n]*\\]\\(\\s*<?([^\\s)>]+)>?(?:\\s+[\"'(][^)]*)?\\)")
+	markdownReferenceRegex = regexp.MustCompile("(?m)^ {0,3}\\[[^\\]\n]+\\]:\\s*<?([^\\s>]+)>?")
+)
+
+// textURLs returns the http and https URLs written in plain text, without the
+// punctuation which tends to follow them in prose.
+func textURLs(body []byte) (hrefs []string) {
+	for _, match := range textURLRegex.FindAll(body, -1) {
+		href := string(match)
+		for {
+			trimmed := strings.TrimRight(href, ".,;:!?'\"")
+			if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, "(") < strings.Count(trimmed, ")") {
+				trimmed = trimmed[:len(trimmed)-1]
+			}
+			if trimmed == href {
+				break
+			}
+			href = trimmed
+		}
+		hrefs = append(hrefs, href)
+	}
+	return
+}
+
+// markdownLinks returns the hrefs of the links and the srcs of the images of
+// a Markdown document, including its reference definitions and bare URLs, but
+// not those in its code.
+func markdownLinks(body []byte) (hrefs []string, srcs []string) {
+	body = markdownCodeRegex.ReplaceAll(body, nil)
+	for _, match := range markdownLinkRegex.FindAllSubmatch(body, -1) {
+		if len(match[1]) > 0 {
+			srcs = append(srcs, string(match[2]))
+		} else {
+			hrefs = append(hrefs, string(match[2]))
+		}
+	}
+	body = markdownLinkRegex.ReplaceAll(body, nil)
+	for _, match := range markdownReferenceRegex.FindAllSubmatch(body, -1) {
+		hrefs = append(hrefs, string(match[1]))
+	}
+	body = markdownReferenceRegex.ReplaceAll(body, nil)
+	return append(hrefs, textURLs(body)...), srcs
+}
+
+// parseText returns the Page describing a plain text or Markdown document,
+// linking to each of the URLs written within it.
+func parseText(task *Task, resp *http.Response, body []byte) Page {
+	page := Page{
+		URL:       task.URL,
+		Checksum:  fmt.Sprintf("%x", sha1.Sum(body)),
+		Processed: true,
+		Depth:     task.Depth,
+		Size:      len(body),
+		Links:     []*Link{},
+		Assets:    []*Link{},
+		Endpoints: []*Link{},
+	}
+
+	var hrefs, srcs []string
+	if isMarkdown(resp) {
+		hrefs, srcs = markdownLinks(body)
+	} else {
+		hrefs = textURLs(body)
+	}
+
+	seen := map[string]bool{}
+	for _, href := range hrefs {
+		link, err := AnchorLink(href, resp.Request.URL, task.Depth+1)
+		if err != nil {
+			logger.Debug("Failed to parse text link", "href", href)
+			continue
+		}
+		if !seen[link.URL.String()] {
+			seen[link.URL.String()] = true
+			page.Links = append(page.Links, link)
+		}
+	}
+	for _, src := range srcs {
+		asset, err := AssetLink("img", src, resp.Request.URL, task.Depth+1)
+		if err != nil {
+			logger.Debug("Failed to parse text image", "src", src)
+			continue
+		}
+		page.Assets = append(page.Assets, asset)
+	}
+	return page
+}
+
+// parseText reads the links of a plain text or Markdown document, unless its
+// X-Robots-Tag asks that they not be followed.
+func (r *RegexPageParser) parseText(task *Task, resp *http.Response) Page {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Warn("Failed to read body", "url", task.URL)
+		return ErrorPage(task.URL, task.Depth, err)
+	}
+
+	page := parseText(task, resp, body)
+	page.Matches = r.grep(body)
+	page.NoIndex, page.NoFollow = readRobotsTag(resp.Header["X-Robots-Tag"])
+	if page.NoFollow && !r.IgnoreRobotsTag {
+		logger.Debug("Not extracting links from nofollow text", "url", task.URL)
+		page.Links = []*Link{}
+	}
+	return page
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TLSReporter records the TLS connection details of each host crawled, and
+// warns of certificates which are invalid or expire soon.
+type TLSReporter struct {
+	// ExpiryWarning is how far ahead of their expiry to warn of certificates.
+	ExpiryWarning time.Duration
+
+	hosts    map[string]*tls.ConnectionState
+	failures map[string]error
+	lock     sync.Mutex
+}
+
+func NewTLSReporter(expiryWarning time.Duration) *TLSReporter {
+	return &TLSReporter{
+		ExpiryWarning: expiryWarning,
+		hosts:         make(map[string]*tls.ConnectionState),
+		failures:      make(map[string]error),
+	}
+}
+
+func (t *TLSReporter) Observe(page Page) {
+	if page.URL.Scheme != "https" {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	host := page.URL.Host
+	if page.TLS != nil {
+		if _, seen := t.hosts[host]; !seen {
+			t.hosts[host] = page.TLS
+		}
+	} else if page.Error != nil && isCertificateError(*page.Error) {
+		if _, seen := t.failures[host]; !seen {
+			t.failures[host] = *page.Error
+		}
+	}
+}
+
+// isCertificateError reports whether the fetch failed because the server's
+// certificate couldn't be verified.
+func isCertificateError(err error) bool {
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var unknownErr x509.UnknownAuthorityError
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) || errors.As(err, &unknownErr)
+}
+
+func (t *TLSReporter) Report(w io.Writer) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	hosts := make([]string, 0, len(t.hosts))
+	for host := range t.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	fmt.Fprintln(w, "TLS:")
+	var warnings []string
+	now := clock()
+	for _, host := range hosts {
+		state := t.hosts[host]
+		fmt.Fprintf(w, "- %s: %s, %s\n", host, tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+		for _, cert := range state.PeerCertificates {
+			fmt.Fprintf(w, "  - %s (expires %s)\n", cert.Subject, cert.NotAfter.Format("2006-01-02"))
+		}
+
+		if len(state.PeerCertificates) == 0 {
+			continue
+		}
+		leaf := state.PeerCertificates[0]
+		hostname := host
+		if u, err := url.Parse("//" + host); err == nil {
+			hostname = u.Hostname()
+		}
+		if err := leaf.VerifyHostname(hostname); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", host, err))
+		}
+		if remaining := leaf.NotAfter.Sub(now); remaining < t.ExpiryWarning {
+			warnings = append(warnings, fmt.Sprintf("%s: certificate expires in %d days", host, int(remaining.Hours()/24)))
+		}
+	}
+
+	failed := make([]string, 0, len(t.failures))
+	for host := range t.failures {
+		failed = append(failed, host)
+	}
+	sort.Strings(failed)
+	for _, host := range failed {
+		warnings = append(warnings, fmt.Sprintf("%s: %s", host, t.failures[host]))
+	}
+
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "Warning: %s\n", warning)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// newTransport prepares the HTTP transport used for the crawl, according to
+// the user's connection preferences.
+func newTransport(opts CrawlOptions) (*http.Transport, error) {
+	connectTo := ConnectTo{}
+	for _, rule := range opts.Resolve {
+		if err := connectTo.AddResolve(rule); err != nil {
+			return nil, err
+		}
+	}
+	for _, rule := range opts.ConnectTo {
+		if err := connectTo.AddConnectTo(rule); err != nil {
+			return nil, err
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: opts.KeepAlive}
+	var dial dialContextFunc = dialer.DialContext
+	if opts.DNSServer != "" || opts.DNSTimeout > 0 {
+		logger.Info("Using custom DNS resolution", "server", opts.DNSServer, "timeout", opts.DNSTimeout)
+		dial = NewDNSDialer(dialer, opts.DNSServer, opts.DNSTimeout).DialContext
+	}
+	if len(connectTo) > 0 {
+		logger.Info("Overriding connection addresses", "connect-to", connectTo)
+		dial = connectTo.DialContext(dial)
+	}
+
+	if opts.IPv4 && opts.IPv6 {
+		return nil, errors.New("--ipv4 and --ipv6 are mutually exclusive options.")
+	} else if opts.IPv4 {
+		logger.Info("Connecting over IPv4 only")
+		dial = dialNetwork("tcp4", dial)
+	} else if opts.IPv6 {
+		logger.Info("Connecting over IPv6 only")
+		dial = dialNetwork("tcp6", dial)
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dial,
+		MaxIdleConnsPerHost: opts.NumConns,
+		MaxConnsPerHost:     opts.NumConns,
+		IdleConnTimeout:     opts.IdleConnTimeout,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" || opts.ClientKey == "" {
+			return nil, errors.New("--client-cert and --client-key must be given together.")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load client certificate: %s", err)
+		}
+		logger.Info("Presenting client certificate", "cert", opts.ClientCert)
+		transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	return transport, nil
+}
+
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dialNetwork wraps the dial function to constrain TCP connections to the
+// given network, such as tcp4 or tcp6.
+func dialNetwork(tcpNetwork string, dial dialContextFunc) dialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp" {
+			network = tcpNetwork
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// ConnectTo maps the host:port addresses which would be connected to onto
+// other addresses, like curl's --resolve and --connect-to options. Requests
+// still carry the original Host header and TLS server name.
+type ConnectTo map[string]string
+
+// AddResolve adds a rule of the form HOST:PORT:ADDR, connecting to ADDR in
+// place of HOST on the given PORT.
+func (c ConnectTo) AddResolve(rule string) error {
+	parts := strings.SplitN(rule, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return fmt.Errorf("Expected --resolve of the form HOST:PORT:ADDR, got %q.", rule)
+	}
+	addr := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+	c[net.JoinHostPort(parts[0], parts[1])] = net.JoinHostPort(addr, parts[1])
+	return nil
+}
+
+// AddConnectTo adds a rule of the form HOST1:PORT1:HOST2:PORT2, connecting
+// to HOST2:PORT2 in place of HOST1:PORT1. An empty HOST2 or PORT2 keeps that
+// of HOST1:PORT1.
+func (c ConnectTo) AddConnectTo(rule string) error {
+	parts := strings.SplitN(rule, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("Expected --connect-to of the form HOST1:PORT1:HOST2:PORT2, got %q.", rule)
+	}
+	host, port, err := net.SplitHostPort(parts[2])
+	if err != nil {
+		return fmt.Errorf("Expected --connect-to of the form HOST1:PORT1:HOST2:PORT2, got %q.", rule)
+	}
+	if host == "" {
+		host = parts[0]
+	}
+	if port == "" {
+		port = parts[1]
+	}
+	c[net.JoinHostPort(parts[0], parts[1])] = net.JoinHostPort(host, port)
+	return nil
+}
+
+// DialContext wraps the dial function to connect to the overridden addresses.
+func (c ConnectTo) DialContext(dial dialContextFunc) dialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if override, found := c[addr]; found {
+			logger.Debug("Connecting to overridden address", "addr", addr, "connect-to", override)
+			addr = override
+		}
+		return dial(ctx, network, addr)
+	}
+}
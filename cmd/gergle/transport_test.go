@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnectTo(t *testing.T) {
+	c := ConnectTo{}
+	for _, rule := range []string{"example.com:443:127.0.0.1", "example.com:80:[::1]"} {
+		if err := c.AddResolve(rule); err != nil {
+			t.Errorf("AddResolve(%q) should not return error: %s", rule, err)
+		}
+	}
+	for _, rule := range []string{"www.example.com:443:staging:8443", "api.example.com:80:localhost:", "cdn.example.com:443::8443"} {
+		if err := c.AddConnectTo(rule); err != nil {
+			t.Errorf("AddConnectTo(%q) should not return error: %s", rule, err)
+		}
+	}
+
+	expected := ConnectTo{
+		"example.com:443":     "127.0.0.1:443",
+		"example.com:80":      "[::1]:80",
+		"www.example.com:443": "staging:8443",
+		"api.example.com:80":  "localhost:80",
+		"cdn.example.com:443": "cdn.example.com:8443",
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("Expected %v but got %v", expected, c)
+	}
+
+	if c.AddResolve("example.com:443") == nil {
+		t.Error("AddResolve should return an error for rules without an address.")
+	}
+	if c.AddConnectTo("example.com:443:staging") == nil {
+		t.Error("AddConnectTo should return an error for rules without a port.")
+	}
+}
+
+func TestTransportProxy(t *testing.T) {
+	transport, err := newTransport(CrawlOptions{NumConns: 1, Resolve: []string{"example.com:443:127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.Proxy == nil {
+		t.Error("Expected the transport to use the HTTP_PROXY and HTTPS_PROXY environment variables")
+	}
+}
+
+func TestProbeTransport(t *testing.T) {
+	requested := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+	}))
+	defer server.Close()
+
+	probes := &ProbeTransport{}
+	client := &http.Client{Transport: probes}
+	if _, err := client.Get(server.URL + "/"); err == nil {
+		t.Error("Expected probes to fail before the crawl hands over its transport")
+	}
+
+	site, _ := url.Parse(server.URL + "/")
+	probes.Follow(http.DefaultTransport, site, 20*time.Millisecond, NewRobotsDisallowFollower("/private"))
+	if _, err := client.Get(server.URL + "/private/logo.png"); err == nil {
+		t.Error("Expected the disallowed path not to be probed")
+	}
+	start := time.Now()
+	for _, path := range []string{"/a.png", "/b.png", "/c.png"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if took := time.Since(start); took < 40*time.Millisecond {
+		t.Errorf("Expected the probes to wait the delay between requests, but 3 took %s", took)
+	}
+	if !reflect.DeepEqual(requested, []string{"/a.png", "/b.png", "/c.png"}) {
+		t.Errorf("Expected only the allowed paths to be requested, but got %v", requested)
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	heads := 0
+	conns := 0
+	lock := sync.Mutex{}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		if r.Method == "HEAD" {
+			heads++
+		}
+		lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			lock.Lock()
+			conns++
+			lock.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	transport, err := newTransport(CrawlOptions{NumConns: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	initUrl, _ := url.Parse(server.URL + "/")
+	urls := warmUpURLs(initUrl, []string{"*.example.com"})
+	warmUp(transport, urls, 3)
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL + "/page")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if heads != 3 || conns != 3 {
+		t.Errorf("Expected 3 HEAD requests to open 3 connections for the crawl to reuse, but got %d requests and %d connections", heads, conns)
+	}
+}
+
+func TestAuthTransport(t *testing.T) {
+	issued := 0
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		issued++
+		fmt.Fprintf(w, `{"access_token": "token%d", "token_type": "bearer", "expires_in": 3600}`, issued)
+	}))
+	defer tokens.Close()
+
+	// The site has revoked the first token it was given.
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer site.Close()
+	siteUrl, _ := url.Parse(site.URL)
+
+	client := &http.Client{Transport: &AuthTransport{
+		Transport: http.DefaultTransport,
+		Host:      siteUrl.Host,
+		Tokens:    &ClientCredentials{TokenURL: tokens.URL, ClientID: "client", ClientSecret: "secret", Client: http.DefaultClient},
+	}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(site.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected request %d to be authorised with a fresh token, but got %d", i, resp.StatusCode)
+		}
+	}
+	if issued != 2 {
+		t.Errorf("Expected 2 tokens to be issued, but got %d", issued)
+	}
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("Expected no token to be sent to other hosts, but got %q", r.Header.Get("Authorization"))
+		}
+	}))
+	defer other.Close()
+	resp, err := client.Get(other.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gergle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gergle"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "gergle" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	transport, err := newTransport(CrawlOptions{ClientCert: certFile, ClientKey: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.TLSClientConfig.RootCAs = x509.NewCertPool()
+	transport.TLSClientConfig.RootCAs.AddCert(server.Certificate())
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the client certificate to be accepted, but got %d", resp.StatusCode)
+	}
+
+	if _, err := newTransport(CrawlOptions{ClientCert: certFile}); err == nil {
+		t.Error("Expected an error for --client-cert without --client-key")
+	}
+}
+
+func TestReadCookiesTxt(t *testing.T) {
+	body := []byte(`# Netscape HTTP Cookie File
+.example.com	TRUE	/	FALSE	0	theme	dark
+#HttpOnly_www.example.com	FALSE	/account	TRUE	4102444800	session	abc123
+www.example.com	FALSE	/	FALSE	1	expired	gone
+`)
+	jar, _ := cookiejar.New(nil)
+	count, err := readCookiesTxt(jar, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 cookies to be read, but got %d", count)
+	}
+
+	for href, expected := range map[string]string{
+		"https://www.example.com/account/": "session=abc123; theme=dark",
+		"http://www.example.com/account/":  "theme=dark",
+		"http://static.example.com/":       "theme=dark",
+		"http://other.com/":                "",
+	} {
+		u, _ := url.Parse(href)
+		req := &http.Request{Header: http.Header{}}
+		for _, cookie := range jar.Cookies(u) {
+			req.AddCookie(cookie)
+		}
+		if actual := req.Header.Get("Cookie"); actual != expected {
+			t.Errorf("Expected cookies %q for %s but got %q", expected, href, actual)
+		}
+	}
+
+	if _, err := readCookiesTxt(jar, []byte("example.com\tTRUE\n")); err == nil {
+		t.Error("Expected an error for a malformed cookies file")
+	}
+}
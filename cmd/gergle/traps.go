@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trapFutureYears is how far in the future a date in a URL must be for the
+// URL to be considered part of an endless calendar.
+const trapFutureYears = 2
+
+var urlDateRegex = regexp.MustCompile(`(?:^|[^\d])((?:19|20|21)\d\d)[-/](0[1-9]|1[0-2])(?:[^\d]|$)`)
+var urlYearRegex = regexp.MustCompile(`(?i)[?&](?:year|yr|y)=(\d{4})(?:&|$)`)
+
+// detectTrap returns why the URL looks like part of a crawler trap, which
+// would otherwise have the crawl follow generated links without end, or ""
+// if it doesn't.
+func detectTrap(u *url.URL, now time.Time) string {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if cycle := repeatingSegments(segments); cycle != "" {
+		return "repeating path " + cycle
+	}
+
+	future := now.AddDate(trapFutureYears, 0, 0)
+	for _, match := range urlDateRegex.FindAllStringSubmatch(u.Path+"?"+u.RawQuery, -1) {
+		year, _ := strconv.Atoi(match[1])
+		month, _ := strconv.Atoi(match[2])
+		if time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC).After(future) {
+			return "calendar date far in the future"
+		}
+	}
+	if match := urlYearRegex.FindStringSubmatch("?" + u.RawQuery); match != nil {
+		if year, _ := strconv.Atoi(match[1]); year > future.Year() {
+			return "calendar date far in the future"
+		}
+	}
+	return ""
+}
+
+// repeatingSegments returns the cycle of path segments which repeats back to
+// back, such as /a/b of /a/b/a/b, or "" if there is none. Single segments must
+// repeat three times, since some sites have paths like /news/news.
+func repeatingSegments(segments []string) string {
+	for size := 1; size <= len(segments)/2; size++ {
+		repeats := 2
+		if size == 1 {
+			repeats = 3
+		}
+		for start := 0; start+size*repeats <= len(segments); start++ {
+			cycle := segments[start : start+size]
+			repeated := true
+			for r := 1; r < repeats && repeated; r++ {
+				next := segments[start+r*size : start+(r+1)*size]
+				repeated = strings.Join(cycle, "/") == strings.Join(next, "/")
+			}
+			if repeated {
+				return "/" + strings.Join(cycle, "/")
+			}
+		}
+	}
+	return ""
+}
+
+// TrapFollower doesn't follow links which look like part of a crawler trap,
+// such as endless calendars and relative links which resolve deeper on every
+// page, recording the traps it finds.
+type TrapFollower struct {
+	counts   map[string]int
+	examples map[string]string
+	lock     sync.Mutex
+}
+
+func NewTrapFollower() *TrapFollower {
+	return &TrapFollower{counts: make(map[string]int), examples: make(map[string]string)}
+}
+
+func (t *TrapFollower) Follow(link *Link) FollowDecision {
+	reason := detectTrap(link.URL, clock())
+	if reason == "" {
+		return Allow
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.counts[reason] == 0 {
+		logger.Info("Avoiding URL trap", "reason", reason, "url", link.URL)
+		t.examples[reason] = link.URL.String()
+	}
+	t.counts[reason]++
+	return Deny("trap", "URL trap: %s", reason)
+}
+
+// TrapReporter lists the crawler traps avoided by its TrapFollower, if there
+// were any.
+type TrapReporter struct {
+	Traps *TrapFollower
+}
+
+func NewTrapReporter(traps *TrapFollower) *TrapReporter {
+	return &TrapReporter{traps}
+}
+
+func (t *TrapReporter) Observe(page Page) {}
+
+func (t *TrapReporter) Report(w io.Writer) {
+	t.Traps.lock.Lock()
+	defer t.Traps.lock.Unlock()
+	if len(t.Traps.counts) == 0 {
+		return
+	}
+
+	reasons := make([]string, 0, len(t.Traps.counts))
+	for reason := range t.Traps.counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	fmt.Fprintf(w, "URL traps: %d traps\n", len(reasons))
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "- %s: %d links not followed, e.g. %s\n", reason, t.Traps.counts[reason], t.Traps.examples[reason])
+	}
+}
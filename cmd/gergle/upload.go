@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxParts is the most parts S3 allows a multipart upload to have.
+const maxParts = 10000
+
+// Uploader puts the files of a crawl into an S3 bucket, or a Google Cloud
+// Storage bucket through its S3-compatible API, signing its requests with
+// AWS Signature Version 4.
+type Uploader struct {
+	Endpoint *url.URL
+	Bucket   string
+	Prefix   string
+	Region   string
+	// VirtualHost puts the bucket in the hostname of requests, rather than
+	// in their path.
+	VirtualHost bool
+	// PartSize is the size of the parts which files larger than it are
+	// uploaded in, as a single PUT can't be larger than 5GB. It grows for
+	// files which would need more than 10,000 parts.
+	PartSize int64
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Client          *http.Client
+}
+
+// ParseUploadURL returns the uploader of an --upload URL of the form
+// s3://BUCKET/PREFIX or gs://BUCKET/PREFIX, taking its credentials from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+// variables, and the S3 region from AWS_REGION. AWS_ENDPOINT_URL replaces the
+// service's endpoint, such as for S3-compatible storage.
+func ParseUploadURL(raw string) (*Uploader, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "s3" && u.Scheme != "gs") || u.Host == "" {
+		return nil, fmt.Errorf("Expected --upload of the form s3://BUCKET/PREFIX or gs://BUCKET/PREFIX, got %q.", raw)
+	}
+	uploader := &Uploader{
+		Bucket:          u.Host,
+		Prefix:          strings.Trim(u.Path, "/"),
+		Region:          os.Getenv("AWS_REGION"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		PartSize:        64 << 20,
+		Client:          &http.Client{},
+	}
+	if uploader.AccessKeyID == "" || uploader.SecretAccessKey == "" {
+		return nil, errors.New("--upload requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.")
+	}
+	if uploader.Region == "" {
+		uploader.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	switch {
+	case endpoint != "":
+	case u.Scheme == "gs":
+		endpoint = "https://storage.googleapis.com"
+		uploader.Region = "auto"
+	default:
+		if uploader.Region == "" {
+			uploader.Region = "us-east-1"
+		}
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", uploader.Region)
+		// The certificate of s3.REGION.amazonaws.com only covers a single
+		// label in front of it, so buckets with dots in their names are put
+		// in the path.
+		uploader.VirtualHost = !strings.Contains(uploader.Bucket, ".")
+	}
+	if uploader.Endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("Invalid AWS_ENDPOINT_URL %q: %s", endpoint, err)
+	}
+	if uploader.Region == "" {
+		uploader.Region = "us-east-1"
+	}
+	return uploader, nil
+}
+
+// Location returns the bucket and key of the named object.
+func (u *Uploader) Location(name string) string {
+	return fmt.Sprintf("%s/%s", u.Bucket, path.Join(u.Prefix, name))
+}
+
+// uploadCrawl uploads the report of a crawl as report.txt, along with the
+// files it wrote.
+func uploadCrawl(uploader *Uploader, report []byte, files []string) error {
+	if err := uploader.Upload("report.txt", report); err != nil {
+		return err
+	}
+	for _, filename := range files {
+		if err := uploader.UploadFile(filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectURL returns the URL of the request for the named object.
+func (u *Uploader) objectURL(name string) *url.URL {
+	object := *u.Endpoint
+	key := path.Join(u.Prefix, name)
+	if u.VirtualHost {
+		object.Host = u.Bucket + "." + object.Host
+		object.Path = "/" + key
+	} else {
+		object.Path = path.Join("/", object.Path, u.Bucket, key)
+	}
+	object.RawPath = awsEscapePath(object.Path)
+	return &object
+}
+
+// UploadFile uploads the file as the object of its base name, streaming it
+// from disk, in parts if it is larger than the PartSize.
+func (u *Uploader) UploadFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if u.PartSize > 0 && info.Size() > u.PartSize {
+		return u.putParts(filepath.Base(filename), file, info.Size())
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return u.put(filepath.Base(filename), file, size, hex.EncodeToString(hash.Sum(nil)))
+}
+
+// Upload uploads the data as the named object.
+func (u *Uploader) Upload(name string, data []byte) error {
+	hash := sha256.Sum256(data)
+	return u.put(name, bytes.NewReader(data), int64(len(data)), hex.EncodeToString(hash[:]))
+}
+
+func (u *Uploader) put(name string, body io.Reader, size int64, payloadHash string) error {
+	logger.Info("Uploading", "name", name, "to", u.Location(name), "size", size)
+	resp, err := u.do("PUT", name, nil, body, size, payloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// putParts uploads the file as the named object with a multipart upload,
+// aborting the upload if any of its parts fail.
+func (u *Uploader) putParts(name string, file io.ReaderAt, size int64) error {
+	partSize := u.PartSize
+	if parts := (size + partSize - 1) / partSize; parts > maxParts {
+		partSize = (size + maxParts - 1) / maxParts
+	}
+
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := u.doXML("POST", name, url.Values{"uploads": {""}}, nil, &initiated); err != nil {
+		return err
+	}
+	logger.Info("Uploading in parts", "name", name, "to", u.Location(name), "size", size, "partSize", partSize)
+
+	complete := completeMultipartUpload{}
+	for offset := int64(0); offset < size; offset += partSize {
+		n := partSize
+		if offset+n > size {
+			n = size - offset
+		}
+		number := len(complete.Parts) + 1
+		etag, err := u.putPart(name, initiated.UploadID, number, io.NewSectionReader(file, offset, n), n)
+		if err != nil {
+			u.abort(name, initiated.UploadID)
+			return err
+		}
+		complete.Parts = append(complete.Parts, completedPart{number, etag})
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	if err := u.doXML("POST", name, url.Values{"uploadId": {initiated.UploadID}}, body, nil); err != nil {
+		u.abort(name, initiated.UploadID)
+		return err
+	}
+	return nil
+}
+
+// putPart uploads a part of a multipart upload, returning its ETag.
+func (u *Uploader) putPart(name, uploadID string, number int, part *io.SectionReader, size int64) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, part); err != nil {
+		return "", err
+	}
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	resp, err := u.do("PUT", name, query, part, size, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+// abort abandons a multipart upload, so that its parts aren't kept.
+func (u *Uploader) abort(name, uploadID string) {
+	resp, err := u.do("DELETE", name, url.Values{"uploadId": {uploadID}}, nil, 0, emptySHA256)
+	if err != nil {
+		logger.Warn("Failed to abort upload", "name", name, "error", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// completeMultipartUpload is the request body which completes a multipart
+// upload from its parts.
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// emptySHA256 is the payload hash of requests without a body.
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// doXML makes a request of the named object with the body, decoding the XML
+// it responds with into result, if set. S3 can respond 200 with an error, so
+// the response is checked for one.
+func (u *Uploader) doXML(method, name string, query url.Values, body []byte, result interface{}) error {
+	hash := sha256.Sum256(body)
+	resp, err := u.do(method, name, query, bytes.NewReader(body), int64(len(body)), hex.EncodeToString(hash[:]))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var failure struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(data, &failure) == nil {
+		return fmt.Errorf("Failed to upload %s: %s: %s", u.Location(name), failure.Code, failure.Message)
+	}
+	if result != nil {
+		if err := xml.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("Failed to upload %s: %s", u.Location(name), err)
+		}
+	}
+	return nil
+}
+
+// do makes a signed request of the named object, returning an error unless
+// it responds 2xx.
+func (u *Uploader) do(method, name string, query url.Values, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	object := u.objectURL(name)
+	object.RawQuery = awsEscapeQuery(query)
+	req, err := http.NewRequest(method, object.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	u.sign(req, payloadHash, time.Now())
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("Failed to upload %s: %s: %s", u.Location(name), resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// sign adds the AWS Signature Version 4 of the request to its headers.
+func (u *Uploader) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if u.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", u.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, u.Region)
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := fmt.Sprintf("AWS4-HMAC-SHA256\n%s\n%s\n%s", amzDate, scope, hex.EncodeToString(canonicalHash[:]))
+
+	key := hmacSHA256([]byte("AWS4"+u.SecretAccessKey), date)
+	for _, part := range []string{u.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", u.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEscapeQuery returns the canonical query string of the values, sorted by
+// name and percent-encoded as AWS signs them.
+func awsEscapeQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := []string{}
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, awsEscapeComponent(name)+"="+awsEscapeComponent(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsEscapeComponent percent-encodes everything but the characters AWS
+// leaves unreserved, including slashes.
+func awsEscapeComponent(s string) string {
+	return strings.Replace(awsEscapePath(s), "/", "%2F", -1)
+}
+
+// awsEscapePath percent-encodes everything in the path but its slashes and
+// the characters AWS leaves unreserved.
+func awsEscapePath(p string) string {
+	escaped := ""
+	for _, b := range []byte(p) {
+		switch {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9', strings.IndexByte("-._~/", b) >= 0:
+			escaped += string(b)
+		default:
+			escaped += fmt.Sprintf("%%%02X", b)
+		}
+	}
+	return escaped
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WARCWriter writes request and response records to a WARC file, as used by
+// web-archiving tools. Files named .gz have each record compressed as its own
+// gzip member, per the convention for .warc.gz files.
+type WARCWriter struct {
+	file *os.File
+	gzip bool
+	lock sync.Mutex
+}
+
+func NewWARCWriter(filename string) (*WARCWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WARCWriter{file: file, gzip: strings.HasSuffix(filename, ".gz")}
+	info := "software: gergle\r\nformat: WARC File Format 1.0\r\n"
+	if err := w.WriteRecord("warcinfo", "", "", "application/warc-fields", []byte(info)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteRecord appends a single record to the WARC file.
+func (w *WARCWriter) WriteRecord(recordType, targetURI, concurrentTo, contentType string, block []byte) error {
+	return w.writeRecord(newWARCRecordID(), recordType, targetURI, concurrentTo, contentType, block)
+}
+
+func (w *WARCWriter) writeRecord(id, recordType, targetURI, concurrentTo, contentType string, block []byte) error {
+	return w.copyRecord(id, recordType, targetURI, concurrentTo, contentType, bytes.NewReader(block), int64(len(block)))
+}
+
+// copyRecord appends a record to the WARC file, copying its block of the given
+// length from the reader, so that large blocks needn't be held in memory.
+func (w *WARCWriter) copyRecord(id, recordType, targetURI, concurrentTo, contentType string, block io.Reader, length int64) error {
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "WARC/1.0\r\n")
+	fmt.Fprintf(&header, "WARC-Type: %s\r\n", recordType)
+	fmt.Fprintf(&header, "WARC-Record-ID: %s\r\n", id)
+	fmt.Fprintf(&header, "WARC-Date: %s\r\n", clock().UTC().Format(time.RFC3339))
+	if targetURI != "" {
+		fmt.Fprintf(&header, "WARC-Target-URI: %s\r\n", targetURI)
+	}
+	if concurrentTo != "" {
+		fmt.Fprintf(&header, "WARC-Concurrent-To: %s\r\n", concurrentTo)
+	}
+	fmt.Fprintf(&header, "Content-Type: %s\r\n", contentType)
+	fmt.Fprintf(&header, "Content-Length: %d\r\n\r\n", length)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var out io.Writer = w.file
+	var gz *gzip.Writer
+	if w.gzip {
+		gz = gzip.NewWriter(w.file)
+		out = gz
+	}
+	if _, err := out.Write(header.Bytes()); err != nil {
+		return err
+	}
+	if n, err := io.Copy(out, block); err != nil {
+		return err
+	} else if n != length {
+		return fmt.Errorf("Expected a block of %d bytes, got %d", length, n)
+	}
+	if _, err := io.WriteString(out, "\r\n\r\n"); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+func (w *WARCWriter) Close() error {
+	return w.file.Close()
+}
+
+// newWARCRecordID returns a random UUID URN to identify a record.
+func newWARCRecordID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// WARCTransport archives every request made, and the response to it, to a
+// WARCWriter. The response body is copied to a temporary file as the client
+// reads it, rather than held in memory, and the records are written once the
+// client closes it.
+type WARCTransport struct {
+	Transport http.RoundTripper
+	WARC      *WARCWriter
+}
+
+func (t *WARCTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := ioutil.TempFile("", "gergle-warc-")
+	if err != nil {
+		logger.Warn("Failed to write WARC record", "url", req.URL, "error", err)
+		return resp, nil
+	}
+	resp.Body = &warcBody{ReadCloser: resp.Body, url: req.URL.String(), file: file, archive: func(body *os.File, size int64) error {
+		return t.archive(req, resp, body, size)
+	}}
+	return resp, nil
+}
+
+func (t *WARCTransport) archive(req *http.Request, resp *http.Response, body io.Reader, size int64) error {
+	// The body is archived as it was read, without any transfer encoding.
+	head := *resp
+	head.ContentLength = size
+	head.TransferEncoding = nil
+	head.Body = nil
+	respHead, err := httputil.DumpResponse(&head, false)
+	if err != nil {
+		return err
+	}
+	reqBlock, err := httputil.DumpRequestOut(req, false)
+	if err != nil {
+		return err
+	}
+
+	responseID := newWARCRecordID()
+	uri := req.URL.String()
+	respBlock := io.MultiReader(bytes.NewReader(respHead), body)
+	if err := t.WARC.copyRecord(responseID, "response", uri, "", "application/http; msgtype=response", respBlock, int64(len(respHead))+size); err != nil {
+		return err
+	}
+	return t.WARC.WriteRecord("request", uri, responseID, "application/http; msgtype=request", reqBlock)
+}
+
+// warcBody copies a response body to a temporary file as it is read, handing
+// the file to archive once the body is closed.
+type warcBody struct {
+	io.ReadCloser
+	url     string
+	file    *os.File
+	size    int64
+	err     error
+	archive func(body *os.File, size int64) error
+	closed  bool
+}
+
+func (b *warcBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.err == nil {
+		_, b.err = b.file.Write(p[:n])
+		b.size += int64(n)
+	}
+	return n, err
+}
+
+// Close archives the whole response, reading the rest of the body if the
+// client stopped short of its end.
+func (b *warcBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	defer os.Remove(b.file.Name())
+	defer b.file.Close()
+
+	_, err := io.Copy(ioutil.Discard, b)
+	closeErr := b.ReadCloser.Close()
+	if err == nil {
+		err = b.err
+	}
+	if err == nil {
+		_, err = b.file.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = b.archive(b.file, b.size)
+	}
+	if err != nil {
+		logger.Warn("Failed to write WARC record", "url", b.url, "error", err)
+	}
+	return closeErr
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWARCTransport(t *testing.T) {
+	big := strings.Repeat("<p>Lorem ipsum</p>\n", 50000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/big" {
+			// Flushing sends the body chunked.
+			io.WriteString(w, big[:100])
+			w.(http.Flusher).Flush()
+			io.WriteString(w, big[100:])
+		} else {
+			io.WriteString(w, "<p>Small</p>")
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gergle-warc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	warcFile := filepath.Join(dir, "crawl.warc.gz")
+	warc, err := NewWARCWriter(warcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &WARCTransport{http.DefaultTransport, warc}}
+
+	// The big page is archived whole, though only its start is read.
+	resp, err := client.Get(server.URL + "/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadFull(resp.Body, make([]byte, 10))
+	resp.Body.Close()
+	resp, err = client.Get(server.URL + "/small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	warc.Close()
+
+	// Parse the records back.
+	file, err := os.Open(warcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := bufio.NewReader(gz)
+	types := []string{}
+	bodies := map[string]string{}
+	responseIDs := map[string]string{}
+	for {
+		version, err := records.ReadString('\n')
+		if err == io.EOF && version == "" {
+			break
+		} else if version != "WARC/1.0\r\n" {
+			t.Fatalf("Expected a WARC/1.0 record, got %q (%v)", version, err)
+		}
+		headers, err := textproto.NewReader(records).ReadMIMEHeader()
+		if err != nil {
+			t.Fatalf("Failed to read record headers: %s", err)
+		}
+		length, err := strconv.Atoi(headers.Get("Content-Length"))
+		if err != nil {
+			t.Fatalf("Invalid record length %q", headers.Get("Content-Length"))
+		}
+		block := make([]byte, length)
+		if _, err := io.ReadFull(records, block); err != nil {
+			t.Fatalf("Failed to read record block: %s", err)
+		}
+		end := make([]byte, 4)
+		if _, err := io.ReadFull(records, end); err != nil || string(end) != "\r\n\r\n" {
+			t.Fatalf("Expected the record to end with a blank line, got %q", end)
+		}
+
+		recordType, uri := headers.Get("WARC-Type"), headers.Get("WARC-Target-URI")
+		types = append(types, recordType)
+		switch recordType {
+		case "response":
+			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), nil)
+			if err != nil {
+				t.Fatalf("Failed to read response of %s: %s", uri, err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			bodies[uri] = string(body)
+			responseIDs[headers.Get("WARC-Record-ID")] = uri
+		case "request":
+			if !bytes.HasPrefix(block, []byte("GET ")) || responseIDs[headers.Get("WARC-Concurrent-To")] != uri {
+				t.Errorf("Expected the GET request of the response to %s, got %q", uri, block)
+			}
+		}
+	}
+
+	expected := []string{"warcinfo", "response", "request", "response", "request"}
+	if strings.Join(types, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected records %v, got %v", expected, types)
+	}
+	if bodies[server.URL+"/big"] != big || bodies[server.URL+"/small"] != "<p>Small</p>" {
+		t.Errorf("Expected the whole bodies to be archived, got %d and %q", len(bodies[server.URL+"/big"]), bodies[server.URL+"/small"])
+	}
+}
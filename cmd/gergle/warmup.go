@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// warmUpURLs returns the URLs whose hosts are warmed up before a crawl: the
+// initial URL, and those of the other hosts the crawl follows links to which
+// aren't patterns.
+func warmUpURLs(initUrl *url.URL, hosts []string) []*url.URL {
+	urls := []*url.URL{initUrl}
+	for _, host := range hosts {
+		if !strings.Contains(host, "*") {
+			urls = append(urls, &url.URL{Scheme: initUrl.Scheme, Host: host, Path: "/"})
+		}
+	}
+	return urls
+}
+
+// warmUp resolves and connects to the host of each URL before the crawl
+// begins, with a HEAD request on each of the given number of connections, so
+// that DNS lookups and TLS handshakes don't inflate the timings of the first
+// pages. The connections are left idle for the crawl to reuse.
+func warmUp(transport http.RoundTripper, urls []*url.URL, conns int) {
+	for _, u := range urls {
+		start := time.Now()
+		wg := sync.WaitGroup{}
+		for i := 0; i < conns; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, err := http.NewRequest("HEAD", u.String(), nil)
+				if err != nil {
+					return
+				}
+				resp, err := transport.RoundTrip(req)
+				if err != nil {
+					logger.Debug("Failed to warm up connection", "url", u, "error", err)
+					return
+				}
+				resp.Body.Close()
+			}()
+		}
+		wg.Wait()
+		logger.Info("Warmed up connections", "host", u.Host, "connections", conns, "took", time.Since(start))
+	}
+}
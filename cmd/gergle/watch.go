@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+// newWatchCommand returns the command which repeatedly crawls a website,
+// reporting the differences between each crawl and the one before it.
+func newWatchCommand(opts *CrawlOptions) *cobra.Command {
+	var every time.Duration
+	var snapshotDir string
+
+	cmd := &cobra.Command{
+		Use:   "watch URL",
+		Short: "Crawl a website periodically, reporting broken and changed pages.",
+	}
+	cmd.Flags().DurationVarP(&every, "every", "", time.Hour, "The interval between the start of each crawl.")
+	cmd.Flags().StringVarP(&snapshotDir, "snapshots", "", "", "Directory in which to store the snapshot of each crawl.")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		initUrl, err := parseURLArg(args)
+		if err != nil {
+			return err
+		}
+		if every <= 0 {
+			return fmt.Errorf("--every must be positive.")
+		}
+
+		var prev *Snapshot
+		if snapshotDir != "" {
+			if prev, err = LatestSnapshot(snapshotDir); err != nil {
+				logger.Warn("Failed to read previous snapshot", "dir", snapshotDir, "error", err)
+			}
+		}
+
+		for crawls := 0; ; crawls++ {
+			if opts.Deterministic {
+				// Each crawl is a period later than the one before it.
+				clock = fixedClock(deterministicTime.Add(time.Duration(crawls) * every))
+			}
+			started := time.Now()
+			snapshot := NewSnapshot()
+			crawlOpts := *opts
+			crawlOpts.Referrers = NewReferrers()
+			pages, _, err := startCrawl(initUrl, crawlOpts)
+			if err != nil {
+				return err
+			}
+			for page := range pages {
+				snapshot.Add(page)
+			}
+			snapshot.AddReferrers(crawlOpts.Referrers)
+
+			fmt.Printf("Crawled %s at %s: %d pages\n", initUrl, snapshot.Time.Format(time.RFC3339), len(snapshot.Pages))
+			if prev != nil {
+				snapshot.RecordHistory(prev)
+				diff := DiffSnapshots(prev, snapshot)
+				diff.Print(os.Stdout, snapshot)
+				if webhook := opts.Webhook(); webhook != nil && !diff.Empty() {
+					webhook.notify("diff", map[string]interface{}{
+						"url":  initUrl.String(),
+						"diff": diff,
+					})
+				}
+			}
+
+			if snapshotDir != "" {
+				if err := snapshot.Save(snapshotDir); err != nil {
+					logger.Warn("Failed to save snapshot", "dir", snapshotDir, "error", err)
+				}
+			}
+			prev = snapshot
+
+			next := started.Add(every)
+			logger.Info("Waiting for next crawl", "at", next)
+			time.Sleep(next.Sub(time.Now()))
+		}
+	}
+
+	return cmd
+}
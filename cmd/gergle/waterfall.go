@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// waterfallWidth is the number of characters of the bars of a waterfall.
+const waterfallWidth = 40
+
+// A resourceTiming is the outcome of fetching a page or one of its assets,
+// timed from the start of the page's request.
+type resourceTiming struct {
+	Type     string
+	URL      *url.URL
+	Status   int
+	Error    error
+	Start    time.Duration
+	TTFB     time.Duration
+	Duration time.Duration
+	Size     int
+}
+
+func (r *resourceTiming) End() time.Duration {
+	return r.Start + r.Duration
+}
+
+// PageDetail fetches a page with the fetcher, followed by all of its assets and
+// frames on the given number of workers, as a browser would, returning the
+// timing of each, the page first.
+func PageDetail(fetcher Fetcher, client *http.Client, u *url.URL, workers int) []*resourceTiming {
+	start := time.Now()
+	page := fetcher.Fetch(&Task{URL: u})
+	timings := []*resourceTiming{{
+		Type:     "page",
+		URL:      u,
+		Status:   page.StatusCode,
+		TTFB:     page.TTFB,
+		Duration: time.Since(start),
+		Size:     page.Size,
+	}}
+	if page.Error != nil {
+		timings[0].Error = *page.Error
+	}
+
+	seen := map[string]bool{u.String(): true}
+	resources := make(chan *resourceTiming)
+	go func() {
+		defer close(resources)
+		queue := func(link *Link) {
+			if !link.IsHTTP() || seen[link.URL.String()] {
+				return
+			}
+			seen[link.URL.String()] = true
+			timing := &resourceTiming{Type: link.Type, URL: link.URL}
+			timings = append(timings, timing)
+			resources <- timing
+		}
+		for _, asset := range page.Assets {
+			queue(asset)
+		}
+		for _, link := range page.Links {
+			if link.IsFrame() {
+				queue(link)
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for timing := range resources {
+				timeResource(client, timing, start)
+			}
+		}()
+	}
+	wg.Wait()
+	return timings
+}
+
+// timeResource fetches the resource, recording its timings relative to start.
+func timeResource(client *http.Client, timing *resourceTiming, start time.Time) {
+	req, err := http.NewRequest("GET", timing.URL.String(), nil)
+	if err != nil {
+		timing.Error = err
+		return
+	}
+	requested := time.Now()
+	timing.Start = requested.Sub(start)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { timing.TTFB = time.Since(requested) },
+	}))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		timing.Error = err
+		timing.Duration = time.Since(requested)
+		return
+	}
+	defer resp.Body.Close()
+	size, err := io.Copy(ioutil.Discard, resp.Body)
+	timing.Status, timing.Size, timing.Error = resp.StatusCode, int(size), err
+	timing.Duration = time.Since(requested)
+}
+
+// printWaterfall writes the timings of each resource, with a bar spanning the
+// time each was being fetched, followed by the totals.
+func printWaterfall(w io.Writer, timings []*resourceTiming) {
+	var end time.Duration
+	size := 0
+	for _, timing := range timings {
+		if timing.End() > end {
+			end = timing.End()
+		}
+		size += timing.Size
+	}
+
+	fmt.Fprintf(w, "Page detail: %d resources, %d bytes, %s\n", len(timings), size, end.Round(time.Millisecond))
+	for _, timing := range timings {
+		bar := []byte(strings.Repeat(" ", waterfallWidth))
+		if end > 0 {
+			from := int(int64(timing.Start) * waterfallWidth / int64(end))
+			to := int(int64(timing.End()) * waterfallWidth / int64(end))
+			if to >= waterfallWidth {
+				to = waterfallWidth - 1
+			}
+			for i := from; i <= to; i++ {
+				bar[i] = '='
+			}
+		}
+
+		status := fmt.Sprint(timing.Status)
+		if timing.Error != nil {
+			status = timing.Error.Error()
+		}
+		fmt.Fprintf(w, "- |%s| %6s +%-6s ttfb %-6s %8d bytes  %s %s %s\n",
+			bar,
+			timing.Start.Round(time.Millisecond),
+			timing.Duration.Round(time.Millisecond),
+			timing.TTFB.Round(time.Millisecond),
+			timing.Size,
+			status,
+			timing.Type,
+			timing.URL)
+	}
+}
+
+// pageDetailAssetRules are the assets fetched along with a page by
+// --page-detail, in addition to those of --asset.
+var pageDetailAssetRules = append([]AssetRule{
+	{Tag: "link", Attr: "href", Rel: "stylesheet"},
+	{Tag: "link", Attr: "href", Rel: "icon"},
+	{Tag: "link", Attr: "href", Rel: "preload"},
+	{Tag: "iframe", Attr: "src"},
+}, DefaultAssetRules...)
+
+// runPageDetail fetches the page at the raw URL and all of its assets,
+// printing the waterfall of their timings.
+func runPageDetail(raw string, opts CrawlOptions) error {
+	u, err := parseURLArg([]string{raw})
+	if err != nil {
+		return err
+	}
+	transport, err := newSiteTransport(u, opts)
+	if err != nil {
+		return err
+	}
+	jar, err := newCookieJar(u, opts)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: transport, Jar: jar, CheckRedirect: checkRedirect}
+
+	parser := &RegexPageParser{AssetRules: append([]AssetRule{}, pageDetailAssetRules...)}
+	for _, rule := range opts.Assets {
+		assetRule, err := ParseAssetRule(rule)
+		if err != nil {
+			return err
+		}
+		parser.AssetRules = append(parser.AssetRules, assetRule)
+	}
+
+	logger.Info("Fetching page detail", "url", u)
+	printWaterfall(os.Stdout, PageDetail(&HTTPFetcher{Client: client, Parser: parser}, client, u, opts.NumConns))
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Webhook POSTs JSON notifications of crawl events to a URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+
+	// OnServerError has the first 5xx response of a crawl notified.
+	OnServerError bool
+	// BrokenThreshold has a notification sent once the number of broken pages
+	// in a crawl exceeds it. Zero disables the notification.
+	BrokenThreshold int
+}
+
+func NewWebhook(hookURL string) *Webhook {
+	return &Webhook{URL: hookURL, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Notify sends the event and its data to the webhook.
+func (w *Webhook) Notify(event string, data interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"event": event,
+		"time":  clock().UTC().Format(time.RFC3339),
+		"data":  data,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook responded %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (w *Webhook) notify(event string, data interface{}) {
+	logger.Debug("Notifying webhook", "event", event)
+	if err := w.Notify(event, data); err != nil {
+		logger.Warn("Failed to notify webhook", "event", event, "error", err)
+	}
+}
+
+// A webhookEvent is a notification waiting to be sent to the webhook.
+type webhookEvent struct {
+	event string
+	data  interface{}
+}
+
+// Watch forwards the pages of a crawl, notifying the webhook as its
+// conditions are met and once the crawl is complete. The notifications are
+// sent in order from a separate goroutine, so that a slow webhook doesn't hold
+// up the crawl, and the pages are closed once they have all been sent.
+func (w *Webhook) Watch(initUrl *url.URL, in <-chan Page) <-chan Page {
+	out := make(chan Page, cap(in))
+	// Each event is notified at most once per crawl, so the queue never fills.
+	events := make(chan webhookEvent, 3)
+	sent := make(chan struct{})
+	go func() {
+		for e := range events {
+			w.notify(e.event, e.data)
+		}
+		close(sent)
+	}()
+
+	go func() {
+		start := time.Now()
+		pages, broken := 0, 0
+		seenServerError := false
+
+		for page := range in {
+			pages++
+			if page.Broken() {
+				broken++
+				if broken == w.BrokenThreshold+1 && w.BrokenThreshold > 0 {
+					events <- webhookEvent{"broken_threshold", map[string]interface{}{
+						"url":       initUrl.String(),
+						"broken":    broken,
+						"threshold": w.BrokenThreshold,
+					}}
+				}
+			}
+			if w.OnServerError && !seenServerError && page.StatusCode >= 500 {
+				seenServerError = true
+				events <- webhookEvent{"server_error", map[string]interface{}{
+					"url":       page.URL.String(),
+					"status":    page.StatusCode,
+					"referrers": urlStrings(page.Referrers),
+				}}
+			}
+			out <- page
+		}
+
+		events <- webhookEvent{"complete", map[string]interface{}{
+			"url":      initUrl.String(),
+			"pages":    pages,
+			"broken":   broken,
+			"duration": time.Since(start).Seconds(),
+		}}
+		close(events)
+		<-sent
+		close(out)
+	}()
+	return out
+}
+
+func urlStrings(urls []*url.URL) []string {
+	hrefs := make([]string, len(urls))
+	for i, u := range urls {
+		hrefs[i] = u.String()
+	}
+	return hrefs
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestWebhookWatch(t *testing.T) {
+	type notification struct {
+		Event string                 `json:"event"`
+		Data  map[string]interface{} `json:"data"`
+	}
+	received := make(chan notification, 3)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("Expected a JSON notification: %s", err)
+		}
+		received <- n
+		<-release
+	}))
+	defer server.Close()
+
+	site, _ := url.Parse("http://example.com/")
+	missing, _ := url.Parse("http://example.com/missing")
+	failing, _ := url.Parse("http://example.com/failing")
+	webhook := NewWebhook(server.URL)
+	webhook.BrokenThreshold = 1
+	webhook.OnServerError = true
+
+	in := make(chan Page)
+	out := webhook.Watch(site, in)
+	go func() {
+		in <- Page{URL: site, StatusCode: 200}
+		in <- Page{URL: missing, StatusCode: 404}
+		in <- Page{URL: failing, StatusCode: 500, Referrers: []*url.URL{site}}
+		close(in)
+	}()
+
+	// The pages are forwarded while the webhook is still responding to the
+	// first notification.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-out:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the pages to be forwarded without waiting for the webhook")
+		}
+	}
+	close(release)
+	if _, open := <-out; open {
+		t.Error("Expected the pages to be closed once the crawl is complete")
+	}
+
+	expected := []struct {
+		event string
+		key   string
+		value interface{}
+	}{
+		{"broken_threshold", "broken", 2.0},
+		{"server_error", "url", failing.String()},
+		{"complete", "pages", 3.0},
+	}
+	for _, e := range expected {
+		select {
+		case n := <-received:
+			if n.Event != e.event || n.Data[e.key] != e.value {
+				t.Errorf("Expected %s with %s %v but got %+v", e.event, e.key, e.value, n)
+			}
+		default:
+			t.Fatalf("Expected %s to be notified before the pages were closed", e.event)
+		}
+	}
+}
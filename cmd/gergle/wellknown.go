@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WellKnownPaths are the standard URLs of a site probed by WellKnownReporter.
+var WellKnownPaths = []string{
+	"/.well-known/security.txt",
+	"/.well-known/change-password",
+	"/robots.txt",
+	"/sitemap.xml",
+}
+
+// maxWellKnownSize caps the size of the well-known documents which are read.
+const maxWellKnownSize = 1 << 20
+
+// A wellKnownResult is the response to the probe of a well-known URL.
+type wellKnownResult struct {
+	URL      string
+	Status   int
+	Location string
+	Problems []string
+	Err      error
+}
+
+// Found reports whether the URL exists, either responding successfully or
+// redirecting elsewhere.
+func (r *wellKnownResult) Found() bool {
+	return r.Err == nil && r.Status >= 200 && r.Status < 400
+}
+
+// probeWellKnown requests the well-known path of the site, without following
+// redirects, checking the contents of those it knows the format of.
+func probeWellKnown(client *http.Client, site *url.URL, path string) wellKnownResult {
+	result := wellKnownResult{URL: site.ResolveReference(&url.URL{Path: path}).String()}
+	noRedirects := *client
+	noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	logger.Debug("Probing well-known URL", "url", result.URL)
+	resp, err := noRedirects.Get(result.URL)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+	result.Status = resp.StatusCode
+	result.Location = resp.Header.Get("Location")
+
+	if resp.StatusCode == http.StatusOK && path == "/.well-known/security.txt" {
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxWellKnownSize))
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		result.Problems = securityTxtProblems(body)
+	}
+	return result
+}
+
+// securityTxtProblems returns the missing and invalid fields of a
+// security.txt, per RFC 9116.
+func securityTxtProblems(body []byte) (problems []string) {
+	fields := make(map[string][]string)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if colon := strings.Index(line, ":"); colon > 0 {
+			name := strings.ToLower(strings.TrimSpace(line[:colon]))
+			fields[name] = append(fields[name], strings.TrimSpace(line[colon+1:]))
+		}
+	}
+
+	if len(fields["contact"]) == 0 {
+		problems = append(problems, "missing Contact")
+	}
+	switch expires := fields["expires"]; {
+	case len(expires) == 0:
+		problems = append(problems, "missing Expires")
+	case len(expires) > 1:
+		problems = append(problems, "more than one Expires")
+	default:
+		if t, err := time.Parse(time.RFC3339, expires[0]); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid Expires %q", expires[0]))
+		} else if t.Before(clock()) {
+			problems = append(problems, fmt.Sprintf("expired %s", expires[0]))
+		}
+	}
+	return
+}
+
+// WellKnownReporter probes the site's well-known URLs once the crawl is
+// complete, listing the status of each and the problems of its security.txt.
+type WellKnownReporter struct {
+	Client *http.Client
+
+	site *url.URL
+}
+
+func NewWellKnownReporter(client *http.Client, site *url.URL) *WellKnownReporter {
+	return &WellKnownReporter{Client: client, site: site}
+}
+
+func (k *WellKnownReporter) Observe(page Page) {}
+
+func (k *WellKnownReporter) Report(w io.Writer) {
+	logger.Info("Probing well-known URLs", "count", len(WellKnownPaths))
+	results := make([]wellKnownResult, 0, len(WellKnownPaths))
+	found := 0
+	for _, path := range WellKnownPaths {
+		result := probeWellKnown(k.Client, k.site, path)
+		if result.Found() {
+			found++
+		}
+		results = append(results, result)
+	}
+
+	fmt.Fprintf(w, "Well-known URLs: %d of %d found\n", found, len(results))
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			fmt.Fprintf(w, "- %s: %s\n", result.URL, result.Err)
+		case result.Location != "":
+			fmt.Fprintf(w, "- %s: %d to %s\n", result.URL, result.Status, result.Location)
+		default:
+			fmt.Fprintf(w, "- %s: %d\n", result.URL, result.Status)
+		}
+		for _, problem := range result.Problems {
+			fmt.Fprintf(w, "  - %s\n", problem)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+	"strings"
+)
+
+// isXHTML reports whether the XML document is an XHTML page, served as XML
+// rather than as HTML, by its root element.
+func isXHTML(body []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return strings.ToLower(start.Name.Local) == "html"
+		}
+	}
+}
+
+var xhtmlPrefixRegex = regexp.MustCompile(`(?i)\sxmlns:([\w.-]+)\s*=\s*["']http://www\.w3\.org/1999/xhtml["']`)
+
+// unprefixXHTML strips the namespace prefixes of the XHTML elements of the
+// document, such as <h:a href="/">, so that they are parsed as the elements of
+// an HTML page. Documents without prefixed XHTML elements are returned as
+// they are.
+func unprefixXHTML(body []byte) []byte {
+	for _, match := range xhtmlPrefixRegex.FindAllSubmatch(body, -1) {
+		prefix := regexp.MustCompile(`(</?)` + regexp.QuoteMeta(string(match[1])) + `:`)
+		body = prefix.ReplaceAll(body, []byte("$1"))
+	}
+	return body
+}
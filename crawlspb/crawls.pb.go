@@ -0,0 +1,490 @@
+// The crawl service of gergle serve, on its --grpc-listen address, for
+// clients generated with protoc. It mirrors the HTTP API: StartCrawl is POST
+// /crawls, GetCrawl is GET /crawls/ID, StreamPages is GET /crawls/ID/pages and
+// Cancel is DELETE /crawls/ID.
+//
+// After changing it, regenerate package crawlspb with go generate, which needs
+// protoc, protoc-gen-go and protoc-gen-go-grpc.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: crawls.proto
+
+package crawlspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// CrawlRequest is a JobRequest: the crawl options given to serve are used
+// unless it has its own.
+type CrawlRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Url           string                 `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	Depth         *uint32                `protobuf:"varint,2,opt,name=depth,proto3,oneof" json:"depth,omitempty"`
+	Connections   *int32                 `protobuf:"varint,3,opt,name=connections,proto3,oneof" json:"connections,omitempty"`
+	Delay         *float64               `protobuf:"fixed64,4,opt,name=delay,proto3,oneof" json:"delay,omitempty"`
+	Disallow      []string               `protobuf:"bytes,5,rep,name=disallow,proto3" json:"disallow,omitempty"`
+	Webhook       *string                `protobuf:"bytes,6,opt,name=webhook,proto3,oneof" json:"webhook,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CrawlRequest) Reset() {
+	*x = CrawlRequest{}
+	mi := &file_crawls_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CrawlRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CrawlRequest) ProtoMessage() {}
+
+func (x *CrawlRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_crawls_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CrawlRequest.ProtoReflect.Descriptor instead.
+func (*CrawlRequest) Descriptor() ([]byte, []int) {
+	return file_crawls_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CrawlRequest) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *CrawlRequest) GetDepth() uint32 {
+	if x != nil && x.Depth != nil {
+		return *x.Depth
+	}
+	return 0
+}
+
+func (x *CrawlRequest) GetConnections() int32 {
+	if x != nil && x.Connections != nil {
+		return *x.Connections
+	}
+	return 0
+}
+
+func (x *CrawlRequest) GetDelay() float64 {
+	if x != nil && x.Delay != nil {
+		return *x.Delay
+	}
+	return 0
+}
+
+func (x *CrawlRequest) GetDisallow() []string {
+	if x != nil {
+		return x.Disallow
+	}
+	return nil
+}
+
+func (x *CrawlRequest) GetWebhook() string {
+	if x != nil && x.Webhook != nil {
+		return *x.Webhook
+	}
+	return ""
+}
+
+type CrawlID struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CrawlID) Reset() {
+	*x = CrawlID{}
+	mi := &file_crawls_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CrawlID) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CrawlID) ProtoMessage() {}
+
+func (x *CrawlID) ProtoReflect() protoreflect.Message {
+	mi := &file_crawls_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CrawlID.ProtoReflect.Descriptor instead.
+func (*CrawlID) Descriptor() ([]byte, []int) {
+	return file_crawls_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CrawlID) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+// CrawlStatus is a JobStatus.
+type CrawlStatus struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Url   string                 `protobuf:"bytes,2,opt,name=url,proto3" json:"url,omitempty"`
+	// queued, running, complete, cancelled or failed.
+	State         string                 `protobuf:"bytes,3,opt,name=state,proto3" json:"state,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	Started       *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=started,proto3" json:"started,omitempty"`
+	Finished      *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=finished,proto3" json:"finished,omitempty"`
+	Pages         int32                  `protobuf:"varint,7,opt,name=pages,proto3" json:"pages,omitempty"`
+	Broken        int32                  `protobuf:"varint,8,opt,name=broken,proto3" json:"broken,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CrawlStatus) Reset() {
+	*x = CrawlStatus{}
+	mi := &file_crawls_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CrawlStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CrawlStatus) ProtoMessage() {}
+
+func (x *CrawlStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_crawls_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CrawlStatus.ProtoReflect.Descriptor instead.
+func (*CrawlStatus) Descriptor() ([]byte, []int) {
+	return file_crawls_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CrawlStatus) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *CrawlStatus) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *CrawlStatus) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *CrawlStatus) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *CrawlStatus) GetStarted() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Started
+	}
+	return nil
+}
+
+func (x *CrawlStatus) GetFinished() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Finished
+	}
+	return nil
+}
+
+func (x *CrawlStatus) GetPages() int32 {
+	if x != nil {
+		return x.Pages
+	}
+	return 0
+}
+
+func (x *CrawlStatus) GetBroken() int32 {
+	if x != nil {
+		return x.Broken
+	}
+	return 0
+}
+
+// Page is a JobPage.
+type Page struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Url           string                 `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	Depth         uint32                 `protobuf:"varint,2,opt,name=depth,proto3" json:"depth,omitempty"`
+	Links         int32                  `protobuf:"varint,3,opt,name=links,proto3" json:"links,omitempty"`
+	Assets        int32                  `protobuf:"varint,4,opt,name=assets,proto3" json:"assets,omitempty"`
+	Status        int32                  `protobuf:"varint,5,opt,name=status,proto3" json:"status,omitempty"`
+	Error         string                 `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	Checksum      string                 `protobuf:"bytes,7,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	Broken        bool                   `protobuf:"varint,8,opt,name=broken,proto3" json:"broken,omitempty"`
+	Redirects     []string               `protobuf:"bytes,9,rep,name=redirects,proto3" json:"redirects,omitempty"`
+	Referrers     []string               `protobuf:"bytes,10,rep,name=referrers,proto3" json:"referrers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Page) Reset() {
+	*x = Page{}
+	mi := &file_crawls_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Page) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Page) ProtoMessage() {}
+
+func (x *Page) ProtoReflect() protoreflect.Message {
+	mi := &file_crawls_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Page.ProtoReflect.Descriptor instead.
+func (*Page) Descriptor() ([]byte, []int) {
+	return file_crawls_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Page) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Page) GetDepth() uint32 {
+	if x != nil {
+		return x.Depth
+	}
+	return 0
+}
+
+func (x *Page) GetLinks() int32 {
+	if x != nil {
+		return x.Links
+	}
+	return 0
+}
+
+func (x *Page) GetAssets() int32 {
+	if x != nil {
+		return x.Assets
+	}
+	return 0
+}
+
+func (x *Page) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *Page) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Page) GetChecksum() string {
+	if x != nil {
+		return x.Checksum
+	}
+	return ""
+}
+
+func (x *Page) GetBroken() bool {
+	if x != nil {
+		return x.Broken
+	}
+	return false
+}
+
+func (x *Page) GetRedirects() []string {
+	if x != nil {
+		return x.Redirects
+	}
+	return nil
+}
+
+func (x *Page) GetReferrers() []string {
+	if x != nil {
+		return x.Referrers
+	}
+	return nil
+}
+
+var File_crawls_proto protoreflect.FileDescriptor
+
+const file_crawls_proto_rawDesc = "" +
+	"\n" +
+	"\fcrawls.proto\x12\x06gergle\x1a\x1fgoogle/protobuf/timestamp.proto\"\xe8\x01\n" +
+	"\fCrawlRequest\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url\x12\x19\n" +
+	"\x05depth\x18\x02 \x01(\rH\x00R\x05depth\x88\x01\x01\x12%\n" +
+	"\vconnections\x18\x03 \x01(\x05H\x01R\vconnections\x88\x01\x01\x12\x19\n" +
+	"\x05delay\x18\x04 \x01(\x01H\x02R\x05delay\x88\x01\x01\x12\x1a\n" +
+	"\bdisallow\x18\x05 \x03(\tR\bdisallow\x12\x1d\n" +
+	"\awebhook\x18\x06 \x01(\tH\x03R\awebhook\x88\x01\x01B\b\n" +
+	"\x06_depthB\x0e\n" +
+	"\f_connectionsB\b\n" +
+	"\x06_delayB\n" +
+	"\n" +
+	"\b_webhook\"\x19\n" +
+	"\aCrawlID\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\xf7\x01\n" +
+	"\vCrawlStatus\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x10\n" +
+	"\x03url\x18\x02 \x01(\tR\x03url\x12\x14\n" +
+	"\x05state\x18\x03 \x01(\tR\x05state\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x124\n" +
+	"\astarted\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\astarted\x126\n" +
+	"\bfinished\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\bfinished\x12\x14\n" +
+	"\x05pages\x18\a \x01(\x05R\x05pages\x12\x16\n" +
+	"\x06broken\x18\b \x01(\x05R\x06broken\"\xfa\x01\n" +
+	"\x04Page\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url\x12\x14\n" +
+	"\x05depth\x18\x02 \x01(\rR\x05depth\x12\x14\n" +
+	"\x05links\x18\x03 \x01(\x05R\x05links\x12\x16\n" +
+	"\x06assets\x18\x04 \x01(\x05R\x06assets\x12\x16\n" +
+	"\x06status\x18\x05 \x01(\x05R\x06status\x12\x14\n" +
+	"\x05error\x18\x06 \x01(\tR\x05error\x12\x1a\n" +
+	"\bchecksum\x18\a \x01(\tR\bchecksum\x12\x16\n" +
+	"\x06broken\x18\b \x01(\bR\x06broken\x12\x1c\n" +
+	"\tredirects\x18\t \x03(\tR\tredirects\x12\x1c\n" +
+	"\treferrers\x18\n" +
+	" \x03(\tR\treferrers2\xd3\x01\n" +
+	"\x06Crawls\x127\n" +
+	"\n" +
+	"StartCrawl\x12\x14.gergle.CrawlRequest\x1a\x13.gergle.CrawlStatus\x120\n" +
+	"\bGetCrawl\x12\x0f.gergle.CrawlID\x1a\x13.gergle.CrawlStatus\x12.\n" +
+	"\vStreamPages\x12\x0f.gergle.CrawlID\x1a\f.gergle.Page0\x01\x12.\n" +
+	"\x06Cancel\x12\x0f.gergle.CrawlID\x1a\x13.gergle.CrawlStatusB!Z\x1fgithub.com/icio/gergle/crawlspbb\x06proto3"
+
+var (
+	file_crawls_proto_rawDescOnce sync.Once
+	file_crawls_proto_rawDescData []byte
+)
+
+func file_crawls_proto_rawDescGZIP() []byte {
+	file_crawls_proto_rawDescOnce.Do(func() {
+		file_crawls_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_crawls_proto_rawDesc), len(file_crawls_proto_rawDesc)))
+	})
+	return file_crawls_proto_rawDescData
+}
+
+var file_crawls_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_crawls_proto_goTypes = []any{
+	(*CrawlRequest)(nil),          // 0: gergle.CrawlRequest
+	(*CrawlID)(nil),               // 1: gergle.CrawlID
+	(*CrawlStatus)(nil),           // 2: gergle.CrawlStatus
+	(*Page)(nil),                  // 3: gergle.Page
+	(*timestamppb.Timestamp)(nil), // 4: google.protobuf.Timestamp
+}
+var file_crawls_proto_depIdxs = []int32{
+	4, // 0: gergle.CrawlStatus.started:type_name -> google.protobuf.Timestamp
+	4, // 1: gergle.CrawlStatus.finished:type_name -> google.protobuf.Timestamp
+	0, // 2: gergle.Crawls.StartCrawl:input_type -> gergle.CrawlRequest
+	1, // 3: gergle.Crawls.GetCrawl:input_type -> gergle.CrawlID
+	1, // 4: gergle.Crawls.StreamPages:input_type -> gergle.CrawlID
+	1, // 5: gergle.Crawls.Cancel:input_type -> gergle.CrawlID
+	2, // 6: gergle.Crawls.StartCrawl:output_type -> gergle.CrawlStatus
+	2, // 7: gergle.Crawls.GetCrawl:output_type -> gergle.CrawlStatus
+	3, // 8: gergle.Crawls.StreamPages:output_type -> gergle.Page
+	2, // 9: gergle.Crawls.Cancel:output_type -> gergle.CrawlStatus
+	6, // [6:10] is the sub-list for method output_type
+	2, // [2:6] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_crawls_proto_init() }
+func file_crawls_proto_init() {
+	if File_crawls_proto != nil {
+		return
+	}
+	file_crawls_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_crawls_proto_rawDesc), len(file_crawls_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_crawls_proto_goTypes,
+		DependencyIndexes: file_crawls_proto_depIdxs,
+		MessageInfos:      file_crawls_proto_msgTypes,
+	}.Build()
+	File_crawls_proto = out.File
+	file_crawls_proto_goTypes = nil
+	file_crawls_proto_depIdxs = nil
+}
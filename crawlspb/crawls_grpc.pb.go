@@ -0,0 +1,257 @@
+// The crawl service of gergle serve, on its --grpc-listen address, for
+// clients generated with protoc. It mirrors the HTTP API: StartCrawl is POST
+// /crawls, GetCrawl is GET /crawls/ID, StreamPages is GET /crawls/ID/pages and
+// Cancel is DELETE /crawls/ID.
+//
+// After changing it, regenerate package crawlspb with go generate, which needs
+// protoc, protoc-gen-go and protoc-gen-go-grpc.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: crawls.proto
+
+package crawlspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Crawls_StartCrawl_FullMethodName  = "/gergle.Crawls/StartCrawl"
+	Crawls_GetCrawl_FullMethodName    = "/gergle.Crawls/GetCrawl"
+	Crawls_StreamPages_FullMethodName = "/gergle.Crawls/StreamPages"
+	Crawls_Cancel_FullMethodName      = "/gergle.Crawls/Cancel"
+)
+
+// CrawlsClient is the client API for Crawls service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CrawlsClient interface {
+	// StartCrawl queues a crawl, returning its status.
+	StartCrawl(ctx context.Context, in *CrawlRequest, opts ...grpc.CallOption) (*CrawlStatus, error)
+	// GetCrawl returns the status of a crawl.
+	GetCrawl(ctx context.Context, in *CrawlID, opts ...grpc.CallOption) (*CrawlStatus, error)
+	// StreamPages sends each page of a crawl, from the first, as it is crawled,
+	// ending once the crawl is finished.
+	StreamPages(ctx context.Context, in *CrawlID, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Page], error)
+	// Cancel drops the remaining pages of a crawl.
+	Cancel(ctx context.Context, in *CrawlID, opts ...grpc.CallOption) (*CrawlStatus, error)
+}
+
+type crawlsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCrawlsClient(cc grpc.ClientConnInterface) CrawlsClient {
+	return &crawlsClient{cc}
+}
+
+func (c *crawlsClient) StartCrawl(ctx context.Context, in *CrawlRequest, opts ...grpc.CallOption) (*CrawlStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CrawlStatus)
+	err := c.cc.Invoke(ctx, Crawls_StartCrawl_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crawlsClient) GetCrawl(ctx context.Context, in *CrawlID, opts ...grpc.CallOption) (*CrawlStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CrawlStatus)
+	err := c.cc.Invoke(ctx, Crawls_GetCrawl_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crawlsClient) StreamPages(ctx context.Context, in *CrawlID, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Page], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Crawls_ServiceDesc.Streams[0], Crawls_StreamPages_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[CrawlID, Page]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Crawls_StreamPagesClient = grpc.ServerStreamingClient[Page]
+
+func (c *crawlsClient) Cancel(ctx context.Context, in *CrawlID, opts ...grpc.CallOption) (*CrawlStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CrawlStatus)
+	err := c.cc.Invoke(ctx, Crawls_Cancel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CrawlsServer is the server API for Crawls service.
+// All implementations must embed UnimplementedCrawlsServer
+// for forward compatibility.
+type CrawlsServer interface {
+	// StartCrawl queues a crawl, returning its status.
+	StartCrawl(context.Context, *CrawlRequest) (*CrawlStatus, error)
+	// GetCrawl returns the status of a crawl.
+	GetCrawl(context.Context, *CrawlID) (*CrawlStatus, error)
+	// StreamPages sends each page of a crawl, from the first, as it is crawled,
+	// ending once the crawl is finished.
+	StreamPages(*CrawlID, grpc.ServerStreamingServer[Page]) error
+	// Cancel drops the remaining pages of a crawl.
+	Cancel(context.Context, *CrawlID) (*CrawlStatus, error)
+	mustEmbedUnimplementedCrawlsServer()
+}
+
+// UnimplementedCrawlsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCrawlsServer struct{}
+
+func (UnimplementedCrawlsServer) StartCrawl(context.Context, *CrawlRequest) (*CrawlStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartCrawl not implemented")
+}
+func (UnimplementedCrawlsServer) GetCrawl(context.Context, *CrawlID) (*CrawlStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCrawl not implemented")
+}
+func (UnimplementedCrawlsServer) StreamPages(*CrawlID, grpc.ServerStreamingServer[Page]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamPages not implemented")
+}
+func (UnimplementedCrawlsServer) Cancel(context.Context, *CrawlID) (*CrawlStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Cancel not implemented")
+}
+func (UnimplementedCrawlsServer) mustEmbedUnimplementedCrawlsServer() {}
+func (UnimplementedCrawlsServer) testEmbeddedByValue()                {}
+
+// UnsafeCrawlsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CrawlsServer will
+// result in compilation errors.
+type UnsafeCrawlsServer interface {
+	mustEmbedUnimplementedCrawlsServer()
+}
+
+func RegisterCrawlsServer(s grpc.ServiceRegistrar, srv CrawlsServer) {
+	// If the following call pancis, it indicates UnimplementedCrawlsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Crawls_ServiceDesc, srv)
+}
+
+func _Crawls_StartCrawl_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CrawlRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrawlsServer).StartCrawl(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Crawls_StartCrawl_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrawlsServer).StartCrawl(ctx, req.(*CrawlRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Crawls_GetCrawl_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CrawlID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrawlsServer).GetCrawl(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Crawls_GetCrawl_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrawlsServer).GetCrawl(ctx, req.(*CrawlID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Crawls_StreamPages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(CrawlID)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CrawlsServer).StreamPages(m, &grpc.GenericServerStream[CrawlID, Page]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Crawls_StreamPagesServer = grpc.ServerStreamingServer[Page]
+
+func _Crawls_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CrawlID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrawlsServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Crawls_Cancel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrawlsServer).Cancel(ctx, req.(*CrawlID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Crawls_ServiceDesc is the grpc.ServiceDesc for Crawls service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Crawls_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gergle.Crawls",
+	HandlerType: (*CrawlsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartCrawl",
+			Handler:    _Crawls_StartCrawl_Handler,
+		},
+		{
+			MethodName: "GetCrawl",
+			Handler:    _Crawls_GetCrawl_Handler,
+		},
+		{
+			MethodName: "Cancel",
+			Handler:    _Crawls_Cancel_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamPages",
+			Handler:       _Crawls_StreamPages_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "crawls.proto",
+}
@@ -0,0 +1,5 @@
+// Package crawlspb is the Go code generated from crawls.proto: the messages of
+// the gRPC service of gergle serve, and its client and server.
+package crawlspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative crawls.proto